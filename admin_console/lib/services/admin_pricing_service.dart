@@ -33,7 +33,7 @@ class AdminPricingService {
     return const [];
   }
 
-  // POST /api/pricing/price-sets
+  // POST /api/pricing/price-sets (files a draft; returns its definition)
   Future<PriceSet> createPriceSet(PriceSet set) async {
     final response = await http.post(
       Uri.parse('$baseUrl/api/pricing/price-sets'),
@@ -41,7 +41,7 @@ class AdminPricingService {
       body: jsonEncode(set.toJson()),
     );
     _checkStatus(response, 'Failed to create price set');
-    return PriceSet.fromJson(jsonDecode(response.body)['data']);
+    return PriceSet.fromJson(jsonDecode(response.body)['data']['set']);
   }
 
   // PUT /api/pricing/price-sets/:id
@@ -80,7 +80,7 @@ class AdminPricingService {
     return const [];
   }
 
-  // POST /api/pricing/price-schedules
+  // POST /api/pricing/price-schedules (files a draft; returns its definition)
   Future<PriceSchedule> createPriceSchedule(PriceSchedule schedule) async {
     final response = await http.post(
       Uri.parse('$baseUrl/api/pricing/price-schedules'),
@@ -88,7 +88,7 @@ class AdminPricingService {
       body: jsonEncode(schedule.toJson()),
     );
     _checkStatus(response, 'Failed to create price schedule');
-    return PriceSchedule.fromJson(jsonDecode(response.body)['data']);
+    return PriceSchedule.fromJson(jsonDecode(response.body)['data']['schedule']);
   }
 
   // PUT /api/pricing/price-schedules/:id
@@ -124,6 +124,9 @@ func (h *AuthHandlers) UpdateProfile(c *fiber.Ctx) error {
 	// Remove sensitive fields that shouldn't be updated via this endpoint
 	delete(updates, "passwordHash")
 	delete(updates, "email") // Email changes should be handled separately
+	delete(updates, "tier")  // Loyalty tiers are set by the store
+	delete(updates, "role")  // Roles are granted by the store, never self-assigned
+	delete(updates, "type")  // The user type picks the price lists
 
 	err := h.authService.UpdateUser(userID, updates)
 	if err != nil {
@@ -1,6 +1,10 @@
 package handlers
 
 import (
+	"errors"
+	"strconv"
+	"strings"
+
 	"mercadomio-backend/middleware"
 	"mercadomio-backend/services"
 
@@ -8,13 +12,49 @@ import (
 )
 
 type CartHandlers struct {
-	CartService services.CartService
+	CartService           services.CartService
+	CustomerStatsService  *services.CustomerStatsService
+	CartValidationService *services.CartValidationService
 }
 
-func NewCartHandlers(cartService services.CartService) *CartHandlers {
+func NewCartHandlers(cartService services.CartService, customerStatsService *services.CustomerStatsService, cartValidationService *services.CartValidationService) *CartHandlers {
 	return &CartHandlers{
-		CartService: cartService,
+		CartService:           cartService,
+		CustomerStatsService:  customerStatsService,
+		CartValidationService: cartValidationService,
+	}
+}
+
+// setCartETag exposes the cart version as its ETag, for If-Match on the next
+// write.
+func setCartETag(c *fiber.Ctx, version int64) {
+	c.Set(fiber.HeaderETag, `"`+strconv.FormatInt(version, 10)+`"`)
+}
+
+// cartIfMatch reads the cart version a write expects from If-Match; without
+// the header (or with "*") any version goes.
+func cartIfMatch(c *fiber.Ctx) (int64, error) {
+	tag := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if tag == "" || tag == "*" {
+		return services.AnyCartVersion, nil
+	}
+	version, err := strconv.ParseInt(strings.Trim(tag, `"`), 10, 64)
+	if err != nil || version < 0 {
+		return 0, middleware.BadRequest("invalid If-Match: expected the cart ETag")
 	}
+	return version, nil
+}
+
+// cartWriteError maps a failed cart write to a response: a stale If-Match is
+// 412 Precondition Failed, anything else 400.
+func cartWriteError(err error) error {
+	if errors.Is(err, services.ErrCartVersionMismatch) {
+		return fiber.NewError(fiber.StatusPreconditionFailed, err.Error())
+	}
+	if errors.Is(err, services.ErrCartBusy) {
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	}
+	return middleware.BadRequest(err.Error())
 }
 
 // GetCart handles GET /api/cart/:cartId
@@ -24,19 +64,26 @@ func (h *CartHandlers) GetCart(c *fiber.Ctx) error {
 	if err != nil {
 		return middleware.InternalError(err.Error())
 	}
+	setCartETag(c, cart.Version)
 	return c.JSON(cart)
 }
 
 // AddToCart handles POST /api/cart/:cartId/items
 func (h *CartHandlers) AddToCart(c *fiber.Ctx) error {
 	cartID := c.Params("cartId")
+	ifVersion, err := cartIfMatch(c)
+	if err != nil {
+		return err
+	}
 	var item services.CartItem
 	if err := c.BodyParser(&item); err != nil {
 		return middleware.BadRequest("Invalid input")
 	}
-	if err := h.CartService.AddToCart(c.Context(), cartID, item); err != nil {
-		return middleware.BadRequest(err.Error())
+	cart, err := h.CartService.AddToCart(c.Context(), cartID, item, ifVersion)
+	if err != nil {
+		return cartWriteError(err)
 	}
+	setCartETag(c, cart.Version)
 	return c.SendStatus(201)
 }
 
@@ -45,15 +92,21 @@ func (h *CartHandlers) UpdateCartItem(c *fiber.Ctx) error {
 	cartID := c.Params("cartId")
 	productID := c.Params("productId")
 	variantID := c.Query("variantId", "")
+	ifVersion, err := cartIfMatch(c)
+	if err != nil {
+		return err
+	}
 	var body struct {
 		Quantity int `json:"quantity"`
 	}
 	if err := c.BodyParser(&body); err != nil {
 		return middleware.BadRequest("Invalid input")
 	}
-	if err := h.CartService.UpdateCartItem(c.Context(), cartID, productID, variantID, body.Quantity); err != nil {
-		return middleware.BadRequest(err.Error())
+	cart, err := h.CartService.UpdateCartItem(c.Context(), cartID, productID, variantID, body.Quantity, ifVersion)
+	if err != nil {
+		return cartWriteError(err)
 	}
+	setCartETag(c, cart.Version)
 	return c.SendStatus(204)
 }
 
@@ -62,12 +115,99 @@ func (h *CartHandlers) RemoveFromCart(c *fiber.Ctx) error {
 	cartID := c.Params("cartId")
 	productID := c.Params("productId")
 	variantID := c.Query("variantId", "")
-	if err := h.CartService.RemoveFromCart(c.Context(), cartID, productID, variantID); err != nil {
-		return middleware.BadRequest(err.Error())
+	ifVersion, err := cartIfMatch(c)
+	if err != nil {
+		return err
 	}
+	cart, err := h.CartService.RemoveFromCart(c.Context(), cartID, productID, variantID, ifVersion)
+	if err != nil {
+		return cartWriteError(err)
+	}
+	setCartETag(c, cart.Version)
 	return c.SendStatus(204)
 }
 
+// pricingContext prices for the signed-in customer, with their order history
+// for first-order and loyalty sets; guests get the public prices.
+func (h *CartHandlers) pricingContext(c *fiber.Ctx) services.PricingContext {
+	pc := customerPricing(c)
+	if pc.CustomerID != "" && h.CustomerStatsService != nil {
+		if stats, err := h.CustomerStatsService.GetStats(c.Context(), pc.CustomerID); err == nil {
+			pc.CustomerStats = stats
+		}
+	}
+	return *pc
+}
+
+// priced responds with the cart priced for the caller.
+func (h *CartHandlers) priced(c *fiber.Ctx, cartID string) error {
+	priced, err := h.CartService.PriceCart(c.Context(), cartID, h.pricingContext(c))
+	if err != nil {
+		return middleware.InternalError("failed to price cart: " + err.Error())
+	}
+	setCartETag(c, priced.Version)
+	return middleware.Success(c, priced)
+}
+
+// GetPricedCart handles GET /api/cart/:cartId/priced: the cart with unit
+// prices, discounts, applied sets and totals for the caller, coupon included.
+func (h *CartHandlers) GetPricedCart(c *fiber.Ctx) error {
+	return h.priced(c, c.Params("cartId"))
+}
+
+// ValidateCart handles GET /api/cart/:cartId/validate: the checkout report
+// of the cart, with the issues of each line and of its coupon. "valid" says
+// whether the cart can be ordered as it is.
+func (h *CartHandlers) ValidateCart(c *fiber.Ctx) error {
+	if h.CartValidationService == nil {
+		return middleware.InternalError("cart validation is not configured")
+	}
+	cart, err := h.CartService.GetCart(c.Context(), c.Params("cartId"))
+	if err != nil {
+		return middleware.InternalError(err.Error())
+	}
+	report, err := h.CartValidationService.ValidateCart(c.Context(), cart, h.pricingContext(c))
+	if err != nil {
+		return middleware.InternalError("failed to validate cart: " + err.Error())
+	}
+	setCartETag(c, cart.Version)
+	return middleware.Success(c, report)
+}
+
+// AttachCoupon handles PUT /api/cart/:cartId/coupon and returns the priced
+// cart, so the coupon's effect shows before checkout.
+func (h *CartHandlers) AttachCoupon(c *fiber.Ctx) error {
+	cartID := c.Params("cartId")
+	ifVersion, err := cartIfMatch(c)
+	if err != nil {
+		return err
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return middleware.BadRequest("Invalid input")
+	}
+	if _, err := h.CartService.AttachCoupon(c.Context(), cartID, body.Code, ifVersion); err != nil {
+		return cartWriteError(err)
+	}
+	return h.priced(c, cartID)
+}
+
+// DetachCoupon handles DELETE /api/cart/:cartId/coupon and returns the
+// priced cart.
+func (h *CartHandlers) DetachCoupon(c *fiber.Ctx) error {
+	cartID := c.Params("cartId")
+	ifVersion, err := cartIfMatch(c)
+	if err != nil {
+		return err
+	}
+	if _, err := h.CartService.DetachCoupon(c.Context(), cartID, ifVersion); err != nil {
+		return cartWriteError(err)
+	}
+	return h.priced(c, cartID)
+}
+
 // MergeCarts handles POST /api/cart/merge
 func (h *CartHandlers) MergeCarts(c *fiber.Ctx) error {
 	var body struct {
@@ -77,8 +217,10 @@ func (h *CartHandlers) MergeCarts(c *fiber.Ctx) error {
 	if err := c.BodyParser(&body); err != nil {
 		return middleware.BadRequest("Invalid input")
 	}
-	if err := h.CartService.MergeCarts(c.Context(), body.GuestCartID, body.UserCartID); err != nil {
-		return middleware.BadRequest(err.Error())
+	cart, err := h.CartService.MergeCarts(c.Context(), body.GuestCartID, body.UserCartID)
+	if err != nil {
+		return cartWriteError(err)
 	}
+	setCartETag(c, cart.Version)
 	return c.SendStatus(200)
 }
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"mercadomio-backend/middleware"
 	"mercadomio-backend/models"
 	"mercadomio-backend/services"
@@ -10,16 +11,18 @@ import (
 )
 
 type OrderHandlers struct {
-	orderService   *services.OrderService
-	cartService    services.CartService
-	productService services.ProductService
+	orderService         *services.OrderService
+	cartService          services.CartService
+	productService       services.ProductService
+	customerStatsService *services.CustomerStatsService
 }
 
-func NewOrderHandlers(orderService *services.OrderService, cartService services.CartService, productService services.ProductService) *OrderHandlers {
+func NewOrderHandlers(orderService *services.OrderService, cartService services.CartService, productService services.ProductService, customerStatsService *services.CustomerStatsService) *OrderHandlers {
 	return &OrderHandlers{
-		orderService:   orderService,
-		cartService:    cartService,
-		productService: productService,
+		orderService:         orderService,
+		cartService:          cartService,
+		productService:       productService,
+		customerStatsService: customerStatsService,
 	}
 }
 
@@ -32,10 +35,11 @@ func (h *OrderHandlers) CreateOrder(c *fiber.Ctx) error {
 		return middleware.Unauthorized(c, "authentication required")
 	}
 
-	// Optional pricing context (coupon code, loyalty tier)
+	// Optional coupon code, else the one attached to the cart. The
+	// customer's tier and price lists come from their token, never from the
+	// body.
 	var req struct {
-		CouponCode   string `json:"couponCode"`
-		CustomerTier string `json:"customerTier"`
+		CouponCode string `json:"couponCode"`
 	}
 	_ = c.BodyParser(&req)
 
@@ -52,12 +56,31 @@ func (h *OrderHandlers) CreateOrder(c *fiber.Ctx) error {
 	}
 
 	// Create order from cart
-	priceCtx := &services.PricingContext{
-		CustomerID:   userID,
-		CustomerTier: req.CustomerTier,
-		CouponCode:   req.CouponCode,
+	priceCtx := customerPricing(c)
+	priceCtx.CouponCode = req.CouponCode
+	if priceCtx.CouponCode == "" {
+		priceCtx.CouponCode = cart.CouponCode
+	}
+	// Order history for first-order, win-back and VIP price sets; without
+	// it those sets simply do not apply.
+	if h.customerStatsService != nil {
+		if stats, err := h.customerStatsService.GetStats(c.Context(), userID); err == nil {
+			priceCtx.CustomerStats = stats
+		}
+	}
+	// The order takes over the stock the cart holds; the cart's holds are
+	// released once the order is saved.
+	order, err := h.orderService.CheckoutCart(c.Context(), userID, cart, priceCtx)
+	var invalid *services.CartValidationError
+	if errors.As(err, &invalid) {
+		invalid.Validation.CartID = cartID
+		invalid.Validation.Version = cart.Version
+		return c.Status(fiber.StatusConflict).JSON(middleware.APIResponse{
+			Success: false,
+			Data:    invalid.Validation,
+			Error:   &middleware.APIResponseError{Code: "CART_INVALID", Message: invalid.Error()},
+		})
 	}
-	order, err := h.orderService.CreateOrderFromCart(c.Context(), userID, cart.Items, priceCtx)
 	if err != nil {
 		return middleware.BadRequestResponse(c, "failed to create order: "+err.Error())
 	}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/csv"
+	"io"
 	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"go.mongodb.org/mongo-driver/bson"
 
 	"mercadomio-backend/middleware"
 	"mercadomio-backend/models"
@@ -45,39 +48,170 @@ func (h *PricingHandlers) ListPriceSets(c *fiber.Ctx) error {
 	return middleware.SuccessPaginated(c, sets, int(total), page, limit)
 }
 
-// CreatePriceSet creates a new price set.
+// priceSetDraftRequest is the body of the create endpoint: the new price
+// set, plus an optional "note" for the approver.
+type priceSetDraftRequest struct {
+	models.PriceSet
+	Note string `json:"note"`
+}
+
+// CreatePriceSet files a new price set as a draft. The set is created once
+// another admin approves the draft.
 func (h *PricingHandlers) CreatePriceSet(c *fiber.Ctx) error {
-	var set models.PriceSet
-	if err := c.BodyParser(&set); err != nil {
+	var req priceSetDraftRequest
+	if err := c.BodyParser(&req); err != nil {
 		return middleware.BadRequest("invalid price set payload")
 	}
-	created, err := h.pricingService.CreatePriceSet(c.Context(), &set)
+	draft, err := h.pricingService.CreateNewPriceSetDraft(c.Context(), &req.PriceSet, req.Note, currentUserID(c))
 	if err != nil {
-		return middleware.BadRequest("failed to create price set: " + err.Error())
+		return middleware.BadRequest("failed to create price set draft: " + err.Error())
+	}
+	return middleware.Created(c, draft, "draft created; the price set is created once another admin approves it")
+}
+
+// draftPatch reads an update body: the fields to change, plus an optional
+// "note" for the approver.
+func draftPatch(c *fiber.Ctx) (map[string]interface{}, string, error) {
+	var patch map[string]interface{}
+	if err := c.BodyParser(&patch); err != nil {
+		return nil, "", err
 	}
-	return middleware.Created(c, created, "price set created successfully")
+	note, _ := patch["note"].(string)
+	delete(patch, "note")
+	return patch, note, nil
 }
 
-// UpdatePriceSet updates a price set.
+// UpdatePriceSet files the changed price set as a draft. The live set is
+// unchanged until another admin approves the draft.
 func (h *PricingHandlers) UpdatePriceSet(c *fiber.Ctx) error {
-	id := c.Params("id")
-	var upd map[string]interface{}
-	if err := c.BodyParser(&upd); err != nil {
+	patch, note, err := draftPatch(c)
+	if err != nil {
 		return middleware.BadRequest("invalid price set payload")
 	}
-	if err := h.pricingService.UpdatePriceSet(c.Context(), id, bson.M(upd)); err != nil {
-		return middleware.BadRequest("failed to update price set: " + err.Error())
+	draft, err := h.pricingService.PatchPriceSetDraft(c.Context(), c.Params("id"), patch, note, currentUserID(c))
+	if err != nil {
+		return middleware.BadRequest("failed to create price set draft: " + err.Error())
 	}
-	return middleware.SuccessMessage(c, "price set updated successfully")
+	return middleware.Created(c, draft, "draft created; it goes live once another admin approves it")
 }
 
-// DeletePriceSet deletes a price set.
+// DeletePriceSet files the deletion of a price set as a draft; ?note= is
+// shown to the approver.
 func (h *PricingHandlers) DeletePriceSet(c *fiber.Ctx) error {
-	id := c.Params("id")
-	if err := h.pricingService.DeletePriceSet(c.Context(), id); err != nil {
-		return middleware.BadRequest("failed to delete price set: " + err.Error())
+	draft, err := h.pricingService.DeletePriceSetDraft(c.Context(), c.Params("id"), c.Query("note"), currentUserID(c))
+	if err != nil {
+		return middleware.BadRequest("failed to create price set deletion draft: " + err.Error())
+	}
+	return middleware.Created(c, draft, "draft created; the price set is deleted once another admin approves it")
+}
+
+// ---- Price lists ----
+
+// ListPriceLists returns all price lists (paginated).
+func (h *PricingHandlers) ListPriceLists(c *fiber.Ctx) error {
+	page, limit := pageLimit(c)
+	lists, total, err := h.pricingService.ListPriceLists(c.Context(), page, limit)
+	if err != nil {
+		return middleware.BadRequest("failed to list price lists: " + err.Error())
+	}
+	return middleware.SuccessPaginated(c, lists, int(total), page, limit)
+}
+
+// GetPriceList returns one price list.
+func (h *PricingHandlers) GetPriceList(c *fiber.Ctx) error {
+	list, err := h.pricingService.GetPriceList(c.Context(), c.Params("id"))
+	if err != nil {
+		return middleware.NotFound(err.Error())
+	}
+	return middleware.Success(c, list)
+}
+
+// CreatePriceList creates a new price list.
+func (h *PricingHandlers) CreatePriceList(c *fiber.Ctx) error {
+	var list models.PriceList
+	if err := c.BodyParser(&list); err != nil {
+		return middleware.BadRequest("invalid price list payload")
+	}
+	created, err := h.pricingService.CreatePriceList(c.Context(), &list)
+	if err != nil {
+		return middleware.BadRequest("failed to create price list: " + err.Error())
+	}
+	return middleware.Created(c, created, "price list created successfully")
+}
+
+// UpdatePriceList replaces a price list's name, assignment and prices.
+func (h *PricingHandlers) UpdatePriceList(c *fiber.Ctx) error {
+	var list models.PriceList
+	if err := c.BodyParser(&list); err != nil {
+		return middleware.BadRequest("invalid price list payload")
+	}
+	updated, err := h.pricingService.UpdatePriceList(c.Context(), c.Params("id"), &list)
+	if err != nil {
+		return middleware.BadRequest("failed to update price list: " + err.Error())
+	}
+	return middleware.Success(c, updated, "price list updated successfully")
+}
+
+// DeletePriceList deletes a price list.
+func (h *PricingHandlers) DeletePriceList(c *fiber.Ctx) error {
+	if err := h.pricingService.DeletePriceList(c.Context(), c.Params("id")); err != nil {
+		return middleware.BadRequest("failed to delete price list: " + err.Error())
+	}
+	return middleware.SuccessMessage(c, "price list deleted successfully")
+}
+
+// ---- Coupon codes ----
+
+// generateCouponCodesRequest is the body of GenerateCouponCodes.
+type generateCouponCodesRequest struct {
+	Count   int    `json:"count"`
+	Prefix  string `json:"prefix"`
+	Pattern string `json:"pattern"` // X = letter or digit, # = digit
+}
+
+// GenerateCouponCodes creates single-use coupon codes for a price set.
+func (h *PricingHandlers) GenerateCouponCodes(c *fiber.Ctx) error {
+	var req generateCouponCodesRequest
+	if err := c.BodyParser(&req); err != nil {
+		return middleware.BadRequest("invalid coupon code payload")
+	}
+	codes, err := h.pricingService.GenerateCouponCodes(c.Context(), c.Params("id"), req.Count, req.Prefix, req.Pattern)
+	if err != nil {
+		return middleware.BadRequest("failed to generate coupon codes: " + err.Error())
+	}
+	return middleware.Created(c, codes, strconv.Itoa(len(codes))+" coupon codes generated")
+}
+
+// ExportCouponCodes downloads a price set's coupon codes as CSV.
+func (h *PricingHandlers) ExportCouponCodes(c *fiber.Ctx) error {
+	setID := c.Params("id")
+	codes, err := h.pricingService.ListCouponCodes(c.Context(), setID)
+	if err != nil {
+		return middleware.BadRequest("failed to export coupon codes: " + err.Error())
+	}
+
+	c.Set("Content-Type", "text/csv; charset=utf-8")
+	c.Set("Content-Disposition", `attachment; filename="coupon-codes-`+setID+`.csv"`)
+	w := csv.NewWriter(c.Response().BodyWriter())
+	w.Write([]string{"code", "status", "orderId", "customerId", "createdAt", "redeemedAt"})
+	for _, cc := range codes {
+		redeemedAt := ""
+		if cc.RedeemedAt != nil {
+			redeemedAt = cc.RedeemedAt.Format(time.RFC3339)
+		}
+		w.Write([]string{cc.Code, cc.Status, cc.OrderID, cc.CustomerID, cc.CreatedAt.Format(time.RFC3339), redeemedAt})
 	}
-	return middleware.SuccessMessage(c, "price set deleted successfully")
+	w.Flush()
+	return w.Error()
+}
+
+// GetCouponCodeStatus returns a coupon code's status and redemptions.
+func (h *PricingHandlers) GetCouponCodeStatus(c *fiber.Ctx) error {
+	status, err := h.pricingService.GetCouponCodeStatus(c.Context(), c.Params("code"))
+	if err != nil {
+		return middleware.NotFound(err.Error())
+	}
+	return middleware.Success(c, status)
 }
 
 // ---- PriceSchedules ----
@@ -92,39 +226,147 @@ func (h *PricingHandlers) ListPriceSchedules(c *fiber.Ctx) error {
 	return middleware.SuccessPaginated(c, schedules, int(total), page, limit)
 }
 
-// CreatePriceSchedule creates a new price schedule.
+// priceScheduleDraftRequest is the body of the create endpoint: the new
+// price schedule, plus an optional "note" for the approver.
+type priceScheduleDraftRequest struct {
+	models.PriceSchedule
+	Note string `json:"note"`
+}
+
+// CreatePriceSchedule files a new price schedule as a draft.
 func (h *PricingHandlers) CreatePriceSchedule(c *fiber.Ctx) error {
-	var sch models.PriceSchedule
-	if err := c.BodyParser(&sch); err != nil {
+	var req priceScheduleDraftRequest
+	if err := c.BodyParser(&req); err != nil {
 		return middleware.BadRequest("invalid price schedule payload")
 	}
-	created, err := h.pricingService.CreatePriceSchedule(c.Context(), &sch)
+	draft, err := h.pricingService.CreateNewPriceScheduleDraft(c.Context(), &req.PriceSchedule, req.Note, currentUserID(c))
 	if err != nil {
-		return middleware.BadRequest("failed to create price schedule: " + err.Error())
+		return middleware.BadRequest("failed to create price schedule draft: " + err.Error())
 	}
-	return middleware.Created(c, created, "price schedule created successfully")
+	return middleware.Created(c, draft, "draft created; the price schedule is created once another admin approves it")
 }
 
-// UpdatePriceSchedule updates a price schedule.
+// UpdatePriceSchedule files the changed price schedule as a draft.
 func (h *PricingHandlers) UpdatePriceSchedule(c *fiber.Ctx) error {
-	id := c.Params("id")
-	var upd map[string]interface{}
-	if err := c.BodyParser(&upd); err != nil {
+	patch, note, err := draftPatch(c)
+	if err != nil {
 		return middleware.BadRequest("invalid price schedule payload")
 	}
-	if err := h.pricingService.UpdatePriceSchedule(c.Context(), id, bson.M(upd)); err != nil {
-		return middleware.BadRequest("failed to update price schedule: " + err.Error())
+	draft, err := h.pricingService.PatchPriceScheduleDraft(c.Context(), c.Params("id"), patch, note, currentUserID(c))
+	if err != nil {
+		return middleware.BadRequest("failed to create price schedule draft: " + err.Error())
 	}
-	return middleware.SuccessMessage(c, "price schedule updated successfully")
+	return middleware.Created(c, draft, "draft created; it goes live once another admin approves it")
 }
 
-// DeletePriceSchedule deletes a price schedule.
+// DeletePriceSchedule files the deletion of a price schedule as a draft.
 func (h *PricingHandlers) DeletePriceSchedule(c *fiber.Ctx) error {
-	id := c.Params("id")
-	if err := h.pricingService.DeletePriceSchedule(c.Context(), id); err != nil {
-		return middleware.BadRequest("failed to delete price schedule: " + err.Error())
+	draft, err := h.pricingService.DeletePriceScheduleDraft(c.Context(), c.Params("id"), c.Query("note"), currentUserID(c))
+	if err != nil {
+		return middleware.BadRequest("failed to create price schedule deletion draft: " + err.Error())
+	}
+	return middleware.Created(c, draft, "draft created; the price schedule is deleted once another admin approves it")
+}
+
+// ---- Versions ----
+
+// currentUserID is the authenticated admin, or "" without a token.
+func currentUserID(c *fiber.Ctx) string {
+	id, _ := c.Locals("userID").(string)
+	return id
+}
+
+// customerPricing is the pricing context of the signed-in customer, from
+// their token: their id, their user type for price lists, and their loyalty
+// tier for tier-gated sets. Empty for guests.
+func customerPricing(c *fiber.Ctx) *services.PricingContext {
+	userType, _ := c.Locals("userType").(models.UserType)
+	tier, _ := c.Locals("userTier").(string)
+	return &services.PricingContext{
+		CustomerID:   currentUserID(c),
+		CustomerType: userType,
+		CustomerTier: tier,
 	}
-	return middleware.SuccessMessage(c, "price schedule deleted successfully")
+}
+
+// ListPriceSetVersions returns a price set's drafts and published versions.
+func (h *PricingHandlers) ListPriceSetVersions(c *fiber.Ctx) error {
+	return h.listVersions(c, models.VersionKindSet, c.Params("id"))
+}
+
+// ListPriceScheduleVersions returns a price schedule's drafts and published
+// versions.
+func (h *PricingHandlers) ListPriceScheduleVersions(c *fiber.Ctx) error {
+	return h.listVersions(c, models.VersionKindSchedule, c.Params("id"))
+}
+
+// ListVersions returns versions across all sets and schedules; ?status=draft
+// lists the drafts waiting for approval.
+func (h *PricingHandlers) ListVersions(c *fiber.Ctx) error {
+	return h.listVersions(c, c.Query("kind"), "")
+}
+
+func (h *PricingHandlers) listVersions(c *fiber.Ctx, kind, targetID string) error {
+	versions, err := h.pricingService.ListVersions(c.Context(), kind, targetID, c.Query("status"))
+	if err != nil {
+		return middleware.BadRequest("failed to list versions: " + err.Error())
+	}
+	return middleware.Success(c, versions)
+}
+
+// GetVersion returns one version.
+func (h *PricingHandlers) GetVersion(c *fiber.Ctx) error {
+	v, err := h.pricingService.GetVersion(c.Context(), c.Params("id"))
+	if err != nil {
+		return middleware.NotFound(err.Error())
+	}
+	return middleware.Success(c, v)
+}
+
+// ApproveVersion publishes a draft; the approver must be an admin other
+// than its author.
+func (h *PricingHandlers) ApproveVersion(c *fiber.Ctx) error {
+	v, err := h.pricingService.ApproveVersion(c.Context(), c.Params("id"), currentUserID(c))
+	if err != nil {
+		return middleware.BadRequest("failed to approve version: " + err.Error())
+	}
+	return middleware.Success(c, v, "version published")
+}
+
+// DiscardVersion drops a draft.
+func (h *PricingHandlers) DiscardVersion(c *fiber.Ctx) error {
+	if err := h.pricingService.DiscardVersion(c.Context(), c.Params("id")); err != nil {
+		return middleware.BadRequest("failed to discard version: " + err.Error())
+	}
+	return middleware.SuccessMessage(c, "draft discarded")
+}
+
+// revertRequest is the body of the revert endpoints.
+type revertRequest struct {
+	Version int `json:"version"`
+}
+
+// RevertPriceSet files an earlier version of a price set as a draft.
+func (h *PricingHandlers) RevertPriceSet(c *fiber.Ctx) error {
+	return h.revert(c, models.VersionKindSet)
+}
+
+// RevertPriceSchedule files an earlier version of a price schedule as a
+// draft.
+func (h *PricingHandlers) RevertPriceSchedule(c *fiber.Ctx) error {
+	return h.revert(c, models.VersionKindSchedule)
+}
+
+func (h *PricingHandlers) revert(c *fiber.Ctx, kind string) error {
+	var req revertRequest
+	if err := c.BodyParser(&req); err != nil {
+		return middleware.BadRequest("invalid revert payload")
+	}
+	v, err := h.pricingService.RevertVersion(c.Context(), kind, c.Params("id"), req.Version, currentUserID(c))
+	if err != nil {
+		return middleware.BadRequest("failed to revert: " + err.Error())
+	}
+	return middleware.Created(c, v, "revert drafted; it goes live once another admin approves it")
 }
 
 // ---- Price history ----
@@ -140,50 +382,264 @@ func (h *PricingHandlers) ListPriceHistory(c *fiber.Ctx) error {
 	return middleware.Success(c, entries)
 }
 
-// ---- Resolution ----
+// ---- Bulk price jobs ----
 
-// ResolvePricesPreview lets admin preview how a set of lines prices with given context.
-func (h *PricingHandlers) ResolvePricesPreview(c *fiber.Ctx) error {
-	var req struct {
-		CouponCode   string `json:"couponCode"`
-		CustomerTier string `json:"customerTier"`
-		Lines        []struct {
-			ProductID string `json:"productID"`
-			VariantID string `json:"variantID"`
-			Quantity  int    `json:"quantity"`
-		} `json:"lines"`
+// ListPriceJobs returns bulk price jobs, newest first (paginated).
+func (h *PricingHandlers) ListPriceJobs(c *fiber.Ctx) error {
+	page, limit := pageLimit(c)
+	jobs, total, err := h.pricingService.ListPriceJobs(c.Context(), page, limit)
+	if err != nil {
+		return middleware.BadRequest("failed to list price jobs: " + err.Error())
+	}
+	return middleware.SuccessPaginated(c, jobs, int(total), page, limit)
+}
+
+// CreatePriceJob previews a bulk price job: a percentage or absolute move
+// over a selector, or explicit rows. Nothing changes until it is applied.
+func (h *PricingHandlers) CreatePriceJob(c *fiber.Ctx) error {
+	var job models.PriceJob
+	if err := c.BodyParser(&job); err != nil {
+		return middleware.BadRequest("invalid price job payload")
+	}
+	job.Skipped = nil
+	created, err := h.pricingService.PreviewPriceJob(c.Context(), &job, currentUserID(c))
+	if err != nil {
+		return middleware.BadRequest("failed to preview price job: " + err.Error())
+	}
+	return middleware.Created(c, created, "price job previewed")
+}
+
+// CreatePriceJobFromCSV previews a "set" job from a CSV upload (multipart
+// field "file", or the raw request body) with sku or productId and price
+// columns. ?name= names the job.
+func (h *PricingHandlers) CreatePriceJobFromCSV(c *fiber.Ctx) error {
+	var body io.Reader = bytes.NewReader(c.Body())
+	if fh, err := c.FormFile("file"); err == nil {
+		f, err := fh.Open()
+		if err != nil {
+			return middleware.BadRequest("failed to read CSV upload")
+		}
+		defer f.Close()
+		body = f
+	}
+	rows, skipped, err := services.ParsePriceJobCSV(body)
+	if err != nil {
+		return middleware.BadRequest("invalid price CSV: " + err.Error())
+	}
+	job := models.PriceJob{Name: c.Query("name"), Operation: models.PriceJobSet, Rows: rows, Skipped: skipped}
+	created, err := h.pricingService.PreviewPriceJob(c.Context(), &job, currentUserID(c))
+	if err != nil {
+		return middleware.BadRequest("failed to preview price job: " + err.Error())
+	}
+	return middleware.Created(c, created, "price job previewed")
+}
+
+// GetPriceJob returns a price job with its changes.
+func (h *PricingHandlers) GetPriceJob(c *fiber.Ctx) error {
+	job, err := h.pricingService.GetPriceJob(c.Context(), c.Params("id"))
+	if err != nil {
+		return middleware.NotFound(err.Error())
+	}
+	return middleware.Success(c, job)
+}
+
+// ApplyPriceJob writes a previewed job's prices, approved by the signed-in
+// admin.
+func (h *PricingHandlers) ApplyPriceJob(c *fiber.Ctx) error {
+	job, err := h.pricingService.ApplyPriceJob(c.Context(), c.Params("id"), currentUserID(c))
+	if err != nil {
+		return middleware.BadRequest("failed to apply price job: " + err.Error())
+	}
+	return middleware.Success(c, job)
+}
+
+// RollbackPriceJob restores the prices an applied job changed.
+func (h *PricingHandlers) RollbackPriceJob(c *fiber.Ctx) error {
+	job, err := h.pricingService.RollbackPriceJob(c.Context(), c.Params("id"), currentUserID(c))
+	if err != nil {
+		return middleware.BadRequest("failed to roll back price job: " + err.Error())
+	}
+	return middleware.Success(c, job)
+}
+
+// ---- Settings ----
+
+// GetPricingSettings returns the store-wide pricing settings.
+func (h *PricingHandlers) GetPricingSettings(c *fiber.Ctx) error {
+	settings, err := h.pricingService.GetPricingSettings(c.Context())
+	if err != nil {
+		return middleware.BadRequest("failed to load pricing settings: " + err.Error())
+	}
+	return middleware.Success(c, settings)
+}
+
+// UpdatePricingSettings replaces the store-wide pricing settings.
+func (h *PricingHandlers) UpdatePricingSettings(c *fiber.Ctx) error {
+	var settings models.PricingSettings
+	if err := c.BodyParser(&settings); err != nil {
+		return middleware.BadRequest("invalid pricing settings payload")
+	}
+	if err := h.pricingService.UpdatePricingSettings(c.Context(), &settings); err != nil {
+		return middleware.BadRequest("failed to update pricing settings: " + err.Error())
+	}
+	return middleware.Success(c, settings)
+}
+
+// ---- Price floors ----
+
+// GetClampReport lists the active promotions currently cut short by a
+// product's cost and minimum-margin floor, biggest lost discount first.
+func (h *PricingHandlers) GetClampReport(c *fiber.Ctx) error {
+	report, err := h.pricingService.ClampReport(c.Context())
+	if err != nil {
+		return middleware.BadRequest("failed to build clamp report: " + err.Error())
 	}
+	return middleware.Success(c, report)
+}
+
+// ---- Simulation ----
+
+// simulationRequest is the body of SimulatePricing.
+type simulationRequest struct {
+	Set          *models.PriceSet      `json:"set"`
+	Schedule     *models.PriceSchedule `json:"schedule"`
+	Date         string                `json:"date"` // RFC3339; defaults to now
+	Mode         string                `json:"mode"` // catalog (default) or orders
+	Orders       int                   `json:"orders"`
+	LookbackDays int                   `json:"lookbackDays"`
+	CustomerTier string                `json:"customerTier"`
+}
+
+// SimulatePricing shows what an unsaved price set or schedule would do to
+// the catalog or to recent orders: the products it reprices, the average and
+// largest discount, and the revenue impact against the rules active now.
+func (h *PricingHandlers) SimulatePricing(c *fiber.Ctx) error {
+	var req simulationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return middleware.BadRequest("invalid simulation payload")
+	}
+	sr := services.SimulationRequest{
+		Set:          req.Set,
+		Schedule:     req.Schedule,
+		Mode:         req.Mode,
+		Orders:       req.Orders,
+		LookbackDays: req.LookbackDays,
+		CustomerTier: req.CustomerTier,
+	}
+	if req.Date != "" {
+		date, err := time.Parse(time.RFC3339, req.Date)
+		if err != nil {
+			return middleware.BadRequest("invalid date, expected RFC3339: " + err.Error())
+		}
+		sr.Date = date
+	}
+	sim, err := h.pricingService.Simulate(c.Context(), sr)
+	if err != nil {
+		return middleware.BadRequest("failed to simulate pricing: " + err.Error())
+	}
+	return middleware.Success(c, sim)
+}
+
+// ---- Resolution ----
+
+// pricingRequest is the body shared by the resolve preview and explain
+// endpoints: the lines to price and the context to price them in.
+type pricingRequest struct {
+	CouponCode   string          `json:"couponCode"`
+	CustomerTier string          `json:"customerTier"`
+	CustomerType models.UserType `json:"customerType"` // picks price lists by user type
+	CustomerID   string          `json:"customerId"`
+	Date         string          `json:"date"` // RFC3339; defaults to now
+	Lines        []struct {
+		ProductID string `json:"productID"`
+		VariantID string `json:"variantID"`
+		Quantity  int    `json:"quantity"`
+	} `json:"lines"`
+}
+
+// parsePricingRequest reads a pricingRequest and loads the products it names.
+func (h *PricingHandlers) parsePricingRequest(c *fiber.Ctx) ([]services.PriceInput, services.PricingContext, error) {
+	var req pricingRequest
 	if err := c.BodyParser(&req); err != nil {
-		return middleware.BadRequest("invalid pricing payload")
+		return nil, services.PricingContext{}, middleware.BadRequest("invalid pricing payload")
+	}
+	pc := services.PricingContext{
+		CouponCode:   req.CouponCode,
+		CustomerTier: req.CustomerTier,
+		CustomerType: req.CustomerType,
+		CustomerID:   req.CustomerID,
 	}
+	if req.Date != "" {
+		date, err := time.Parse(time.RFC3339, req.Date)
+		if err != nil {
+			return nil, pc, middleware.BadRequest("invalid date, expected RFC3339: " + err.Error())
+		}
+		pc.Date = date
+	}
+
 	ctx := c.Context()
 	// Enrich lines with products so the engine has base prices/scopes.
 	inputs := make([]services.PriceInput, 0, len(req.Lines))
 	for _, line := range req.Lines {
 		product, err := h.pricingService.GetProductForPrice(ctx, line.ProductID)
 		if err != nil {
-			return middleware.BadRequest("product not found: " + line.ProductID)
+			return nil, pc, middleware.BadRequest("product not found: " + line.ProductID)
 		}
-		in := services.PriceInput{Product: product, Quantity: line.Quantity}
-		if line.VariantID != "" {
-			for i := range product.Variants {
-				if product.Variants[i].VariantID == line.VariantID {
-					in.Variant = &product.Variants[i]
-					break
-				}
-			}
+		in := services.PriceInput{Product: product, Variant: product.FindVariant(line.VariantID), Quantity: line.Quantity}
+		if line.VariantID != "" && in.Variant == nil {
+			return nil, pc, middleware.BadRequest("variant not found: " + line.VariantID)
 		}
 		if in.Quantity <= 0 {
 			in.Quantity = 1
 		}
 		inputs = append(inputs, in)
 	}
-	result, err := h.pricingService.ResolvePrices(ctx, inputs, services.PricingContext{
-		CouponCode:   req.CouponCode,
-		CustomerTier: req.CustomerTier,
-	})
+	return inputs, pc, nil
+}
+
+// ResolvePricesPreview lets admin preview how a set of lines prices with given context.
+// Lines covered by a tiered rule carry their quantity-break table (Tiers) and
+// the break their quantity landed in (ActiveTier). With ?activations=N the
+// result also lists the next N windows of each recurring set and schedule
+// that could touch the lines, starting from the request date.
+func (h *PricingHandlers) ResolvePricesPreview(c *fiber.Ctx) error {
+	inputs, pc, err := h.parsePricingRequest(c)
+	if err != nil {
+		return err
+	}
+	result, err := h.pricingService.ResolvePrices(c.Context(), inputs, pc)
 	if err != nil {
 		return middleware.BadRequest("failed to resolve prices: " + err.Error())
 	}
+	if n, _ := strconv.Atoi(c.Query("activations")); n > 0 {
+		from := pc.Date
+		if from.IsZero() {
+			from = time.Now()
+		}
+		if n > 50 {
+			n = 50
+		}
+		result.Activations, err = h.pricingService.NextActivations(c.Context(), inputs, from, n)
+		if err != nil {
+			return middleware.BadRequest("failed to list activations: " + err.Error())
+		}
+	}
+	return middleware.Success(c, result)
+}
+
+// ExplainPrices resolves like ResolvePricesPreview and attaches a trace to
+// every line: each schedule and set considered, whether it matched, why it
+// was rejected (scope, window, coupon, tier, usage cap, stop rule), and the
+// running unit price after each step.
+func (h *PricingHandlers) ExplainPrices(c *fiber.Ctx) error {
+	inputs, pc, err := h.parsePricingRequest(c)
+	if err != nil {
+		return err
+	}
+	pc.Explain = true
+	result, err := h.pricingService.ResolvePrices(c.Context(), inputs, pc)
+	if err != nil {
+		return middleware.BadRequest("failed to explain prices: " + err.Error())
+	}
 	return middleware.Success(c, result)
 }
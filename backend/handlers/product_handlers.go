@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"context"
 	"log"
 	"math"
 	"strconv"
@@ -18,65 +17,119 @@ type ProductHandlers struct {
 	SearchService    services.SearchService
 	AnalyticsService services.AnalyticsService
 	PricingService   *services.PricingService
+	TaxService       *services.TaxService
 }
 
-func NewProductHandlers(productService services.ProductService, searchService services.SearchService, analyticsService services.AnalyticsService, pricingService *services.PricingService) *ProductHandlers {
+func NewProductHandlers(productService services.ProductService, searchService services.SearchService, analyticsService services.AnalyticsService, pricingService *services.PricingService, taxService *services.TaxService) *ProductHandlers {
 	return &ProductHandlers{
 		ProductService:   productService,
 		SearchService:    searchService,
 		AnalyticsService: analyticsService,
 		PricingService:   pricingService,
+		TaxService:       taxService,
 	}
 }
 
+// catalogTaxIncluded reports whether catalog prices are shown with taxes:
+// ?taxIncluded=true|false, else the tax service's display setting.
+func (h *ProductHandlers) catalogTaxIncluded(c *fiber.Ctx) bool {
+	if h.TaxService == nil {
+		return false
+	}
+	if v, err := strconv.ParseBool(c.Query("taxIncluded")); err == nil {
+		return v
+	}
+	return h.TaxService.DisplayTaxIncluded()
+}
+
 // enrichCatalogPrices resolves each product's effective price through the
 // pricing engine (schedules + active price sets) and attaches the derived
 // price, discount percent, and unit to the product's customAttributes.
 //
 // The engine is the single source of truth for pricing: the catalog only
 // carries base prices, while discounts come from PriceSchedules/PriceSets.
+// Every variant is priced from its own base (BasePrice + PriceAdjustment) and
+// exposed under "variantPrices"; the first variant drives effectivePrice.
+// With taxIncluded, prices carry the product's taxes ("taxIncluded" says which).
+// A signed-in customer sees the prices of their price lists and tier.
 // None of the attached values are persisted — they are computed per-request.
 // If resolution fails, the product is left untouched (never fail a read).
-func (h *ProductHandlers) enrichCatalogPrices(ctx context.Context, products []*services.Product) {
+func (h *ProductHandlers) enrichCatalogPrices(c *fiber.Ctx, products []*services.Product, taxIncluded bool) {
 	if h.PricingService == nil || len(products) == 0 {
 		return
 	}
 
 	inputs := make([]services.PriceInput, 0, len(products))
-	for _, product := range products {
-		in := services.PriceInput{Product: product, Quantity: 1}
-		if len(product.Variants) > 0 {
-			in.Variant = &product.Variants[0]
+	owners := make([]int, 0, len(products))
+	for pi, product := range products {
+		if len(product.Variants) == 0 {
+			inputs = append(inputs, services.PriceInput{Product: product, Quantity: 1})
+			owners = append(owners, pi)
+			continue
+		}
+		for vi := range product.Variants {
+			inputs = append(inputs, services.PriceInput{Product: product, Variant: &product.Variants[vi], Quantity: 1})
+			owners = append(owners, pi)
 		}
-		inputs = append(inputs, in)
 	}
 
-	result, err := h.PricingService.ResolvePrices(ctx, inputs, services.PricingContext{})
+	pc := customerPricing(c)
+	pc.UnitPricesOnly = true
+	result, err := h.PricingService.ResolvePrices(c.Context(), inputs, *pc)
 	if err != nil {
 		log.Printf("pricing enrichment skipped for %d products: %v", len(products), err)
 		return
 	}
 
 	for i, line := range result.Lines {
-		if i >= len(products) {
+		if i >= len(owners) {
 			break
 		}
-		applyCatalogPrice(products[i], line)
+		applyCatalogPrice(products[owners[i]], line, taxIncluded)
+	}
+}
+
+// showAvailability sets each variant's Available: its stock minus what
+// orders and carts hold.
+func showAvailability(products []*services.Product) {
+	for _, product := range products {
+		for i := range product.Variants {
+			available := product.Available(&product.Variants[i])
+			product.Variants[i].Available = &available
+		}
 	}
 }
 
 // applyCatalogPrice writes the resolved unit price, discount percent, and unit
-// label into a product's customAttributes (transient, per-request).
-func applyCatalogPrice(product *services.Product, line services.PricedLine) {
+// label into a product's customAttributes (transient, per-request). Lines are
+// applied in variant order; only the first one sets the headline price.
+func applyCatalogPrice(product *services.Product, line services.PricedLine, taxIncluded bool) {
 	if product.CustomAttributes == nil {
 		product.CustomAttributes = map[string]interface{}{}
 	}
+	product.CustomAttributes["taxIncluded"] = taxIncluded
+	price := line.UnitPrice
+	if taxIncluded {
+		price = price.Add(line.Tax)
+	}
+
+	if line.VariantID != "" {
+		prices, _ := product.CustomAttributes["variantPrices"].(map[string]float64)
+		if prices == nil {
+			prices = map[string]float64{}
+			product.CustomAttributes["variantPrices"] = prices
+		}
+		prices[line.VariantID] = price.Float()
+		if len(prices) > 1 {
+			return
+		}
+	}
 
-	product.CustomAttributes["effectivePrice"] = line.UnitPrice
+	product.CustomAttributes["effectivePrice"] = price.Float()
 
 	discountPct := 0.0
-	if line.BasePrice > 0 {
-		discountPct = math.Round((line.BasePrice - line.UnitPrice) / line.BasePrice * 100)
+	if line.BasePrice.Amount > 0 {
+		discountPct = math.Round(float64(line.BasePrice.Amount-line.UnitPrice.Amount) / float64(line.BasePrice.Amount) * 100)
 	}
 	product.CustomAttributes["discountPercent"] = discountPct
 
@@ -146,7 +199,8 @@ func (h *ProductHandlers) GetProducts(c *fiber.Ctx) error {
 		if err != nil {
 			return middleware.InternalError("Failed to search products")
 		}
-		h.enrichCatalogPrices(c.Context(), productsOf(result.Data))
+		h.enrichCatalogPrices(c, productsOf(result.Data), h.catalogTaxIncluded(c))
+		showAvailability(productsOf(result.Data))
 
 		return c.JSON(fiber.Map{
 			"data":  result.Data,
@@ -162,7 +216,8 @@ func (h *ProductHandlers) GetProducts(c *fiber.Ctx) error {
 	if err != nil {
 		return middleware.InternalError("Failed to fetch products")
 	}
-	h.enrichCatalogPrices(c.Context(), productsOf(products))
+	h.enrichCatalogPrices(c, productsOf(products), h.catalogTaxIncluded(c))
+	showAvailability(productsOf(products))
 
 	return c.JSON(fiber.Map{
 		"data":  products,
@@ -180,7 +235,8 @@ func (h *ProductHandlers) GetProduct(c *fiber.Ctx) error {
 	if err != nil {
 		return middleware.NotFound("Product not found")
 	}
-	h.enrichCatalogPrices(c.Context(), []*services.Product{product})
+	h.enrichCatalogPrices(c, []*services.Product{product}, h.catalogTaxIncluded(c))
+	showAvailability([]*services.Product{product})
 
 	return c.JSON(product)
 }
@@ -7,6 +7,7 @@ import (
 	"mercadomio-backend/routes"
 	"mercadomio-backend/services"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -60,8 +61,11 @@ func main() {
 	// Initialize Services
 	categoryService := services.NewCategoryService(db)
 	productService := services.NewProductService(db, categoryService)
+	productService.SetEventBus(eventBus)
 	searchService := services.NewSearchService(db, categoryService)
 	cartConfig := services.NewCartConfig()
+	// Carts hold stock from add-to-cart when enabled; checkout always does.
+	cartConfig.HoldStock = os.Getenv("CART_HOLD_STOCK") == "true"
 	cartAnalyticsConfig := services.NewCartAnalyticsConfig()
 
 	// Initialize cart service with event bus
@@ -77,9 +81,51 @@ func main() {
 	orderService := services.NewOrderService(db)
 	orderService.SetProductService(productService)
 
+	// Initialize Inventory Service (stock holds for checkouts and carts)
+	inventoryService := services.NewInventoryService(db)
+	orderService.SetInventoryService(inventoryService)
+	cartService.SetInventoryService(inventoryService)
+
 	// Initialize Pricing Service
 	pricingService := services.NewPricingService(db, productService)
+	pricingService.SetRedis(rdb)
 	orderService.SetPricingService(pricingService)
+	cartService.SetPricingService(pricingService)
+	categoryService.SetPricingService(pricingService)
+
+	// Initialize Cart Validation Service (checkout report, enforced on orders)
+	cartValidationService := services.NewCartValidationService(productService)
+	cartValidationService.SetPricingService(pricingService)
+	cartValidationService.SetInventoryService(inventoryService)
+	orderService.SetCartValidationService(cartValidationService)
+	go orderService.StartExpiryRoutine(time.Minute)
+
+	// Record price history on list price edits and promotion windows
+	pricingService.StartHistory(eventBus)
+	go pricingService.StartHistoryRoutine(time.Minute)
+
+	// Recurring sets and schedules without a timezone run on the store's
+	if tz := os.Getenv("STORE_TIMEZONE"); tz != "" {
+		if err := services.SetStoreTimezone(tz); err != nil {
+			log.Printf("Warning: STORE_TIMEZONE: %v; using %s", err, services.StoreTimezone())
+		}
+	}
+
+	// Initialize Tax Service (IVA/IEPS, applied after pricing)
+	taxService := services.NewTaxService()
+	pricingService.SetTaxService(taxService)
+	// Products from before tax categories are tagged with the default one;
+	// UNTAGGED_PRICES_INCLUDE_TAX=true converts their prices to net as well.
+	pricesIncludeTax, _ := strconv.ParseBool(os.Getenv("UNTAGGED_PRICES_INCLUDE_TAX"))
+	if tagged, err := taxService.MigrateProducts(context.Background(), db, pricesIncludeTax); err != nil {
+		log.Printf("Warning: Failed to tag products with a tax category: %v", err)
+	} else if tagged > 0 {
+		log.Printf("Tagged %d products without a tax category as %s (prices converted to net: %t)", tagged, taxService.DefaultCategory(), pricesIncludeTax)
+	}
+
+	// Initialize Customer Stats Service (order history for pricing conditions)
+	customerStatsService := services.NewCustomerStatsService(db, eventBus)
+	orderService.SetEventBus(eventBus)
 
 	// Initialize Payment Service
 	paymentService := services.NewPaymentService(orderService)
@@ -89,6 +135,10 @@ func main() {
 		log.Printf("Warning: Failed to start analytics service: %v", err)
 	}
 
+	if err := customerStatsService.Start(); err != nil {
+		log.Printf("Warning: Failed to start customer stats service: %v", err)
+	}
+
 	// Set up Fiber app with error handling
 	app := fiber.New(fiber.Config{
 		ErrorHandler: middleware.ErrorHandler(),
@@ -105,6 +155,10 @@ func main() {
 		OrderService:     orderService,
 		PaymentService:   paymentService,
 		PricingService:   pricingService,
+
+		CustomerStatsService:  customerStatsService,
+		TaxService:            taxService,
+		CartValidationService: cartValidationService,
 	}
 
 	routes.SetupRoutes(app, routeDeps)
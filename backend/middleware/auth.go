@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"mercadomio-backend/models"
 	"mercadomio-backend/services"
 	"strings"
 
@@ -43,11 +44,26 @@ func AuthMiddleware(authService *services.AuthService) fiber.Handler {
 		c.Locals("userID", claims.UserID)
 		c.Locals("userEmail", claims.Email)
 		c.Locals("userType", claims.Type)
+		c.Locals("userRole", claims.Role)
+		c.Locals("userTier", claims.Tier)
 
 		return c.Next()
 	}
 }
 
+// AdminMiddleware lets only admins through. It runs after AuthMiddleware.
+func AdminMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if role, _ := c.Locals("userRole").(models.UserRole); role != models.UserRoleAdmin {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"success": false,
+				"message": "Admin access required",
+			})
+		}
+		return c.Next()
+	}
+}
+
 // OptionalAuthMiddleware allows requests with or without authentication
 func OptionalAuthMiddleware(authService *services.AuthService) fiber.Handler {
 	return func(c *fiber.Ctx) error {
@@ -66,6 +82,8 @@ func OptionalAuthMiddleware(authService *services.AuthService) fiber.Handler {
 					c.Locals("userID", claims.UserID)
 					c.Locals("userEmail", claims.Email)
 					c.Locals("userType", claims.Type)
+					c.Locals("userRole", claims.Role)
+					c.Locals("userTier", claims.Tier)
 				}
 			}
 		}
@@ -10,7 +10,8 @@ func SetupCORS() fiber.Handler {
 	return cors.New(cors.Config{
 		AllowOrigins:     "*", // In production, specify exact origins
 		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders:     "Origin,Content-Type,Accept,Authorization",
+		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,If-Match",
+		ExposeHeaders:    "ETag", // cart version, sent back in If-Match
 		AllowCredentials: false,
 	})
 }
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Coupon code statuses.
+const (
+	CouponCodeAvailable = "available"
+	CouponCodeReserved  = "reserved" // held by a pending order
+	CouponCodeRedeemed  = "redeemed"
+)
+
+// CouponCode is a generated single-use code that redeems one PriceSet.
+type CouponCode struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	SetID      string             `json:"setId" bson:"setId"`
+	Code       string             `json:"code" bson:"code"`
+	Status     string             `json:"status" bson:"status"`
+	OrderID    string             `json:"orderId,omitempty" bson:"orderId,omitempty"`
+	CustomerID string             `json:"customerId,omitempty" bson:"customerId,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  time.Time          `json:"updatedAt" bson:"updatedAt"`
+	RedeemedAt *time.Time         `json:"redeemedAt,omitempty" bson:"redeemedAt,omitempty"`
+}
+
+// CouponRedemption records one order's use of a coupon code. Its status
+// follows the order's usage reservation: held, committed or released.
+type CouponRedemption struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	CodeID     primitive.ObjectID `json:"codeId" bson:"codeId"`
+	Code       string             `json:"code" bson:"code"`
+	SetID      string             `json:"setId" bson:"setId"`
+	OrderID    string             `json:"orderId" bson:"orderId"`
+	CustomerID string             `json:"customerId,omitempty" bson:"customerId,omitempty"`
+	Status     string             `json:"status" bson:"status"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// CouponCodeStatus is a code together with its redemption history.
+type CouponCodeStatus struct {
+	CouponCode  `bson:",inline"`
+	Redemptions []CouponRedemption `json:"redemptions"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// CustomerStats is a per-customer projection of paid orders, kept up to date
+// from order events and used by behaviour-based price conditions.
+type CustomerStats struct {
+	CustomerID    string     `json:"customerId" bson:"_id"`
+	OrderCount    int        `json:"orderCount" bson:"orderCount"`
+	LifetimeSpend Money      `json:"lifetimeSpend" bson:"lifetimeSpend"`
+	FirstOrderAt  *time.Time `json:"firstOrderAt,omitempty" bson:"firstOrderAt,omitempty"`
+	LastOrderAt   *time.Time `json:"lastOrderAt,omitempty" bson:"lastOrderAt,omitempty"`
+	// PendingOrders counts orders placed but not paid yet. It is read live
+	// with the stats, not stored: a pending order still rules out a first
+	// order.
+	PendingOrders int       `json:"pendingOrders" bson:"-"`
+	UpdatedAt     time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// FirstOrderClaim is the one order a customer may take first-order sets on.
+// It is held while the order awaits payment and settled with the order's set
+// usage: released, it can be claimed again; committed, it is spent for good.
+type FirstOrderClaim struct {
+	CustomerID string    `json:"customerId" bson:"_id"`
+	OrderID    string    `json:"orderId" bson:"orderId"`
+	Status     string    `json:"status" bson:"status"`
+	CreatedAt  time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt" bson:"updatedAt"`
+}
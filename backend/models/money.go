@@ -0,0 +1,282 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// DefaultCurrency is the currency assumed for legacy float amounts and for
+// Money values created without one.
+const DefaultCurrency = "MXN"
+
+// minorUnitsPerMajor is the number of minor units (cents) in one major unit.
+// Every currency we sell in has two decimals.
+const minorUnitsPerMajor = 100
+
+// Money is an exact amount in integer minor units (cents) plus an ISO 4217
+// currency code.
+//
+// JSON keeps the API shape unchanged: a Money encodes as a plain number in
+// major units (12.34). In Mongo it is stored as {amount: <cents>, currency},
+// and documents written before the switch (a bare double such as 12.34) still
+// decode, as MXN.
+//
+// Rounding is always half away from zero to the nearest minor unit, whether
+// converting a float (NewMoney) or taking a percentage (Percent).
+type Money struct {
+	Amount   int64 // minor units
+	Currency string
+}
+
+// NewMoney converts a major-unit float (e.g. a catalog price) to Money in the
+// default currency.
+func NewMoney(major float64) Money {
+	return Money{Amount: int64(math.Round(major * minorUnitsPerMajor)), Currency: DefaultCurrency}
+}
+
+// MoneyFromMinor builds Money from minor units in the default currency.
+func MoneyFromMinor(minor int64) Money {
+	return Money{Amount: minor, Currency: DefaultCurrency}
+}
+
+// Float returns the amount in major units. Use it for display and for
+// comparisons against float configuration, never to do further arithmetic.
+func (m Money) Float() float64 {
+	return float64(m.Amount) / minorUnitsPerMajor
+}
+
+// IsZero reports whether the amount is zero.
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// currencyWith returns the currency for the result of an operation between m
+// and o. The zero Money has no currency and adopts the other operand's.
+func (m Money) currencyWith(o Money) string {
+	if m.Currency != "" {
+		return m.Currency
+	}
+	if o.Currency != "" {
+		return o.Currency
+	}
+	return DefaultCurrency
+}
+
+// Add returns m + o.
+func (m Money) Add(o Money) Money {
+	return Money{Amount: m.Amount + o.Amount, Currency: m.currencyWith(o)}
+}
+
+// Sub returns m - o.
+func (m Money) Sub(o Money) Money {
+	return Money{Amount: m.Amount - o.Amount, Currency: m.currencyWith(o)}
+}
+
+// Mul returns m multiplied by a quantity.
+func (m Money) Mul(qty int) Money {
+	return Money{Amount: m.Amount * int64(qty), Currency: m.currencyWith(m)}
+}
+
+// Div splits m into qty equal parts, rounded half away from zero. The parts
+// do not necessarily add back up to m; use Allocate when they must.
+func (m Money) Div(qty int) Money {
+	if qty == 0 {
+		return m
+	}
+	r := new(big.Rat).SetFrac64(m.Amount, int64(qty))
+	return Money{Amount: roundRat(r), Currency: m.currencyWith(m)}
+}
+
+// Percent returns pct percent of m, rounded half away from zero to the minor
+// unit. The percentage is taken at its decimal value, so 33.3% is exactly
+// 333/1000 rather than the nearest float.
+func (m Money) Percent(pct float64) Money {
+	p, ok := new(big.Rat).SetString(strconv.FormatFloat(pct, 'f', -1, 64))
+	if !ok {
+		return Money{Currency: m.currencyWith(m)}
+	}
+	r := new(big.Rat).SetInt64(m.Amount)
+	r.Mul(r, p)
+	r.Quo(r, big.NewRat(100, 1))
+	return Money{Amount: roundRat(r), Currency: m.currencyWith(m)}
+}
+
+// Min returns the smaller of m and o.
+func (m Money) Min(o Money) Money {
+	if o.Amount < m.Amount {
+		return Money{Amount: o.Amount, Currency: m.currencyWith(o)}
+	}
+	return Money{Amount: m.Amount, Currency: m.currencyWith(o)}
+}
+
+// Allocate splits m across weights in proportion, to the minor unit, so that
+// the parts always sum exactly to m. Leftover minor units go to the parts
+// with the largest remainders; ties go to the earlier part.
+func (m Money) Allocate(weights []int64) []Money {
+	out := make([]Money, len(weights))
+	cur := m.currencyWith(m)
+	var sum int64
+	for _, w := range weights {
+		if w > 0 {
+			sum += w
+		}
+	}
+	for i := range out {
+		out[i] = Money{Currency: cur}
+	}
+	if sum == 0 || len(weights) == 0 {
+		return out
+	}
+
+	sign := int64(1)
+	total := m.Amount
+	if total < 0 {
+		sign, total = -1, -total
+	}
+
+	bigTotal := big.NewInt(total)
+	bigSum := big.NewInt(sum)
+	rems := make([]*big.Int, len(weights))
+	allocated := int64(0)
+	for i, w := range weights {
+		rems[i] = new(big.Int)
+		if w <= 0 {
+			continue
+		}
+		q, r := new(big.Int).QuoRem(new(big.Int).Mul(bigTotal, big.NewInt(w)), bigSum, new(big.Int))
+		out[i].Amount = q.Int64()
+		rems[i] = r
+		allocated += out[i].Amount
+	}
+
+	for left := total - allocated; left > 0; left-- {
+		best := -1
+		for i := range rems {
+			if weights[i] <= 0 {
+				continue
+			}
+			if best < 0 || rems[i].Cmp(rems[best]) > 0 {
+				best = i
+			}
+		}
+		out[best].Amount++
+		rems[best].SetInt64(-1)
+	}
+
+	for i := range out {
+		out[i].Amount *= sign
+	}
+	return out
+}
+
+// String formats m as "12.34 MXN".
+func (m Money) String() string {
+	return m.decimal() + " " + m.currencyWith(m)
+}
+
+// decimal formats the amount in major units with exactly two decimals,
+// without going through float64.
+func (m Money) decimal() string {
+	a := m.Amount
+	sign := ""
+	if a < 0 {
+		sign, a = "-", -a
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, a/minorUnitsPerMajor, a%minorUnitsPerMajor)
+}
+
+// roundRat rounds r to the nearest integer, half away from zero.
+func roundRat(r *big.Rat) int64 {
+	num := new(big.Int).Set(r.Num())
+	den := r.Denom()
+	neg := num.Sign() < 0
+	num.Abs(num)
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Mul(rem, big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if neg {
+		q.Neg(q)
+	}
+	return q.Int64()
+}
+
+// MarshalJSON encodes m as a number in major units.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.decimal()), nil
+}
+
+// UnmarshalJSON accepts a number in major units, or the {amount, currency}
+// object form.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*m = Money{}
+		return nil
+	}
+	if len(data) > 0 && data[0] == '{' {
+		var doc struct {
+			Amount   int64  `json:"amount"`
+			Currency string `json:"currency"`
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		*m = Money{Amount: doc.Amount, Currency: doc.Currency}
+		if m.Currency == "" {
+			m.Currency = DefaultCurrency
+		}
+		return nil
+	}
+	var major float64
+	if err := json.Unmarshal(data, &major); err != nil {
+		return err
+	}
+	*m = NewMoney(major)
+	return nil
+}
+
+// MarshalBSONValue stores m as an embedded {amount, currency} document.
+func (m Money) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(bson.D{
+		{Key: "amount", Value: m.Amount},
+		{Key: "currency", Value: m.currencyWith(m)},
+	})
+}
+
+// UnmarshalBSONValue decodes the embedded document form, and also the legacy
+// bare numbers (major units, MXN) that orders were stored with before Money.
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+	switch t {
+	case bsontype.Null, bsontype.Undefined:
+		*m = Money{}
+	case bsontype.Double:
+		*m = NewMoney(raw.Double())
+	case bsontype.Int32:
+		*m = NewMoney(float64(raw.Int32()))
+	case bsontype.Int64:
+		*m = NewMoney(float64(raw.Int64()))
+	case bsontype.EmbeddedDocument:
+		var doc struct {
+			Amount   int64  `bson:"amount"`
+			Currency string `bson:"currency"`
+		}
+		if err := raw.Unmarshal(&doc); err != nil {
+			return err
+		}
+		*m = Money{Amount: doc.Amount, Currency: doc.Currency}
+		if m.Currency == "" {
+			m.Currency = DefaultCurrency
+		}
+	default:
+		return errors.New("cannot decode money from bson type " + t.String())
+	}
+	return nil
+}
@@ -23,8 +23,12 @@ type OrderItem struct {
 	ProductID primitive.ObjectID `bson:"productId" json:"productId"`
 	VariantID string             `bson:"variantId,omitempty" json:"variantId,omitempty"`
 	Quantity  int                `bson:"quantity" json:"quantity"`
-	Price     float64            `bson:"price" json:"price"`
-	Rebate    float64            `bson:"rebate,omitempty" json:"rebate,omitempty"`
+	Price     Money              `bson:"price" json:"price"`
+	Rebate    Money              `bson:"rebate,omitempty" json:"rebate,omitzero"`
+
+	// Taxes on the line's net total after discounts
+	Tax   Money     `bson:"tax,omitempty" json:"tax,omitzero"`
+	Taxes []TaxLine `bson:"taxes,omitempty" json:"taxes,omitempty"`
 
 	// Denormalized product info for order history
 	ProductName string `bson:"productName,omitempty" json:"productName,omitempty"`
@@ -36,9 +40,11 @@ type Order struct {
 	ID          primitive.ObjectID     `bson:"_id,omitempty" json:"id,omitempty"`
 	UserID      primitive.ObjectID     `bson:"userId" json:"userId"`
 	Items       []OrderItem            `bson:"items" json:"items"`
-	Subtotal    float64                `bson:"subtotal" json:"subtotal"`
-	Discount    float64                `bson:"discount" json:"discount"`
-	Total       float64                `bson:"total" json:"total"`
+	Subtotal    Money                  `bson:"subtotal" json:"subtotal"`
+	Discount    Money                  `bson:"discount" json:"discount"`
+	Tax         Money                  `bson:"tax,omitempty" json:"tax"`
+	Taxes       []TaxLine              `bson:"taxes,omitempty" json:"taxes,omitempty"` // Tax per tax and rate
+	Total       Money                  `bson:"total" json:"total"`                     // subtotal - discount + tax
 	Pricing     map[string]interface{} `bson:"pricing,omitempty" json:"pricing,omitempty"`
 	Status      OrderStatus            `bson:"status" json:"status"`
 	PaymentInfo map[string]interface{} `bson:"paymentInfo,omitempty" json:"paymentInfo,omitempty"`
@@ -53,9 +59,11 @@ type OrderResponse struct {
 	ID          primitive.ObjectID     `json:"id"`
 	UserID      primitive.ObjectID     `json:"userId"`
 	Items       []OrderItem            `json:"items"`
-	Subtotal    float64                `json:"subtotal"`
-	Discount    float64                `json:"discount"`
-	Total       float64                `json:"total"`
+	Subtotal    Money                  `json:"subtotal"`
+	Discount    Money                  `json:"discount"`
+	Tax         Money                  `json:"tax"`
+	Taxes       []TaxLine              `json:"taxes,omitempty"`
+	Total       Money                  `json:"total"`
 	Pricing     map[string]interface{} `json:"pricing,omitempty"`
 	Status      OrderStatus            `json:"status"`
 	PaymentInfo map[string]interface{} `json:"paymentInfo,omitempty"`
@@ -83,6 +91,8 @@ func (o *Order) ToResponse() *OrderResponse {
 		Items:       o.Items,
 		Subtotal:    o.Subtotal,
 		Discount:    o.Discount,
+		Tax:         o.Tax,
+		Taxes:       o.Taxes,
 		Total:       o.Total,
 		Pricing:     o.Pricing,
 		Status:      o.Status,
@@ -123,7 +133,7 @@ func (o *Order) Validate() error {
 	if len(o.Items) == 0 {
 		return fmt.Errorf("at least one item is required")
 	}
-	if o.Total <= 0 {
+	if o.Total.Amount <= 0 {
 		return fmt.Errorf("total must be greater than zero")
 	}
 
@@ -134,7 +144,7 @@ func (o *Order) Validate() error {
 		if item.Quantity <= 0 {
 			return fmt.Errorf("item %d: quantity must be greater than zero", i)
 		}
-		if item.Price < 0 {
+		if item.Price.Amount < 0 {
 			return fmt.Errorf("item %d: price must be non-negative", i)
 		}
 	}
@@ -144,9 +154,9 @@ func (o *Order) Validate() error {
 
 // CalculateTotal recalculates the total from items
 func (o *Order) CalculateTotal() {
-	total := 0.0
+	total := MoneyFromMinor(0)
 	for _, item := range o.Items {
-		total += item.Price * float64(item.Quantity)
+		total = total.Add(item.Price.Mul(item.Quantity))
 	}
 	o.Total = total
 }
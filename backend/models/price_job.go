@@ -0,0 +1,89 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Price job statuses. A job is created as a preview; applying and rolling
+// back are one-way steps.
+const (
+	PriceJobPreview     = "preview"  // diff computed, nothing written
+	PriceJobApplying    = "applying" // batches being written
+	PriceJobApplied     = "applied"
+	PriceJobRollingBack = "rolling_back"
+	PriceJobRolledBack  = "rolled_back"
+)
+
+// Price job operations.
+const (
+	PriceJobPercentage = "percentage" // move each base price by Value percent
+	PriceJobAbsolute   = "absolute"   // move each base price by Value
+	PriceJobSet        = "set"        // new base prices per product, e.g. from a supplier CSV
+)
+
+// PriceJobSelector picks the products a percentage or absolute job touches.
+// Set fields combine with AND; at least one must be set.
+type PriceJobSelector struct {
+	Category   string   `json:"category,omitempty" bson:"category,omitempty"` // id or name; subcategories included
+	Tag        string   `json:"tag,omitempty" bson:"tag,omitempty"`           // matched against customAttributes.tags
+	ProductIDs []string `json:"productIds,omitempty" bson:"productIds,omitempty"`
+}
+
+// PriceJobRow is one line of a "set" job: the product by id or SKU and its
+// new base price. Row is the source line number, for error reporting.
+type PriceJobRow struct {
+	Row       int     `json:"row,omitempty" bson:"row,omitempty"`
+	ProductID string  `json:"productId,omitempty" bson:"productId,omitempty"`
+	SKU       string  `json:"sku,omitempty" bson:"sku,omitempty"`
+	Price     float64 `json:"price" bson:"price"`
+}
+
+// Price job change statuses.
+const (
+	PriceChangePending    = "pending"
+	PriceChangeApplied    = "applied"
+	PriceChangeConflict   = "conflict" // the product's price moved after the preview
+	PriceChangeRolledBack = "rolled_back"
+)
+
+// PriceJobChange is one product's base price move.
+type PriceJobChange struct {
+	ProductID string  `json:"productId" bson:"productId"`
+	SKU       string  `json:"sku" bson:"sku"`
+	Name      string  `json:"name" bson:"name"`
+	OldPrice  float64 `json:"oldPrice" bson:"oldPrice"`
+	NewPrice  float64 `json:"newPrice" bson:"newPrice"`
+	Status    string  `json:"status" bson:"status"`
+}
+
+// PriceJobSkip is a product or CSV row the job leaves out, and why.
+type PriceJobSkip struct {
+	Row       int    `json:"row,omitempty" bson:"row,omitempty"`
+	ProductID string `json:"productId,omitempty" bson:"productId,omitempty"`
+	SKU       string `json:"sku,omitempty" bson:"sku,omitempty"`
+	Reason    string `json:"reason" bson:"reason"`
+}
+
+// PriceJob is a bulk base price update: previewed, then applied in batches
+// once another admin approves it, and reversible as a whole. Its history entries carry the job id as
+// SourceRef.
+type PriceJob struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name         string             `json:"name" bson:"name"`
+	Operation    string             `json:"operation" bson:"operation"`
+	Value        float64            `json:"value,omitempty" bson:"value,omitempty"`
+	Selector     PriceJobSelector   `json:"selector" bson:"selector"`
+	Rows         []PriceJobRow      `json:"rows,omitempty" bson:"-"`
+	Status       string             `json:"status" bson:"status"`
+	Changes      []PriceJobChange   `json:"changes" bson:"changes"`
+	Skipped      []PriceJobSkip     `json:"skipped,omitempty" bson:"skipped,omitempty"`
+	CreatedBy    string             `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+	AppliedBy    string             `json:"appliedBy,omitempty" bson:"appliedBy,omitempty"`
+	RolledBackBy string             `json:"rolledBackBy,omitempty" bson:"rolledBackBy,omitempty"`
+	CreatedAt    time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt    time.Time          `json:"updatedAt" bson:"updatedAt"`
+	AppliedAt    *time.Time         `json:"appliedAt,omitempty" bson:"appliedAt,omitempty"`
+	RolledBackAt *time.Time         `json:"rolledBackAt,omitempty" bson:"rolledBackAt,omitempty"`
+}
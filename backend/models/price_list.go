@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PriceList is a named list of explicit unit prices, for the user types and
+// customers it is assigned to. A listed price replaces the catalog base price
+// (BasePrice + PriceAdjustment) before schedules and sets apply; anything the
+// list does not name falls back to the base price.
+type PriceList struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description,omitempty" bson:"description,omitempty"`
+	Active      bool               `json:"active" bson:"active"`
+	// Lists assigned to the customer win over lists assigned to their user
+	// type; among those, the lowest Priority wins.
+	Priority    int              `json:"priority" bson:"priority"`
+	UserTypes   []UserType       `json:"userTypes,omitempty" bson:"userTypes,omitempty"`
+	CustomerIDs []string         `json:"customerIDs,omitempty" bson:"customerIDs,omitempty"`
+	Prices      []PriceListEntry `json:"prices" bson:"prices"`
+	CreatedAt   time.Time        `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time        `json:"updatedAt" bson:"updatedAt"`
+}
+
+// PriceListEntry is the price of one SKU or variant. A variant entry wins
+// over a SKU entry for the same line.
+type PriceListEntry struct {
+	SKU       string  `json:"sku,omitempty" bson:"sku,omitempty"`
+	VariantID string  `json:"variantId,omitempty" bson:"variantId,omitempty"`
+	Price     float64 `json:"price" bson:"price"`
+}
@@ -14,6 +14,44 @@ type PriceRule struct {
 	ScopeRefs   []string  `json:"scopeRefs,omitempty" bson:"scopeRefs,omitempty"`
 	MaxDiscount float64   `json:"maxDiscount,omitempty" bson:"maxDiscount,omitempty"`
 	Priority    int       `json:"priority" bson:"priority"`
+	// ExactCategory limits a category scope to the listed categories; by
+	// default it also covers their subcategories.
+	ExactCategory bool `json:"exactCategory,omitempty" bson:"exactCategory,omitempty"`
+
+	// Multi-line rule parameters (see RuleKind). BuyQuantity is the number of
+	// paid units per group for buy_x_get_y, the group size for cheapest_free,
+	// and the pool size for a component-less bundle. GetQuantity is the number
+	// of free units per buy_x_get_y group.
+	BuyQuantity int               `json:"buyQuantity,omitempty" bson:"buyQuantity,omitempty"`
+	GetQuantity int               `json:"getQuantity,omitempty" bson:"getQuantity,omitempty"`
+	Components  []BundleComponent `json:"components,omitempty" bson:"components,omitempty"`
+
+	// Tiers holds the quantity breaks of a tiered rule.
+	Tiers []PriceTier `json:"tiers,omitempty" bson:"tiers,omitempty"`
+}
+
+// PriceTier is one quantity break of a tiered rule: a line whose quantity
+// falls within [MinQuantity, MaxQuantity] is priced at UnitPrice per unit.
+// A zero MaxQuantity leaves the tier open-ended.
+type PriceTier struct {
+	MinQuantity int     `json:"minQuantity" bson:"minQuantity"`
+	MaxQuantity int     `json:"maxQuantity,omitempty" bson:"maxQuantity,omitempty"`
+	UnitPrice   float64 `json:"unitPrice" bson:"unitPrice"`
+}
+
+// Contains reports whether qty falls within the tier.
+func (t PriceTier) Contains(qty int) bool {
+	return qty >= t.MinQuantity && (t.MaxQuantity == 0 || qty <= t.MaxQuantity)
+}
+
+// BundleComponent is one slot of a bundle rule: Quantity units matching the
+// scope are consumed per bundle.
+type BundleComponent struct {
+	Scope     RuleScope `json:"scope" bson:"scope"`
+	ScopeRefs []string  `json:"scopeRefs,omitempty" bson:"scopeRefs,omitempty"`
+	Quantity  int       `json:"quantity" bson:"quantity"`
+	// ExactCategory: see PriceRule.ExactCategory.
+	ExactCategory bool `json:"exactCategory,omitempty" bson:"exactCategory,omitempty"`
 }
 
 // RuleKind mirrors the standard pricing operators.
@@ -23,8 +61,24 @@ const (
 	RuleKindPercentage RuleKind = "percentage"
 	RuleKindAbsolute   RuleKind = "absolute"
 	RuleKindOverride   RuleKind = "override"
+	RuleKindTiered     RuleKind = "tiered" // unit price picked from Tiers by line quantity
+
+	// Multi-line kinds look across every line in scope rather than one line
+	// at a time.
+	RuleKindBuyXGetY     RuleKind = "buy_x_get_y"   // every BuyQuantity+GetQuantity units, the cheapest GetQuantity are free
+	RuleKindBundle       RuleKind = "bundle"        // each complete bundle of Components costs Amount
+	RuleKindCheapestFree RuleKind = "cheapest_free" // every BuyQuantity units, the cheapest one is free
 )
 
+// IsMultiLine reports whether the kind groups units across lines.
+func (k RuleKind) IsMultiLine() bool {
+	switch k {
+	case RuleKindBuyXGetY, RuleKindBundle, RuleKindCheapestFree:
+		return true
+	}
+	return false
+}
+
 // RuleScope controls which items a rule targets.
 type RuleScope string
 
@@ -44,26 +98,206 @@ type PriceConditions struct {
 	MinQuantity  int      `json:"minQuantity,omitempty" bson:"minQuantity,omitempty"`
 	CustomerTier string   `json:"customerTier,omitempty" bson:"customerTier,omitempty"`
 	CustomerIDs  []string `json:"customerIDs,omitempty" bson:"customerIDs,omitempty"`
+	// UniqueCodes makes the set redeemable with any of its generated
+	// single-use coupon codes (see CouponCode), besides the shared code.
+	UniqueCodes bool `json:"uniqueCodes,omitempty" bson:"uniqueCodes,omitempty"`
+
+	// Customer behaviour, evaluated against the customer's paid orders.
+	FirstOrder       bool    `json:"firstOrder,omitempty" bson:"firstOrder,omitempty"`             // no paid or pending orders yet
+	MinOrders        int     `json:"minOrders,omitempty" bson:"minOrders,omitempty"`               // at least this many paid orders
+	MaxOrders        int     `json:"maxOrders,omitempty" bson:"maxOrders,omitempty"`               // at most this many paid orders
+	MinLifetimeSpend float64 `json:"minLifetimeSpend,omitempty" bson:"minLifetimeSpend,omitempty"` // total paid at least this
+	InactiveDays     int     `json:"inactiveDays,omitempty" bson:"inactiveDays,omitempty"`         // has ordered, but not in this many days
+}
+
+// NeedsCustomerStats reports whether the conditions depend on order history.
+func (c PriceConditions) NeedsCustomerStats() bool {
+	return c.FirstOrder || c.MinOrders > 0 || c.MaxOrders > 0 || c.MinLifetimeSpend > 0 || c.InactiveDays > 0
+}
+
+// Recurrence limits a set or schedule to recurring windows inside its
+// absolute start/end: some days of the week and/or some times of day, in a
+// given timezone. Empty DaysOfWeek means every day; empty Windows means all
+// day.
+type Recurrence struct {
+	DaysOfWeek []time.Weekday `json:"daysOfWeek,omitempty" bson:"daysOfWeek,omitempty"` // 0 = Sunday
+	Windows    []TimeWindow   `json:"windows,omitempty" bson:"windows,omitempty"`
+	Timezone   string         `json:"timezone,omitempty" bson:"timezone,omitempty"` // IANA name; defaults to the store's
+}
+
+// TimeWindow is a daily window in "HH:MM" local time, start inclusive and end
+// exclusive. An end at or before the start runs past midnight and belongs to
+// the day it starts on.
+type TimeWindow struct {
+	Start string `json:"start" bson:"start"`
+	End   string `json:"end" bson:"end"`
+}
+
+// StackingPolicy controls how the price sets of one stacking group combine.
+type StackingPolicy string
+
+const (
+	// StackingAdditive applies every eligible set of the group, in priority order.
+	StackingAdditive StackingPolicy = "additive"
+	// StackingBestOf applies only the set that takes the most off the cart.
+	StackingBestOf StackingPolicy = "best_of"
+	// StackingFirstMatch applies only the first set, by priority, that applies.
+	StackingFirstMatch StackingPolicy = "first_match"
+)
+
+// StackingDecision records how an exclusive stacking group was resolved: the
+// set that was applied and the alternatives that were not.
+type StackingDecision struct {
+	Group       string                `json:"group" bson:"group"`
+	Policy      StackingPolicy        `json:"policy" bson:"policy"`
+	ChosenSetID string                `json:"chosenSetId,omitempty" bson:"chosenSetId,omitempty"`
+	ChosenName  string                `json:"chosenName,omitempty" bson:"chosenName,omitempty"`
+	Discount    Money                 `json:"discount" bson:"discount"`
+	Rejected    []StackingAlternative `json:"rejected,omitempty" bson:"rejected,omitempty"`
+}
+
+// StackingAlternative is a set of a stacking group that was not applied.
+type StackingAlternative struct {
+	SetID    string `json:"setId" bson:"setId"`
+	Name     string `json:"name" bson:"name"`
+	Discount Money  `json:"discount" bson:"discount"` // what it would have taken off; zero if ineligible
+	Reason   string `json:"reason" bson:"reason"`
+}
+
+// RoundingStrategy shapes resolved unit prices after schedules and after sets.
+type RoundingStrategy string
+
+const (
+	RoundingCents     RoundingStrategy = "cents"      // leave prices at the cent
+	RoundingUp90      RoundingStrategy = "up_90"      // round up to the next .90
+	RoundingUp99      RoundingStrategy = "up_99"      // round up to the next .99
+	RoundingNearest50 RoundingStrategy = "nearest_50" // round to the nearest .00/.50
+)
+
+// Valid reports whether r is a known strategy; empty inherits and is valid.
+func (r RoundingStrategy) Valid() bool {
+	switch r {
+	case "", RoundingCents, RoundingUp90, RoundingUp99, RoundingNearest50:
+		return true
+	}
+	return false
+}
+
+// PricingSettings holds store-wide pricing configuration (a single document).
+type PricingSettings struct {
+	Rounding  RoundingStrategy `json:"rounding" bson:"rounding"` // default for products whose categories set none
+	UpdatedAt time.Time        `json:"updatedAt" bson:"updatedAt"`
+}
+
+// Stages rounding runs at.
+const (
+	RoundingStageSchedules = "schedules"
+	RoundingStageSets      = "sets"
+)
+
+// RoundingStep records one rounding of a line's unit price.
+type RoundingStep struct {
+	Stage    string           `json:"stage" bson:"stage"` // schedules or sets
+	Strategy RoundingStrategy `json:"strategy" bson:"strategy"`
+	Before   Money            `json:"before" bson:"before"`
+	After    Money            `json:"after" bson:"after"`
+}
+
+// Sources of a price clamp.
+const (
+	ClampSourceSchedule = "schedule"
+	ClampSourceSet      = "set"
+)
+
+// PriceClamp records a discount cut short by a line's price floor (cost plus
+// the minimum margin).
+type PriceClamp struct {
+	Source    string `json:"source" bson:"source"` // schedule or set
+	ID        string `json:"id" bson:"id"`
+	Name      string `json:"name" bson:"name"`
+	ProductID string `json:"productId" bson:"productId"`
+	VariantID string `json:"variantId,omitempty" bson:"variantId,omitempty"`
+	Floor     Money  `json:"floor" bson:"floor"`         // unit floor price
+	Requested Money  `json:"requested" bson:"requested"` // discount the promotion asked for on the line
+	Allowed   Money  `json:"allowed" bson:"allowed"`     // discount actually given
+}
+
+// PromotionClamps groups the clamps of one schedule or set.
+type PromotionClamps struct {
+	Source string       `json:"source" bson:"source"`
+	ID     string       `json:"id" bson:"id"`
+	Name   string       `json:"name" bson:"name"`
+	Lost   Money        `json:"lost" bson:"lost"` // requested minus allowed, summed over the clamps
+	Clamps []PriceClamp `json:"clamps" bson:"clamps"`
+}
+
+// Simulation modes.
+const (
+	SimulateCatalog = "catalog" // every product and variant, one unit each
+	SimulateOrders  = "orders"  // the carts of recent orders, repriced
+)
+
+// SimulatedProduct is one product (or variant) a draft would reprice.
+// Prices are per unit; a negative Discount is a price increase.
+type SimulatedProduct struct {
+	ProductID       string  `json:"productId"`
+	VariantID       string  `json:"variantId,omitempty"`
+	Name            string  `json:"name"`
+	SKU             string  `json:"sku"`
+	CurrentPrice    Money   `json:"currentPrice"`
+	DraftPrice      Money   `json:"draftPrice"`
+	Discount        Money   `json:"discount"`
+	DiscountPercent float64 `json:"discountPercent"`
+	Units           int     `json:"units"` // sold in the lookback window, or in the sampled orders
+}
+
+// PricingSimulation compares the active rules with the same rules plus a
+// draft set or schedule. Revenue is weighted by units actually sold: in
+// catalog mode over the lookback window, in orders mode the sampled orders
+// themselves.
+type PricingSimulation struct {
+	Mode                   string             `json:"mode"`
+	Date                   time.Time          `json:"date"`
+	Evaluated              int                `json:"evaluated"` // products/variants, or orders
+	Affected               []SimulatedProduct `json:"affected"`  // biggest discount first
+	AverageDiscount        Money              `json:"averageDiscount"`
+	AverageDiscountPercent float64            `json:"averageDiscountPercent"`
+	MaxDiscount            Money              `json:"maxDiscount"`
+	MaxDiscountPercent     float64            `json:"maxDiscountPercent"`
+	CurrentRevenue         Money              `json:"currentRevenue"`
+	DraftRevenue           Money              `json:"draftRevenue"`
+	RevenueImpact          Money              `json:"revenueImpact"` // draft minus current
 }
 
 // PriceSet is an ordered, condition-gated bundle of price rules.
 type PriceSet struct {
-	ID                 primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Name               string             `json:"name" bson:"name"`
-	Description        string             `json:"description,omitempty" bson:"description,omitempty"`
-	Priority           int                `json:"priority" bson:"priority"`
-	StopFurtherRules   bool               `json:"stopFurtherRules" bson:"stopFurtherRules"`
-	Conditions         PriceConditions    `json:"conditions" bson:"conditions"`
-	Rules              []PriceRule        `json:"rules" bson:"rules"`
-	MaxUses            int                `json:"maxUses,omitempty" bson:"maxUses,omitempty"`
-	UsedCount          int                `json:"usedCount" bson:"usedCount"`
-	MaxUsesPerCustomer int                `json:"maxUsesPerCustomer,omitempty" bson:"maxUsesPerCustomer,omitempty"`
-	CustomerUsage      map[string]int     `json:"customerUsage,omitempty" bson:"customerUsage,omitempty"`
-	Active             bool               `json:"active" bson:"active"`
-	StartsAt           *time.Time         `json:"startsAt,omitempty" bson:"startsAt,omitempty"`
-	EndsAt             *time.Time         `json:"endsAt,omitempty" bson:"endsAt,omitempty"`
-	CreatedAt          time.Time          `json:"createdAt" bson:"createdAt"`
-	UpdatedAt          time.Time          `json:"updatedAt" bson:"updatedAt"`
+	ID               primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name             string             `json:"name" bson:"name"`
+	Description      string             `json:"description,omitempty" bson:"description,omitempty"`
+	Priority         int                `json:"priority" bson:"priority"`
+	StopFurtherRules bool               `json:"stopFurtherRules" bson:"stopFurtherRules"`
+	// StackingGroup puts the set in an exclusivity group; StackingPolicy is
+	// how the group's sets combine. A group takes the policy of its
+	// highest-priority set, and an empty policy means additive.
+	StackingGroup      string          `json:"stackingGroup,omitempty" bson:"stackingGroup,omitempty"`
+	StackingPolicy     StackingPolicy  `json:"stackingPolicy,omitempty" bson:"stackingPolicy,omitempty"`
+	Conditions         PriceConditions `json:"conditions" bson:"conditions"`
+	Rules              []PriceRule     `json:"rules" bson:"rules"`
+	MaxUses            int             `json:"maxUses,omitempty" bson:"maxUses,omitempty"`
+	UsedCount          int             `json:"usedCount" bson:"usedCount"`
+	MaxUsesPerCustomer int             `json:"maxUsesPerCustomer,omitempty" bson:"maxUsesPerCustomer,omitempty"`
+	CustomerUsage      map[string]int  `json:"customerUsage,omitempty" bson:"customerUsage,omitempty"`
+	// Uses held by pending orders; they count against the caps until the
+	// order is paid (moved to UsedCount) or cancelled/expired (released).
+	ReservedCount    int            `json:"reservedCount" bson:"reservedCount"`
+	CustomerReserved map[string]int `json:"customerReserved,omitempty" bson:"customerReserved,omitempty"`
+	Active           bool           `json:"active" bson:"active"`
+	StartsAt         *time.Time     `json:"startsAt,omitempty" bson:"startsAt,omitempty"`
+	EndsAt           *time.Time     `json:"endsAt,omitempty" bson:"endsAt,omitempty"`
+	Recurrence       *Recurrence    `json:"recurrence,omitempty" bson:"recurrence,omitempty"`
+	Version          int            `json:"version" bson:"version"` // published version; see PricingVersion
+	CreatedAt        time.Time      `json:"createdAt" bson:"createdAt"`
+	UpdatedAt        time.Time      `json:"updatedAt" bson:"updatedAt"`
 }
 
 // PriceSchedule applies a time-based price formula to a scope (inflation/market).
@@ -73,12 +307,15 @@ type PriceSchedule struct {
 	Description   string             `json:"description,omitempty" bson:"description,omitempty"`
 	Scope         ScheduleScope      `json:"scope" bson:"scope"`
 	ScopeRefs     []string           `json:"scopeRefs,omitempty" bson:"scopeRefs,omitempty"`
+	ExactCategory bool               `json:"exactCategory,omitempty" bson:"exactCategory,omitempty"` // see PriceRule.ExactCategory
 	Mode          ScheduleMode       `json:"mode" bson:"mode"`
 	Value         float64            `json:"value" bson:"value"`
 	Priority      int                `json:"priority" bson:"priority"`
 	Active        bool               `json:"active" bson:"active"`
 	EffectiveFrom time.Time          `json:"effectiveFrom" bson:"effectiveFrom"`
 	EffectiveTo   *time.Time         `json:"effectiveTo,omitempty" bson:"effectiveTo,omitempty"`
+	Recurrence    *Recurrence        `json:"recurrence,omitempty" bson:"recurrence,omitempty"`
+	Version       int                `json:"version" bson:"version"` // published version; see PricingVersion
 	CreatedAt     time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt     time.Time          `json:"updatedAt" bson:"updatedAt"`
 }
@@ -101,15 +338,44 @@ const (
 )
 
 // PriceHistoryEntry records a single effective price change (audit trail).
+// Sources of a price history entry.
+const (
+	PriceHistorySourceBasePrice = "base_price" // the catalog price was edited
+	PriceHistorySourceSchedule  = "schedule"   // a schedule's window opened or closed
+	PriceHistorySourceSet       = "set"        // a price set's window opened or closed
+	PriceHistorySourceBulkJob   = "bulk_job"   // a bulk price job was applied or rolled back
+)
+
 type PriceHistoryEntry struct {
-	ProductID   string    `json:"productId" bson:"productId"`
-	VariantID   string    `json:"variantId,omitempty" bson:"variantId,omitempty"`
-	OldPrice    float64   `json:"oldPrice" bson:"oldPrice"`
-	NewPrice    float64   `json:"newPrice" bson:"newPrice"`
-	Reason      string    `json:"reason,omitempty" bson:"reason,omitempty"`
-	SourceType  string    `json:"sourceType" bson:"sourceType"`
-	SourceRef   string    `json:"sourceRef,omitempty" bson:"sourceRef,omitempty"`
-	EffectiveAt time.Time `json:"effectiveAt" bson:"effectiveAt"`
+	ProductID  string  `json:"productId" bson:"productId"`
+	VariantID  string  `json:"variantId,omitempty" bson:"variantId,omitempty"`
+	OldPrice   float64 `json:"oldPrice" bson:"oldPrice"`
+	NewPrice   float64 `json:"newPrice" bson:"newPrice"`
+	Reason     string  `json:"reason,omitempty" bson:"reason,omitempty"`
+	SourceType string  `json:"sourceType" bson:"sourceType"`
+	SourceRef  string  `json:"sourceRef,omitempty" bson:"sourceRef,omitempty"`
+	// Rounding lists the roundings that shaped NewPrice (PricedLine.Rounding).
+	Rounding    []RoundingStep `json:"rounding,omitempty" bson:"rounding,omitempty"`
+	EffectiveAt time.Time      `json:"effectiveAt" bson:"effectiveAt"`
+}
+
+// Reservation statuses for PriceSetReservation.
+const (
+	ReservationHeld      = "held"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+)
+
+// PriceSetReservation is one order's hold on one use of a capped price set.
+type PriceSetReservation struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrderID    string             `json:"orderId" bson:"orderId"`
+	SetID      string             `json:"setId" bson:"setId"`
+	CustomerID string             `json:"customerId,omitempty" bson:"customerId,omitempty"`
+	Status     string             `json:"status" bson:"status"`
+	ExpiresAt  time.Time          `json:"expiresAt" bson:"expiresAt"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  time.Time          `json:"updatedAt" bson:"updatedAt"`
 }
 
 // AppliedPriceRule records which set/rule produced a discount (for order Pricing map).
@@ -118,5 +384,18 @@ type AppliedPriceRule struct {
 	SetName  string   `json:"setName" bson:"setName"`
 	Kind     RuleKind `json:"kind" bson:"kind"`
 	Amount   float64  `json:"amount" bson:"amount"`
-	Discount float64  `json:"discount" bson:"discount"`
+	Discount Money    `json:"discount" bson:"discount"`
+	// SetVersion is the published version of the set that was applied.
+	SetVersion int `json:"setVersion,omitempty" bson:"setVersion,omitempty"`
+	// Lines lists the units a multi-line rule consumed, one entry per line.
+	Lines []AppliedLine `json:"lines,omitempty" bson:"lines,omitempty"`
+}
+
+// AppliedLine records how many units of a line a multi-line rule consumed and
+// the share of the discount that landed on that line.
+type AppliedLine struct {
+	ProductID string `json:"productId" bson:"productId"`
+	VariantID string `json:"variantId,omitempty" bson:"variantId,omitempty"`
+	Quantity  int    `json:"quantity" bson:"quantity"`
+	Discount  Money  `json:"discount" bson:"discount"`
 }
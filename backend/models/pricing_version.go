@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of versioned pricing documents.
+const (
+	VersionKindSet      = "set"
+	VersionKindSchedule = "schedule"
+)
+
+// Pricing version statuses. Drafts wait for approval; published versions are
+// never changed again.
+const (
+	VersionDraft     = "draft"
+	VersionPublished = "published"
+	VersionDiscarded = "discarded"
+)
+
+// What publishing a version does to the live document. Versions from before
+// actions were recorded have none and are updates.
+const (
+	VersionActionCreate = "create"
+	VersionActionUpdate = "update"
+	VersionActionDelete = "delete"
+)
+
+// PricingVersion is one version of a price set or schedule: a draft waiting
+// for approval, or an entry in its immutable history. Exactly one of Set and
+// Schedule is filled, with the definition only (no usage counters).
+type PricingVersion struct {
+	ID       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Kind     string             `json:"kind" bson:"kind"`
+	TargetID string             `json:"targetId" bson:"targetId"` // the set or schedule id
+	Action   string             `json:"action,omitempty" bson:"action,omitempty"`
+	// Version is the number the draft got when published; BaseVersion is the
+	// live version the draft was written against.
+	Version      int            `json:"version,omitempty" bson:"version,omitempty"`
+	BaseVersion  int            `json:"baseVersion" bson:"baseVersion"`
+	Status       string         `json:"status" bson:"status"`
+	Set          *PriceSet      `json:"set,omitempty" bson:"set,omitempty"`
+	Schedule     *PriceSchedule `json:"schedule,omitempty" bson:"schedule,omitempty"`
+	Note         string         `json:"note,omitempty" bson:"note,omitempty"`
+	RevertedFrom int            `json:"revertedFrom,omitempty" bson:"revertedFrom,omitempty"` // the version a revert restored
+	CreatedBy    string         `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+	ApprovedBy   string         `json:"approvedBy,omitempty" bson:"approvedBy,omitempty"`
+	CreatedAt    time.Time      `json:"createdAt" bson:"createdAt"`
+	PublishedAt  *time.Time     `json:"publishedAt,omitempty" bson:"publishedAt,omitempty"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StockHold is one holder's hold on units of a variant: an order awaiting
+// payment, or a cart when carts hold stock. While held, the units count
+// against the variant's availability. Status uses the Reservation* values:
+// a hold is committed when its order is paid and released on cancellation
+// or expiry.
+type StockHold struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Holder    string             `json:"holder" bson:"holder"`
+	ProductID string             `json:"productId" bson:"productId"`
+	VariantID string             `json:"variantId" bson:"variantId"`
+	Quantity  int                `json:"quantity" bson:"quantity"`
+	Status    string             `json:"status" bson:"status"`
+	ExpiresAt time.Time          `json:"expiresAt" bson:"expiresAt"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
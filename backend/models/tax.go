@@ -0,0 +1,42 @@
+package models
+
+// Taxes levied on sales in Mexico.
+const (
+	TaxIVA  = "IVA"  // Impuesto al Valor Agregado
+	TaxIEPS = "IEPS" // Impuesto Especial sobre Producción y Servicios
+)
+
+// Built-in tax categories. A product without a tax category is taxed in the
+// store's default category, TaxCategoryGeneral unless configured otherwise.
+const (
+	TaxCategoryGeneral   = "general"      // IVA 16%
+	TaxCategoryZeroRated = "zero_rated"   // IVA 0%: basic foods, medicine
+	TaxCategoryExempt    = "exempt"       // no IVA
+	TaxCategorySnacks    = "ieps_snacks"  // high-calorie foods: IEPS 8% + IVA 16%
+	TaxCategoryBeer      = "ieps_beer"    // beer up to 14°: IEPS 26.5% + IVA 16%
+	TaxCategorySpirits   = "ieps_spirits" // over 20°: IEPS 53% + IVA 16%
+)
+
+// TaxRate is one tax of a category, as a percentage. A compound rate is
+// charged on the net amount plus the taxes before it, as IVA is on IEPS.
+type TaxRate struct {
+	Tax      string  `json:"tax" bson:"tax"`
+	Rate     float64 `json:"rate" bson:"rate"`
+	Compound bool    `json:"compound,omitempty" bson:"compound,omitempty"`
+}
+
+// TaxCategory groups the taxes charged on a kind of product, in the order
+// they apply.
+type TaxCategory struct {
+	Code  string    `json:"code" bson:"code"`
+	Name  string    `json:"name" bson:"name"`
+	Rates []TaxRate `json:"rates" bson:"rates"`
+}
+
+// TaxLine is the amount of one tax charged on a line or an order.
+type TaxLine struct {
+	Tax    string  `json:"tax" bson:"tax"`
+	Rate   float64 `json:"rate" bson:"rate"`
+	Base   Money   `json:"base" bson:"base"`
+	Amount Money   `json:"amount" bson:"amount"`
+}
@@ -14,6 +14,14 @@ const (
 	UserTypeWholesale  UserType = "wholesale"
 )
 
+// UserRole grants staff permissions. Roles are assigned in the database;
+// registration never grants one.
+type UserRole string
+
+const (
+	UserRoleAdmin UserRole = "admin"
+)
+
 // User represents a user in the system
 type User struct {
 	ID               primitive.ObjectID     `bson:"_id,omitempty" json:"id,omitempty"`
@@ -23,6 +31,8 @@ type User struct {
 	OrderHistory     []primitive.ObjectID   `bson:"orderHistory" json:"orderHistory,omitempty"`
 	RebateCredits    float64                `bson:"rebateCredits" json:"rebateCredits,omitempty"`
 	Type             UserType               `bson:"type" json:"type" validate:"required,oneof=individual wholesale"`
+	Role             UserRole               `bson:"role,omitempty" json:"role,omitempty"`
+	Tier             string                 `bson:"tier,omitempty" json:"tier,omitempty"` // loyalty tier (e.g. "gold"), set by the store
 	CustomAttributes map[string]interface{} `bson:"customAttributes,omitempty" json:"customAttributes,omitempty"`
 
 	// New shopping profile features
@@ -55,6 +65,8 @@ type UserResponse struct {
 	Email         string             `json:"email"`
 	Name          string             `json:"name"`
 	Type          UserType           `json:"type"`
+	Role          UserRole           `json:"role,omitempty"`
+	Tier          string             `json:"tier,omitempty"`
 	RebateCredits float64            `json:"rebateCredits"`
 	CreatedAt     time.Time          `json:"createdAt"`
 	UpdatedAt     time.Time          `json:"updatedAt"`
@@ -67,6 +79,8 @@ func (u *User) ToResponse() *UserResponse {
 		Email:         u.Email,
 		Name:          u.Name,
 		Type:          u.Type,
+		Role:          u.Role,
+		Tier:          u.Tier,
 		RebateCredits: u.RebateCredits,
 		CreatedAt:     u.CreatedAt,
 		UpdatedAt:     u.UpdatedAt,
@@ -14,6 +14,10 @@ func SetupCartRoutes(app *fiber.App, cartHandlers *handlers.CartHandlers, authSe
 	// so authentication is optional. When a valid token is present, userID is
 	// still populated in Locals for handlers that want it.
 	app.Get("/api/cart/:cartId", middleware.OptionalAuthMiddleware(authService), cartHandlers.GetCart)
+	app.Get("/api/cart/:cartId/priced", middleware.OptionalAuthMiddleware(authService), cartHandlers.GetPricedCart)
+	app.Get("/api/cart/:cartId/validate", middleware.OptionalAuthMiddleware(authService), cartHandlers.ValidateCart)
+	app.Put("/api/cart/:cartId/coupon", middleware.OptionalAuthMiddleware(authService), cartHandlers.AttachCoupon)
+	app.Delete("/api/cart/:cartId/coupon", middleware.OptionalAuthMiddleware(authService), cartHandlers.DetachCoupon)
 	app.Post("/api/cart/:cartId/items", middleware.OptionalAuthMiddleware(authService), cartHandlers.AddToCart)
 	app.Put("/api/cart/:cartId/items/:productId", middleware.OptionalAuthMiddleware(authService), cartHandlers.UpdateCartItem)
 	app.Delete("/api/cart/:cartId/items/:productId", middleware.OptionalAuthMiddleware(authService), cartHandlers.RemoveFromCart)
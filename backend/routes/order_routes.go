@@ -15,10 +15,12 @@ func SetupOrderRoutes(app *fiber.App, orderHandlers *handlers.OrderHandlers, aut
 	admin.Get("/", orderHandlers.GetOrdersAdmin)
 	admin.Get("/stats", orderHandlers.GetOrderStats)
 
-	// Order API routes
-	app.Get("/api/orders", orderHandlers.GetUserOrders)                // Get user orders
-	app.Post("/api/orders", orderHandlers.CreateOrder)                 // Create new order
-	app.Get("/api/orders/:id", orderHandlers.GetOrder)                 // Get specific order
-	app.Put("/api/orders/:id/status", orderHandlers.UpdateOrderStatus) // Update order status
-	app.Post("/api/orders/:id/payment", orderHandlers.AddPaymentInfo)  // Add payment info
+	// Order API routes. Handlers act for the user in the token, and orders
+	// are priced with that user's type.
+	orders := app.Group("/api/orders", middleware.AuthMiddleware(authService))
+	orders.Get("/", orderHandlers.GetUserOrders)               // Get user orders
+	orders.Post("/", orderHandlers.CreateOrder)                // Create new order
+	orders.Get("/:id", orderHandlers.GetOrder)                 // Get specific order
+	orders.Put("/:id/status", orderHandlers.UpdateOrderStatus) // Update order status
+	orders.Post("/:id/payment", orderHandlers.AddPaymentInfo)  // Add payment info
 }
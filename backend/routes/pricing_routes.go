@@ -4,27 +4,72 @@ import (
 	"github.com/gofiber/fiber/v2"
 
 	"mercadomio-backend/handlers"
+	"mercadomio-backend/middleware"
+	"mercadomio-backend/services"
 )
 
-// SetupPricingRoutes registers price-set, price-schedule and price-history admin endpoints.
-func SetupPricingRoutes(app *fiber.App, pricingHandlers *handlers.PricingHandlers) {
+// SetupPricingRoutes registers price-set, price-list, coupon-code, price-schedule, version and price-history admin endpoints.
+func SetupPricingRoutes(app *fiber.App, pricingHandlers *handlers.PricingHandlers, authService *services.AuthService) {
 	admin := app.Group("/api/pricing")
+	// Drafts, approvals and reverts are attributed to the signed-in admin;
+	// only admins may file, approve or discard drafts.
+	signedIn := middleware.AuthMiddleware(authService)
+	adminOnly := middleware.AdminMiddleware()
 
 	// Price sets
 	admin.Get("/price-sets", pricingHandlers.ListPriceSets)
-	admin.Post("/price-sets", pricingHandlers.CreatePriceSet)
-	admin.Put("/price-sets/:id", pricingHandlers.UpdatePriceSet)
-	admin.Delete("/price-sets/:id", pricingHandlers.DeletePriceSet)
+	admin.Post("/price-sets", signedIn, adminOnly, pricingHandlers.CreatePriceSet)    // files a draft
+	admin.Put("/price-sets/:id", signedIn, adminOnly, pricingHandlers.UpdatePriceSet) // files a draft
+	admin.Get("/price-sets/:id/versions", pricingHandlers.ListPriceSetVersions)
+	admin.Post("/price-sets/:id/revert", signedIn, adminOnly, pricingHandlers.RevertPriceSet) // files a draft
+	admin.Delete("/price-sets/:id", signedIn, adminOnly, pricingHandlers.DeletePriceSet)      // files a draft
+
+	// Price lists (explicit prices by user type or customer)
+	admin.Get("/price-lists", pricingHandlers.ListPriceLists)
+	admin.Post("/price-lists", signedIn, adminOnly, pricingHandlers.CreatePriceList)
+	admin.Get("/price-lists/:id", pricingHandlers.GetPriceList)
+	admin.Put("/price-lists/:id", signedIn, adminOnly, pricingHandlers.UpdatePriceList)
+	admin.Delete("/price-lists/:id", signedIn, adminOnly, pricingHandlers.DeletePriceList)
+
+	// Coupon codes
+	admin.Post("/price-sets/:id/coupon-codes", signedIn, adminOnly, pricingHandlers.GenerateCouponCodes)
+	admin.Get("/price-sets/:id/coupon-codes/export", signedIn, adminOnly, pricingHandlers.ExportCouponCodes)
+	admin.Get("/coupon-codes/:code", pricingHandlers.GetCouponCodeStatus)
 
 	// Price schedules
 	admin.Get("/price-schedules", pricingHandlers.ListPriceSchedules)
-	admin.Post("/price-schedules", pricingHandlers.CreatePriceSchedule)
-	admin.Put("/price-schedules/:id", pricingHandlers.UpdatePriceSchedule)
-	admin.Delete("/price-schedules/:id", pricingHandlers.DeletePriceSchedule)
+	admin.Post("/price-schedules", signedIn, adminOnly, pricingHandlers.CreatePriceSchedule)    // files a draft
+	admin.Put("/price-schedules/:id", signedIn, adminOnly, pricingHandlers.UpdatePriceSchedule) // files a draft
+	admin.Get("/price-schedules/:id/versions", pricingHandlers.ListPriceScheduleVersions)
+	admin.Post("/price-schedules/:id/revert", signedIn, adminOnly, pricingHandlers.RevertPriceSchedule) // files a draft
+	admin.Delete("/price-schedules/:id", signedIn, adminOnly, pricingHandlers.DeletePriceSchedule)      // files a draft
+
+	// Drafts and version history
+	admin.Get("/versions", pricingHandlers.ListVersions)
+	admin.Get("/versions/:id", pricingHandlers.GetVersion)
+	admin.Post("/versions/:id/approve", signedIn, adminOnly, pricingHandlers.ApproveVersion)
+	admin.Post("/versions/:id/discard", signedIn, adminOnly, pricingHandlers.DiscardVersion)
 
 	// Price history
 	admin.Get("/price-history", pricingHandlers.ListPriceHistory)
 
+	// Bulk price jobs: a preview is applied by another admin, like a draft
+	admin.Get("/price-jobs", pricingHandlers.ListPriceJobs)
+	admin.Post("/price-jobs", signedIn, adminOnly, pricingHandlers.CreatePriceJob)
+	admin.Post("/price-jobs/csv", signedIn, adminOnly, pricingHandlers.CreatePriceJobFromCSV)
+	admin.Get("/price-jobs/:id", pricingHandlers.GetPriceJob)
+	admin.Post("/price-jobs/:id/apply", signedIn, adminOnly, pricingHandlers.ApplyPriceJob)
+	admin.Post("/price-jobs/:id/rollback", signedIn, adminOnly, pricingHandlers.RollbackPriceJob)
+
 	// Resolution preview
 	admin.Post("/resolve", pricingHandlers.ResolvePricesPreview)
+	admin.Post("/explain", pricingHandlers.ExplainPrices)
+	admin.Post("/simulate", pricingHandlers.SimulatePricing)
+
+	// Store-wide settings (rounding)
+	admin.Get("/settings", pricingHandlers.GetPricingSettings)
+	admin.Put("/settings", signedIn, adminOnly, pricingHandlers.UpdatePricingSettings)
+
+	// Promotions held back by price floors
+	admin.Get("/clamps", pricingHandlers.GetClampReport)
 }
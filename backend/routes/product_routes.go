@@ -2,15 +2,18 @@ package routes
 
 import (
 	"mercadomio-backend/handlers"
+	"mercadomio-backend/middleware"
+	"mercadomio-backend/services"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 // SetupProductRoutes configures all product-related routes
-func SetupProductRoutes(app *fiber.App, productHandlers *handlers.ProductHandlers) {
-	// Product API routes
-	app.Get("/api/products", productHandlers.GetProducts)
-	app.Get("/api/products/:id", productHandlers.GetProduct)
+func SetupProductRoutes(app *fiber.App, productHandlers *handlers.ProductHandlers, authService *services.AuthService) {
+	// Product API routes. Reads are public; a signed-in customer sees the
+	// prices of their user type.
+	app.Get("/api/products", middleware.OptionalAuthMiddleware(authService), productHandlers.GetProducts)
+	app.Get("/api/products/:id", middleware.OptionalAuthMiddleware(authService), productHandlers.GetProduct)
 	app.Post("/api/products", productHandlers.CreateProduct)
 	app.Put("/api/products/:id", productHandlers.UpdateProduct)
 	app.Delete("/api/products/:id", productHandlers.DeleteProduct)
@@ -35,8 +35,8 @@ func SetupRoutes(app *fiber.App, deps *RouteDependencies) {
 	})
 
 	// Initialize handlers
-	productHandlers := handlers.NewProductHandlers(deps.ProductService, deps.SearchService, deps.AnalyticsService, deps.PricingService)
-	cartHandlers := handlers.NewCartHandlers(deps.CartService)
+	productHandlers := handlers.NewProductHandlers(deps.ProductService, deps.SearchService, deps.AnalyticsService, deps.PricingService, deps.TaxService)
+	cartHandlers := handlers.NewCartHandlers(deps.CartService, deps.CustomerStatsService, deps.CartValidationService)
 	analyticsHandlers := handlers.NewAnalyticsHandlers(deps.AnalyticsService)
 
 	// Initialize Cloudinary configuration
@@ -56,13 +56,13 @@ func SetupRoutes(app *fiber.App, deps *RouteDependencies) {
 	imageHandlers := handlers.NewImageHandlers()
 	categoryHandlers := handlers.NewCategoryHandlers(deps.CategoryService)
 	authHandlers := handlers.NewAuthHandlers(deps.AuthService)
-	orderHandlers := handlers.NewOrderHandlers(deps.OrderService, deps.CartService, deps.ProductService)
+	orderHandlers := handlers.NewOrderHandlers(deps.OrderService, deps.CartService, deps.ProductService, deps.CustomerStatsService)
 	paymentHandlers := handlers.NewPaymentHandlers(deps.PaymentService)
 	paymentRoutes := NewPaymentHandlers(paymentHandlers)
 	pricingHandlers := handlers.NewPricingHandlers(deps.PricingService)
 
 	// Setup routes
-	SetupProductRoutes(app, productHandlers)
+	SetupProductRoutes(app, productHandlers, deps.AuthService)
 	SetupCartRoutes(app, cartHandlers, deps.AuthService)
 	SetupAnalyticsRoutes(app, analyticsHandlers)
 	SetupImageRoutes(app, imageHandlers, cloudinaryHandlers, directusHandlers)
@@ -70,7 +70,7 @@ func SetupRoutes(app *fiber.App, deps *RouteDependencies) {
 	SetupAuthRoutes(app, authHandlers)
 	SetupOrderRoutes(app, orderHandlers, deps.AuthService)
 	SetupPaymentRoutes(app, paymentRoutes)
-	SetupPricingRoutes(app, pricingHandlers)
+	SetupPricingRoutes(app, pricingHandlers, deps.AuthService)
 
 	// Health check endpoint
 	app.Get("/health", func(c *fiber.Ctx) error {
@@ -92,4 +92,8 @@ type RouteDependencies struct {
 	OrderService     *services.OrderService
 	PaymentService   *services.PaymentService
 	PricingService   *services.PricingService
+
+	CustomerStatsService  *services.CustomerStatsService
+	TaxService            *services.TaxService
+	CartValidationService *services.CartValidationService
 }
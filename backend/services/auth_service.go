@@ -27,6 +27,8 @@ type AuthClaims struct {
 	UserID string          `json:"userId"`
 	Email  string          `json:"email"`
 	Type   models.UserType `json:"type"`
+	Role   models.UserRole `json:"role,omitempty"`
+	Tier   string          `json:"tier,omitempty"` // loyalty tier, for tier-gated price sets
 	jwt.RegisteredClaims
 }
 
@@ -207,6 +209,8 @@ func (s *AuthService) generateToken(user *models.User) (string, error) {
 		UserID: user.ID.Hex(),
 		Email:  user.Email,
 		Type:   user.Type,
+		Role:   user.Role,
+		Tier:   user.Tier,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expiration),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
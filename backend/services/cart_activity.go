@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Cart activity lives in Redis so every replica sees the same carts and it
+// survives restarts. Active carts are scored by their last touch (Unix ms) in
+// cartActivityKey; once a cart has been idle for MaxInactiveDuration it is
+// claimed into cartAbandonedKey, and publishing CartAbandoned goes to whoever
+// made the claim. Abandoned carts are deleted once idle for AbandonedCartTTL.
+const (
+	cartActivityKey  = "cart:activity"
+	cartAbandonedKey = "cart:abandoned"
+	cartSweepLockKey = "cart:sweep:lock"
+	// cartSweepLockTTL bounds how long a crashed sweeper blocks the others.
+	cartSweepLockTTL = 10 * time.Minute
+	// cartSweepBatch is how many carts a sweep reads per round trip.
+	cartSweepBatch = 500
+)
+
+// claimAbandonedScript moves a cart from the active to the abandoned set if
+// it is still idle since the cutoff, and returns 1 if it did. A cart touched
+// in the meantime has a newer score and is left alone.
+var claimAbandonedScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score or tonumber(score) > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('ZADD', KEYS[2], score, ARGV[1])
+return 1
+`)
+
+// expireAbandonedScript deletes a cart that is still in the abandoned set
+// and idle since the cutoff, and returns 1 if it did.
+var expireAbandonedScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score or tonumber(score) > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('DEL', KEYS[2])
+return 1
+`)
+
+// releaseLockScript deletes the lock only if this sweeper still holds it.
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// touchActivity queues, on a transaction pipeline, the writes that mark a
+// cart active as of now.
+func touchActivity(ctx context.Context, pipe redis.Pipeliner, cartID string, now time.Time) {
+	pipe.ZAdd(ctx, cartActivityKey, redis.Z{Score: float64(now.UnixMilli()), Member: cartID})
+	pipe.ZRem(ctx, cartAbandonedKey, cartID)
+}
+
+// forgetActivity queues the writes that stop tracking a cart.
+func forgetActivity(ctx context.Context, pipe redis.Pipeliner, cartID string) {
+	pipe.ZRem(ctx, cartActivityKey, cartID)
+	pipe.ZRem(ctx, cartAbandonedKey, cartID)
+}
+
+// SweepCarts publishes CartAbandoned for carts idle longer than
+// MaxInactiveDuration and deletes carts abandoned for AbandonedCartTTL. It
+// runs under a Redis lock, so one replica sweeps at a time; the claim scripts
+// still make each abandonment publish once should two sweeps overlap.
+func (cs *CartServiceImpl) SweepCarts(ctx context.Context, now time.Time) error {
+	token := primitive.NewObjectID().Hex()
+	ok, err := cs.redis.SetNX(ctx, cartSweepLockKey, token, cartSweepLockTTL).Result()
+	if err != nil || !ok {
+		return err // another replica is sweeping
+	}
+	defer releaseLockScript.Run(context.Background(), cs.redis, []string{cartSweepLockKey}, token)
+
+	if err := cs.abandonInactive(ctx, now); err != nil {
+		return err
+	}
+	return cs.expireAbandoned(ctx, now)
+}
+
+// abandonInactive claims every cart idle since now - MaxInactiveDuration and
+// publishes CartAbandoned for the claimed carts that hold items.
+func (cs *CartServiceImpl) abandonInactive(ctx context.Context, now time.Time) error {
+	cutoff := strconv.FormatInt(now.Add(-cs.config.MaxInactiveDuration).UnixMilli(), 10)
+	return cs.eachIdle(ctx, cartActivityKey, cutoff, func(cartID string) error {
+		claimed, err := claimAbandonedScript.Run(ctx, cs.redis, []string{cartActivityKey, cartAbandonedKey}, cartID, cutoff).Int()
+		if err != nil || claimed == 0 {
+			return err
+		}
+		// The cart is claimed: from here on, failures must not count as a
+		// skipped member.
+		cart, err := cs.GetCart(ctx, cartID)
+		if err != nil {
+			log.Printf("cart sweep: %s abandoned but unreadable: %v", cartID, err)
+			return nil
+		}
+		if len(cart.Items) == 0 {
+			return nil
+		}
+		cs.eventBus.Publish(ctx, CartAbandoned{
+			CartID:    cartID,
+			UserID:    cart.UserID,
+			Value:     cs.calculateCartValue(ctx, cart),
+			ItemCount: len(cart.Items),
+			Timestamp: now,
+		})
+		return nil
+	})
+}
+
+// expireAbandoned deletes abandoned carts idle since now - AbandonedCartTTL.
+func (cs *CartServiceImpl) expireAbandoned(ctx context.Context, now time.Time) error {
+	cutoff := strconv.FormatInt(now.Add(-cs.config.AbandonedCartTTL).UnixMilli(), 10)
+	return cs.eachIdle(ctx, cartAbandonedKey, cutoff, func(cartID string) error {
+		return expireAbandonedScript.Run(ctx, cs.redis, []string{cartAbandonedKey, cs.getCartKey(cartID)}, cartID, cutoff).Err()
+	})
+}
+
+// eachIdle calls fn, batch by batch, for the members of a sorted set scored
+// at or before the cutoff. fn takes the member out of the range (claims,
+// deletes, or finds it touched since); members it fails on are skipped and
+// retried on the next sweep.
+func (cs *CartServiceImpl) eachIdle(ctx context.Context, key, cutoff string, fn func(cartID string) error) error {
+	skipped := int64(0)
+	for {
+		ids, err := cs.redis.ZRangeArgs(ctx, redis.ZRangeArgs{
+			Key:     key,
+			Start:   "-inf",
+			Stop:    cutoff,
+			ByScore: true,
+			Offset:  skipped,
+			Count:   cartSweepBatch,
+		}).Result()
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := fn(id); err != nil {
+				log.Printf("cart sweep: %s: %v", id, err)
+				skipped++
+			}
+		}
+		if len(ids) < cartSweepBatch {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// recordingBus counts published events per aggregate.
+type recordingBus struct {
+	mu     sync.Mutex
+	events map[string]int
+}
+
+func (b *recordingBus) Publish(ctx context.Context, event DomainEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events[event.EventType()+" "+event.AggregateID()]++
+	return nil
+}
+
+func (b *recordingBus) Subscribe(string, EventHandler)   {}
+func (b *recordingBus) Unsubscribe(string, EventHandler) {}
+
+func (b *recordingBus) count(eventType, id string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.events[eventType+" "+id]
+}
+
+// catalogStub serves GetProduct from a fixed product; "gone" is missing.
+type catalogStub struct {
+	ProductService
+	product *Product
+}
+
+func (c catalogStub) GetProduct(ctx context.Context, id string) (*Product, error) {
+	if id == "gone" {
+		return nil, errors.New("product not found")
+	}
+	return c.product, nil
+}
+
+func TestCartAbandonmentAcrossReplicas(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	bus := &recordingBus{events: map[string]int{}}
+	catalog := catalogStub{product: &Product{SKU: "ARROZ", BasePrice: 30}}
+
+	// Three pods sharing one Redis.
+	replicas := make([]*CartServiceImpl, 3)
+	for i := range replicas {
+		replicas[i] = &CartServiceImpl{
+			redis:          redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+			productService: catalog,
+			config:         NewCartConfig(),
+			eventBus:       bus,
+		}
+	}
+
+	const carts = 30
+	var wg sync.WaitGroup
+	for i := 0; i < carts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cart := &Cart{ID: fmt.Sprintf("c%d", i), Items: []CartItem{{ProductID: "p", Quantity: 2}}}
+			if err := replicas[i%len(replicas)].SaveCart(ctx, cart); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	// A cart turned into an order is not abandoned.
+	if err := replicas[0].ClearCart(ctx, "c1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every pod sweeps at once; some also claim without the lock, as an
+	// overrunning sweep would.
+	later := time.Now().Add(time.Hour)
+	for _, cs := range replicas {
+		wg.Add(2)
+		go func(cs *CartServiceImpl) {
+			defer wg.Done()
+			if err := cs.SweepCarts(ctx, later); err != nil {
+				t.Error(err)
+			}
+		}(cs)
+		go func(cs *CartServiceImpl) {
+			defer wg.Done()
+			if err := cs.abandonInactive(ctx, later); err != nil {
+				t.Error(err)
+			}
+		}(cs)
+	}
+	wg.Wait()
+
+	for i := 0; i < carts; i++ {
+		want := 1
+		if i == 1 {
+			want = 0
+		}
+		if got := bus.count("cart.abandoned", fmt.Sprintf("c%d", i)); got != want {
+			t.Errorf("cart c%d: expected %d abandonment(s), got %d", i, want, got)
+		}
+	}
+
+	// A cart touched again can be abandoned again; the others are not
+	// republished.
+	if err := replicas[1].SaveCart(ctx, &Cart{ID: "c0", Items: []CartItem{{ProductID: "p", Quantity: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := replicas[2].SweepCarts(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if got := bus.count("cart.abandoned", "c0"); got != 2 {
+		t.Errorf("expected c0 abandoned a second time, got %d", got)
+	}
+	if got := bus.count("cart.abandoned", "c2"); got != 1 {
+		t.Errorf("expected c2 not republished, got %d", got)
+	}
+
+	// While another pod holds the lock, a sweep does nothing.
+	mr.Set(cartSweepLockKey, "other-pod")
+	if err := replicas[0].SweepCarts(ctx, time.Now().Add(8*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if !mr.Exists("cart:c2") {
+		t.Fatal("a sweep without the lock must not delete carts")
+	}
+	mr.Del(cartSweepLockKey)
+
+	// Abandoned carts are deleted after AbandonedCartTTL.
+	if err := replicas[0].SweepCarts(ctx, time.Now().Add(8*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("expected every cart and index deleted, left %v", keys)
+	}
+}
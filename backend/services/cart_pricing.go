@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"mercadomio-backend/models"
+)
+
+// PricedCart is a cart run through the pricing engine: what each line and
+// the whole cart cost for this customer now, with the attached coupon.
+type PricedCart struct {
+	ID           string                    `json:"id"`
+	Version      int64                     `json:"version"`
+	CouponCode   string                    `json:"couponCode,omitempty"`
+	Lines        []PricedCartLine          `json:"lines"`
+	Subtotal     models.Money              `json:"subtotal"`
+	Discount     models.Money              `json:"discount"`
+	Total        models.Money              `json:"total"`
+	Tax          models.Money              `json:"tax"`
+	TotalWithTax models.Money              `json:"totalWithTax"`
+	AppliedSets  []models.AppliedPriceRule `json:"appliedSets,omitempty"`
+	Schedules    []string                  `json:"schedules,omitempty"`
+	// CouponApplied reports whether the attached coupon takes anything off
+	// this cart; CouponDiscount is how much.
+	CouponApplied  bool         `json:"couponApplied"`
+	CouponDiscount models.Money `json:"couponDiscount"`
+	// Unavailable lists items whose product no longer exists; they are left
+	// out of the totals.
+	Unavailable []CartItem `json:"unavailable,omitempty"`
+}
+
+// PricedCartLine is one priced cart line.
+type PricedCartLine struct {
+	ProductID   string                    `json:"productId"`
+	VariantID   string                    `json:"variantId,omitempty"`
+	SKU         string                    `json:"sku"`
+	Name        string                    `json:"name"`
+	ImageURL    string                    `json:"imageUrl,omitempty"`
+	Quantity    int                       `json:"quantity"`
+	BasePrice   models.Money              `json:"basePrice"`
+	PriceList   string                    `json:"priceList,omitempty"`
+	UnitPrice   models.Money              `json:"unitPrice"`
+	Subtotal    models.Money              `json:"subtotal"`
+	Discount    models.Money              `json:"discount"`
+	LineTotal   models.Money              `json:"lineTotal"`
+	Tax         models.Money              `json:"tax"`
+	AppliedSets []models.AppliedPriceRule `json:"appliedSets,omitempty"`
+}
+
+// SetPricingService wires the pricing engine used by PriceCart.
+func (cs *CartServiceImpl) SetPricingService(pricingService *PricingService) {
+	cs.pricingService = pricingService
+}
+
+// AttachCoupon stores a coupon code on the cart; it is priced in by
+// PriceCart and used by the order placed from the cart.
+func (cs *CartServiceImpl) AttachCoupon(ctx context.Context, cartID, code string, ifVersion int64) (*Cart, error) {
+	code = normalizeCouponCode(code)
+	if code == "" {
+		return nil, errors.New("coupon code is required")
+	}
+	return cs.updateCart(ctx, cartID, ifVersion, func(cart *Cart) error {
+		if cart.CouponCode == code {
+			return errCartUnchanged
+		}
+		cart.CouponCode = code
+		return nil
+	})
+}
+
+// DetachCoupon removes the cart's coupon code.
+func (cs *CartServiceImpl) DetachCoupon(ctx context.Context, cartID string, ifVersion int64) (*Cart, error) {
+	return cs.updateCart(ctx, cartID, ifVersion, func(cart *Cart) error {
+		if cart.CouponCode == "" {
+			return errCartUnchanged
+		}
+		cart.CouponCode = ""
+		return nil
+	})
+}
+
+// PriceCart prices a cart for the customer in pc, with the cart's coupon.
+// Nothing is reserved: usage caps and single-use codes are only claimed when
+// the order is placed.
+func (cs *CartServiceImpl) PriceCart(ctx context.Context, cartID string, pc PricingContext) (*PricedCart, error) {
+	if cs.pricingService == nil {
+		return nil, errors.New("pricing is not configured")
+	}
+	cart, err := cs.GetCart(ctx, cartID)
+	if err != nil {
+		return nil, err
+	}
+	out := &PricedCart{ID: cart.ID, Version: cart.Version, CouponCode: cart.CouponCode, Lines: []PricedCartLine{}}
+
+	var inputs []PriceInput
+	for _, item := range cart.Items {
+		product, err := cs.productService.GetProduct(ctx, item.ProductID)
+		if err != nil {
+			out.Unavailable = append(out.Unavailable, item)
+			continue
+		}
+		variant := product.FindVariant(item.VariantID)
+		if item.VariantID != "" && variant == nil {
+			out.Unavailable = append(out.Unavailable, item)
+			continue
+		}
+		inputs = append(inputs, PriceInput{Product: product, Variant: variant, Quantity: item.Quantity})
+	}
+	if len(inputs) == 0 {
+		return out, nil
+	}
+
+	pc.CouponCode = cart.CouponCode
+	res, err := cs.pricingService.ResolvePrices(ctx, inputs, pc)
+	if err != nil {
+		return nil, err
+	}
+	for i, l := range res.Lines {
+		out.Lines = append(out.Lines, PricedCartLine{
+			ProductID:   l.ProductID,
+			VariantID:   l.VariantID,
+			SKU:         l.SKU,
+			Name:        inputs[i].Product.Name,
+			ImageURL:    inputs[i].Product.ImageURL,
+			Quantity:    l.Quantity,
+			BasePrice:   l.BasePrice,
+			PriceList:   l.PriceList,
+			UnitPrice:   l.UnitPrice,
+			Subtotal:    l.Subtotal,
+			Discount:    l.Discount,
+			LineTotal:   l.LineTotal,
+			Tax:         l.Tax,
+			AppliedSets: l.AppliedSets,
+		})
+	}
+	out.Subtotal = res.Subtotal
+	out.Discount = res.Discount
+	out.Total = res.Total
+	out.Tax = res.Tax
+	out.TotalWithTax = res.TotalWithTax
+	out.AppliedSets = res.AppliedSets
+	out.Schedules = res.AppliedScheduleNames
+
+	if cart.CouponCode != "" {
+		// The coupon's effect is the difference to the same cart without it.
+		pc.CouponCode = ""
+		without, err := cs.pricingService.ResolvePrices(ctx, inputs, pc)
+		if err != nil {
+			return nil, err
+		}
+		out.CouponDiscount = without.Total.Sub(res.Total)
+		out.CouponApplied = out.CouponDiscount.Amount > 0
+	}
+	return out, nil
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+)
+
+func TestPriceCartWithCoupon(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	cs := newTestCartService(t, mr)
+
+	summer := models.PriceSet{ID: primitive.NewObjectID(), Name: "verano", Active: true,
+		Conditions: models.PriceConditions{CouponCode: "VERANO"},
+		Rules:      []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 20}}}
+	pricing := &PricingService{}
+	pricing.cache.snap.Store(compileSnapshot([]models.PriceSet{summer}, nil, -1, time.Now()))
+	cs.SetPricingService(pricing)
+
+	if _, err := cs.AddToCart(ctx, "c1", CartItem{ProductID: "p1", Quantity: 2}, AnyCartVersion); err != nil {
+		t.Fatal(err)
+	}
+	// An item whose product was deleted since.
+	if _, err := cs.updateCart(ctx, "c1", AnyCartVersion, func(c *Cart) error {
+		c.Items = append(c.Items, CartItem{ProductID: "gone", Quantity: 1})
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cart, err := cs.AttachCoupon(ctx, "c1", " verano ", AnyCartVersion)
+	if err != nil || cart.CouponCode != "VERANO" {
+		t.Fatalf("expected the normalized coupon stored, got %+v (%v)", cart, err)
+	}
+	priced, err := cs.PriceCart(ctx, "c1", PricingContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(priced.Lines) != 1 || priced.Lines[0].UnitPrice != mxn(24) || len(priced.Lines[0].AppliedSets) != 1 {
+		t.Fatalf("unexpected lines %+v", priced.Lines)
+	}
+	if priced.Subtotal != mxn(60) || priced.Total != mxn(48) || !priced.CouponApplied || priced.CouponDiscount != mxn(12) {
+		t.Errorf("expected 60 - 12 coupon = 48, got %s - %s = %s (applied %v)", priced.Subtotal, priced.CouponDiscount, priced.Total, priced.CouponApplied)
+	}
+	if len(priced.Unavailable) != 1 || priced.Unavailable[0].ProductID != "gone" {
+		t.Errorf("expected the deleted product reported unavailable, got %+v", priced.Unavailable)
+	}
+	if priced.Version != cart.Version {
+		t.Errorf("expected the cart version %d, got %d", cart.Version, priced.Version)
+	}
+
+	// A code that matches nothing is kept, but takes nothing off.
+	if _, err := cs.AttachCoupon(ctx, "c1", "NOPE", AnyCartVersion); err != nil {
+		t.Fatal(err)
+	}
+	priced, _ = cs.PriceCart(ctx, "c1", PricingContext{})
+	if priced.CouponApplied || priced.Total != mxn(60) {
+		t.Errorf("an unknown coupon should not apply, got %s (applied %v)", priced.Total, priced.CouponApplied)
+	}
+
+	if _, err := cs.DetachCoupon(ctx, "c1", AnyCartVersion); err != nil {
+		t.Fatal(err)
+	}
+	priced, _ = cs.PriceCart(ctx, "c1", PricingContext{})
+	if priced.CouponCode != "" || priced.CouponDiscount.Amount != 0 || priced.Total != mxn(60) {
+		t.Errorf("expected no coupon after detaching, got %+v", priced)
+	}
+}
@@ -5,12 +5,34 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"math/rand/v2"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// AnyCartVersion skips the version check of a cart write.
+const AnyCartVersion int64 = -1
+
+// cartWriteRetries bounds how often a cart write is retried after losing a
+// race with another write; retries back off by up to cartRetryBackoff more
+// each time.
+const (
+	cartWriteRetries = 20
+	cartRetryBackoff = 5 * time.Millisecond
+)
+
+var (
+	// ErrCartVersionMismatch is returned when a write names a cart version
+	// (If-Match) that is no longer current.
+	ErrCartVersionMismatch = errors.New("cart was changed by another request; reload it and try again")
+	// ErrCartBusy is returned when a write kept losing races to other writes.
+	ErrCartBusy = errors.New("cart is being changed by other requests; try again")
+)
+
 // CartServiceImpl implements CartService
 type CartServiceImpl struct {
 	redis          *redis.Client
@@ -18,7 +40,8 @@ type CartServiceImpl struct {
 	config         *CartConfig
 	db             *mongo.Database
 	eventBus       EventBus
-	lastActivity   map[string]time.Time // Tracks last activity per cart
+	pricingService *PricingService // prices carts; nil disables PriceCart
+	inventory      *InventoryService
 }
 
 // Ensure CartServiceImpl implements CartService
@@ -32,74 +55,36 @@ func NewCartService(redis *redis.Client, ps ProductService, config *CartConfig,
 		config:         config,
 		db:             db,
 		eventBus:       eventBus,
-		lastActivity:   make(map[string]time.Time),
 	}
 
 	go cs.startCleanupRoutine()
 	return cs
 }
 
-// startCleanupRoutine starts the background cleanup routine
+// startCleanupRoutine sweeps abandoned carts every CleanupInterval. Every
+// replica runs it; SweepCarts makes sure only one sweeps at a time.
 func (cs *CartServiceImpl) startCleanupRoutine() {
 	ticker := time.NewTicker(cs.config.CleanupInterval)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		cs.cleanupExpiredCarts()
-	}
-}
-
-// cleanupExpiredCarts removes expired carts and publishes abandonment events
-func (cs *CartServiceImpl) cleanupExpiredCarts() {
-	ctx := context.Background()
-	now := time.Now()
-
-	for cartID, lastActive := range cs.lastActivity {
-		inactiveDuration := now.Sub(lastActive)
-		var ttl time.Duration
-
-		switch {
-		case inactiveDuration > cs.config.MaxInactiveDuration:
-			ttl = cs.config.AbandonedCartTTL
-		default:
-			ttl = cs.config.ActiveCartTTL
-		}
-
-		if now.Sub(lastActive) > ttl {
-			// Publish cart abandonment event before deletion
-			if inactiveDuration > cs.config.MaxInactiveDuration {
-				cart, err := cs.GetCart(ctx, cartID)
-				if err == nil && len(cart.Items) > 0 {
-					cartValue := cs.calculateCartValue(ctx, cart)
-
-					// Publish abandonment event (non-blocking)
-					event := CartAbandoned{
-						CartID:    cartID,
-						UserID:    cart.UserID,
-						Value:     cartValue,
-						ItemCount: len(cart.Items),
-						Timestamp: now,
-					}
-					cs.eventBus.Publish(ctx, event)
-				}
-			}
-
-			cs.redis.Del(ctx, cs.getCartKey(cartID))
-			delete(cs.lastActivity, cartID)
+		if err := cs.SweepCarts(context.Background(), time.Now()); err != nil {
+			log.Printf("cart sweep failed: %v", err)
 		}
 	}
 }
 
-// updateActivity updates the last activity time for a cart
-func (cs *CartServiceImpl) updateActivity(cartID string) {
-	cs.lastActivity[cartID] = time.Now()
-}
-
 // getCartKey returns the Redis key for a cart
 func (cs *CartServiceImpl) getCartKey(cartID string) string {
 	return "cart:" + cartID
 }
 
+// CartValue returns the list value of a cart's items: each unit at its
+// product's base price plus the variant adjustment, before any pricing rules.
+func (cs *CartServiceImpl) CartValue(ctx context.Context, cart *Cart) float64 {
+	return cs.calculateCartValue(ctx, cart)
+}
+
 // calculateCartValue calculates the total value of items in a cart
 func (cs *CartServiceImpl) calculateCartValue(ctx context.Context, cart *Cart) float64 {
 	if cart == nil || len(cart.Items) == 0 {
@@ -114,15 +99,8 @@ func (cs *CartServiceImpl) calculateCartValue(ctx context.Context, cart *Cart) f
 			continue // Skip items we can't price
 		}
 
-		itemPrice := product.BasePrice
-		// Add variant price adjustment if applicable
-		if item.VariantID != "" {
-			variant, err := cs.productService.GetVariant(ctx, item.ProductID, item.VariantID)
-			if err == nil {
-				itemPrice += variant.PriceAdjustment
-			}
-		}
-
+		// Base price plus the variant's adjustment, same as pricing and orders
+		itemPrice := product.PriceFor(product.FindVariant(item.VariantID))
 		totalValue += itemPrice * float64(item.Quantity)
 	}
 
@@ -131,7 +109,13 @@ func (cs *CartServiceImpl) calculateCartValue(ctx context.Context, cart *Cart) f
 
 // GetCart retrieves a cart by ID
 func (cs *CartServiceImpl) GetCart(ctx context.Context, cartID string) (*Cart, error) {
-	data, err := cs.redis.Get(ctx, cs.getCartKey(cartID)).Result()
+	return cs.readCart(ctx, cs.redis, cartID)
+}
+
+// readCart loads a cart through c, which may be a transaction watching it.
+// A missing cart is an empty one at version 0.
+func (cs *CartServiceImpl) readCart(ctx context.Context, c redis.Cmdable, cartID string) (*Cart, error) {
+	data, err := c.Get(ctx, cs.getCartKey(cartID)).Result()
 	if err == redis.Nil {
 		return &Cart{
 			ID:        cartID,
@@ -151,103 +135,232 @@ func (cs *CartServiceImpl) GetCart(ctx context.Context, cartID string) (*Cart, e
 	return &cart, nil
 }
 
-// SaveCart saves a cart to Redis
-func (cs *CartServiceImpl) SaveCart(ctx context.Context, cart *Cart) error {
-	if cart == nil {
-		return errors.New("cart cannot be nil")
-	}
-	if cart.ID == "" {
-		return errors.New("cart ID cannot be empty")
-	}
-
+// writeCart queues, on a transaction pipeline, the write of a cart at its
+// next version, together with its activity.
+func (cs *CartServiceImpl) writeCart(ctx context.Context, pipe redis.Pipeliner, cart *Cart) error {
+	cart.Version++
 	cart.UpdatedAt = time.Now()
 	data, err := json.Marshal(cart)
 	if err != nil {
 		return err
 	}
+	// The key's own TTL is a backstop; abandoned carts are removed by
+	// SweepCarts.
+	pipe.Set(ctx, cs.getCartKey(cart.ID), data, cs.config.ActiveCartTTL)
+	touchActivity(ctx, pipe, cart.ID, cart.UpdatedAt)
+	return nil
+}
 
-	// Update activity tracking
-	cs.updateActivity(cart.ID)
+// cartStockHolderPrefix marks the stock holds of carts; orders hold stock
+// under their ID.
+const cartStockHolderPrefix = "cart:"
 
-	// Determine TTL based on activity
-	inactiveDuration := time.Since(cs.lastActivity[cart.ID])
-	var ttl time.Duration
+// cartStockHolder names a cart as a stock holder.
+func cartStockHolder(cartID string) string {
+	return cartStockHolderPrefix + cartID
+}
+
+// isCartStockHolder reports whether holder is a cart.
+func isCartStockHolder(holder string) bool {
+	return strings.HasPrefix(holder, cartStockHolderPrefix)
+}
+
+// SetInventoryService sets the inventory carts hold stock in when
+// HoldStock is configured.
+func (cs *CartServiceImpl) SetInventoryService(inventory *InventoryService) {
+	cs.inventory = inventory
+}
 
-	switch {
-	case inactiveDuration > cs.config.MaxInactiveDuration:
-		ttl = cs.config.AbandonedCartTTL
-	default:
-		ttl = cs.config.ActiveCartTTL
+// holdsStock reports whether carts hold the stock of their items.
+func (cs *CartServiceImpl) holdsStock() bool {
+	return cs.inventory != nil && cs.config.HoldStock
+}
+
+// holdCartStock makes the cart's stock holds match its items. It is safe to
+// repeat, so a cart write retried after a race holds the same stock.
+func (cs *CartServiceImpl) holdCartStock(ctx context.Context, cart *Cart) error {
+	if !cs.holdsStock() {
+		return nil
 	}
+	lines := make([]StockLine, 0, len(cart.Items))
+	for _, item := range cart.Items {
+		lines = append(lines, StockLine{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity})
+	}
+	return cs.inventory.HoldStock(ctx, cartStockHolder(cart.ID), lines, time.Now().Add(cs.config.StockHoldTTL))
+}
 
-	return cs.redis.Set(ctx, cs.getCartKey(cart.ID), data, ttl).Err()
+// releaseCartStock gives back the stock a cart holds, when it is cleared or
+// merged away. Checkout hands it over to the order instead
+// (OrderService.CheckoutCart).
+func (cs *CartServiceImpl) releaseCartStock(ctx context.Context, cartID string) error {
+	if !cs.holdsStock() {
+		return nil
+	}
+	return cs.inventory.ReleaseStockHolds(ctx, cartStockHolder(cartID))
 }
 
-// AddToCart adds an item to a cart
-func (cs *CartServiceImpl) AddToCart(ctx context.Context, cartID string, item CartItem) error {
+// errCartUnchanged lets an updateCart callback finish without writing.
+var errCartUnchanged = errors.New("cart unchanged")
+
+// updateCart applies fn to the cart and writes it back as one optimistic
+// transaction: the cart is WATCHed while fn runs, and the whole read-modify-
+// write is retried when another request wrote it in between. With a version
+// other than AnyCartVersion, the cart must still be at that version.
+func (cs *CartServiceImpl) updateCart(ctx context.Context, cartID string, ifVersion int64, fn func(*Cart) error) (*Cart, error) {
 	if cartID == "" {
-		return errors.New("cart ID cannot be empty")
+		return nil, errors.New("cart ID cannot be empty")
 	}
-	if item.Quantity <= 0 {
-		return errors.New("quantity must be positive")
+	var out *Cart
+	held := false // stock was held for a cart that may not have been written
+	txf := func(tx *redis.Tx) error {
+		cart, err := cs.readCart(ctx, tx, cartID)
+		if err != nil {
+			return err
+		}
+		if ifVersion != AnyCartVersion && cart.Version != ifVersion {
+			return ErrCartVersionMismatch
+		}
+		if err := fn(cart); err != nil {
+			if errors.Is(err, errCartUnchanged) {
+				out = cart
+				return nil
+			}
+			return err
+		}
+		held = cs.holdsStock()
+		if err := cs.holdCartStock(ctx, cart); err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return cs.writeCart(ctx, pipe, cart)
+		})
+		out = cart
+		return err
 	}
-
-	// Validate product exists
-	product, err := cs.productService.GetProduct(ctx, item.ProductID)
-	if err != nil {
-		return fmt.Errorf("product validation failed: %w", err)
+	fail := func(err error) (*Cart, error) {
+		if held {
+			cs.reconcileCartStock(context.WithoutCancel(ctx), cartID)
+		}
+		return nil, err
 	}
+	for attempt := 0; attempt < cartWriteRetries; attempt++ {
+		err := cs.redis.Watch(ctx, txf, cs.getCartKey(cartID))
+		if errors.Is(err, redis.TxFailedErr) {
+			if err := backoff(ctx, attempt); err != nil {
+				return fail(err)
+			}
+			continue
+		}
+		if err != nil {
+			return fail(err)
+		}
+		return out, nil
+	}
+	return fail(ErrCartBusy)
+}
 
-	// Validate variant if specified
-	var variant *Variant
-	if item.VariantID != "" {
-		variant, err = cs.productService.GetVariant(ctx, item.ProductID, item.VariantID)
+// reconcileCartStock makes a cart's stock holds match the stored cart again,
+// after a write that changed them did not land. Like a write, it is retried
+// when the cart changes underneath it, so it never undoes a newer write's
+// holds. Failures are only logged; the cart's next write holds again.
+func (cs *CartServiceImpl) reconcileCartStock(ctx context.Context, cartID string) {
+	txf := func(tx *redis.Tx) error {
+		cart, err := cs.readCart(ctx, tx, cartID)
+		if err != nil {
+			return err
+		}
+		if err := cs.holdCartStock(ctx, cart); err != nil {
+			return err
+		}
+		// Fails when the cart was written since it was read.
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Exists(ctx, cs.getCartKey(cartID))
+			return nil
+		})
+		return err
+	}
+	for attempt := 0; attempt < cartWriteRetries; attempt++ {
+		err := cs.redis.Watch(ctx, txf, cs.getCartKey(cartID))
+		if errors.Is(err, redis.TxFailedErr) {
+			if backoff(ctx, attempt) == nil {
+				continue
+			}
+		}
 		if err != nil {
-			return fmt.Errorf("variant validation failed: %w", err)
+			log.Printf("cart %s: reconciling stock holds: %v", cartID, err)
 		}
+		return
 	}
+	log.Printf("cart %s: reconciling stock holds: %v", cartID, ErrCartBusy)
+}
+
+// backoff waits a random while, longer with each attempt, before a cart
+// write is retried, so racing writers spread out.
+func backoff(ctx context.Context, attempt int) error {
+	wait := time.Duration(rand.Int64N(int64(cartRetryBackoff) * int64(attempt+1)))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
 
-	cart, err := cs.GetCart(ctx, cartID)
+// SaveCart replaces a cart's user and items, as a new version.
+func (cs *CartServiceImpl) SaveCart(ctx context.Context, cart *Cart) error {
+	if cart == nil {
+		return errors.New("cart cannot be nil")
+	}
+	saved, err := cs.updateCart(ctx, cart.ID, AnyCartVersion, func(c *Cart) error {
+		c.UserID = cart.UserID
+		c.Items = cart.Items
+		return nil
+	})
 	if err != nil {
 		return err
 	}
+	*cart = *saved
+	return nil
+}
+
+// AddToCart adds an item to a cart
+func (cs *CartServiceImpl) AddToCart(ctx context.Context, cartID string, item CartItem, ifVersion int64) (*Cart, error) {
+	if cartID == "" {
+		return nil, errors.New("cart ID cannot be empty")
+	}
+	if item.Quantity <= 0 {
+		return nil, errors.New("quantity must be positive")
+	}
 
-	// Calculate item value for event
-	itemPrice := product.BasePrice
-	if variant != nil {
-		itemPrice += variant.PriceAdjustment
+	// Validate product exists
+	product, err := cs.productService.GetProduct(ctx, item.ProductID)
+	if err != nil {
+		return nil, fmt.Errorf("product validation failed: %w", err)
 	}
-	itemValue := itemPrice * float64(item.Quantity)
 
-	// Update quantity if item already exists
-	for i, existing := range cart.Items {
-		if existing.ProductID == item.ProductID && existing.VariantID == item.VariantID {
-			cart.Items[i].Quantity += item.Quantity
-			err = cs.SaveCart(ctx, cart)
-			if err != nil {
-				return err
-			}
+	// Validate variant if specified
+	variant := product.FindVariant(item.VariantID)
+	if item.VariantID != "" && variant == nil {
+		return nil, fmt.Errorf("variant validation failed: variant %s not found", item.VariantID)
+	}
 
-			// Publish item added event
-			event := CartItemAdded{
-				CartID:    cartID,
-				ProductID: item.ProductID,
-				VariantID: item.VariantID,
-				UserID:    cart.UserID,
-				Quantity:  item.Quantity,
-				Value:     itemValue,
-				Timestamp: time.Now(),
+	// The customer adds at today's price, whatever the request says.
+	item.Price = product.PriceFor(variant)
+
+	cart, err := cs.updateCart(ctx, cartID, ifVersion, func(cart *Cart) error {
+		// Update quantity if item already exists
+		for i, existing := range cart.Items {
+			if existing.ProductID == item.ProductID && existing.VariantID == item.VariantID {
+				cart.Items[i].Quantity += item.Quantity
+				cart.Items[i].Price = item.Price
+				return nil
 			}
-			cs.eventBus.Publish(ctx, event)
-			return nil
 		}
-	}
-
-	// Add new item
-	cart.Items = append(cart.Items, item)
-	err = cs.SaveCart(ctx, cart)
+		cart.Items = append(cart.Items, item)
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Publish item added event
@@ -257,157 +370,178 @@ func (cs *CartServiceImpl) AddToCart(ctx context.Context, cartID string, item Ca
 		VariantID: item.VariantID,
 		UserID:    cart.UserID,
 		Quantity:  item.Quantity,
-		Value:     itemValue,
+		Value:     item.Price * float64(item.Quantity),
 		Timestamp: time.Now(),
 	}
 	cs.eventBus.Publish(ctx, event)
-	return nil
+	return cart, nil
 }
 
-// RemoveFromCart removes an item from a cart
-func (cs *CartServiceImpl) RemoveFromCart(ctx context.Context, cartID string, productID string, variantID string) error {
-	cart, err := cs.GetCart(ctx, cartID)
+// unitPrice is a line's list price for cart events; 0 when the product is gone.
+func (cs *CartServiceImpl) unitPrice(ctx context.Context, productID, variantID string) float64 {
+	product, err := cs.productService.GetProduct(ctx, productID)
 	if err != nil {
-		return err
+		return 0
 	}
+	return product.PriceFor(product.FindVariant(variantID))
+}
 
-	for i, item := range cart.Items {
-		if item.ProductID == productID && item.VariantID == variantID {
-			// Calculate item value for event
-			product, err := cs.productService.GetProduct(ctx, productID)
-			if err != nil {
-				return fmt.Errorf("product validation failed: %w", err)
-			}
-
-			itemPrice := product.BasePrice
-			if variantID != "" {
-				variant, err := cs.productService.GetVariant(ctx, productID, variantID)
-				if err == nil {
-					itemPrice += variant.PriceAdjustment
-				}
-			}
-			itemValue := itemPrice * float64(item.Quantity)
-
-			// Remove item
-			cart.Items = append(cart.Items[:i], cart.Items[i+1:]...)
-			err = cs.SaveCart(ctx, cart)
-			if err != nil {
-				return err
-			}
-
-			// Publish item removed event
-			event := CartItemRemoved{
-				CartID:    cartID,
-				ProductID: productID,
-				VariantID: variantID,
-				UserID:    cart.UserID,
-				Quantity:  item.Quantity,
-				Value:     itemValue,
-				Timestamp: time.Now(),
+// RemoveFromCart removes an item from a cart
+func (cs *CartServiceImpl) RemoveFromCart(ctx context.Context, cartID string, productID string, variantID string, ifVersion int64) (*Cart, error) {
+	var removed *CartItem
+	cart, err := cs.updateCart(ctx, cartID, ifVersion, func(cart *Cart) error {
+		removed = nil
+		for i, item := range cart.Items {
+			if item.ProductID == productID && item.VariantID == variantID {
+				removed = &item
+				cart.Items = append(cart.Items[:i], cart.Items[i+1:]...)
+				return nil
 			}
-			cs.eventBus.Publish(ctx, event)
-			return nil
 		}
+		return errCartUnchanged
+	})
+	if err != nil || removed == nil {
+		return cart, err
 	}
 
-	return nil
+	// Publish item removed event
+	event := CartItemRemoved{
+		CartID:    cartID,
+		ProductID: productID,
+		VariantID: variantID,
+		UserID:    cart.UserID,
+		Quantity:  removed.Quantity,
+		Value:     cs.unitPrice(ctx, productID, variantID) * float64(removed.Quantity),
+		Timestamp: time.Now(),
+	}
+	cs.eventBus.Publish(ctx, event)
+	return cart, nil
 }
 
 // UpdateCartItem updates the quantity of an item in a cart
-func (cs *CartServiceImpl) UpdateCartItem(ctx context.Context, cartID string, productID string, variantID string, quantity int) error {
+func (cs *CartServiceImpl) UpdateCartItem(ctx context.Context, cartID string, productID string, variantID string, quantity int, ifVersion int64) (*Cart, error) {
 	if quantity <= 0 {
-		return cs.RemoveFromCart(ctx, cartID, productID, variantID)
+		return cs.RemoveFromCart(ctx, cartID, productID, variantID, ifVersion)
 	}
 
-	cart, err := cs.GetCart(ctx, cartID)
-	if err != nil {
-		return err
-	}
-
-	for i, item := range cart.Items {
-		if item.ProductID == productID && item.VariantID == variantID {
-			oldQuantity := item.Quantity
-
-			// Calculate value change for event
-			product, err := cs.productService.GetProduct(ctx, productID)
-			if err != nil {
-				return fmt.Errorf("product validation failed: %w", err)
-			}
-
-			itemPrice := product.BasePrice
-			if variantID != "" {
-				variant, err := cs.productService.GetVariant(ctx, productID, variantID)
-				if err == nil {
-					itemPrice += variant.PriceAdjustment
-				}
-			}
-			valueChange := itemPrice * float64(quantity-oldQuantity)
-
-			// Update quantity
-			cart.Items[i].Quantity = quantity
-			err = cs.SaveCart(ctx, cart)
-			if err != nil {
-				return err
-			}
-
-			// Publish item updated event
-			event := CartItemUpdated{
-				CartID:      cartID,
-				ProductID:   productID,
-				VariantID:   variantID,
-				UserID:      cart.UserID,
-				OldQuantity: oldQuantity,
-				NewQuantity: quantity,
-				ValueChange: valueChange,
-				Timestamp:   time.Now(),
+	oldQuantity := 0
+	cart, err := cs.updateCart(ctx, cartID, ifVersion, func(cart *Cart) error {
+		for i, item := range cart.Items {
+			if item.ProductID == productID && item.VariantID == variantID {
+				oldQuantity = item.Quantity
+				cart.Items[i].Quantity = quantity
+				return nil
 			}
-			cs.eventBus.Publish(ctx, event)
-			return nil
 		}
+		return errors.New("item not found in cart")
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return errors.New("item not found in cart")
+	// Publish item updated event
+	event := CartItemUpdated{
+		CartID:      cartID,
+		ProductID:   productID,
+		VariantID:   variantID,
+		UserID:      cart.UserID,
+		OldQuantity: oldQuantity,
+		NewQuantity: quantity,
+		ValueChange: cs.unitPrice(ctx, productID, variantID) * float64(quantity-oldQuantity),
+		Timestamp:   time.Now(),
+	}
+	cs.eventBus.Publish(ctx, event)
+	return cart, nil
 }
 
 // ClearCart clears all items from a cart
 func (cs *CartServiceImpl) ClearCart(ctx context.Context, cartID string) error {
-	return cs.redis.Del(ctx, cs.getCartKey(cartID)).Err()
-}
-
-// MergeCarts merges a guest cart into a user cart
-func (cs *CartServiceImpl) MergeCarts(ctx context.Context, guestCartID string, userCartID string) error {
-	guestCart, err := cs.GetCart(ctx, guestCartID)
+	_, err := cs.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, cs.getCartKey(cartID))
+		forgetActivity(ctx, pipe, cartID)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
+	return cs.releaseCartStock(ctx, cartID)
+}
 
-	userCart, err := cs.GetCart(ctx, userCartID)
-	if err != nil {
-		return err
+// MergeCarts merges a guest cart into a user cart. Both carts are watched,
+// so the merge and the removal of the guest cart happen together or not at
+// all.
+func (cs *CartServiceImpl) MergeCarts(ctx context.Context, guestCartID string, userCartID string) (*Cart, error) {
+	if guestCartID == "" || userCartID == "" {
+		return nil, errors.New("cart ID cannot be empty")
+	}
+	if guestCartID == userCartID {
+		return nil, errors.New("cannot merge a cart into itself")
 	}
 
-	// Calculate merge value
-	mergeValue := cs.calculateCartValue(ctx, guestCart)
-	itemCount := len(guestCart.Items)
+	var userCart, guestCart *Cart
+	txf := func(tx *redis.Tx) error {
+		var err error
+		if guestCart, err = cs.readCart(ctx, tx, guestCartID); err != nil {
+			return err
+		}
+		if userCart, err = cs.readCart(ctx, tx, userCartID); err != nil {
+			return err
+		}
+
+		// Merge items by combining quantities of identical items
+		for _, guestItem := range guestCart.Items {
+			found := false
+			for i, userItem := range userCart.Items {
+				if userItem.ProductID == guestItem.ProductID && userItem.VariantID == guestItem.VariantID {
+					userCart.Items[i].Quantity += guestItem.Quantity
+					found = true
+					break
+				}
+			}
+			if !found {
+				userCart.Items = append(userCart.Items, guestItem)
+			}
+		}
 
-	// Merge items by combining quantities of identical items
-	for _, guestItem := range guestCart.Items {
-		found := false
-		for i, userItem := range userCart.Items {
-			if userItem.ProductID == guestItem.ProductID && userItem.VariantID == guestItem.VariantID {
-				userCart.Items[i].Quantity += guestItem.Quantity
-				found = true
-				break
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if err := cs.writeCart(ctx, pipe, userCart); err != nil {
+				return err
 			}
+			// Clear guest cart
+			pipe.Del(ctx, cs.getCartKey(guestCartID))
+			forgetActivity(ctx, pipe, guestCartID)
+			return nil
+		})
+		return err
+	}
+	merged := false
+	for attempt := 0; attempt < cartWriteRetries && !merged; attempt++ {
+		err := cs.redis.Watch(ctx, txf, cs.getCartKey(guestCartID), cs.getCartKey(userCartID))
+		if errors.Is(err, redis.TxFailedErr) {
+			if err := backoff(ctx, attempt); err != nil {
+				return nil, err
+			}
+			continue
 		}
-		if !found {
-			userCart.Items = append(userCart.Items, guestItem)
+		if err != nil {
+			return nil, err
 		}
+		merged = true
+	}
+	if !merged {
+		return nil, ErrCartBusy
 	}
 
-	// Save merged cart
-	if err := cs.SaveCart(ctx, userCart); err != nil {
-		return err
+	// The guest cart's units move to the user cart. Logging in must not fail
+	// over stock, so a short variant is only logged; the user cart holds what
+	// it can on its next change.
+	if cs.holdsStock() {
+		if err := cs.releaseCartStock(ctx, guestCartID); err != nil {
+			log.Printf("cart merge: releasing stock of %s: %v", guestCartID, err)
+		}
+		if err := cs.holdCartStock(ctx, userCart); err != nil {
+			log.Printf("cart merge: holding stock of %s: %v", userCartID, err)
+		}
 	}
 
 	// Publish cart merged event
@@ -415,12 +549,10 @@ func (cs *CartServiceImpl) MergeCarts(ctx context.Context, guestCartID string, u
 		GuestCartID: guestCartID,
 		UserCartID:  userCartID,
 		UserID:      userCart.UserID,
-		ItemCount:   itemCount,
-		Value:       mergeValue,
+		ItemCount:   len(guestCart.Items),
+		Value:       cs.calculateCartValue(ctx, guestCart),
 		Timestamp:   time.Now(),
 	}
 	cs.eventBus.Publish(ctx, event)
-
-	// Clear guest cart
-	return cs.ClearCart(ctx, guestCartID)
+	return userCart, nil
 }
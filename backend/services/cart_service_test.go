@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestCartService(t *testing.T, mr *miniredis.Miniredis) *CartServiceImpl {
+	t.Helper()
+	return &CartServiceImpl{
+		redis:          redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		productService: catalogStub{product: &Product{SKU: "ARROZ", BasePrice: 30}},
+		config:         NewCartConfig(),
+		eventBus:       &recordingBus{events: map[string]int{}},
+	}
+}
+
+func TestConcurrentCartWritesAreNotLost(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	// Two tabs, through two connections.
+	tabs := []*CartServiceImpl{newTestCartService(t, mr), newTestCartService(t, mr)}
+
+	const adds = 40
+	var wg sync.WaitGroup
+	for i := 0; i < adds; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Half the adds land on the same line.
+			item := CartItem{ProductID: fmt.Sprintf("p%d", i), Quantity: 1}
+			if i%2 == 0 {
+				item.ProductID = "shared"
+			}
+			if _, err := tabs[i%2].AddToCart(ctx, "c1", item, AnyCartVersion); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	cart, err := tabs[0].GetCart(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cart.Version != adds {
+		t.Errorf("expected version %d after %d writes, got %d", adds, adds, cart.Version)
+	}
+	if len(cart.Items) != adds/2+1 {
+		t.Fatalf("expected %d lines, got %d", adds/2+1, len(cart.Items))
+	}
+	for _, it := range cart.Items {
+		if it.ProductID == "shared" && it.Quantity != adds/2 {
+			t.Errorf("expected %d shared units, got %d", adds/2, it.Quantity)
+		}
+	}
+}
+
+func TestCartIfMatch(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	cs := newTestCartService(t, mr)
+
+	cart, err := cs.AddToCart(ctx, "c1", CartItem{ProductID: "p1", Quantity: 1}, 0)
+	if err != nil {
+		t.Fatalf("a new cart is at version 0: %v", err)
+	}
+	seen := cart.Version
+
+	// Another tab changes the cart.
+	if _, err := cs.UpdateCartItem(ctx, "c1", "p1", "", 3, AnyCartVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.RemoveFromCart(ctx, "c1", "p1", "", seen); !errors.Is(err, ErrCartVersionMismatch) {
+		t.Fatalf("expected a version mismatch, got %v", err)
+	}
+	cart, _ = cs.GetCart(ctx, "c1")
+	if len(cart.Items) != 1 || cart.Items[0].Quantity != 3 {
+		t.Fatalf("a rejected write must not change the cart, got %+v", cart.Items)
+	}
+	if cart, err = cs.RemoveFromCart(ctx, "c1", "p1", "", cart.Version); err != nil || len(cart.Items) != 0 {
+		t.Fatalf("expected the item removed at the current version, got %+v (%v)", cart, err)
+	}
+}
+
+func TestMergeCartsIsAtomic(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	cs := newTestCartService(t, mr)
+
+	if _, err := cs.AddToCart(ctx, "guest", CartItem{ProductID: "p1", Quantity: 2}, AnyCartVersion); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cs.AddToCart(ctx, "user", CartItem{ProductID: "p1", Quantity: 1}, AnyCartVersion); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	merged, err := cs.MergeCarts(ctx, "guest", "user")
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Version == 0 {
+		t.Error("the merged cart should carry its new version")
+	}
+	cart, _ := cs.GetCart(ctx, "user")
+	if len(cart.Items) != 1 || cart.Items[0].Quantity != 12 {
+		t.Errorf("expected 12 units after merging under concurrent adds, got %+v", cart.Items)
+	}
+	if mr.Exists("cart:guest") {
+		t.Error("the guest cart should be gone")
+	}
+}
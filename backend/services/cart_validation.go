@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"mercadomio-backend/models"
+)
+
+// CartIssueCode names a problem found in a cart before checkout.
+type CartIssueCode string
+
+const (
+	CartIssueProductRemoved    CartIssueCode = "product_removed"
+	CartIssueVariantRemoved    CartIssueCode = "variant_removed"
+	CartIssueOutOfStock        CartIssueCode = "out_of_stock"
+	CartIssueInsufficientStock CartIssueCode = "insufficient_stock"
+	CartIssuePriceChanged      CartIssueCode = "price_changed"
+	CartIssueCouponInvalid     CartIssueCode = "coupon_invalid"
+)
+
+// CartIssue is one problem with a cart line or the whole cart. A blocking
+// issue keeps the cart from being ordered until the customer fixes it;
+// the others are shown so nothing comes as a surprise.
+type CartIssue struct {
+	Code     CartIssueCode `json:"code"`
+	Blocking bool          `json:"blocking"`
+	Message  string        `json:"message"`
+	// Available is how many units can still be bought, for stock issues.
+	Available *int `json:"available,omitempty"`
+	// OldPrice and NewPrice are the unit list prices when the item was added
+	// and now, for price changes.
+	OldPrice float64 `json:"oldPrice,omitempty"`
+	NewPrice float64 `json:"newPrice,omitempty"`
+}
+
+// CartLineValidation is the issues found with one cart item.
+type CartLineValidation struct {
+	ProductID string      `json:"productId"`
+	VariantID string      `json:"variantId,omitempty"`
+	Name      string      `json:"name,omitempty"`
+	Quantity  int         `json:"quantity"`
+	Issues    []CartIssue `json:"issues"`
+}
+
+// CartValidation is the checkout report of a cart: issues per line, in cart
+// order, and issues with the cart as a whole (its coupon).
+type CartValidation struct {
+	CartID  string               `json:"cartId,omitempty"`
+	Version int64                `json:"version"`
+	Valid   bool                 `json:"valid"` // no blocking issue
+	Lines   []CartLineValidation `json:"lines"`
+	Issues  []CartIssue          `json:"issues"`
+}
+
+// blocking returns the first blocking issue, or nil.
+func (v *CartValidation) blocking() *CartIssue {
+	for _, l := range v.Lines {
+		for i := range l.Issues {
+			if l.Issues[i].Blocking {
+				return &l.Issues[i]
+			}
+		}
+	}
+	for i := range v.Issues {
+		if v.Issues[i].Blocking {
+			return &v.Issues[i]
+		}
+	}
+	return nil
+}
+
+// CartValidationError is returned by CreateOrderFromCart for a cart with
+// blocking issues; Validation is the full report.
+type CartValidationError struct {
+	Validation *CartValidation
+}
+
+func (e *CartValidationError) Error() string {
+	if issue := e.Validation.blocking(); issue != nil {
+		return "cart needs review: " + issue.Message
+	}
+	return "cart needs review"
+}
+
+// CartValidationService checks a cart against the catalog, stock and
+// pricing as they are now.
+type CartValidationService struct {
+	productService ProductService
+	pricingService *PricingService   // checks the coupon; nil skips it
+	inventory      *InventoryService // credits a cart's own stock holds
+}
+
+// NewCartValidationService creates a new cart validation service
+func NewCartValidationService(productService ProductService) *CartValidationService {
+	return &CartValidationService{productService: productService}
+}
+
+// SetPricingService sets the pricing engine coupons are checked with
+func (s *CartValidationService) SetPricingService(pricingService *PricingService) {
+	s.pricingService = pricingService
+}
+
+// SetInventoryService sets the inventory whose cart holds count as the
+// cart's own stock
+func (s *CartValidationService) SetInventoryService(inventory *InventoryService) {
+	s.inventory = inventory
+}
+
+// ValidateCart reports, per item, products that were deleted, variants that
+// are gone or sold out, quantities above the available stock and list
+// prices that changed since the item was added, and whether the cart's
+// coupon still takes anything off. Everything but a price change blocks
+// checkout. pc prices the coupon check for the customer.
+func (s *CartValidationService) ValidateCart(ctx context.Context, cart *Cart, pc PricingContext) (*CartValidation, error) {
+	out := &CartValidation{CartID: cart.ID, Version: cart.Version, Lines: []CartLineValidation{}, Issues: []CartIssue{}}
+
+	// Units the cart holds itself are available to it.
+	own := map[stockKey]int{}
+	if s.inventory != nil && cart.ID != "" {
+		held, err := s.inventory.heldBy(ctx, cartStockHolder(cart.ID))
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range held {
+			own[stockKey{h.ProductID, h.VariantID}] += h.Quantity
+		}
+	}
+
+	var inputs []PriceInput
+	for _, item := range cart.Items {
+		line := CartLineValidation{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity, Issues: []CartIssue{}}
+		if in := s.checkItem(ctx, item, own, &line); in != nil {
+			inputs = append(inputs, *in)
+		}
+		out.Lines = append(out.Lines, line)
+	}
+
+	if cart.CouponCode != "" && s.pricingService != nil && len(inputs) > 0 {
+		applies, err := s.couponApplies(ctx, inputs, cart.CouponCode, pc)
+		if err != nil {
+			return nil, err
+		}
+		if !applies {
+			out.Issues = append(out.Issues, CartIssue{
+				Code:     CartIssueCouponInvalid,
+				Blocking: true,
+				Message:  fmt.Sprintf("coupon %s is no longer valid for this cart", cart.CouponCode),
+			})
+		}
+	}
+
+	out.Valid = out.blocking() == nil
+	return out, nil
+}
+
+// checkItem records the item's issues on line, and returns the item to
+// price when its product and variant still exist.
+func (s *CartValidationService) checkItem(ctx context.Context, item CartItem, own map[stockKey]int, line *CartLineValidation) *PriceInput {
+	product, err := s.productService.GetProduct(ctx, item.ProductID)
+	if err != nil {
+		line.Issues = append(line.Issues, CartIssue{
+			Code:     CartIssueProductRemoved,
+			Blocking: true,
+			Message:  "this product is no longer sold",
+		})
+		return nil
+	}
+	line.Name = product.Name
+	variant := product.FindVariant(item.VariantID)
+	if item.VariantID != "" && variant == nil {
+		line.Issues = append(line.Issues, CartIssue{
+			Code:     CartIssueVariantRemoved,
+			Blocking: true,
+			Message:  product.Name + ": this option is no longer sold",
+		})
+		return nil
+	}
+
+	// Stock is tracked per variant.
+	if variant != nil {
+		available := min(product.Available(variant)+own[stockKey{item.ProductID, item.VariantID}], variant.Stock)
+		switch {
+		case available <= 0:
+			line.Issues = append(line.Issues, CartIssue{
+				Code:      CartIssueOutOfStock,
+				Blocking:  true,
+				Message:   product.Name + " is out of stock",
+				Available: &available,
+			})
+		case item.Quantity > available:
+			line.Issues = append(line.Issues, CartIssue{
+				Code:      CartIssueInsufficientStock,
+				Blocking:  true,
+				Message:   fmt.Sprintf("only %d of %s left", available, product.Name),
+				Available: &available,
+			})
+		}
+	}
+
+	// Items added before prices were recorded carry none.
+	if price := product.PriceFor(variant); item.Price > 0 && models.NewMoney(price).Amount != models.NewMoney(item.Price).Amount {
+		line.Issues = append(line.Issues, CartIssue{
+			Code:     CartIssuePriceChanged,
+			Message:  fmt.Sprintf("the price of %s changed from %.2f to %.2f", product.Name, item.Price, price),
+			OldPrice: item.Price,
+			NewPrice: price,
+		})
+	}
+	return &PriceInput{Product: product, Variant: variant, Quantity: item.Quantity}
+}
+
+// couponApplies reports whether the coupon takes anything off the lines:
+// the same lines priced without it must cost more. Codes already redeemed,
+// sets past their usage caps or out of their dates do not.
+func (s *CartValidationService) couponApplies(ctx context.Context, inputs []PriceInput, code string, pc PricingContext) (bool, error) {
+	pc.CouponCode = code
+	with, err := s.pricingService.ResolvePrices(ctx, inputs, pc)
+	if err != nil {
+		return false, err
+	}
+	pc.CouponCode = ""
+	without, err := s.pricingService.ResolvePrices(ctx, inputs, pc)
+	if err != nil {
+		return false, err
+	}
+	return with.Total.Amount < without.Total.Amount, nil
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+)
+
+func TestValidateCart(t *testing.T) {
+	ctx := context.Background()
+	product := &Product{Name: "Salsa macha", SKU: "MACHA", BasePrice: 30,
+		Variants: []Variant{
+			{VariantID: "chica", SKU: "MACHA-CH", Stock: 5},
+			{VariantID: "grande", SKU: "MACHA-GR", Stock: 0},
+			{VariantID: "familiar", SKU: "MACHA-FA", Stock: 10, PriceAdjustment: 5},
+		},
+		ReservedStock: map[string]int{"chica": 2},
+	}
+	summer := models.PriceSet{ID: primitive.NewObjectID(), Name: "verano", Active: true,
+		Conditions: models.PriceConditions{CouponCode: "VERANO"},
+		Rules:      []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 10}}}
+	pricing := &PricingService{}
+	pricing.cache.snap.Store(compileSnapshot([]models.PriceSet{summer}, nil, -1, time.Now()))
+	v := NewCartValidationService(catalogStub{product: product})
+	v.SetPricingService(pricing)
+
+	cart := &Cart{ID: "c1", CouponCode: "NOPE", Items: []CartItem{
+		{ProductID: "p", VariantID: "chica", Quantity: 4, Price: 30},
+		{ProductID: "p", VariantID: "grande", Quantity: 1, Price: 30},
+		{ProductID: "p", VariantID: "mini", Quantity: 1},
+		{ProductID: "gone", Quantity: 1},
+		{ProductID: "p", VariantID: "familiar", Quantity: 1, Price: 30},
+	}}
+	report, err := v.ValidateCart(ctx, cart, PricingContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Valid {
+		t.Error("a cart with blocking issues must not be valid")
+	}
+	want := []CartIssueCode{CartIssueInsufficientStock, CartIssueOutOfStock, CartIssueVariantRemoved, CartIssueProductRemoved, CartIssuePriceChanged}
+	for i, code := range want {
+		issues := report.Lines[i].Issues
+		if len(issues) != 1 || issues[0].Code != code {
+			t.Errorf("line %d: expected %s, got %+v", i, code, issues)
+		}
+	}
+	if a := report.Lines[0].Issues[0].Available; a == nil || *a != 3 {
+		t.Errorf("expected 3 of chica available (5 in stock, 2 held), got %v", a)
+	}
+	if p := report.Lines[4].Issues[0]; p.Blocking || p.OldPrice != 30 || p.NewPrice != 35 {
+		t.Errorf("expected a non-blocking change from 30 to 35, got %+v", p)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Code != CartIssueCouponInvalid {
+		t.Errorf("expected the unknown coupon reported, got %+v", report.Issues)
+	}
+
+	// A price change alone, with a coupon that applies, can be ordered.
+	cart.CouponCode = "VERANO"
+	cart.Items = cart.Items[4:]
+	report, err = v.ValidateCart(ctx, cart, PricingContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid || len(report.Issues) != 0 || len(report.Lines[0].Issues) != 1 {
+		t.Errorf("expected a valid cart with one price warning, got %+v", report)
+	}
+
+	// Orders refuse blocking carts before touching anything, with the report.
+	orders := &OrderService{validation: v}
+	_, err = orders.CreateOrderFromCart(ctx, primitive.NewObjectID().Hex(), []CartItem{{ProductID: "gone", Quantity: 1}}, nil)
+	var invalid *CartValidationError
+	if !errors.As(err, &invalid) || invalid.Validation.Lines[0].Issues[0].Code != CartIssueProductRemoved {
+		t.Fatalf("expected a CartValidationError, got %v", err)
+	}
+}
@@ -8,11 +8,14 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+
+	"mercadomio-backend/models"
 )
 
 type categoryService struct {
 	db         *mongo.Database
 	collection *mongo.Collection
+	pricing    *PricingService // prices with the category tree; nil skips invalidation
 }
 
 func NewCategoryService(db *mongo.Database) CategoryService {
@@ -22,12 +25,29 @@ func NewCategoryService(db *mongo.Database) CategoryService {
 	}
 }
 
+// SetPricingService sets the pricing engine whose snapshot carries the
+// category tree, with each category's minimum margin and rounding
+func (s *categoryService) SetPricingService(pricingService *PricingService) {
+	s.pricing = pricingService
+}
+
+// invalidatePricing makes the pricing engine reload the category tree after
+// a category write.
+func (s *categoryService) invalidatePricing(ctx context.Context) {
+	if s.pricing != nil {
+		s.pricing.invalidatePricing(ctx)
+	}
+}
+
 // CreateCategory creates a new category
 func (s *categoryService) CreateCategory(ctx context.Context, category *Category) (*Category, error) {
 	category.ID = primitive.NewObjectID()
 	category.CreatedAt = time.Now()
 	category.UpdatedAt = time.Now()
 
+	if !category.Rounding.Valid() {
+		return nil, errors.New("unknown rounding strategy " + string(category.Rounding))
+	}
 	if category.ParentID != nil {
 		// Verify parent exists
 		count, err := s.collection.CountDocuments(ctx, bson.M{"_id": *category.ParentID})
@@ -43,6 +63,7 @@ func (s *categoryService) CreateCategory(ctx context.Context, category *Category
 	if err != nil {
 		return nil, err
 	}
+	s.invalidatePricing(ctx)
 	return category, nil
 }
 
@@ -82,13 +103,20 @@ func (s *categoryService) GetChildCategories(ctx context.Context, parentID primi
 
 // UpdateCategory updates an existing category
 func (s *categoryService) UpdateCategory(ctx context.Context, id primitive.ObjectID, updates bson.M) error {
+	if r, ok := updates["rounding"].(string); ok && !models.RoundingStrategy(r).Valid() {
+		return errors.New("unknown rounding strategy " + r)
+	}
 	updates["updatedAt"] = time.Now()
 	_, err := s.collection.UpdateOne(
 		ctx,
 		bson.M{"_id": id},
 		bson.M{"$set": updates},
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	s.invalidatePricing(ctx)
+	return nil
 }
 
 // DeleteCategory removes a category
@@ -112,7 +140,11 @@ func (s *categoryService) DeleteCategory(ctx context.Context, id primitive.Objec
 	}
 
 	_, err = s.collection.DeleteOne(ctx, bson.M{"_id": id})
-	return err
+	if err != nil {
+		return err
+	}
+	s.invalidatePricing(ctx)
+	return nil
 }
 
 // GetCategoryTree returns the full category hierarchy
@@ -12,6 +12,10 @@ type CartConfig struct {
 	ActiveCartTTL       time.Duration `json:"activeCartTTL" bson:"activeCartTTL" validate:"required"`
 	CleanupInterval     time.Duration `json:"cleanupInterval" bson:"cleanupInterval" validate:"required"`
 	MaxInactiveDuration time.Duration `json:"maxInactiveDuration" bson:"maxInactiveDuration" validate:"required"`
+	// HoldStock makes carts hold the stock of their items for StockHoldTTL
+	// after each change; otherwise stock is only held at checkout.
+	HoldStock    bool          `json:"holdStock" bson:"holdStock"`
+	StockHoldTTL time.Duration `json:"stockHoldTTL" bson:"stockHoldTTL"`
 }
 
 // NewCartConfig creates a new CartConfig with default values
@@ -22,6 +26,7 @@ func NewCartConfig() *CartConfig {
 		ActiveCartTTL:       90 * 24 * time.Hour, // 90 days
 		CleanupInterval:     24 * time.Hour,      // Daily cleanup
 		MaxInactiveDuration: 30 * time.Minute,    // 30 minutes
+		StockHoldTTL:        15 * time.Minute,
 	}
 }
 
@@ -39,6 +44,9 @@ func (cc *CartConfig) Validate() error {
 	if cc.CleanupInterval < time.Hour {
 		return errors.New("cleanup interval must be at least 1 hour")
 	}
+	if cc.HoldStock && cc.StockHoldTTL < time.Minute {
+		return errors.New("stock hold TTL must be at least 1 minute")
+	}
 	return nil
 }
 
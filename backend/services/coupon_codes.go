@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"mercadomio-backend/models"
+)
+
+const (
+	// DefaultCouponPattern is used when a generate request has no pattern.
+	// In a pattern X is a random letter or digit, # a random digit, and
+	// anything else is copied as is.
+	DefaultCouponPattern = "XXXXXXXX"
+	// MaxCouponCodesPerBatch bounds a single generate request.
+	MaxCouponCodesPerBatch = 10000
+
+	// Letters and digits without the easily confused 0/O and 1/I.
+	couponAlphabet   = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	couponDigits     = "0123456789"
+	minCouponRandom  = 4
+	couponGenAttempt = 5
+)
+
+// ErrCouponCodeUnavailable is returned by RedeemCouponCode when the code was
+// taken by another order after the cart was priced.
+var ErrCouponCodeUnavailable = errors.New("coupon code already used")
+
+// normalizeCouponCode is the stored form of a code: trimmed, upper case.
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// expandCouponPattern builds one random code from a prefix and a pattern.
+func expandCouponPattern(prefix, pattern string) (string, error) {
+	var b strings.Builder
+	b.WriteString(normalizeCouponCode(prefix))
+	for _, r := range normalizeCouponCode(pattern) {
+		var alphabet string
+		switch r {
+		case 'X':
+			alphabet = couponAlphabet
+		case '#':
+			alphabet = couponDigits
+		default:
+			b.WriteRune(r)
+			continue
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(alphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// GenerateCouponCodes creates count single-use codes for a set and marks the
+// set as redeemable with them (Conditions.UniqueCodes).
+func (s *PricingService) GenerateCouponCodes(ctx context.Context, setID string, count int, prefix, pattern string) ([]models.CouponCode, error) {
+	if count < 1 || count > MaxCouponCodesPerBatch {
+		return nil, errors.New("count must be between 1 and 10000")
+	}
+	if pattern == "" {
+		pattern = DefaultCouponPattern
+	}
+	if strings.Count(strings.ToUpper(pattern), "X")+strings.Count(pattern, "#") < minCouponRandom {
+		return nil, errors.New("pattern needs at least 4 random positions (X or #)")
+	}
+	set, err := s.GetPriceSet(ctx, setID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.coupons.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "setId", Value: 1}}},
+	}); err != nil {
+		return nil, err
+	}
+
+	// Codes colliding with existing ones are rejected by the unique index and
+	// regenerated on the next attempt.
+	created := make([]models.CouponCode, 0, count)
+	for attempt := 0; attempt < couponGenAttempt && len(created) < count; attempt++ {
+		now := time.Now()
+		batch := make([]models.CouponCode, 0, count-len(created))
+		seen := map[string]bool{}
+		for len(batch) < count-len(created) {
+			code, err := expandCouponPattern(prefix, pattern)
+			if err != nil {
+				return nil, err
+			}
+			if seen[code] {
+				continue
+			}
+			seen[code] = true
+			batch = append(batch, models.CouponCode{
+				SetID:     setID,
+				Code:      code,
+				Status:    models.CouponCodeAvailable,
+				CreatedAt: now,
+				UpdatedAt: now,
+			})
+		}
+
+		docs := make([]interface{}, len(batch))
+		for i := range batch {
+			docs[i] = batch[i]
+		}
+		res, err := s.coupons.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+		failed := map[int]bool{}
+		var bulkErr mongo.BulkWriteException
+		if errors.As(err, &bulkErr) {
+			for _, we := range bulkErr.WriteErrors {
+				if !mongo.IsDuplicateKeyError(we) {
+					return nil, err
+				}
+				failed[we.Index] = true
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		for i := range batch {
+			if !failed[i] {
+				if res != nil && i < len(res.InsertedIDs) {
+					batch[i].ID, _ = res.InsertedIDs[i].(primitive.ObjectID)
+				}
+				created = append(created, batch[i])
+			}
+		}
+	}
+	if len(created) < count {
+		return created, errors.New("could not generate enough unique codes, use a longer pattern")
+	}
+
+	if !set.Conditions.UniqueCodes {
+		if err := s.UpdatePriceSet(ctx, setID, bson.M{"conditions.uniqueCodes": true}); err != nil {
+			return nil, err
+		}
+	}
+	return created, nil
+}
+
+// ListCouponCodes returns every code generated for a set, oldest first.
+func (s *PricingService) ListCouponCodes(ctx context.Context, setID string) ([]models.CouponCode, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.coupons.Find(ctx, bson.M{"setId": setID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var codes []models.CouponCode
+	if err := cursor.All(ctx, &codes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// GetCouponCodeStatus returns a code with its redemption history.
+func (s *PricingService) GetCouponCodeStatus(ctx context.Context, code string) (*models.CouponCodeStatus, error) {
+	var status models.CouponCodeStatus
+	if err := s.coupons.FindOne(ctx, bson.M{"code": normalizeCouponCode(code)}).Decode(&status.CouponCode); err != nil {
+		return nil, errors.New("coupon code not found")
+	}
+	opts := options.Find().SetSort(bson.M{"createdAt": 1})
+	cursor, err := s.redemptions.Find(ctx, bson.M{"codeId": status.ID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	status.Redemptions = []models.CouponRedemption{}
+	if err := cursor.All(ctx, &status.Redemptions); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// couponCodeSet returns the set an available generated code redeems, or ""
+// when the code is not a generated one (it may still be a shared code).
+func (s *PricingService) couponCodeSet(ctx context.Context, code string) string {
+	if s.coupons == nil {
+		return ""
+	}
+	var cc models.CouponCode
+	err := s.coupons.FindOne(ctx, bson.M{"code": normalizeCouponCode(code), "status": models.CouponCodeAvailable}).Decode(&cc)
+	if err != nil {
+		return ""
+	}
+	return cc.SetID
+}
+
+// RedeemCouponCode reserves a generated code for an order, provided the code
+// belongs to one of the sets the order applied. Shared codes are left alone.
+// The reservation is settled together with the order's set usage.
+func (s *PricingService) RedeemCouponCode(ctx context.Context, orderID, code, customerID string, setIDs []string) error {
+	var cc models.CouponCode
+	err := s.coupons.FindOne(ctx, bson.M{"code": normalizeCouponCode(code)}).Decode(&cc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !containsStr(setIDs, cc.SetID) {
+		return nil
+	}
+
+	now := time.Now()
+	res, err := s.coupons.UpdateOne(ctx,
+		bson.M{"_id": cc.ID, "status": models.CouponCodeAvailable},
+		bson.M{"$set": bson.M{
+			"status":     models.CouponCodeReserved,
+			"orderId":    orderID,
+			"customerId": customerID,
+			"updatedAt":  now,
+		}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrCouponCodeUnavailable
+	}
+	_, err = s.redemptions.InsertOne(ctx, models.CouponRedemption{
+		CodeID:     cc.ID,
+		Code:       cc.Code,
+		SetID:      cc.SetID,
+		OrderID:    orderID,
+		CustomerID: customerID,
+		Status:     models.ReservationHeld,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	})
+	return err
+}
+
+// settleCouponRedemptions moves an order's held redemptions to status and
+// the codes with them: committed redeems the code, released frees it again.
+func (s *PricingService) settleCouponRedemptions(ctx context.Context, orderID, status string) error {
+	if s.redemptions == nil {
+		return nil
+	}
+	cursor, err := s.redemptions.Find(ctx, bson.M{"orderId": orderID, "status": models.ReservationHeld})
+	if err != nil {
+		return err
+	}
+	var held []models.CouponRedemption
+	if err := cursor.All(ctx, &held); err != nil {
+		return err
+	}
+
+	for _, r := range held {
+		now := time.Now()
+		res, err := s.redemptions.UpdateOne(ctx,
+			bson.M{"_id": r.ID, "status": models.ReservationHeld},
+			bson.M{"$set": bson.M{"status": status, "updatedAt": now}})
+		if err != nil {
+			return err
+		}
+		if res.ModifiedCount == 0 {
+			continue
+		}
+
+		update := bson.M{"$set": bson.M{"status": models.CouponCodeRedeemed, "redeemedAt": now, "updatedAt": now}}
+		if status == models.ReservationReleased {
+			update = bson.M{
+				"$set":   bson.M{"status": models.CouponCodeAvailable, "updatedAt": now},
+				"$unset": bson.M{"orderId": "", "customerId": ""},
+			}
+		}
+		if _, err := s.coupons.UpdateOne(ctx, bson.M{"_id": r.CodeID, "orderId": orderID}, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
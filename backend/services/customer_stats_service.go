@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"mercadomio-backend/models"
+)
+
+// CustomerStatsService maintains the customer_stats projection (paid order
+// count, lifetime spend, first/last order) from order events. The orders it
+// counted are kept apart, in customer_stats_orders, so a redelivered event is
+// only counted once without every customer's stats carrying its order IDs.
+type CustomerStatsService struct {
+	collection *mongo.Collection
+	counted    *mongo.Collection
+	orders     *mongo.Collection
+	migrations *mongo.Collection
+	eventBus   EventBus
+}
+
+// customerStatsRebuildID marks, in the migrations collection, that a replica
+// has taken on rebuilding the projection, so the others start without it.
+const customerStatsRebuildID = "customer_stats_rebuild"
+
+// NewCustomerStatsService creates a new customer stats service
+func NewCustomerStatsService(db *mongo.Database, eventBus EventBus) *CustomerStatsService {
+	return &CustomerStatsService{
+		collection: db.Collection("customer_stats"),
+		counted:    db.Collection("customer_stats_orders"),
+		orders:     db.Collection("orders"),
+		migrations: db.Collection("migrations"),
+		eventBus:   eventBus,
+	}
+}
+
+// Start subscribes to order events and, on an empty projection or one that
+// still lists its counted orders in each customer's stats, rebuilds it from
+// existing orders. The rebuild runs once per database: a replica that finds
+// it already taken skips it and keeps the projection up to date from events.
+func (s *CustomerStatsService) Start() error {
+	s.eventBus.Subscribe("order.paid", s.handleOrderPaid)
+	s.eventBus.Subscribe("order.cancelled", s.handleOrderCancelled)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	count, err := s.collection.EstimatedDocumentCount(ctx)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		legacy, err := s.collection.CountDocuments(ctx, bson.M{"countedOrders": bson.M{"$exists": true}}, options.Count().SetLimit(1))
+		if err != nil || legacy == 0 {
+			return err
+		}
+	}
+
+	_, err = s.migrations.InsertOne(ctx, bson.M{"_id": customerStatsRebuildID, "ranAt": time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.Rebuild(ctx); err != nil {
+		// Left for the next start to try again.
+		s.migrations.DeleteOne(ctx, bson.M{"_id": customerStatsRebuildID})
+		return err
+	}
+	return nil
+}
+
+// GetStats returns a customer's stats; a customer without paid orders gets
+// zero stats, not an error. PendingOrders is counted from the orders as they
+// are now.
+func (s *CustomerStatsService) GetStats(ctx context.Context, customerID string) (*models.CustomerStats, error) {
+	var stats models.CustomerStats
+	err := s.collection.FindOne(ctx, bson.M{"_id": customerID}).Decode(&stats)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		stats = models.CustomerStats{CustomerID: customerID, LifetimeSpend: models.MoneyFromMinor(0)}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if userID, err := primitive.ObjectIDFromHex(customerID); err == nil {
+		pending, err := s.orders.CountDocuments(ctx, bson.M{"userId": userID, "status": models.OrderStatusPending})
+		if err != nil {
+			return nil, err
+		}
+		stats.PendingOrders = int(pending)
+	}
+	return &stats, nil
+}
+
+// RecordPaid counts a paid order once, as of when it was placed.
+func (s *CustomerStatsService) RecordPaid(ctx context.Context, orderID, customerID string, total models.Money, orderedAt time.Time) error {
+	_, err := s.counted.InsertOne(ctx, bson.M{"_id": orderID, "customerId": customerID, "createdAt": time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.collection.UpdateOne(ctx,
+		bson.M{"_id": customerID},
+		bson.M{
+			"$inc": bson.M{"orderCount": 1, "lifetimeSpend.amount": total.Amount},
+			"$set": bson.M{"lifetimeSpend.currency": total.Currency, "updatedAt": time.Now()},
+			"$min": bson.M{"firstOrderAt": orderedAt},
+			"$max": bson.M{"lastOrderAt": orderedAt},
+		},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		// Not counted after all, so a redelivered event tries again.
+		s.counted.DeleteOne(ctx, bson.M{"_id": orderID})
+	}
+	return err
+}
+
+// RecordRefund takes a cancelled paid order back out of the stats. The last
+// order date is left as is.
+func (s *CustomerStatsService) RecordRefund(ctx context.Context, orderID, customerID string, total models.Money) error {
+	res, err := s.counted.DeleteOne(ctx, bson.M{"_id": orderID, "customerId": customerID})
+	if err != nil || res.DeletedCount == 0 {
+		return err
+	}
+
+	_, err = s.collection.UpdateOne(ctx,
+		bson.M{"_id": customerID},
+		bson.M{
+			"$inc": bson.M{"orderCount": -1, "lifetimeSpend.amount": -total.Amount},
+			"$set": bson.M{"updatedAt": time.Now()},
+		})
+	if err != nil {
+		// Still counted, so a redelivered event tries again.
+		s.counted.InsertOne(ctx, bson.M{"_id": orderID, "customerId": customerID, "createdAt": time.Now()})
+	}
+	return err
+}
+
+func (s *CustomerStatsService) handleOrderPaid(ctx context.Context, event DomainEvent) error {
+	e, ok := event.(OrderPaid)
+	if !ok {
+		return nil
+	}
+	// Events published before OrderedAt was carried only have the payment time.
+	orderedAt := e.OrderedAt
+	if orderedAt.IsZero() {
+		orderedAt = e.Timestamp
+	}
+	return s.RecordPaid(ctx, e.OrderID, e.UserID, e.Total, orderedAt)
+}
+
+func (s *CustomerStatsService) handleOrderCancelled(ctx context.Context, event DomainEvent) error {
+	e, ok := event.(OrderCancelled)
+	if !ok || !e.WasPaid {
+		return nil
+	}
+	return s.RecordRefund(ctx, e.OrderID, e.UserID, e.Total)
+}
+
+// Rebuild recomputes the projection from the orders collection, starting
+// over from nothing.
+func (s *CustomerStatsService) Rebuild(ctx context.Context) error {
+	if _, err := s.collection.DeleteMany(ctx, bson.M{}); err != nil {
+		return err
+	}
+	if _, err := s.counted.DeleteMany(ctx, bson.M{}); err != nil {
+		return err
+	}
+
+	cursor, err := s.orders.Find(ctx, bson.M{"status": bson.M{"$in": bson.A{
+		models.OrderStatusPaid, models.OrderStatusShipped, models.OrderStatusCompleted,
+	}}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var order models.Order
+		if err := cursor.Decode(&order); err != nil {
+			log.Printf("customer stats: skipping undecodable order: %v", err)
+			continue
+		}
+		if err := s.RecordPaid(ctx, order.ID.Hex(), order.UserID.Hex(), order.Total, order.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -1,6 +1,10 @@
 package services
 
-import "time"
+import (
+	"time"
+
+	"mercadomio-backend/models"
+)
 
 // DomainEvent represents a business event that occurred in the system
 type DomainEvent interface {
@@ -112,3 +116,45 @@ type ProductViewed struct {
 func (e ProductViewed) EventType() string     { return "product.viewed" }
 func (e ProductViewed) AggregateID() string   { return e.ProductID }
 func (e ProductViewed) OccurredAt() time.Time { return e.Timestamp }
+
+// ProductPriceChanged is published when an edit changes the list price of a
+// product or one of its variants.
+type ProductPriceChanged struct {
+	ProductID string    `json:"productId"`
+	VariantID string    `json:"variantId,omitempty"`
+	OldPrice  float64   `json:"oldPrice"`
+	NewPrice  float64   `json:"newPrice"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func (e ProductPriceChanged) EventType() string     { return "product.price.changed" }
+func (e ProductPriceChanged) AggregateID() string   { return e.ProductID }
+func (e ProductPriceChanged) OccurredAt() time.Time { return e.Timestamp }
+
+// OrderPaid represents when an order's payment is confirmed; OrderedAt is
+// when the order was placed
+type OrderPaid struct {
+	OrderID   string       `json:"orderId"`
+	UserID    string       `json:"userId"`
+	Total     models.Money `json:"total"`
+	OrderedAt time.Time    `json:"orderedAt"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+func (e OrderPaid) EventType() string     { return "order.paid" }
+func (e OrderPaid) AggregateID() string   { return e.OrderID }
+func (e OrderPaid) OccurredAt() time.Time { return e.Timestamp }
+
+// OrderCancelled represents when an order is cancelled; WasPaid tells a
+// refund of a paid order apart from an abandoned pending one
+type OrderCancelled struct {
+	OrderID   string       `json:"orderId"`
+	UserID    string       `json:"userId"`
+	Total     models.Money `json:"total"`
+	WasPaid   bool         `json:"wasPaid"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+func (e OrderCancelled) EventType() string     { return "order.cancelled" }
+func (e OrderCancelled) AggregateID() string   { return e.OrderID }
+func (e OrderCancelled) OccurredAt() time.Time { return e.Timestamp }
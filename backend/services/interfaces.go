@@ -18,6 +18,7 @@ type (
 		UpdateCategory(ctx context.Context, id primitive.ObjectID, updates bson.M) error
 		DeleteCategory(ctx context.Context, id primitive.ObjectID) error
 		GetCategoryTree(ctx context.Context) ([]Category, error)
+		SetPricingService(pricingService *PricingService)
 	}
 
 	ProductService interface {
@@ -37,6 +38,7 @@ type (
 		DecrementStock(ctx context.Context, productID, variantID string, qty int) error
 		IncrementStock(ctx context.Context, productID, variantID string, qty int) error
 		SetVariantStock(ctx context.Context, productID, variantID string, stock int) error
+		SetEventBus(eventBus EventBus)
 	}
 
 	SearchService interface {
@@ -45,9 +47,14 @@ type (
 
 	CartService interface {
 		GetCart(ctx context.Context, cartID string) (*Cart, error)
-		AddToCart(ctx context.Context, cartID string, item CartItem) error
-		UpdateCartItem(ctx context.Context, cartID, productID, variantID string, quantity int) error
-		RemoveFromCart(ctx context.Context, cartID, productID, variantID string) error
-		MergeCarts(ctx context.Context, guestCartID, userCartID string) error
+		// Writes take the cart version the caller expects (AnyCartVersion for
+		// none) and return the cart as written.
+		AddToCart(ctx context.Context, cartID string, item CartItem, ifVersion int64) (*Cart, error)
+		UpdateCartItem(ctx context.Context, cartID, productID, variantID string, quantity int, ifVersion int64) (*Cart, error)
+		RemoveFromCart(ctx context.Context, cartID, productID, variantID string, ifVersion int64) (*Cart, error)
+		MergeCarts(ctx context.Context, guestCartID, userCartID string) (*Cart, error)
+		AttachCoupon(ctx context.Context, cartID, code string, ifVersion int64) (*Cart, error)
+		DetachCoupon(ctx context.Context, cartID string, ifVersion int64) (*Cart, error)
+		PriceCart(ctx context.Context, cartID string, pc PricingContext) (*PricedCart, error)
 	}
 )
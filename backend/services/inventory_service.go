@@ -0,0 +1,310 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"mercadomio-backend/models"
+)
+
+// ErrInsufficientStock is returned by HoldStock when a variant does not have
+// enough unheld units left.
+var ErrInsufficientStock = errors.New("not enough stock available")
+
+// StockLine is a quantity of one variant to hold. Stock is tracked per
+// variant; lines without a variant are not held.
+type StockLine struct {
+	ProductID string
+	VariantID string
+	Quantity  int
+}
+
+// InventoryService keeps stock holds: units set aside for an order awaiting
+// payment, or for a cart when carts hold stock. Each product counts its held
+// units per variant in ReservedStock, next to the variants' stock, so taking
+// a hold is a single conditional update and concurrent checkouts cannot hold
+// more than is in stock. The stock_holds collection records who holds what.
+type InventoryService struct {
+	products *mongo.Collection
+	holds    *mongo.Collection
+}
+
+// NewInventoryService creates a new inventory service
+func NewInventoryService(db *mongo.Database) *InventoryService {
+	return &InventoryService{
+		products: db.Collection("products"),
+		holds:    db.Collection("stock_holds"),
+	}
+}
+
+// stockAvailable builds an aggregation expression that is true when a
+// variant's stock minus its held units covers qty.
+func stockAvailable(variantID string, qty int) bson.M {
+	stock := bson.M{"$sum": bson.M{"$map": bson.M{
+		"input": bson.M{"$filter": bson.M{
+			"input": "$variants",
+			"as":    "v",
+			"cond":  bson.M{"$eq": bson.A{"$$v.variantId", variantID}},
+		}},
+		"as": "v",
+		"in": "$$v.stock",
+	}}}
+	return bson.M{"$gte": bson.A{
+		bson.M{"$subtract": bson.A{stock, bson.M{"$ifNull": bson.A{"$reservedStock." + variantID, 0}}}},
+		qty,
+	}}
+}
+
+// stockKey identifies a variant among a holder's lines.
+type stockKey struct{ productID, variantID string }
+
+// HoldStock makes the holder's held stock match lines, and moves every hold
+// of the holder to expire at expiresAt. Only the difference to what is
+// already held is taken or given back, so calling it again with the same
+// lines changes nothing. If a variant is short, what this call took is given
+// back and ErrInsufficientStock is returned.
+func (s *InventoryService) HoldStock(ctx context.Context, holder string, lines []StockLine, expiresAt time.Time) error {
+	return s.holdStock(ctx, holder, "", lines, expiresAt)
+}
+
+// HoldStockOver is HoldStock for a holder taking over another holder's
+// units, as an order takes over its cart's: what from holds counts as
+// available, so for a moment both hold those units. The caller releases from
+// once the new holder is in place, and only then.
+func (s *InventoryService) HoldStockOver(ctx context.Context, holder, from string, lines []StockLine, expiresAt time.Time) error {
+	return s.holdStock(ctx, holder, from, lines, expiresAt)
+}
+
+func (s *InventoryService) holdStock(ctx context.Context, holder, from string, lines []StockLine, expiresAt time.Time) error {
+	want := map[stockKey]int{}
+	var order []stockKey
+	for _, l := range lines {
+		if l.VariantID == "" || l.Quantity <= 0 {
+			continue
+		}
+		if strings.ContainsAny(l.VariantID, ".$") {
+			return errors.New("invalid variant ID: " + l.VariantID)
+		}
+		key := stockKey{l.ProductID, l.VariantID}
+		if _, ok := want[key]; !ok {
+			order = append(order, key)
+		}
+		want[key] += l.Quantity
+	}
+
+	held, err := s.heldBy(ctx, holder)
+	if err != nil {
+		return err
+	}
+	have := map[stockKey]int{}
+	for _, h := range held {
+		key := stockKey{h.ProductID, h.VariantID}
+		have[key] = h.Quantity
+		// Give back first, so a holder swapping variants frees before it takes.
+		if n := h.Quantity - want[key]; n > 0 {
+			if err := s.shrink(ctx, h, n); err != nil {
+				return err
+			}
+		}
+	}
+
+	credit := map[stockKey]int{}
+	if from != "" {
+		theirs, err := s.heldBy(ctx, from)
+		if err != nil {
+			return err
+		}
+		for _, h := range theirs {
+			credit[stockKey{h.ProductID, h.VariantID}] += h.Quantity
+		}
+	}
+
+	var taken []stockKey
+	for _, key := range order {
+		n := want[key] - have[key]
+		if n <= 0 {
+			continue
+		}
+		if err := s.grow(ctx, holder, key, n, credit[key], expiresAt); err != nil {
+			for _, k := range taken {
+				s.giveBack(ctx, holder, k, want[k]-have[k])
+			}
+			return err
+		}
+		taken = append(taken, key)
+	}
+
+	_, err = s.holds.UpdateMany(ctx,
+		bson.M{"holder": holder, "status": models.ReservationHeld},
+		bson.M{"$set": bson.M{"expiresAt": expiresAt, "updatedAt": time.Now()}})
+	return err
+}
+
+// ExtendStockHolds moves every hold of the holder to expire at expiresAt,
+// keeping what it holds.
+func (s *InventoryService) ExtendStockHolds(ctx context.Context, holder string, expiresAt time.Time) error {
+	_, err := s.holds.UpdateMany(ctx,
+		bson.M{"holder": holder, "status": models.ReservationHeld},
+		bson.M{"$set": bson.M{"expiresAt": expiresAt, "updatedAt": time.Now()}})
+	return err
+}
+
+// CommitStockHolds converts a paid order's holds into sold stock: each held
+// variant's stock and held count drop together. It returns the holds it
+// committed; units whose hold had already lapsed are the caller's to take.
+func (s *InventoryService) CommitStockHolds(ctx context.Context, holder string) ([]models.StockHold, error) {
+	return s.settle(ctx, holder, models.ReservationCommitted)
+}
+
+// ReleaseStockHolds gives back everything the holder still holds, when its
+// order is cancelled, its hold expires, or its cart goes away. Committed
+// holds are left alone.
+func (s *InventoryService) ReleaseStockHolds(ctx context.Context, holder string) error {
+	_, err := s.settle(ctx, holder, models.ReservationReleased)
+	return err
+}
+
+// ExpiredStockHolders returns the holders with holds past their expiry.
+func (s *InventoryService) ExpiredStockHolders(ctx context.Context, now time.Time) ([]string, error) {
+	ids, err := s.holds.Distinct(ctx, "holder", bson.M{
+		"status":    models.ReservationHeld,
+		"expiresAt": bson.M{"$lte": now},
+	})
+	if err != nil {
+		return nil, err
+	}
+	holders := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if holder, ok := id.(string); ok {
+			holders = append(holders, holder)
+		}
+	}
+	return holders, nil
+}
+
+// heldBy returns the holder's active holds.
+func (s *InventoryService) heldBy(ctx context.Context, holder string) ([]models.StockHold, error) {
+	cursor, err := s.holds.Find(ctx, bson.M{"holder": holder, "status": models.ReservationHeld})
+	if err != nil {
+		return nil, err
+	}
+	var held []models.StockHold
+	if err := cursor.All(ctx, &held); err != nil {
+		return nil, err
+	}
+	return held, nil
+}
+
+// grow takes n more units of a variant for the holder: the product's held
+// count is raised only while stock covers it, then the hold records them.
+// credit units held by a holder being taken over need no stock of their own.
+func (s *InventoryService) grow(ctx context.Context, holder string, key stockKey, n, credit int, expiresAt time.Time) error {
+	productID, err := primitive.ObjectIDFromHex(key.productID)
+	if err != nil {
+		return errors.New("invalid product ID: " + key.productID)
+	}
+	filter := bson.M{"_id": productID, "variants.variantId": key.variantID}
+	if n > credit {
+		filter["$expr"] = stockAvailable(key.variantID, n-credit)
+	}
+	res, err := s.products.UpdateOne(ctx, filter,
+		bson.M{"$inc": bson.M{"reservedStock." + key.variantID: n}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrInsufficientStock
+	}
+
+	now := time.Now()
+	_, err = s.holds.UpdateOne(ctx,
+		bson.M{"holder": holder, "productId": key.productID, "variantId": key.variantID, "status": models.ReservationHeld},
+		bson.M{
+			"$inc":         bson.M{"quantity": n},
+			"$set":         bson.M{"expiresAt": expiresAt, "updatedAt": now},
+			"$setOnInsert": bson.M{"createdAt": now},
+		},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		s.products.UpdateOne(ctx, bson.M{"_id": productID}, bson.M{"$inc": bson.M{"reservedStock." + key.variantID: -n}})
+	}
+	return err
+}
+
+// giveBack undoes a grow of n units, for a HoldStock that failed part way.
+func (s *InventoryService) giveBack(ctx context.Context, holder string, key stockKey, n int) {
+	var h models.StockHold
+	err := s.holds.FindOne(ctx, bson.M{
+		"holder": holder, "productId": key.productID, "variantId": key.variantID, "status": models.ReservationHeld,
+	}).Decode(&h)
+	if err == nil {
+		s.shrink(ctx, h, n)
+	}
+}
+
+// shrink gives back n units of a hold. A hold given back entirely is
+// released. The hold changes first and the product's held count only when
+// that succeeded, so a concurrent shrink never gives back twice.
+func (s *InventoryService) shrink(ctx context.Context, h models.StockHold, n int) error {
+	filter := bson.M{"_id": h.ID, "status": models.ReservationHeld, "quantity": h.Quantity}
+	update := bson.M{"$inc": bson.M{"quantity": -n}, "$set": bson.M{"updatedAt": time.Now()}}
+	if n >= h.Quantity {
+		n = h.Quantity
+		update = bson.M{"$set": bson.M{"status": models.ReservationReleased, "updatedAt": time.Now()}}
+	}
+	res, err := s.holds.UpdateOne(ctx, filter, update)
+	if err != nil || res.ModifiedCount == 0 {
+		return err
+	}
+	return s.moveStock(ctx, h, n, false)
+}
+
+// settle moves each of the holder's active holds to status, and returns the
+// holds it moved. As in shrink, a hold is flipped before the product changes.
+func (s *InventoryService) settle(ctx context.Context, holder, status string) ([]models.StockHold, error) {
+	held, err := s.heldBy(ctx, holder)
+	if err != nil {
+		return nil, err
+	}
+	var settled []models.StockHold
+	for _, h := range held {
+		res, err := s.holds.UpdateOne(ctx,
+			bson.M{"_id": h.ID, "status": models.ReservationHeld},
+			bson.M{"$set": bson.M{"status": status, "updatedAt": time.Now()}})
+		if err != nil {
+			return settled, err
+		}
+		if res.ModifiedCount == 0 {
+			continue
+		}
+		if err := s.moveStock(ctx, h, h.Quantity, status == models.ReservationCommitted); err != nil {
+			return settled, err
+		}
+		settled = append(settled, h)
+	}
+	return settled, nil
+}
+
+// moveStock takes n held units of a hold's variant off the product's held
+// count, and with sold also off its stock.
+func (s *InventoryService) moveStock(ctx context.Context, h models.StockHold, n int, sold bool) error {
+	productID, err := primitive.ObjectIDFromHex(h.ProductID)
+	if err != nil {
+		return nil
+	}
+	filter := bson.M{"_id": productID}
+	inc := bson.M{"reservedStock." + h.VariantID: -n}
+	if sold {
+		filter["variants.variantId"] = h.VariantID
+		inc["variants.$.stock"] = -n
+	}
+	_, err = s.products.UpdateOne(ctx, filter, bson.M{"$inc": inc})
+	return err
+}
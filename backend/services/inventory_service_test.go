@@ -0,0 +1,50 @@
+package services
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+)
+
+func TestUnheldItems(t *testing.T) {
+	p := primitive.NewObjectID()
+	items := []models.OrderItem{
+		{ProductID: p, VariantID: "a", Quantity: 3},
+		{ProductID: p, VariantID: "b", Quantity: 2},
+		{ProductID: p, Quantity: 1},
+	}
+	// "a" lapsed in part, "b" is fully held; items without a variant are
+	// never held.
+	holds := []models.StockHold{
+		{ProductID: p.Hex(), VariantID: "a", Quantity: 1},
+		{ProductID: p.Hex(), VariantID: "b", Quantity: 2},
+	}
+
+	got := unheldItems(items, holds)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 items left to decrement, got %+v", got)
+	}
+	if got[0].VariantID != "a" || got[0].Quantity != 2 {
+		t.Errorf("expected 2 units of a, got %+v", got[0])
+	}
+	if got[1].VariantID != "" || got[1].Quantity != 1 {
+		t.Errorf("expected the variant-less item untouched, got %+v", got[1])
+	}
+	if items[0].Quantity != 3 {
+		t.Error("the order's items must not be modified")
+	}
+}
+
+func TestProductAvailable(t *testing.T) {
+	p := &Product{
+		Variants:      []Variant{{VariantID: "a", Stock: 5}, {VariantID: "b", Stock: 1}, {VariantID: "c", Stock: 4}},
+		ReservedStock: map[string]int{"a": 2, "b": 3},
+	}
+	for id, want := range map[string]int{"a": 3, "b": 0, "c": 4} {
+		if got := p.Available(p.FindVariant(id)); got != want {
+			t.Errorf("variant %s: expected %d available, got %d", id, want, got)
+		}
+	}
+}
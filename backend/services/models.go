@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
 )
 
 // Product events
@@ -28,9 +30,13 @@ type Variant struct {
 	VariantID       string                 `bson:"variantId" json:"variantId" validate:"required"`
 	Attributes      map[string]interface{} `bson:"attributes" json:"attributes"`
 	PriceAdjustment float64                `bson:"priceAdjustment" json:"priceAdjustment"`
+	CostPrice       float64                `bson:"costPrice,omitempty" json:"costPrice,omitempty"` // overrides the product's cost price
 	SKU             string                 `bson:"sku" json:"sku" validate:"required"`
 	Barcode         string                 `bson:"barcode" json:"barcode"`
 	Stock           int                    `bson:"stock" json:"stock"`
+	// Available is Stock minus active holds; it is only filled on catalog
+	// reads.
+	Available *int `bson:"-" json:"available,omitempty"`
 }
 
 // Category represents a product category
@@ -43,8 +49,14 @@ type Category struct {
 	Children    []primitive.ObjectID `bson:"children" json:"children"`
 	ImageURL    string               `bson:"imageUrl" json:"imageUrl"`
 	IsActive    bool                 `bson:"isActive" json:"isActive"`
-	CreatedAt   time.Time            `bson:"createdAt" json:"createdAt"`
-	UpdatedAt   time.Time            `bson:"updatedAt" json:"updatedAt"`
+	// MinMarginPercent is the margin policy of products filed here (and below,
+	// unless a subcategory sets its own); 0 inherits the parent's.
+	MinMarginPercent float64 `bson:"minMarginPercent,omitempty" json:"minMarginPercent,omitempty"`
+	// Rounding is the price rounding of products filed here; empty inherits
+	// the parent's, then the global strategy.
+	Rounding  models.RoundingStrategy `bson:"rounding,omitempty" json:"rounding,omitempty"`
+	CreatedAt time.Time               `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time               `bson:"updatedAt" json:"updatedAt"`
 }
 
 // Product represents a product in the store
@@ -55,7 +67,10 @@ type Product struct {
 	Type             string                 `bson:"type" json:"type" validate:"required,oneof=physical service subscription"`
 	Category         string                 `bson:"category" json:"category"`
 	Categories       []primitive.ObjectID   `bson:"categories" json:"categories" validate:"required"`
-	BasePrice        float64                `bson:"basePrice" json:"basePrice" validate:"required"`
+	BasePrice        float64                `bson:"basePrice" json:"basePrice" validate:"required"`     // net of taxes
+	TaxCategory      string                 `bson:"taxCategory,omitempty" json:"taxCategory,omitempty"` // see models.TaxCategory*; empty is the store's default
+	CostPrice        float64                `bson:"costPrice,omitempty" json:"costPrice,omitempty"`
+	MinMarginPercent float64                `bson:"minMarginPercent,omitempty" json:"minMarginPercent,omitempty"` // 0 falls back to the category policy
 	SKU              string                 `bson:"sku" json:"sku" validate:"required"`
 	Barcode          string                 `bson:"barcode" json:"barcode"`
 	ImageURL         string                 `bson:"imageUrl" json:"imageUrl"`
@@ -64,6 +79,53 @@ type Product struct {
 	Identifiers      map[string]string      `bson:"identifiers" json:"identifiers"`
 	CreatedAt        time.Time              `bson:"createdAt" json:"createdAt"`
 	UpdatedAt        time.Time              `bson:"updatedAt" json:"updatedAt"`
+	// ReservedStock counts the units of each variant held by orders and
+	// carts (see InventoryService). It is kept by the holds alone and never
+	// read from or written to the API.
+	ReservedStock map[string]int `bson:"reservedStock,omitempty" json:"-"`
+}
+
+// FindVariant returns the product's variant with the given id, or nil.
+func (p *Product) FindVariant(variantID string) *Variant {
+	if variantID == "" {
+		return nil
+	}
+	for i := range p.Variants {
+		if p.Variants[i].VariantID == variantID {
+			return &p.Variants[i]
+		}
+	}
+	return nil
+}
+
+// PriceFor returns the list price of one unit of the product in the given
+// variant: the base price plus the variant's price adjustment. Carts, the
+// pricing engine and orders all start from this number.
+func (p *Product) PriceFor(v *Variant) float64 {
+	if v == nil {
+		return p.BasePrice
+	}
+	return p.BasePrice + v.PriceAdjustment
+}
+
+// Available returns how many units of a variant can still be sold: its
+// stock minus the units held by orders and carts.
+func (p *Product) Available(v *Variant) int {
+	if v == nil {
+		return 0
+	}
+	if n := v.Stock - p.ReservedStock[v.VariantID]; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// CostFor returns the cost of one unit in the given variant; 0 when unknown.
+func (p *Product) CostFor(v *Variant) float64 {
+	if v != nil && v.CostPrice > 0 {
+		return v.CostPrice
+	}
+	return p.CostPrice
 }
 
 // SearchParams represents search parameters
@@ -91,15 +153,22 @@ type CartItem struct {
 	VariantID  string                 `json:"variantId,omitempty"`
 	Quantity   int                    `json:"quantity"`
 	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	// Price is the unit list price when the item was last added, set by the
+	// cart service; checkout reports when it has changed since.
+	Price float64 `json:"price,omitempty"`
 }
 
 // Cart represents a shopping cart
 type Cart struct {
-	ID        string     `json:"id"`
-	UserID    string     `json:"userId,omitempty"`
-	Items     []CartItem `json:"items"`
-	CreatedAt time.Time  `json:"createdAt"`
-	UpdatedAt time.Time  `json:"updatedAt"`
+	ID      string     `json:"id"`
+	UserID  string     `json:"userId,omitempty"`
+	Items   []CartItem `json:"items"`
+	Version int64      `json:"version"` // bumped by every write; the cart's ETag
+	// CouponCode is the coupon attached to the cart, priced into the cart
+	// view and the order placed from it.
+	CouponCode string    `json:"couponCode,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
 }
 
 // CartAnalyticsResult represents analytics query results
@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"errors"
+	"log"
 	"mercadomio-backend/models"
 	"time"
 
@@ -12,12 +13,28 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrOrderStatusChanged is returned by UpdateOrderStatus when the order's
+// status changed after it was read; nothing was updated.
+var ErrOrderStatusChanged = errors.New("order status changed concurrently")
+
+// UsageHoldTTL is how long a pending order holds its price-set uses and its
+// stock before the expiry routine cancels it and gives them back, unless a
+// payment opened for it keeps it longer (see KeepForPayment).
+const UsageHoldTTL = 60 * time.Minute
+
+// PaymentGrace is how long past its payment deadline a pending order keeps
+// its holds, for the payment provider's confirmation to come in.
+const PaymentGrace = 30 * time.Minute
+
 // OrderService handles order operations
 type OrderService struct {
 	db             *mongo.Database
 	collection     *mongo.Collection
 	productService ProductService
 	pricingService *PricingService
+	inventory      *InventoryService
+	validation     *CartValidationService
+	eventBus       EventBus
 }
 
 // NewOrderService creates a new order service
@@ -38,17 +55,60 @@ func (s *OrderService) SetPricingService(pricingService *PricingService) {
 	s.pricingService = pricingService
 }
 
+// SetInventoryService sets the inventory that holds stock for pending orders
+func (s *OrderService) SetInventoryService(inventory *InventoryService) {
+	s.inventory = inventory
+}
+
+// SetCartValidationService sets the checks a cart must pass to be ordered
+func (s *OrderService) SetCartValidationService(validation *CartValidationService) {
+	s.validation = validation
+}
+
+// SetEventBus sets the event bus order status changes are published on
+func (s *OrderService) SetEventBus(eventBus EventBus) {
+	s.eventBus = eventBus
+}
+
 // CreateOrderFromCart creates an order from cart items
 func (s *OrderService) CreateOrderFromCart(ctx context.Context, userID string, cartItems []CartItem, priceCtx *PricingContext) (*models.Order, error) {
+	return s.CheckoutCart(ctx, userID, &Cart{Items: cartItems}, priceCtx)
+}
+
+// CheckoutCart is CreateOrderFromCart for a stored cart. The order takes
+// over the stock the cart holds: those units count as available to it, and
+// the cart's holds are released only once the order is saved, so a failed
+// checkout leaves the cart holding what it held.
+func (s *OrderService) CheckoutCart(ctx context.Context, userID string, cart *Cart, priceCtx *PricingContext) (*models.Order, error) {
+	cartItems := cart.Items
 	// Validate user ID
 	userObjID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		return nil, errors.New("invalid user ID")
 	}
 
+	// Refuse carts with deleted products, missing stock or a coupon that no
+	// longer applies; the error carries the full report.
+	if s.validation != nil {
+		pc := PricingContext{}
+		if priceCtx != nil {
+			pc = *priceCtx
+		}
+		if pc.CustomerID == "" {
+			pc.CustomerID = userID
+		}
+		report, err := s.validation.ValidateCart(ctx, &Cart{ID: cart.ID, Items: cartItems, CouponCode: pc.CouponCode}, pc)
+		if err != nil {
+			return nil, errors.New("failed to validate cart: " + err.Error())
+		}
+		if !report.Valid {
+			return nil, &CartValidationError{Validation: report}
+		}
+	}
+
 	// Convert cart items to order items
 	var orderItems []models.OrderItem
-	total := 0.0
+	total := models.MoneyFromMinor(0)
 
 	var priceInputs []PriceInput
 	var appliedSets []models.AppliedPriceRule
@@ -68,27 +128,23 @@ func (s *OrderService) CreateOrderFromCart(ctx context.Context, userID string, c
 			return nil, errors.New("product not found: " + cartItem.ProductID)
 		}
 
+		variant := product.FindVariant(cartItem.VariantID)
+		if cartItem.VariantID != "" && variant == nil {
+			return nil, errors.New("variant not found: " + cartItem.VariantID)
+		}
+
 		orderItem := models.OrderItem{
 			ProductID:   productID,
 			VariantID:   cartItem.VariantID,
 			Quantity:    cartItem.Quantity,
-			Price:       product.BasePrice, // Use base price, can be enhanced with variant pricing
+			Price:       models.NewMoney(product.PriceFor(variant)),
 			ProductName: product.Name,
 			ImageURL:    product.ImageURL,
 		}
 
 		orderItems = append(orderItems, orderItem)
-		total += orderItem.Price * float64(orderItem.Quantity)
-
-		var variant *Variant
-		if cartItem.VariantID != "" {
-			for i := range product.Variants {
-				if product.Variants[i].VariantID == cartItem.VariantID {
-					variant = &product.Variants[i]
-					break
-				}
-			}
-		}
+		total = total.Add(orderItem.Price.Mul(orderItem.Quantity))
+
 		priceInputs = append(priceInputs, PriceInput{
 			Product:  product,
 			Variant:  variant,
@@ -100,13 +156,15 @@ func (s *OrderService) CreateOrderFromCart(ctx context.Context, userID string, c
 		return nil, errors.New("no valid items in cart")
 	}
 
-	if total <= 0 {
+	if total.Amount <= 0 {
 		return nil, errors.New("invalid order total")
 	}
 
 	// Resolve pricing (schedules + coupons/loyalty price sets)
 	subtotal := total
-	discount := 0.0
+	discount := models.MoneyFromMinor(0)
+	tax := models.MoneyFromMinor(0)
+	var taxes []models.TaxLine
 	var pricingMap map[string]interface{}
 
 	if priceCtx != nil && s.pricingService != nil {
@@ -119,10 +177,22 @@ func (s *OrderService) CreateOrderFromCart(ctx context.Context, userID string, c
 		}
 		subtotal = result.Subtotal
 		discount = result.Discount
-		total = result.Total
+		tax = result.Tax
+		taxes = result.Taxes
+		total = result.TotalWithTax
 		appliedSets = result.AppliedSets
+		priceLists := map[string]string{}
+		for i := range result.Lines {
+			// A price list replaces the catalog price the item is sold at.
+			orderItems[i].Price = result.Lines[i].BasePrice
+			orderItems[i].Tax = result.Lines[i].Tax
+			orderItems[i].Taxes = result.Lines[i].Taxes
+			if id := result.Lines[i].PriceListID; id != "" {
+				priceLists[id] = result.Lines[i].PriceList
+			}
+		}
 
-		if discount > 0 {
+		if discount.Amount > 0 || len(priceLists) > 0 {
 			pricingMap = map[string]interface{}{
 				"subtotal":     subtotal,
 				"discount":     discount,
@@ -131,13 +201,22 @@ func (s *OrderService) CreateOrderFromCart(ctx context.Context, userID string, c
 				"appliedSets":  appliedSets,
 				"schedules":    result.AppliedScheduleNames,
 			}
+			if len(priceLists) > 0 {
+				pricingMap["priceLists"] = priceLists
+			}
+			if len(result.Stacking) > 0 {
+				pricingMap["stacking"] = result.Stacking
+			}
+			if len(result.Clamps) > 0 {
+				pricingMap["clamps"] = result.Clamps
+			}
 		}
 	}
 
-	if subtotal <= 0 {
+	if subtotal.Amount <= 0 {
 		return nil, errors.New("invalid order subtotal")
 	}
-	if total <= 0 {
+	if total.Amount <= 0 {
 		return nil, errors.New("invalid order total after discounts")
 	}
 
@@ -149,6 +228,8 @@ func (s *OrderService) CreateOrderFromCart(ctx context.Context, userID string, c
 		Items:       orderItems,
 		Subtotal:    subtotal,
 		Discount:    discount,
+		Tax:         tax,
+		Taxes:       taxes,
 		Total:       total,
 		Pricing:     pricingMap,
 		Status:      models.OrderStatusPending,
@@ -162,30 +243,147 @@ func (s *OrderService) CreateOrderFromCart(ctx context.Context, userID string, c
 		return nil, err
 	}
 
-	// Save to database
-	_, err = s.collection.InsertOne(ctx, order)
-	if err != nil {
-		return nil, err
+	// Hold the stock of every item until the order is paid or cancelled, so
+	// two checkouts cannot both take the last unit.
+	var cartHolder string
+	if cart.ID != "" {
+		cartHolder = cartStockHolder(cart.ID)
+	}
+	if s.inventory != nil {
+		if err := s.inventory.HoldStockOver(ctx, order.ID.Hex(), cartHolder, stockLines(orderItems), now.Add(UsageHoldTTL)); err != nil {
+			s.inventory.ReleaseStockHolds(ctx, order.ID.Hex())
+			if errors.Is(err, ErrInsufficientStock) {
+				return nil, errors.New("some items are no longer in stock, please review your cart")
+			}
+			return nil, errors.New("failed to reserve stock: " + err.Error())
+		}
 	}
 
-	// Enforce usage caps: bump counters for every applied price set.
+	// Hold one use of every applied price set before the order exists, so
+	// concurrent checkouts cannot overrun a set's usage caps.
+	var setIDs []string
 	if len(appliedSets) > 0 && s.pricingService != nil {
 		seen := map[string]bool{}
-		var setIDs []string
 		for _, a := range appliedSets {
 			if !seen[a.SetID] {
 				seen[a.SetID] = true
 				setIDs = append(setIDs, a.SetID)
 			}
 		}
-		if err := s.pricingService.IncrementSetUsage(ctx, setIDs, userID); err != nil {
-			return nil, errors.New("failed to record set usage: " + err.Error())
+		err := s.pricingService.ReserveSetUsage(ctx, order.ID.Hex(), setIDs, priceCtx.CustomerID, now.Add(UsageHoldTTL))
+		if err == nil && priceCtx.CouponCode != "" {
+			if err = s.pricingService.RedeemCouponCode(ctx, order.ID.Hex(), priceCtx.CouponCode, priceCtx.CustomerID, setIDs); err != nil {
+				s.pricingService.ReleaseSetUsage(ctx, order.ID.Hex())
+			}
+		}
+		if err != nil {
+			s.releaseStock(ctx, order.ID.Hex())
+		}
+		if errors.Is(err, ErrSetUsageExhausted) || errors.Is(err, ErrCouponCodeUnavailable) {
+			return nil, errors.New("coupon or promotion no longer available, please review your cart")
 		}
+		if err != nil {
+			return nil, errors.New("failed to reserve set usage: " + err.Error())
+		}
+	}
+
+	// Save to database
+	_, err = s.collection.InsertOne(ctx, order)
+	if err != nil {
+		if len(setIDs) > 0 {
+			s.pricingService.ReleaseSetUsage(ctx, order.ID.Hex())
+		}
+		s.releaseStock(ctx, order.ID.Hex())
+		return nil, err
 	}
 
+	if s.inventory != nil && cartHolder != "" {
+		if err := s.inventory.ReleaseStockHolds(ctx, cartHolder); err != nil {
+			log.Printf("order %s: releasing stock of cart %s: %v", order.ID.Hex(), cart.ID, err)
+		}
+	}
 	return order, nil
 }
 
+// stockLines lists the variants and quantities of order items to hold.
+func stockLines(items []models.OrderItem) []StockLine {
+	lines := make([]StockLine, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, StockLine{ProductID: item.ProductID.Hex(), VariantID: item.VariantID, Quantity: item.Quantity})
+	}
+	return lines
+}
+
+// releaseStock gives back an order's stock holds, if stock is held.
+func (s *OrderService) releaseStock(ctx context.Context, orderID string) error {
+	if s.inventory == nil {
+		return nil
+	}
+	return s.inventory.ReleaseStockHolds(ctx, orderID)
+}
+
+// takeStock removes a paid order's items from stock. Held units are
+// converted; the rest (holds that lapsed, or orders placed before stock was
+// held) are decremented directly.
+func (s *OrderService) takeStock(ctx context.Context, order *models.Order) error {
+	items := order.Items
+	if s.inventory != nil {
+		committed, err := s.inventory.CommitStockHolds(ctx, order.ID.Hex())
+		if err != nil {
+			return err
+		}
+		items = unheldItems(items, committed)
+	}
+	return s.adjustStock(ctx, items, -1)
+}
+
+// unheldItems returns the order items, with their quantities reduced by the
+// units the holds covered; fully covered items are left out.
+func unheldItems(items []models.OrderItem, holds []models.StockHold) []models.OrderItem {
+	covered := map[stockKey]int{}
+	for _, h := range holds {
+		covered[stockKey{h.ProductID, h.VariantID}] += h.Quantity
+	}
+	var out []models.OrderItem
+	for _, item := range items {
+		key := stockKey{item.ProductID.Hex(), item.VariantID}
+		n := min(covered[key], item.Quantity)
+		covered[key] -= n
+		if item.Quantity > n {
+			item.Quantity -= n
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// KeepForPayment keeps a pending order and its holds until deadline plus
+// PaymentGrace, for a payment that may complete up to deadline (cash and
+// bank transfers complete long after checkout). It fails if the order is no
+// longer pending.
+func (s *OrderService) KeepForPayment(ctx context.Context, orderID string, deadline time.Time) error {
+	until := deadline.Add(PaymentGrace)
+	if s.pricingService != nil {
+		if err := s.pricingService.ExtendSetUsage(ctx, orderID, until); err != nil {
+			return err
+		}
+	}
+	if s.inventory != nil {
+		if err := s.inventory.ExtendStockHolds(ctx, orderID, until); err != nil {
+			return err
+		}
+	}
+	// Checked after extending, so an expiry that got in first is seen here.
+	order, err := s.GetOrderByID(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if order.Status != models.OrderStatusPending {
+		return errors.New("order is not in payable state")
+	}
+	return nil
+}
+
 // GetOrderByID retrieves an order by ID
 func (s *OrderService) GetOrderByID(ctx context.Context, orderID string) (*models.Order, error) {
 	orderObjID, err := primitive.ObjectIDFromHex(orderID)
@@ -370,13 +568,40 @@ func (s *OrderService) UpdateOrderStatus(ctx context.Context, orderID string, ne
 		return errors.New("invalid status transition from " + string(order.Status) + " to " + string(newStatus))
 	}
 
-	// Inventory: decrement stock when an order becomes paid
-	if newStatus == models.OrderStatusPaid && order.Status != models.OrderStatusPaid {
-		if err := s.adjustStock(ctx, order.Items, -1); err != nil {
+	// Move the status only from the one read, so of two racing updates (an
+	// expiry cancelling the order while its payment comes in) only one
+	// applies its side effects.
+	result, err := s.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": orderObjID, "status": order.Status},
+		bson.M{"$set": bson.M{
+			"status":    newStatus,
+			"updatedAt": time.Now(),
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return ErrOrderStatusChanged
+	}
+	// Holds a failed step below leaves behind are settled by the expiry
+	// routine, by the order's new status.
+
+	// Inventory: convert the order's stock holds when it becomes paid
+	if newStatus == models.OrderStatusPaid {
+		if err := s.takeStock(ctx, order); err != nil {
 			return errors.New("failed to update inventory: " + err.Error())
 		}
 	}
 
+	// Inventory: give back what a cancelled order still holds
+	if newStatus == models.OrderStatusCancelled {
+		if err := s.releaseStock(ctx, orderID); err != nil {
+			return errors.New("failed to release stock holds: " + err.Error())
+		}
+	}
+
 	// Inventory: restore stock when a paid order is cancelled
 	if newStatus == models.OrderStatusCancelled && order.Status == models.OrderStatusPaid {
 		if err := s.adjustStock(ctx, order.Items, 1); err != nil {
@@ -384,27 +609,48 @@ func (s *OrderService) UpdateOrderStatus(ctx context.Context, orderID string, ne
 		}
 	}
 
-	// Update status
-	update := bson.M{
-		"status":    newStatus,
-		"updatedAt": time.Now(),
+	// Price-set usage: paid orders consume their held uses, cancelled ones
+	// give back whatever is still held (committed uses stay counted).
+	if s.pricingService != nil {
+		switch newStatus {
+		case models.OrderStatusPaid:
+			err = s.pricingService.CommitSetUsage(ctx, orderID)
+		case models.OrderStatusCancelled:
+			err = s.pricingService.ReleaseSetUsage(ctx, orderID)
+		}
+		if err != nil {
+			return errors.New("failed to settle price set usage: " + err.Error())
+		}
 	}
 
-	result, err := s.collection.UpdateOne(
-		ctx,
-		bson.M{"_id": orderObjID},
-		bson.M{"$set": update},
-	)
+	s.publishStatusChange(ctx, order, newStatus)
+	return nil
+}
 
-	if err != nil {
-		return err
+// publishStatusChange announces payments and cancellations (non-blocking).
+func (s *OrderService) publishStatusChange(ctx context.Context, order *models.Order, newStatus models.OrderStatus) {
+	if s.eventBus == nil {
+		return
 	}
-
-	if result.ModifiedCount == 0 {
-		return errors.New("order not updated")
+	now := time.Now()
+	switch newStatus {
+	case models.OrderStatusPaid:
+		s.eventBus.Publish(ctx, OrderPaid{
+			OrderID:   order.ID.Hex(),
+			UserID:    order.UserID.Hex(),
+			Total:     order.Total,
+			OrderedAt: order.CreatedAt,
+			Timestamp: now,
+		})
+	case models.OrderStatusCancelled:
+		s.eventBus.Publish(ctx, OrderCancelled{
+			OrderID:   order.ID.Hex(),
+			UserID:    order.UserID.Hex(),
+			Total:     order.Total,
+			WasPaid:   order.Status == models.OrderStatusPaid,
+			Timestamp: now,
+		})
 	}
-
-	return nil
 }
 
 // UpdateOrderPayment updates payment information
@@ -437,6 +683,94 @@ func (s *OrderService) UpdateOrderPayment(ctx context.Context, orderID string, p
 	return s.UpdateOrderStatus(ctx, orderID, models.OrderStatusPaid)
 }
 
+// StartExpiryRoutine periodically cancels pending orders whose price-set or
+// stock holds expired, releasing the uses and units for other customers.
+func (s *OrderService) StartExpiryRoutine(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.ExpireUsageHolds(context.Background(), time.Now()); err != nil {
+			log.Printf("Failed to expire price set holds: %v", err)
+		}
+		if err := s.ExpireStockHolds(context.Background(), time.Now()); err != nil {
+			log.Printf("Failed to expire stock holds: %v", err)
+		}
+	}
+}
+
+// ExpireUsageHolds settles every price-set hold past its expiry: the pending
+// order is cancelled (which releases it), a hold left on a paid order is
+// committed, and anything else is released.
+func (s *OrderService) ExpireUsageHolds(ctx context.Context, now time.Time) error {
+	if s.pricingService == nil {
+		return nil
+	}
+	orderIDs, err := s.pricingService.ExpiredSetReservations(ctx, now)
+	if err != nil {
+		return err
+	}
+	for _, id := range orderIDs {
+		order, err := s.GetOrderByID(ctx, id)
+		switch {
+		case err != nil:
+			err = s.pricingService.ReleaseSetUsage(ctx, id)
+		case order.Status == models.OrderStatusPending:
+			// A payment that got in first wins; its holds are settled with it.
+			if err = s.UpdateOrderStatus(ctx, id, models.OrderStatusCancelled); errors.Is(err, ErrOrderStatusChanged) {
+				err = nil
+			}
+		case order.Status == models.OrderStatusCancelled:
+			err = s.pricingService.ReleaseSetUsage(ctx, id)
+		default:
+			err = s.pricingService.CommitSetUsage(ctx, id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpireStockHolds settles every stock hold past its expiry the same way:
+// carts' holds and those of cancelled or missing orders are released, a
+// pending order is cancelled, and a hold left on a paid order is committed.
+func (s *OrderService) ExpireStockHolds(ctx context.Context, now time.Time) error {
+	if s.inventory == nil {
+		return nil
+	}
+	holders, err := s.inventory.ExpiredStockHolders(ctx, now)
+	if err != nil {
+		return err
+	}
+	for _, holder := range holders {
+		if isCartStockHolder(holder) {
+			if err := s.inventory.ReleaseStockHolds(ctx, holder); err != nil {
+				return err
+			}
+			continue
+		}
+		order, err := s.GetOrderByID(ctx, holder)
+		switch {
+		case err != nil:
+			err = s.inventory.ReleaseStockHolds(ctx, holder)
+		case order.Status == models.OrderStatusPending:
+			// A payment that got in first wins; its holds are settled with it.
+			if err = s.UpdateOrderStatus(ctx, holder, models.OrderStatusCancelled); errors.Is(err, ErrOrderStatusChanged) {
+				err = nil
+			}
+		case order.Status == models.OrderStatusCancelled:
+			err = s.inventory.ReleaseStockHolds(ctx, holder)
+		default:
+			_, err = s.inventory.CommitStockHolds(ctx, holder)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // GetOrderStats returns basic order statistics
 func (s *OrderService) GetOrderStats(ctx context.Context) (map[string]int, error) {
 	pipeline := []bson.M{
@@ -15,6 +15,8 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"mercadomio-backend/models"
@@ -93,13 +95,10 @@ func (s *PaymentService) CreatePaymentIntent(ctx context.Context, orderID string
 		return nil, fmt.Errorf("order is not in payable state")
 	}
 
-	// Calculate amount in cents (assuming order.Total is in dollars)
-	amount := int64(order.Total * 100) // Convert to cents
-
-	// Create payment intent parameters
+	// Order totals are already exact minor units
 	params := &stripe.PaymentIntentParams{
-		Amount:   stripe.Int64(amount),
-		Currency: stripe.String("usd"),
+		Amount:   stripe.Int64(order.Total.Amount),
+		Currency: stripe.String(strings.ToLower(order.Total.Currency)),
 		Metadata: map[string]string{
 			"order_id": orderID,
 			"user_id":  userID,
@@ -285,19 +284,15 @@ func (s *PaymentService) CreateCheckoutSession(ctx context.Context, orderID stri
 		}, nil
 	}
 
-	lineItems := make([]map[string]interface{}, 0, len(order.Items))
-	for _, item := range order.Items {
-		name := item.ProductName
-		if name == "" {
-			name = "Producto"
-		}
-		lineItems = append(lineItems, map[string]interface{}{
-			"name":       name,
-			"unit_price": int(item.Price * 100), // integer cents
-			"quantity":   item.Quantity,
-		})
+	// The checkout takes payments up to the hold TTL from now, and the order
+	// keeps its holds until then.
+	deadline := time.Now().Add(UsageHoldTTL)
+	if err := s.orderService.KeepForPayment(ctx, orderID, deadline); err != nil {
+		return nil, fmt.Errorf("failed to hold order for payment: %w", err)
 	}
 
+	lineItems, discountLines := conektaLines(order)
+
 	successURL := s.baseURL + "/payments/confirmation?order_id=" + orderID
 	failureURL := s.baseURL + "/payments/cancelled?order_id=" + orderID
 	if envSuccess := os.Getenv("CONEKTA_SUCCESS_URL"); envSuccess != "" {
@@ -308,7 +303,7 @@ func (s *PaymentService) CreateCheckoutSession(ctx context.Context, orderID stri
 	}
 
 	body := map[string]interface{}{
-		"currency": "MXN",
+		"currency": order.Total.Currency,
 		"customer_info": map[string]interface{}{
 			"name":  "Mercado Mio Customer",
 			"email": "customer@mercadomio.mx",
@@ -320,12 +315,19 @@ func (s *PaymentService) CreateCheckoutSession(ctx context.Context, orderID stri
 			"success_url":             successURL,
 			"failure_url":             failureURL,
 			"allowed_payment_methods": []string{"card", "cash", "bank_transfer"},
+			"expires_at":              deadline.Unix(),
 		},
 		"metadata": map[string]interface{}{
 			"internal_order_id": orderID,
 		},
 		"pre_authorize": false,
 	}
+	if len(discountLines) > 0 {
+		body["discount_lines"] = discountLines
+	}
+	if taxLines := conektaTaxLines(order); len(taxLines) > 0 {
+		body["tax_lines"] = taxLines
+	}
 
 	payload, err := json.Marshal(body)
 	if err != nil {
@@ -367,6 +369,7 @@ func (s *PaymentService) CreateCheckoutSession(ctx context.Context, orderID stri
 		"conekta_order_id": result.ID,
 		"checkout_id":      result.Checkout.ID,
 		"status":           "pending",
+		"expires_at":       deadline.Format(time.RFC3339),
 	}
 	if err := s.orderService.AttachPaymentInfo(ctx, orderID, ref); err != nil {
 		return nil, fmt.Errorf("failed to store payment reference: %w", err)
@@ -509,3 +512,58 @@ func (s *PaymentService) HandleConektaWebhook(ctx context.Context, payload []byt
 	log.Printf("[conekta-webhook] order %s marked paid via %s (event %s)", order.ID.Hex(), paymentMethod, event.ID)
 	return event.Type, nil
 }
+
+// conektaLines builds the Conekta line items and discount lines for an order.
+// Line items carry list prices (variant-aware) in integer cents. Whatever
+// separates their sum from order.Total — price-set discounts, or a schedule
+// that raised prices — is sent as a discount line or an adjustment item, so
+// Conekta always charges exactly order.Total. Taxes go in conektaTaxLines.
+func conektaLines(order *models.Order) ([]map[string]interface{}, []map[string]interface{}) {
+	lineItems := make([]map[string]interface{}, 0, len(order.Items)+1)
+	itemsTotal := int64(0)
+	for _, item := range order.Items {
+		name := item.ProductName
+		if name == "" {
+			name = "Producto"
+		}
+		lineItems = append(lineItems, map[string]interface{}{
+			"name":       name,
+			"unit_price": item.Price.Amount,
+			"quantity":   item.Quantity,
+		})
+		itemsTotal += item.Price.Amount * int64(item.Quantity)
+	}
+
+	var discountLines []map[string]interface{}
+	switch diff := itemsTotal - order.Total.Sub(order.Tax).Amount; {
+	case diff > 0:
+		discountLines = append(discountLines, map[string]interface{}{
+			"code":   "PROMO",
+			"type":   "campaign",
+			"amount": diff,
+		})
+	case diff < 0:
+		lineItems = append(lineItems, map[string]interface{}{
+			"name":       "Ajuste de precio",
+			"unit_price": -diff,
+			"quantity":   1,
+		})
+	}
+	return lineItems, discountLines
+}
+
+// conektaTaxLines reports the order's taxes, one Conekta tax line per tax
+// and rate.
+func conektaTaxLines(order *models.Order) []map[string]interface{} {
+	var taxLines []map[string]interface{}
+	for _, t := range order.Taxes {
+		if t.Amount.Amount == 0 {
+			continue
+		}
+		taxLines = append(taxLines, map[string]interface{}{
+			"description": fmt.Sprintf("%s %s%%", t.Tax, strconv.FormatFloat(t.Rate, 'f', -1, 64)),
+			"amount":      t.Amount.Amount,
+		})
+	}
+	return taxLines
+}
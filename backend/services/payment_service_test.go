@@ -0,0 +1,54 @@
+package services
+
+import (
+	"testing"
+
+	"mercadomio-backend/models"
+)
+
+func TestConektaLinesMatchOrderTotal(t *testing.T) {
+	items := []models.OrderItem{
+		{ProductName: "Café", Quantity: 3, Price: mxn(33.33)},
+		{ProductName: "Pan", Quantity: 1, Price: mxn(12.5)},
+	}
+
+	// Items add up to 112.49; the discount line carries exactly the difference.
+	discounted := &models.Order{Items: items, Total: mxn(101.99)}
+	lineItems, discountLines := conektaLines(discounted)
+	if len(lineItems) != 2 || lineItems[0]["unit_price"] != int64(3333) {
+		t.Fatalf("unexpected line items %+v", lineItems)
+	}
+	if len(discountLines) != 1 || discountLines[0]["amount"] != int64(1050) {
+		t.Errorf("expected a 10.50 discount line, got %+v", discountLines)
+	}
+
+	// A schedule that raised prices shows up as an adjustment item.
+	raised := &models.Order{Items: items, Total: mxn(113.00)}
+	lineItems, discountLines = conektaLines(raised)
+	if len(discountLines) != 0 || len(lineItems) != 3 || lineItems[2]["unit_price"] != int64(51) {
+		t.Errorf("expected a 0.51 adjustment item, got %+v / %+v", lineItems, discountLines)
+	}
+
+	exact := &models.Order{Items: items, Total: mxn(112.49)}
+	if lineItems, discountLines = conektaLines(exact); len(lineItems) != 2 || len(discountLines) != 0 {
+		t.Errorf("no adjustment expected, got %+v / %+v", lineItems, discountLines)
+	}
+}
+
+func TestConektaLinesWithTax(t *testing.T) {
+	items := []models.OrderItem{{ProductName: "Refresco", Quantity: 2, Price: mxn(50)}}
+	order := &models.Order{
+		Items: items,
+		Tax:   mxn(16),
+		Taxes: []models.TaxLine{{Tax: models.TaxIVA, Rate: 16, Base: mxn(100), Amount: mxn(16)}, {Tax: models.TaxIVA, Rate: 0, Amount: mxn(0)}},
+		Total: mxn(116),
+	}
+	lineItems, discountLines := conektaLines(order)
+	if len(lineItems) != 1 || len(discountLines) != 0 {
+		t.Errorf("tax should not show up as an adjustment, got %+v / %+v", lineItems, discountLines)
+	}
+	taxLines := conektaTaxLines(order)
+	if len(taxLines) != 1 || taxLines[0]["amount"] != int64(1600) || taxLines[0]["description"] != "IVA 16%" {
+		t.Errorf("unexpected tax lines %+v", taxLines)
+	}
+}
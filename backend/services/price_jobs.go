@@ -0,0 +1,515 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"mercadomio-backend/models"
+)
+
+const (
+	// MaxPriceJobRows bounds the rows of one "set" job.
+	MaxPriceJobRows = 20000
+	// priceJobBatchSize is how many products one bulk write updates.
+	priceJobBatchSize = 200
+)
+
+// ---- Bulk price jobs ----
+
+// PreviewPriceJob computes the base price changes of a job and stores it as a
+// preview; nothing is written to products until ApplyPriceJob. Products the
+// job would leave alone (unknown, unchanged, or pushed to zero or below) are
+// listed under Skipped. Job-level Skipped entries, such as unreadable CSV
+// rows, are kept. The preview is the job's draft: author may not apply it.
+func (s *PricingService) PreviewPriceJob(ctx context.Context, job *models.PriceJob, author string) (*models.PriceJob, error) {
+	if author == "" {
+		return nil, errors.New("price jobs need an authenticated admin")
+	}
+	var changes []models.PriceJobChange
+	var skipped []models.PriceJobSkip
+	switch job.Operation {
+	case models.PriceJobPercentage, models.PriceJobAbsolute:
+		if job.Value == 0 {
+			return nil, errors.New("value must not be zero")
+		}
+		if job.Operation == models.PriceJobPercentage && job.Value <= -100 {
+			return nil, errors.New("percentage must be above -100")
+		}
+		products, err := s.selectJobProducts(ctx, job.Selector)
+		if err != nil {
+			return nil, err
+		}
+		changes, skipped = planPriceAdjustment(job.Operation, job.Value, products)
+	case models.PriceJobSet:
+		if len(job.Rows) == 0 {
+			return nil, errors.New("a set job needs at least one row")
+		}
+		if len(job.Rows) > MaxPriceJobRows {
+			return nil, fmt.Errorf("a set job takes at most %d rows", MaxPriceJobRows)
+		}
+		products, err := s.rowProducts(ctx, job.Rows)
+		if err != nil {
+			return nil, err
+		}
+		changes, skipped = planPriceRows(job.Rows, products)
+	default:
+		return nil, errors.New("unknown price job operation " + job.Operation)
+	}
+
+	now := time.Now()
+	job.ID = primitive.NewObjectID()
+	job.Status = models.PriceJobPreview
+	job.Changes = changes
+	job.Skipped = append(job.Skipped, skipped...)
+	job.CreatedBy = author
+	job.AppliedBy = ""
+	job.RolledBackBy = ""
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	job.AppliedAt = nil
+	job.RolledBackAt = nil
+	if _, err := s.jobs().InsertOne(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// ListPriceJobs returns jobs newest first, without their change lists.
+func (s *PricingService) ListPriceJobs(ctx context.Context, page, limit int) ([]models.PriceJob, int64, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{"changes": 0, "skipped": 0})
+	total, _ := s.jobs().CountDocuments(ctx, bson.M{})
+	cursor, err := s.jobs().Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	var out []models.PriceJob
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, 0, err
+	}
+	return out, total, nil
+}
+
+func (s *PricingService) GetPriceJob(ctx context.Context, id string) (*models.PriceJob, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var job models.PriceJob
+	if err := s.jobs().FindOne(ctx, bson.M{"_id": objID}).Decode(&job); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.New("price job not found")
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ApplyPriceJob writes a previewed job's base prices in batches, approved by
+// an admin other than its author, as pricing drafts are. A product is only
+// updated while it still has the price the preview saw; one edited in
+// between is marked as a conflict and left alone. Every applied change gets
+// a price history entry referencing the job.
+func (s *PricingService) ApplyPriceJob(ctx context.Context, id, approver string) (*models.PriceJob, error) {
+	if approver == "" {
+		return nil, errors.New("approval needs an authenticated admin")
+	}
+	preview, err := s.GetPriceJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if preview.CreatedBy == approver {
+		return nil, errors.New("a price job must be applied by a different admin than its author")
+	}
+	job, err := s.claimPriceJob(ctx, id, []string{models.PriceJobPreview}, models.PriceJobApplying)
+	if err != nil {
+		return nil, err
+	}
+	err = s.runPriceJob(ctx, job, models.PriceChangePending, models.PriceChangeApplied,
+		func(c models.PriceJobChange) (float64, float64) { return c.OldPrice, c.NewPrice },
+		fmt.Sprintf("price job %q", job.Name))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	job.Status = models.PriceJobApplied
+	job.AppliedAt = &now
+	job.AppliedBy = approver
+	return job, s.saveJob(ctx, job, bson.M{"appliedAt": now, "appliedBy": approver})
+}
+
+// RollbackPriceJob restores the base prices an applied job changed, back to
+// the ones it was approved over. Products whose price moved again after the
+// job keep their newer price and are marked as conflicts.
+func (s *PricingService) RollbackPriceJob(ctx context.Context, id, by string) (*models.PriceJob, error) {
+	if by == "" {
+		return nil, errors.New("rollbacks need an authenticated admin")
+	}
+	job, err := s.claimPriceJob(ctx, id, []string{models.PriceJobApplied}, models.PriceJobRollingBack)
+	if err != nil {
+		return nil, err
+	}
+	err = s.runPriceJob(ctx, job, models.PriceChangeApplied, models.PriceChangeRolledBack,
+		func(c models.PriceJobChange) (float64, float64) { return c.NewPrice, c.OldPrice },
+		fmt.Sprintf("rollback of price job %q", job.Name))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	job.Status = models.PriceJobRolledBack
+	job.RolledBackAt = &now
+	job.RolledBackBy = by
+	return job, s.saveJob(ctx, job, bson.M{"rolledBackAt": now, "rolledBackBy": by})
+}
+
+func (s *PricingService) jobs() *mongo.Collection {
+	return s.db.Collection("price_jobs")
+}
+
+// claimPriceJob moves a job from one of the given statuses to next, so two
+// concurrent requests can't both apply or roll back the same job.
+func (s *PricingService) claimPriceJob(ctx context.Context, id string, from []string, next string) (*models.PriceJob, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var job models.PriceJob
+	err = s.jobs().FindOneAndUpdate(ctx,
+		bson.M{"_id": objID, "status": bson.M{"$in": from}},
+		bson.M{"$set": bson.M{"status": next, "updatedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		current, err := s.GetPriceJob(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("price job is %s", current.Status)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// runPriceJob moves every change in status from to status to, batch by
+// batch: prices(c) gives the price a product must still have and the one it
+// gets. Progress is saved after each batch.
+func (s *PricingService) runPriceJob(ctx context.Context, job *models.PriceJob, from, to string,
+	prices func(models.PriceJobChange) (float64, float64), reason string) error {
+	var pending []int
+	for i, c := range job.Changes {
+		if c.Status == from {
+			pending = append(pending, i)
+		}
+	}
+	products := s.db.Collection("products")
+	for start := 0; start < len(pending); start += priceJobBatchSize {
+		batch := pending[start:min(start+priceJobBatchSize, len(pending))]
+		now := time.Now()
+		writes := make([]mongo.WriteModel, 0, len(batch))
+		ids := make([]primitive.ObjectID, 0, len(batch))
+		for _, i := range batch {
+			c := job.Changes[i]
+			objID, err := primitive.ObjectIDFromHex(c.ProductID)
+			if err != nil {
+				return err
+			}
+			want, next := prices(c)
+			ids = append(ids, objID)
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": objID, "basePrice": want}).
+				SetUpdate(bson.M{"$set": bson.M{"basePrice": next, "updatedAt": now}}))
+		}
+		res, err := products.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return err
+		}
+
+		// A short count means some products had moved: read back which.
+		current := map[string]float64{}
+		if int(res.ModifiedCount) < len(batch) {
+			cursor, err := products.Find(ctx, bson.M{"_id": bson.M{"$in": ids}},
+				options.Find().SetProjection(bson.M{"basePrice": 1}))
+			if err != nil {
+				return err
+			}
+			var docs []Product
+			if err := cursor.All(ctx, &docs); err != nil {
+				return err
+			}
+			for _, d := range docs {
+				current[d.ID.Hex()] = d.BasePrice
+			}
+		}
+
+		var entries []models.PriceHistoryEntry
+		for _, i := range batch {
+			c := &job.Changes[i]
+			was, next := prices(*c)
+			if int(res.ModifiedCount) < len(batch) && current[c.ProductID] != next {
+				c.Status = models.PriceChangeConflict
+				continue
+			}
+			c.Status = to
+			entries = append(entries, models.PriceHistoryEntry{
+				ProductID:   c.ProductID,
+				OldPrice:    was,
+				NewPrice:    next,
+				Reason:      reason,
+				SourceType:  models.PriceHistorySourceBulkJob,
+				SourceRef:   job.ID.Hex(),
+				EffectiveAt: now,
+			})
+		}
+		if err := s.RecordPriceHistory(ctx, entries); err != nil {
+			return err
+		}
+		if err := s.saveJob(ctx, job, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveJob stores a job's status and changes, plus any extra fields.
+func (s *PricingService) saveJob(ctx context.Context, job *models.PriceJob, extra bson.M) error {
+	job.UpdatedAt = time.Now()
+	set := bson.M{"status": job.Status, "changes": job.Changes, "updatedAt": job.UpdatedAt}
+	for k, v := range extra {
+		set[k] = v
+	}
+	_, err := s.jobs().UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": set})
+	return err
+}
+
+// selectJobProducts loads the products a selector picks. Categories are
+// matched through the category tree, so a parent category takes in its
+// subcategories.
+func (s *PricingService) selectJobProducts(ctx context.Context, sel models.PriceJobSelector) ([]Product, error) {
+	if sel.Category == "" && sel.Tag == "" && len(sel.ProductIDs) == 0 {
+		return nil, errors.New("selector needs a category, tag or product ids")
+	}
+	filter := bson.M{}
+	if sel.Tag != "" {
+		filter["customAttributes.tags"] = sel.Tag
+	}
+	if len(sel.ProductIDs) > 0 {
+		ids := make([]primitive.ObjectID, 0, len(sel.ProductIDs))
+		for _, id := range sel.ProductIDs {
+			objID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return nil, errors.New("invalid product id " + id)
+			}
+			ids = append(ids, objID)
+		}
+		filter["_id"] = bson.M{"$in": ids}
+	}
+	cursor, err := s.db.Collection("products").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var products []Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+	if sel.Category == "" {
+		return products, nil
+	}
+	snap, err := s.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(products, func(p Product) bool {
+		return !slices.Contains(snap.categoryKeysFor(&p), sel.Category)
+	}), nil
+}
+
+// rowProducts loads the products a set job's rows name, by id or SKU.
+func (s *PricingService) rowProducts(ctx context.Context, rows []models.PriceJobRow) ([]Product, error) {
+	var ids []primitive.ObjectID
+	var skus []string
+	for _, r := range rows {
+		if objID, err := primitive.ObjectIDFromHex(r.ProductID); err == nil {
+			ids = append(ids, objID)
+		}
+		if r.SKU != "" {
+			skus = append(skus, r.SKU)
+		}
+	}
+	cursor, err := s.db.Collection("products").Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"sku": bson.M{"$in": skus}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	var products []Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// planPriceAdjustment moves every product's base price by a percentage or an
+// absolute amount, rounded to the minor unit.
+func planPriceAdjustment(operation string, value float64, products []Product) ([]models.PriceJobChange, []models.PriceJobSkip) {
+	var changes []models.PriceJobChange
+	var skipped []models.PriceJobSkip
+	for _, p := range products {
+		old := models.NewMoney(p.BasePrice)
+		next := old.Add(models.NewMoney(value))
+		if operation == models.PriceJobPercentage {
+			next = old.Add(old.Percent(value))
+		}
+		if skip, ok := checkPriceChange(p, old, next); !ok {
+			skipped = append(skipped, skip)
+			continue
+		}
+		changes = append(changes, newPriceChange(p, next))
+	}
+	return changes, skipped
+}
+
+// planPriceRows matches set-job rows to products by id, then SKU.
+func planPriceRows(rows []models.PriceJobRow, products []Product) ([]models.PriceJobChange, []models.PriceJobSkip) {
+	byID := make(map[string]*Product, len(products))
+	bySKU := make(map[string]*Product, len(products))
+	for i := range products {
+		byID[products[i].ID.Hex()] = &products[i]
+		if products[i].SKU != "" {
+			bySKU[products[i].SKU] = &products[i]
+		}
+	}
+
+	var changes []models.PriceJobChange
+	var skipped []models.PriceJobSkip
+	seen := map[string]bool{}
+	for _, r := range rows {
+		p := byID[r.ProductID]
+		if p == nil {
+			p = bySKU[r.SKU]
+		}
+		if p == nil {
+			skipped = append(skipped, models.PriceJobSkip{Row: r.Row, ProductID: r.ProductID, SKU: r.SKU, Reason: "unknown product"})
+			continue
+		}
+		if seen[p.ID.Hex()] {
+			skipped = append(skipped, models.PriceJobSkip{Row: r.Row, ProductID: p.ID.Hex(), SKU: p.SKU, Reason: "product already listed in an earlier row"})
+			continue
+		}
+		seen[p.ID.Hex()] = true
+		old, next := models.NewMoney(p.BasePrice), models.NewMoney(r.Price)
+		if skip, ok := checkPriceChange(*p, old, next); !ok {
+			skip.Row = r.Row
+			skipped = append(skipped, skip)
+			continue
+		}
+		changes = append(changes, newPriceChange(*p, next))
+	}
+	return changes, skipped
+}
+
+// checkPriceChange rejects moves that change nothing or leave no price.
+func checkPriceChange(p Product, old, next models.Money) (models.PriceJobSkip, bool) {
+	skip := models.PriceJobSkip{ProductID: p.ID.Hex(), SKU: p.SKU}
+	switch {
+	case next.Amount <= 0:
+		skip.Reason = "new price " + next.String() + " is not positive"
+	case next == old:
+		skip.Reason = "price unchanged"
+	default:
+		return skip, true
+	}
+	return skip, false
+}
+
+func newPriceChange(p Product, next models.Money) models.PriceJobChange {
+	return models.PriceJobChange{
+		ProductID: p.ID.Hex(),
+		SKU:       p.SKU,
+		Name:      p.Name,
+		OldPrice:  p.BasePrice, // as stored: apply matches on it
+		NewPrice:  next.Float(),
+		Status:    models.PriceChangePending,
+	}
+}
+
+// ParsePriceJobCSV reads set-job rows from a CSV with a header row naming a
+// "sku" or "productId" column and a "price" column (case-insensitive; other
+// columns are ignored). Prices may carry a "$" and thousands separators.
+// Rows whose price can't be read are returned as skips rather than failing
+// the file.
+func ParsePriceJobCSV(r io.Reader) ([]models.PriceJobRow, []models.PriceJobSkip, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, errors.New("empty CSV")
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	idCol, hasID := col["productid"]
+	skuCol, hasSKU := col["sku"]
+	priceCol, hasPrice := col["price"]
+	if !hasPrice || (!hasID && !hasSKU) {
+		return nil, nil, errors.New(`CSV header needs a "price" column and a "sku" or "productId" column`)
+	}
+	field := func(rec []string, i int, ok bool) string {
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	var rows []models.PriceJobRow
+	var skipped []models.PriceJobSkip
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		row := models.PriceJobRow{Row: line, ProductID: field(rec, idCol, hasID), SKU: field(rec, skuCol, hasSKU)}
+		if row.ProductID == "" && row.SKU == "" {
+			continue // blank line
+		}
+		raw := strings.TrimPrefix(field(rec, priceCol, true), "$")
+		num := raw
+		if strings.Contains(num, ".") {
+			num = strings.ReplaceAll(num, ",", "") // thousands separators; a lone comma is ambiguous
+		}
+		price, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			skipped = append(skipped, models.PriceJobSkip{Row: line, ProductID: row.ProductID, SKU: row.SKU, Reason: "unreadable price " + strconv.Quote(raw)})
+			continue
+		}
+		row.Price = price
+		rows = append(rows, row)
+		if len(rows) > MaxPriceJobRows {
+			return nil, nil, fmt.Errorf("a set job takes at most %d rows", MaxPriceJobRows)
+		}
+	}
+	return rows, skipped, nil
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+)
+
+func TestPlanPriceAdjustment(t *testing.T) {
+	rice := Product{ID: primitive.NewObjectID(), SKU: "ARROZ-1K", BasePrice: 32.50}
+	beans := Product{ID: primitive.NewObjectID(), SKU: "FRIJOL-1K", BasePrice: 41}
+	gum := Product{ID: primitive.NewObjectID(), SKU: "CHICLE", BasePrice: 0.10}
+
+	changes, skipped := planPriceAdjustment(models.PriceJobPercentage, 4.5, []Product{rice, beans, gum})
+	if len(changes) != 2 || len(skipped) != 1 {
+		t.Fatalf("expected 2 changes and gum skipped, got %+v / %+v", changes, skipped)
+	}
+	// 32.50 * 1.045 = 33.9625 -> 33.96; 41 * 1.045 = 42.845 -> 42.85.
+	if changes[0].NewPrice != 33.96 || changes[1].NewPrice != 42.85 {
+		t.Errorf("unexpected new prices %v, %v", changes[0].NewPrice, changes[1].NewPrice)
+	}
+	if changes[0].OldPrice != 32.50 || changes[0].Status != models.PriceChangePending || changes[0].SKU != "ARROZ-1K" {
+		t.Errorf("unexpected change %+v", changes[0])
+	}
+	if skipped[0].SKU != "CHICLE" || skipped[0].Reason != "price unchanged" {
+		t.Errorf("0.10 +4.5%% rounds back to 0.10 and should be skipped, got %+v", skipped[0])
+	}
+
+	_, skipped = planPriceAdjustment(models.PriceJobAbsolute, -35, []Product{rice, beans})
+	if len(skipped) != 1 || skipped[0].SKU != "ARROZ-1K" || !strings.Contains(skipped[0].Reason, "not positive") {
+		t.Errorf("expected rice pushed below zero to be skipped, got %+v", skipped)
+	}
+}
+
+func TestPlanPriceRows(t *testing.T) {
+	rice := Product{ID: primitive.NewObjectID(), SKU: "ARROZ-1K", BasePrice: 32.50}
+	beans := Product{ID: primitive.NewObjectID(), SKU: "FRIJOL-1K", BasePrice: 41}
+	oil := Product{ID: primitive.NewObjectID(), SKU: "ACEITE-1L", BasePrice: 45}
+	rows := []models.PriceJobRow{
+		{Row: 2, SKU: "ARROZ-1K", Price: 34.90},
+		{Row: 3, ProductID: beans.ID.Hex(), Price: 41},
+		{Row: 4, SKU: "AZUCAR-1K", Price: 28},
+		{Row: 5, ProductID: rice.ID.Hex(), Price: 35},
+		{Row: 6, SKU: "ACEITE-1L", Price: 0},
+	}
+	changes, skipped := planPriceRows(rows, []Product{rice, beans, oil})
+	if len(changes) != 1 || changes[0].ProductID != rice.ID.Hex() || changes[0].NewPrice != 34.90 {
+		t.Fatalf("expected only the rice row applied, got %+v", changes)
+	}
+	reasons := map[int]string{}
+	for _, s := range skipped {
+		reasons[s.Row] = s.Reason
+	}
+	want := map[int]string{
+		3: "price unchanged",
+		4: "unknown product",
+		5: "product already listed in an earlier row",
+		6: "new price 0.00 MXN is not positive",
+	}
+	for row, reason := range want {
+		if reasons[row] != reason {
+			t.Errorf("row %d: expected %q, got %q", row, reason, reasons[row])
+		}
+	}
+}
+
+func TestParsePriceJobCSV(t *testing.T) {
+	in := "\ufeffSKU, Nombre, Price\n" +
+		"ARROZ-1K, Arroz 1kg, 34.90\n" +
+		"FRIJOL-1K, Frijol, \"$1,041.50\"\n" +
+		"\n" +
+		"AZUCAR-1K, Azúcar, \"28,5\"\n"
+	rows, skipped, err := ParsePriceJobCSV(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].SKU != "ARROZ-1K" || rows[0].Price != 34.90 || rows[0].Row != 2 {
+		t.Fatalf("unexpected rows %+v", rows)
+	}
+	if rows[1].Price != 1041.50 {
+		t.Errorf("expected $ and thousands separators stripped, got %v", rows[1].Price)
+	}
+	if len(skipped) != 1 || skipped[0].SKU != "AZUCAR-1K" || skipped[0].Row != 5 {
+		t.Errorf("a decimal comma is ambiguous and should be skipped, got %+v", skipped)
+	}
+
+	if _, _, err := ParsePriceJobCSV(strings.NewReader("name,price\nArroz,10\n")); err == nil {
+		t.Error("expected an error without a sku or productId column")
+	}
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"mercadomio-backend/models"
+)
+
+// ---- Price lists ----
+
+func (s *PricingService) priceLists() *mongo.Collection {
+	return s.db.Collection("price_lists")
+}
+
+func (s *PricingService) ListPriceLists(ctx context.Context, page, limit int) ([]models.PriceList, int64, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "priority", Value: 1}, {Key: "name", Value: 1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	total, _ := s.priceLists().CountDocuments(ctx, bson.M{})
+	cursor, err := s.priceLists().Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	out := []models.PriceList{}
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, 0, err
+	}
+	return out, total, nil
+}
+
+func (s *PricingService) GetPriceList(ctx context.Context, id string) (*models.PriceList, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var list models.PriceList
+	if err := s.priceLists().FindOne(ctx, bson.M{"_id": objID}).Decode(&list); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.New("price list not found")
+		}
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (s *PricingService) CreatePriceList(ctx context.Context, list *models.PriceList) (*models.PriceList, error) {
+	if err := validatePriceList(list); err != nil {
+		return nil, err
+	}
+	list.ID = primitive.NewObjectID()
+	now := time.Now()
+	list.CreatedAt = now
+	list.UpdatedAt = now
+	if _, err := s.priceLists().InsertOne(ctx, list); err != nil {
+		return nil, err
+	}
+	s.invalidatePricing(ctx)
+	return list, nil
+}
+
+// UpdatePriceList replaces a list's definition; prices left out of the new
+// definition fall back to the base price.
+func (s *PricingService) UpdatePriceList(ctx context.Context, id string, list *models.PriceList) (*models.PriceList, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePriceList(list); err != nil {
+		return nil, err
+	}
+	list.UpdatedAt = time.Now()
+	var updated models.PriceList
+	err = s.priceLists().FindOneAndUpdate(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{
+		"name":        list.Name,
+		"description": list.Description,
+		"active":      list.Active,
+		"priority":    list.Priority,
+		"userTypes":   list.UserTypes,
+		"customerIDs": list.CustomerIDs,
+		"prices":      list.Prices,
+		"updatedAt":   list.UpdatedAt,
+	}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.New("price list not found")
+		}
+		return nil, err
+	}
+	s.invalidatePricing(ctx)
+	return &updated, nil
+}
+
+func (s *PricingService) DeletePriceList(ctx context.Context, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.priceLists().DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return errors.New("price list not found")
+	}
+	s.invalidatePricing(ctx)
+	return nil
+}
+
+func validatePriceList(list *models.PriceList) error {
+	if list.Name == "" {
+		return errors.New("price list name is required")
+	}
+	for _, t := range list.UserTypes {
+		if t != models.UserTypeIndividual && t != models.UserTypeWholesale {
+			return fmt.Errorf("unknown user type %q", t)
+		}
+	}
+	seen := map[string]bool{}
+	for i, e := range list.Prices {
+		if (e.SKU == "") == (e.VariantID == "") {
+			return fmt.Errorf("price %d: set either sku or variantId", i+1)
+		}
+		if e.Price < 0 {
+			return fmt.Errorf("price %d: price cannot be negative", i+1)
+		}
+		key := "sku:" + e.SKU
+		if e.VariantID != "" {
+			key = "variant:" + e.VariantID
+		}
+		if seen[key] {
+			return fmt.Errorf("price %d: %s is listed twice", i+1, key)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+func (s *PricingService) loadActivePriceLists(ctx context.Context) ([]models.PriceList, error) {
+	if s.db == nil {
+		return nil, nil
+	}
+	cursor, err := s.priceLists().Find(ctx, bson.M{"active": true})
+	if err != nil {
+		return nil, err
+	}
+	var out []models.PriceList
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// compiledPriceList is a price list indexed for lookup by the snapshot.
+type compiledPriceList struct {
+	id        string
+	name      string
+	customers map[string]bool
+	userTypes map[models.UserType]bool
+	byVariant map[string]models.Money
+	bySKU     map[string]models.Money
+}
+
+// compilePriceLists indexes the active lists in the order they are tried:
+// priority, then id.
+func compilePriceLists(lists []models.PriceList) []compiledPriceList {
+	sorted := append([]models.PriceList(nil), lists...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority < sorted[j].Priority
+		}
+		return sorted[i].ID.Hex() < sorted[j].ID.Hex()
+	})
+	out := make([]compiledPriceList, 0, len(sorted))
+	for _, l := range sorted {
+		c := compiledPriceList{
+			id:        l.ID.Hex(),
+			name:      l.Name,
+			customers: map[string]bool{},
+			userTypes: map[models.UserType]bool{},
+			byVariant: map[string]models.Money{},
+			bySKU:     map[string]models.Money{},
+		}
+		for _, id := range l.CustomerIDs {
+			c.customers[id] = true
+		}
+		for _, t := range l.UserTypes {
+			c.userTypes[t] = true
+		}
+		for _, e := range l.Prices {
+			if e.VariantID != "" {
+				c.byVariant[e.VariantID] = models.NewMoney(e.Price)
+			} else {
+				c.bySKU[e.SKU] = models.NewMoney(e.Price)
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// priceFor returns the list's price for the line, by variant then SKU.
+func (l *compiledPriceList) priceFor(in PriceInput) (models.Money, bool) {
+	if id := variantIDOf(in.Variant); id != "" {
+		if p, ok := l.byVariant[id]; ok {
+			return p, true
+		}
+	}
+	p, ok := l.bySKU[skuOf(in)]
+	return p, ok
+}
+
+// basePrice is where a line starts before schedules: the price of the first
+// list of the customer's, then of their user type's, that names the line, or
+// else the catalog base price.
+func (snap *pricingSnapshot) basePrice(in PriceInput, pc PricingContext) (models.Money, *compiledPriceList) {
+	if pc.CustomerID != "" {
+		for i := range snap.priceLists {
+			l := &snap.priceLists[i]
+			if !l.customers[pc.CustomerID] {
+				continue
+			}
+			if p, ok := l.priceFor(in); ok {
+				return p, l
+			}
+		}
+	}
+	if pc.CustomerType != "" {
+		for i := range snap.priceLists {
+			l := &snap.priceLists[i]
+			if !l.userTypes[pc.CustomerType] {
+				continue
+			}
+			if p, ok := l.priceFor(in); ok {
+				return p, l
+			}
+		}
+	}
+	return models.NewMoney(in.Product.PriceFor(in.Variant)), nil
+}
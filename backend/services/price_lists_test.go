@@ -0,0 +1,88 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+)
+
+func TestPriceListResolution(t *testing.T) {
+	wholesale := models.PriceList{ID: primitive.NewObjectID(), Name: "mayoreo", Active: true,
+		UserTypes: []models.UserType{models.UserTypeWholesale},
+		Prices: []models.PriceListEntry{
+			{SKU: "ARROZ", Price: 22},
+			{SKU: "ACEITE-1L", Price: 35},
+			{VariantID: "aceite-5l", Price: 150},
+		}}
+	// A negotiated price for one customer wins over the wholesale list.
+	tiendita := models.PriceList{ID: primitive.NewObjectID(), Name: "tiendita", Active: true, Priority: 5,
+		CustomerIDs: []string{"c1"},
+		Prices:      []models.PriceListEntry{{SKU: "ARROZ", Price: 20}}}
+	tenOff := models.PriceSet{ID: primitive.NewObjectID(), Name: "10 off", Active: true,
+		Rules: []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 10}}}
+	snap := compileSnapshot([]models.PriceSet{tenOff}, nil, 0, time.Now())
+	snap.priceLists = compilePriceLists([]models.PriceList{tiendita, wholesale})
+
+	rice := &Product{ID: primitive.NewObjectID(), SKU: "ARROZ", BasePrice: 30}
+	oil := &Product{ID: primitive.NewObjectID(), SKU: "ACEITE", BasePrice: 40, Variants: []Variant{
+		{VariantID: "aceite-1l", SKU: "ACEITE-1L"},
+		{VariantID: "aceite-5l", SKU: "ACEITE-5L", PriceAdjustment: 140},
+		{VariantID: "aceite-20l", SKU: "ACEITE-20L", PriceAdjustment: 600},
+	}}
+	inputs := []PriceInput{
+		{Product: rice, Quantity: 1},
+		{Product: oil, Variant: &oil.Variants[0], Quantity: 1},
+		{Product: oil, Variant: &oil.Variants[1], Quantity: 1},
+		{Product: oil, Variant: &oil.Variants[2], Quantity: 1},
+	}
+
+	cases := []struct {
+		name string
+		pc   PricingContext
+		base []float64
+		list []string
+	}{
+		{"individual", PricingContext{CustomerType: models.UserTypeIndividual}, []float64{30, 40, 180, 640}, []string{"", "", "", ""}},
+		{"wholesale", PricingContext{CustomerType: models.UserTypeWholesale}, []float64{22, 35, 150, 640}, []string{"mayoreo", "mayoreo", "mayoreo", ""}},
+		{"wholesale customer", PricingContext{CustomerID: "c1", CustomerType: models.UserTypeWholesale}, []float64{20, 35, 150, 640}, []string{"tiendita", "mayoreo", "mayoreo", ""}},
+	}
+	for _, tc := range cases {
+		res, err := snap.resolve(inputs, tc.pc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, l := range res.Lines {
+			if l.BasePrice != mxn(tc.base[i]) || l.PriceList != tc.list[i] {
+				t.Errorf("%s line %d: expected %v from %q, got %s from %q", tc.name, i, tc.base[i], tc.list[i], l.BasePrice, l.PriceList)
+			}
+			// Sets still apply on top of the list price.
+			if want := mxn(tc.base[i] * 0.9); l.UnitPrice != want {
+				t.Errorf("%s line %d: expected unit %s after sets, got %s", tc.name, i, want, l.UnitPrice)
+			}
+		}
+	}
+}
+
+func TestValidatePriceList(t *testing.T) {
+	bad := []models.PriceList{
+		{},
+		{Name: "x", UserTypes: []models.UserType{"vip"}},
+		{Name: "x", Prices: []models.PriceListEntry{{Price: 1}}},
+		{Name: "x", Prices: []models.PriceListEntry{{SKU: "A", VariantID: "a", Price: 1}}},
+		{Name: "x", Prices: []models.PriceListEntry{{SKU: "A", Price: -1}}},
+		{Name: "x", Prices: []models.PriceListEntry{{SKU: "A", Price: 1}, {SKU: "A", Price: 2}}},
+	}
+	for i, l := range bad {
+		if err := validatePriceList(&l); err == nil {
+			t.Errorf("case %d: expected an error", i)
+		}
+	}
+	ok := models.PriceList{Name: "mayoreo", UserTypes: []models.UserType{models.UserTypeWholesale},
+		Prices: []models.PriceListEntry{{SKU: "A", Price: 1}, {VariantID: "A", Price: 2}}}
+	if err := validatePriceList(&ok); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+)
+
+// pricingVersionKey is the Redis counter every replica bumps when price sets
+// or schedules change, and checks before trusting its compiled snapshot.
+const pricingVersionKey = "pricing:version"
+
+// snapshotMaxAge bounds how stale a snapshot can get when the version counter
+// cannot be read (no Redis configured, or Redis down).
+const snapshotMaxAge = time.Minute
+
+// pricingSnapshot is an immutable, compiled view of the active price sets and
+// schedules, indexed by scope so a resolve only looks at rules that can touch
+// its lines. Snapshots are shared between requests and must not be mutated,
+// apart from the per-product category memo.
+type pricingSnapshot struct {
+	version  int64 // Redis version it was built at; -1 when unknown
+	loadedAt time.Time
+
+	schedules []models.PriceSchedule // priority, then id
+	schedIdx  scopeIndex
+
+	sets    []models.PriceSet // priority, then id; usage counters are not loaded
+	setsIdx scopeIndex
+	capped  []primitive.ObjectID // sets with a usage cap, checked live per resolve
+
+	categories  *categoryTree // nil when the hierarchy is unknown
+	productCats sync.Map      // product id -> *productCategories, filled lazily
+
+	rounding models.RoundingStrategy // store-wide default from the pricing settings
+
+	priceLists []compiledPriceList // active price lists, in lookup order
+}
+
+// scopeIndex maps scope references to positions in the snapshot's ordered
+// schedules or sets. Global entries apply to every line.
+type scopeIndex struct {
+	global     []int
+	byProduct  map[string][]int
+	byVariant  map[string][]int
+	bySKU      map[string][]int
+	byCategory map[string][]int // category name or category id (hex)
+}
+
+func newScopeIndex() scopeIndex {
+	return scopeIndex{
+		byProduct:  map[string][]int{},
+		byVariant:  map[string][]int{},
+		bySKU:      map[string][]int{},
+		byCategory: map[string][]int{},
+	}
+}
+
+func (x *scopeIndex) add(m map[string][]int, refs []string, pos int) {
+	for _, ref := range refs {
+		if l := m[ref]; len(l) == 0 || l[len(l)-1] != pos {
+			m[ref] = append(l, pos)
+		}
+	}
+}
+
+// candidates appends to the result, in order, the positions that may apply
+// to a line with the given keys and are not in seen yet, marking them seen.
+func (x *scopeIndex) candidates(productID, variantID, sku string, categories []string, seen map[int]bool) []int {
+	var out []int
+	take := func(positions []int) {
+		for _, pos := range positions {
+			if !seen[pos] {
+				seen[pos] = true
+				out = append(out, pos)
+			}
+		}
+	}
+	take(x.global)
+	if productID != "" {
+		take(x.byProduct[productID])
+	}
+	if variantID != "" {
+		take(x.byVariant[variantID])
+	}
+	if sku != "" {
+		take(x.bySKU[sku])
+	}
+	for _, c := range categories {
+		if c != "" {
+			take(x.byCategory[c])
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+// compileSnapshot orders and indexes the active sets and schedules.
+func compileSnapshot(sets []models.PriceSet, schedules []models.PriceSchedule, version int64, now time.Time) *pricingSnapshot {
+	snap := &pricingSnapshot{
+		version:   version,
+		loadedAt:  now,
+		schedules: append([]models.PriceSchedule(nil), schedules...),
+		schedIdx:  newScopeIndex(),
+		sets:      append([]models.PriceSet(nil), sets...),
+		setsIdx:   newScopeIndex(),
+	}
+
+	sort.SliceStable(snap.schedules, func(i, j int) bool {
+		if snap.schedules[i].Priority != snap.schedules[j].Priority {
+			return snap.schedules[i].Priority < snap.schedules[j].Priority
+		}
+		return snap.schedules[i].ID.Hex() < snap.schedules[j].ID.Hex()
+	})
+	for pos, sch := range snap.schedules {
+		x := &snap.schedIdx
+		switch sch.Scope {
+		case models.ScheduleScopeGlobal:
+			x.global = append(x.global, pos)
+		case models.ScheduleScopeProduct:
+			x.add(x.byProduct, sch.ScopeRefs, pos)
+		case models.ScheduleScopeVariant:
+			x.add(x.byVariant, sch.ScopeRefs, pos)
+		case models.ScheduleScopeCategory:
+			x.add(x.byCategory, sch.ScopeRefs, pos)
+		}
+	}
+
+	sort.SliceStable(snap.sets, func(i, j int) bool {
+		if snap.sets[i].Priority != snap.sets[j].Priority {
+			return snap.sets[i].Priority < snap.sets[j].Priority
+		}
+		return snap.sets[i].ID.Hex() < snap.sets[j].ID.Hex()
+	})
+	for pos, set := range snap.sets {
+		indexSetRules(&snap.setsIdx, set, pos)
+		if set.MaxUses > 0 || set.MaxUsesPerCustomer > 0 {
+			snap.capped = append(snap.capped, set.ID)
+		}
+	}
+	return snap
+}
+
+// indexSetRules files a set under every scope any of its rules (or bundle
+// components) can match. A set with an "all" rule is global.
+func indexSetRules(x *scopeIndex, set models.PriceSet, pos int) {
+	type scoped struct {
+		scope models.RuleScope
+		refs  []string
+	}
+	var scopes []scoped
+	for _, r := range set.Rules {
+		scopes = append(scopes, scoped{r.Scope, r.ScopeRefs})
+		for _, c := range r.Components {
+			scopes = append(scopes, scoped{c.Scope, c.ScopeRefs})
+		}
+	}
+	for _, sc := range scopes {
+		switch sc.scope {
+		case models.RuleScopeAll:
+			if l := x.global; len(l) == 0 || l[len(l)-1] != pos {
+				x.global = append(x.global, pos)
+			}
+		case models.RuleScopeProduct:
+			x.add(x.byProduct, sc.refs, pos)
+		case models.RuleScopeVariant:
+			x.add(x.byVariant, sc.refs, pos)
+		case models.RuleScopeSKU:
+			x.add(x.bySKU, sc.refs, pos)
+		case models.RuleScopeCategory:
+			x.add(x.byCategory, sc.refs, pos)
+		}
+	}
+}
+
+// usable reports whether the snapshot can still serve a resolve, given the
+// current Redis version (-1 when it could not be read).
+func (snap *pricingSnapshot) usable(version int64, now time.Time) bool {
+	if snap == nil {
+		return false
+	}
+	if version >= 0 && snap.version >= 0 {
+		return version == snap.version
+	}
+	return now.Sub(snap.loadedAt) < snapshotMaxAge
+}
+
+// schedulesFor returns the schedules that may apply to a line, in order.
+func (snap *pricingSnapshot) schedulesFor(in PriceInput) []models.PriceSchedule {
+	cats := in.categoryKeys
+	if cats == nil {
+		cats = categoryKeys(in.Product)
+	}
+	idx := snap.schedIdx.candidates(in.Product.ID.Hex(), variantIDOf(in.Variant), "", cats, map[int]bool{})
+	out := make([]models.PriceSchedule, 0, len(idx))
+	for _, pos := range idx {
+		out = append(out, snap.schedules[pos])
+	}
+	return out
+}
+
+// setsFor returns the sets whose rules can touch at least one of the lines,
+// in order. Sets that match no line would not apply anyway, so skipping them
+// does not change StopFurtherRules behaviour.
+func (snap *pricingSnapshot) setsFor(lines []PricedLine) []models.PriceSet {
+	seen := map[int]bool{}
+	var idx []int
+	for _, l := range lines {
+		cats := l.categoryKeys
+		if cats == nil {
+			cats = append([]string{l.Category}, l.Categories...)
+		}
+		idx = append(idx, snap.setsIdx.candidates(l.ProductID, l.VariantID, l.SKU, cats, seen)...)
+	}
+	sort.Ints(idx)
+	out := make([]models.PriceSet, 0, len(idx))
+	for _, pos := range idx {
+		out = append(out, snap.sets[pos])
+	}
+	return out
+}
+
+func categoryKeys(p *Product) []string {
+	keys := make([]string, 0, len(p.Categories)+1)
+	if p.Category != "" {
+		keys = append(keys, p.Category)
+	}
+	for _, c := range p.Categories {
+		keys = append(keys, c.Hex())
+	}
+	return keys
+}
+
+// pricingCache holds the current snapshot and the optional Redis client used
+// to coordinate invalidation between replicas.
+type pricingCache struct {
+	snap   atomic.Pointer[pricingSnapshot]
+	gen    atomic.Int64 // bumped by every local invalidation
+	loadMu sync.Mutex
+	redis  *redis.Client
+}
+
+// SetRedis enables cross-replica invalidation of the compiled pricing snapshot.
+func (s *PricingService) SetRedis(rdb *redis.Client) {
+	s.cache.redis = rdb
+}
+
+// snapshot returns a current compiled snapshot, rebuilding it from Mongo when
+// the local copy was invalidated, another replica bumped the version, or (with
+// no readable version) it is older than snapshotMaxAge.
+func (s *PricingService) snapshot(ctx context.Context) (*pricingSnapshot, error) {
+	version := s.remoteVersion(ctx)
+	if snap := s.cache.snap.Load(); snap.usable(version, time.Now()) {
+		return snap, nil
+	}
+
+	s.cache.loadMu.Lock()
+	defer s.cache.loadMu.Unlock()
+	if snap := s.cache.snap.Load(); snap.usable(version, time.Now()) {
+		return snap, nil
+	}
+
+	gen := s.cache.gen.Load()
+	sets, err := s.loadActiveSets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	schedules, err := s.loadActiveSchedules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	categories, err := s.loadCategoryTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lists, err := s.loadActivePriceLists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rounding models.RoundingStrategy
+	if s.db != nil {
+		settings, err := s.GetPricingSettings(ctx)
+		if err != nil {
+			return nil, err
+		}
+		rounding = settings.Rounding
+	}
+	// The version was read before loading, so a write that lands during the
+	// load bumps it past this snapshot and forces another rebuild. A local
+	// write during the load means the data may predate it: serve it to this
+	// call only.
+	snap := compileSnapshot(sets, schedules, version, time.Now())
+	snap.categories = categories
+	snap.rounding = rounding
+	snap.priceLists = compilePriceLists(lists)
+	if s.cache.gen.Load() == gen {
+		s.cache.snap.Store(snap)
+	}
+	return snap, nil
+}
+
+// remoteVersion reads the shared version counter; -1 means unknown.
+func (s *PricingService) remoteVersion(ctx context.Context) int64 {
+	if s.cache.redis == nil {
+		return -1
+	}
+	v, err := s.cache.redis.Get(ctx, pricingVersionKey).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0
+	}
+	if err != nil {
+		return -1
+	}
+	return v
+}
+
+// invalidatePricing drops the local snapshot and bumps the shared version so
+// every replica rebuilds on its next resolve. Called after any write to price
+// sets or schedules.
+func (s *PricingService) invalidatePricing(ctx context.Context) {
+	s.cache.gen.Add(1)
+	s.cache.snap.Store(nil)
+	if s.cache.redis == nil {
+		return
+	}
+	if err := s.cache.redis.Incr(ctx, pricingVersionKey).Err(); err != nil {
+		log.Printf("pricing: failed to bump %s: %v", pricingVersionKey, err)
+	}
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+)
+
+func TestSnapshotSetsForUsesScopeIndex(t *testing.T) {
+	global := models.PriceSet{ID: primitive.NewObjectID(), Name: "global", Priority: 5,
+		Rules: []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 5}}}
+	product := models.PriceSet{ID: primitive.NewObjectID(), Name: "product", Priority: 1,
+		Rules: []models.PriceRule{{Kind: models.RuleKindAbsolute, Scope: models.RuleScopeProduct, ScopeRefs: []string{"p1"}, Amount: 1}}}
+	bundle := models.PriceSet{ID: primitive.NewObjectID(), Name: "bundle", Priority: 2,
+		Rules: []models.PriceRule{{Kind: models.RuleKindBundle, Amount: 10, Components: []models.BundleComponent{
+			{Scope: models.RuleScopeSKU, ScopeRefs: []string{"SKU-2"}, Quantity: 1},
+		}}}}
+	other := models.PriceSet{ID: primitive.NewObjectID(), Name: "other", Priority: 0,
+		Rules: []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeCategory, ScopeRefs: []string{"toys"}, Amount: 50}}}
+
+	snap := compileSnapshot([]models.PriceSet{global, product, bundle, other}, nil, 0, time.Now())
+	lines := []PricedLine{
+		{ProductID: "p1", Category: "groceries"},
+		{ProductID: "p2", SKU: "SKU-2", Category: "groceries"},
+	}
+	got := snap.setsFor(lines)
+	want := []string{"product", "bundle", "global"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %d sets", want, len(got))
+	}
+	for i, name := range want {
+		if got[i].Name != name {
+			t.Errorf("position %d: expected %s, got %s", i, name, got[i].Name)
+		}
+	}
+}
+
+func TestSnapshotResolveMatchesFullScan(t *testing.T) {
+	// The index may only skip sets that could not apply: resolving through it
+	// must give the same totals as applying every set to every line.
+	products, sets, schedules := benchCatalog(200, 40, 10)
+	snap := compileSnapshot(sets, schedules, 0, time.Now())
+	pc := PricingContext{Date: time.Now()}
+
+	for start := 0; start+20 <= len(products); start += 20 {
+		inputs := pageInputs(products[start : start+20])
+		indexed, err := snap.resolve(inputs, pc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := make([]PricedLine, 0, len(inputs))
+		for _, in := range inputs {
+			line, _ := snap.priceLine(in, models.NewMoney(in.Product.PriceFor(in.Variant)), pc.Date, false)
+			lines = append(lines, line)
+		}
+		full := pc
+		full.CartSubtotal = subtotalOf(lines)
+		full.CartQuantity = quantityOf(inputs)
+		if _, _, err := applySets(append([]models.PriceSet(nil), snap.sets...), &lines, full); err != nil {
+			t.Fatal(err)
+		}
+		total := models.MoneyFromMinor(0)
+		for _, l := range lines {
+			total = total.Add(l.LineTotal)
+		}
+		if indexed.Total != total {
+			t.Errorf("page at %d: indexed total %v, full scan %v", start, indexed.Total, total)
+		}
+	}
+}
+
+func TestSnapshotUsable(t *testing.T) {
+	now := time.Now()
+	var missing *pricingSnapshot
+	if missing.usable(0, now) {
+		t.Error("a nil snapshot is never usable")
+	}
+	snap := &pricingSnapshot{version: 3, loadedAt: now.Add(-time.Hour)}
+	if !snap.usable(3, now) {
+		t.Error("matching version should be usable regardless of age")
+	}
+	if snap.usable(4, now) {
+		t.Error("a bumped version must force a rebuild")
+	}
+	if snap.usable(-1, now) {
+		t.Error("without a readable version, an old snapshot must expire")
+	}
+	if !(&pricingSnapshot{version: -1, loadedAt: now}).usable(-1, now) {
+		t.Error("a fresh snapshot without version should be usable")
+	}
+}
+
+func TestPricingInvalidationAcrossReplicas(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	replica := func() *PricingService {
+		s := &PricingService{}
+		s.SetRedis(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+		return s
+	}
+	a, b := replica(), replica()
+
+	a.cache.snap.Store(compileSnapshot(nil, nil, a.remoteVersion(ctx), time.Now()))
+	if !a.cache.snap.Load().usable(a.remoteVersion(ctx), time.Now()) {
+		t.Fatal("fresh snapshot should be usable")
+	}
+
+	// A write on replica b must make replica a's snapshot stale.
+	b.invalidatePricing(ctx)
+	if a.cache.snap.Load().usable(a.remoteVersion(ctx), time.Now()) {
+		t.Error("snapshot should be stale after another replica's write")
+	}
+	if b.cache.snap.Load() != nil {
+		t.Error("the writing replica should drop its own snapshot")
+	}
+}
+
+// benchCatalog builds a catalog of n products over cats categories, with
+// product-, category- and SKU-scoped sets plus a few global ones, and a mix of
+// global, category and product schedules.
+func benchCatalog(n, cats, nSchedules int) ([]*Product, []models.PriceSet, []models.PriceSchedule) {
+	products := make([]*Product, n)
+	for i := range products {
+		products[i] = &Product{
+			ID:        primitive.NewObjectID(),
+			SKU:       fmt.Sprintf("SKU-%05d", i),
+			Category:  fmt.Sprintf("cat-%03d", i%cats),
+			BasePrice: float64(10 + i%90),
+			Variants:  []Variant{{VariantID: fmt.Sprintf("v-%05d", i), PriceAdjustment: 2.5}},
+		}
+	}
+
+	var sets []models.PriceSet
+	for i := 0; i < n/20; i++ {
+		p := products[(i*37)%n]
+		sets = append(sets, models.PriceSet{
+			ID: primitive.NewObjectID(), Name: "product " + p.SKU, Priority: i % 7,
+			Rules: []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeProduct, ScopeRefs: []string{p.ID.Hex()}, Amount: 10}},
+		})
+	}
+	for c := 0; c < cats; c += 3 {
+		sets = append(sets, models.PriceSet{
+			ID: primitive.NewObjectID(), Name: fmt.Sprintf("category %d", c), Priority: c % 5,
+			Rules: []models.PriceRule{{Kind: models.RuleKindAbsolute, Scope: models.RuleScopeCategory, ScopeRefs: []string{fmt.Sprintf("cat-%03d", c)}, Amount: 1}},
+		})
+	}
+	for i := 0; i < n/50; i++ {
+		sets = append(sets, models.PriceSet{
+			ID: primitive.NewObjectID(), Name: "sku 3x2", Priority: 8,
+			Rules: []models.PriceRule{{Kind: models.RuleKindBuyXGetY, Scope: models.RuleScopeSKU, ScopeRefs: []string{products[(i*53)%n].SKU}, BuyQuantity: 2, GetQuantity: 1}},
+		})
+	}
+	sets = append(sets,
+		models.PriceSet{ID: primitive.NewObjectID(), Name: "gold", Priority: 9, Conditions: models.PriceConditions{CustomerTier: "gold"},
+			Rules: []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 5}}},
+		models.PriceSet{ID: primitive.NewObjectID(), Name: "big cart", Priority: 10, Conditions: models.PriceConditions{MinSubtotal: 500},
+			Rules: []models.PriceRule{{Kind: models.RuleKindAbsolute, Scope: models.RuleScopeAll, Amount: 2}}},
+	)
+
+	from := time.Now().Add(-time.Hour)
+	schedules := []models.PriceSchedule{{ID: primitive.NewObjectID(), Name: "inflation", Scope: models.ScheduleScopeGlobal, Mode: models.ScheduleModePercentage, Value: 4, EffectiveFrom: from}}
+	for i := 1; i < nSchedules; i++ {
+		sch := models.PriceSchedule{ID: primitive.NewObjectID(), Name: fmt.Sprintf("schedule %d", i), Mode: models.ScheduleModeAbsolute, Value: 1, Priority: i, EffectiveFrom: from}
+		if i%2 == 0 {
+			sch.Scope, sch.ScopeRefs = models.ScheduleScopeCategory, []string{fmt.Sprintf("cat-%03d", i%cats)}
+		} else {
+			sch.Scope, sch.ScopeRefs = models.ScheduleScopeProduct, []string{products[(i*101)%n].ID.Hex()}
+		}
+		schedules = append(schedules, sch)
+	}
+	return products, sets, schedules
+}
+
+func pageInputs(page []*Product) []PriceInput {
+	inputs := make([]PriceInput, 0, len(page))
+	for _, p := range page {
+		inputs = append(inputs, PriceInput{Product: p, Variant: &p.Variants[0], Quantity: 3})
+	}
+	return inputs
+}
+
+// BenchmarkResolveCatalogPage10k prices a 20-product listing page against a
+// 10k-product catalog's rules through the compiled snapshot.
+func BenchmarkResolveCatalogPage10k(b *testing.B) {
+	products, sets, schedules := benchCatalog(10000, 200, 100)
+	snap := compileSnapshot(sets, schedules, 0, time.Now())
+	pc := PricingContext{Date: time.Now(), CustomerTier: "gold", UnitPricesOnly: true}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := (i * 20) % (len(products) - 20)
+		if _, err := snap.resolve(pageInputs(products[start:start+20]), pc); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkResolveCatalogPage10kFullScan is the same page evaluated against
+// every set and schedule, as the engine did before the scope index.
+func BenchmarkResolveCatalogPage10kFullScan(b *testing.B) {
+	products, sets, schedules := benchCatalog(10000, 200, 100)
+	snap := compileSnapshot(sets, schedules, 0, time.Now())
+	pc := PricingContext{Date: time.Now(), CustomerTier: "gold", UnitPricesOnly: true}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := (i * 20) % (len(products) - 20)
+		inputs := pageInputs(products[start : start+20])
+		lines := make([]PricedLine, 0, len(inputs))
+		for _, in := range inputs {
+			base := models.NewMoney(in.Product.PriceFor(in.Variant))
+			line := PricedLine{ProductID: in.Product.ID.Hex(), VariantID: in.Variant.VariantID, SKU: in.Product.SKU,
+				Category: in.Product.Category, BasePrice: base, UnitPrice: base, Quantity: in.Quantity,
+				Subtotal: base.Mul(in.Quantity), LineTotal: base.Mul(in.Quantity)}
+			for _, sch := range snap.schedules {
+				if scheduleInWindow(sch, pc.Date) && scheduleMatches(sch, in) {
+					line.UnitPrice = applySchedule(sch, line.UnitPrice)
+				}
+			}
+			lines = append(lines, line)
+		}
+		if _, _, err := applySets(append([]models.PriceSet(nil), snap.sets...), &lines, pc); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCompileSnapshot10k measures the rebuild cost paid after a write.
+func BenchmarkCompileSnapshot10k(b *testing.B) {
+	_, sets, schedules := benchCatalog(10000, 200, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		compileSnapshot(sets, schedules, 0, time.Now())
+	}
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"mercadomio-backend/models"
+)
+
+// maxCategoryDepth guards the ancestor walk against a parent cycle.
+const maxCategoryDepth = 32
+
+// categoryTree maps every category key (id hex and name) to the keys of the
+// category itself and all of its ancestors, so a category-scoped rule on
+// "Lácteos" reaches products filed under "Lácteos > Quesos". It also carries
+// each category's effective minimum margin and rounding strategy: its own, or
+// the nearest ancestor's.
+type categoryTree struct {
+	ancestors map[string][]string
+	margins   map[string]float64
+	roundings map[string]models.RoundingStrategy
+}
+
+// newCategoryTree precomputes the ancestor keys of every category.
+func newCategoryTree(categories []Category) *categoryTree {
+	byID := make(map[string]Category, len(categories))
+	for _, c := range categories {
+		byID[c.ID.Hex()] = c
+	}
+
+	tree := &categoryTree{
+		ancestors: make(map[string][]string, 2*len(categories)),
+		margins:   map[string]float64{},
+		roundings: map[string]models.RoundingStrategy{},
+	}
+	for _, c := range categories {
+		var keys []string
+		margin := 0.0
+		var rounding models.RoundingStrategy
+		cur, ok := c, true
+		for depth := 0; ok && depth < maxCategoryDepth; depth++ {
+			keys = append(keys, cur.ID.Hex())
+			if cur.Name != "" {
+				keys = append(keys, cur.Name)
+			}
+			if margin == 0 {
+				margin = cur.MinMarginPercent
+			}
+			if rounding == "" {
+				rounding = cur.Rounding
+			}
+			if cur.ParentID == nil {
+				break
+			}
+			cur, ok = byID[cur.ParentID.Hex()]
+		}
+		tree.ancestors[c.ID.Hex()] = keys
+		if margin > 0 {
+			tree.margins[c.ID.Hex()] = margin
+		}
+		if rounding != "" {
+			tree.roundings[c.ID.Hex()] = rounding
+			// Same-named categories: the first one with a strategy wins.
+			if _, ok := tree.roundings[c.Name]; !ok && c.Name != "" {
+				tree.roundings[c.Name] = rounding
+			}
+		}
+		// Names are not unique across branches: a name maps to the union,
+		// and to the strictest margin.
+		if c.Name != "" {
+			tree.ancestors[c.Name] = appendMissing(tree.ancestors[c.Name], keys...)
+			tree.margins[c.Name] = max(tree.margins[c.Name], margin)
+		}
+	}
+	return tree
+}
+
+// expand returns the given category keys plus the keys of all their
+// ancestors. Unknown keys are kept as they are.
+func (t *categoryTree) expand(keys []string) []string {
+	out := make([]string, 0, len(keys)*3)
+	for _, k := range keys {
+		if k == "" {
+			continue
+		}
+		if t != nil {
+			if anc, ok := t.ancestors[k]; ok {
+				out = appendMissing(out, anc...)
+				continue
+			}
+		}
+		out = appendMissing(out, k)
+	}
+	return out
+}
+
+// marginFor returns the strictest minimum margin among the given (own)
+// category keys; 0 when none has a policy.
+func (t *categoryTree) marginFor(keys []string) float64 {
+	margin := 0.0
+	if t == nil {
+		return margin
+	}
+	for _, k := range keys {
+		margin = max(margin, t.margins[k])
+	}
+	return margin
+}
+
+// roundingFor returns the strategy of the first of the given (own) category
+// keys that has one; empty when none has.
+func (t *categoryTree) roundingFor(keys []string) models.RoundingStrategy {
+	if t == nil {
+		return ""
+	}
+	for _, k := range keys {
+		if r := t.roundings[k]; r != "" {
+			return r
+		}
+	}
+	return ""
+}
+
+func appendMissing(list []string, keys ...string) []string {
+	for _, k := range keys {
+		if !slices.Contains(list, k) {
+			list = append(list, k)
+		}
+	}
+	return list
+}
+
+// productCategories is a memoised ancestor set for one product, valid while
+// the product's own category keys stay the same.
+type productCategories struct {
+	own []string
+	all []string
+}
+
+// categoryKeysFor returns a product's own category keys and those of all
+// their ancestors, computed once per product per snapshot.
+func (snap *pricingSnapshot) categoryKeysFor(p *Product) []string {
+	own := categoryKeys(p)
+	id := p.ID.Hex()
+	if cached, ok := snap.productCats.Load(id); ok {
+		if pc := cached.(*productCategories); slices.Equal(pc.own, own) {
+			return pc.all
+		}
+	}
+	all := snap.categories.expand(own)
+	snap.productCats.Store(id, &productCategories{own: own, all: all})
+	return all
+}
+
+// inCategory reports whether the line is filed under one of refs: directly,
+// or below it unless exact is set. Lines priced outside a snapshot carry no
+// ancestors and only match directly.
+func (l *PricedLine) inCategory(refs []string, exact bool) bool {
+	if !exact && l.categoryKeys != nil {
+		return anyIn(refs, l.categoryKeys)
+	}
+	return (l.Category != "" && containsStr(refs, l.Category)) || anyIn(refs, l.Categories)
+}
+
+// inCategory is PricedLine.inCategory for an input that has not been priced yet.
+func (in PriceInput) inCategory(refs []string, exact bool) bool {
+	if in.Product == nil {
+		return false
+	}
+	if !exact && in.categoryKeys != nil {
+		return anyIn(refs, in.categoryKeys)
+	}
+	if containsStr(refs, in.Product.Category) {
+		return true
+	}
+	for _, c := range in.Product.Categories {
+		if containsStr(refs, c.Hex()) {
+			return true
+		}
+	}
+	return false
+}
+
+func anyIn(refs, keys []string) bool {
+	for _, k := range keys {
+		if containsStr(refs, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCategoryTree reads the category hierarchy for the pricing snapshot.
+// Category writes invalidate the snapshot, so every replica reloads it.
+func (s *PricingService) loadCategoryTree(ctx context.Context) (*categoryTree, error) {
+	if s.db == nil {
+		return nil, nil
+	}
+	opts := options.Find().SetProjection(bson.M{"name": 1, "parentId": 1, "minMarginPercent": 1, "rounding": 1})
+	cursor, err := s.db.Collection("categories").Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var categories []Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+	return newCategoryTree(categories), nil
+}
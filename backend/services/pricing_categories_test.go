@@ -0,0 +1,83 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+)
+
+func TestCategoryScopeMatchesDescendants(t *testing.T) {
+	dairy := Category{ID: primitive.NewObjectID(), Name: "Lácteos"}
+	cheese := Category{ID: primitive.NewObjectID(), Name: "Quesos", ParentID: &dairy.ID}
+	fresh := Category{ID: primitive.NewObjectID(), Name: "Frescos", ParentID: &cheese.ID}
+	bakery := Category{ID: primitive.NewObjectID(), Name: "Panadería"}
+
+	panela := &Product{ID: primitive.NewObjectID(), Category: "Frescos", BasePrice: 100}
+	bolillo := &Product{ID: primitive.NewObjectID(), Categories: []primitive.ObjectID{bakery.ID}, BasePrice: 10}
+
+	byName := models.PriceSet{ID: primitive.NewObjectID(), Name: "dairy week", Priority: 1,
+		Rules: []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeCategory, ScopeRefs: []string{"Lácteos"}, Amount: 10}}}
+	exact := models.PriceSet{ID: primitive.NewObjectID(), Name: "cheese only", Priority: 2,
+		Rules: []models.PriceRule{{Kind: models.RuleKindAbsolute, Scope: models.RuleScopeCategory, ScopeRefs: []string{cheese.ID.Hex()}, Amount: 5, ExactCategory: true}}}
+	schedule := models.PriceSchedule{ID: primitive.NewObjectID(), Name: "dairy inflation", Scope: models.ScheduleScopeCategory,
+		ScopeRefs: []string{dairy.ID.Hex()}, Mode: models.ScheduleModeAbsolute, Value: 20, EffectiveFrom: time.Now().Add(-time.Hour)}
+
+	snap := compileSnapshot([]models.PriceSet{byName, exact}, []models.PriceSchedule{schedule}, 0, time.Now())
+	snap.categories = newCategoryTree([]Category{dairy, cheese, fresh, bakery})
+
+	res, err := snap.resolve([]PriceInput{{Product: panela, Quantity: 1}, {Product: bolillo, Quantity: 1}}, PricingContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 100 + 20 from the dairy schedule, then 10% for dairy week; the exact
+	// cheese rule does not reach a grandchild category.
+	if got := res.Lines[0].UnitPrice; got != mxn(108) {
+		t.Errorf("grandchild of Lácteos: expected 108, got %v", got)
+	}
+	if got := res.Lines[1].UnitPrice; got != mxn(10) {
+		t.Errorf("bakery line should be untouched, got %v", got)
+	}
+
+	// A product filed directly under Quesos gets the exact rule too.
+	oaxaca := &Product{ID: primitive.NewObjectID(), Categories: []primitive.ObjectID{cheese.ID}, BasePrice: 100}
+	res, err = snap.resolve([]PriceInput{{Product: oaxaca, Quantity: 1}}, PricingContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Lines[0].UnitPrice; got != mxn(103) {
+		t.Errorf("direct Quesos product: expected 103, got %v", got)
+	}
+}
+
+func TestCategoryKeysMemoisedPerProduct(t *testing.T) {
+	dairy := Category{ID: primitive.NewObjectID(), Name: "Lácteos"}
+	cheese := Category{ID: primitive.NewObjectID(), Name: "Quesos", ParentID: &dairy.ID}
+	snap := compileSnapshot(nil, nil, 0, time.Now())
+	snap.categories = newCategoryTree([]Category{dairy, cheese})
+
+	p := &Product{ID: primitive.NewObjectID(), Category: "Quesos"}
+	keys := snap.categoryKeysFor(p)
+	for _, want := range []string{"Quesos", cheese.ID.Hex(), "Lácteos", dairy.ID.Hex()} {
+		if !containsStr(keys, want) {
+			t.Errorf("expected %q among %v", want, keys)
+		}
+	}
+
+	// Recategorising the product must not serve the stale memo.
+	p.Category = "Lácteos"
+	if keys := snap.categoryKeysFor(p); containsStr(keys, "Quesos") {
+		t.Errorf("expected the memo refreshed after a category change, got %v", keys)
+	}
+}
+
+func TestCategoryTreeSurvivesCycles(t *testing.T) {
+	a := Category{ID: primitive.NewObjectID(), Name: "a"}
+	b := Category{ID: primitive.NewObjectID(), Name: "b", ParentID: &a.ID}
+	a.ParentID = &b.ID
+	if keys := newCategoryTree([]Category{a, b}).expand([]string{"a"}); !containsStr(keys, "b") {
+		t.Errorf("expected the walk to terminate with both keys, got %v", keys)
+	}
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"mercadomio-backend/models"
+)
+
+// Trace step sources.
+const (
+	TraceSourceBase     = "base"
+	TraceSourceSchedule = "schedule"
+	TraceSourceSet      = "set"
+	TraceSourceRounding = "rounding"
+)
+
+// PriceTraceStep is one entry of a line's explanation: a schedule or set the
+// engine considered, whether it touched the line, why not, and the line's
+// running price after the step.
+type PriceTraceStep struct {
+	Source    string // base, schedule or set
+	ID        string
+	Name      string
+	Priority  int
+	Matched   bool
+	Reason    string       // why it was rejected; empty when it matched
+	Discount  models.Money // what this step took off the line (negative when it raised it)
+	UnitPrice models.Money // running unit price after this step
+	LineTotal models.Money // running line total after this step
+}
+
+// traceBase records the starting point of a line.
+func (l *PricedLine) traceBase() {
+	name := "base price"
+	if l.PriceListID != "" {
+		name = "price list " + l.PriceList
+	}
+	l.Trace = append(l.Trace, PriceTraceStep{
+		Source:    TraceSourceBase,
+		ID:        l.PriceListID,
+		Name:      name,
+		Matched:   true,
+		UnitPrice: l.UnitPrice,
+		LineTotal: l.UnitPrice.Mul(l.Quantity),
+	})
+}
+
+// traceSchedule records a schedule's effect on the running unit price.
+func (l *PricedLine) traceSchedule(sch models.PriceSchedule, before, after models.Money, reason string) {
+	l.Trace = append(l.Trace, PriceTraceStep{
+		Source:    TraceSourceSchedule,
+		ID:        sch.ID.Hex(),
+		Name:      sch.Name,
+		Priority:  sch.Priority,
+		Matched:   reason == "",
+		Reason:    reason,
+		Discount:  before.Sub(after).Mul(l.Quantity),
+		UnitPrice: after,
+		LineTotal: after.Mul(l.Quantity),
+	})
+}
+
+// traceSet records a set's effect on the line, given the line total before it.
+func (l *PricedLine) traceSet(set models.PriceSet, before models.Money, reason string) {
+	l.Trace = append(l.Trace, PriceTraceStep{
+		Source:    TraceSourceSet,
+		ID:        set.ID.Hex(),
+		Name:      set.Name,
+		Priority:  set.Priority,
+		Matched:   reason == "",
+		Reason:    reason,
+		Discount:  before.Sub(l.LineTotal),
+		UnitPrice: l.UnitPrice,
+		LineTotal: l.LineTotal,
+	})
+}
+
+// roundTraced rounds the line at a stage and, in explain mode, records the
+// rounding as a step.
+func (l *PricedLine) roundTraced(stage string, hi models.Money, explain bool) {
+	before, seen := l.UnitPrice, len(l.Rounding)
+	l.round(stage, hi)
+	if !explain || len(l.Rounding) == seen {
+		return
+	}
+	l.Trace = append(l.Trace, PriceTraceStep{
+		Source:    TraceSourceRounding,
+		Name:      string(l.rounding) + " after " + stage,
+		Matched:   true,
+		Discount:  before.Sub(l.UnitPrice).Mul(l.Quantity),
+		UnitPrice: l.UnitPrice,
+		LineTotal: l.LineTotal,
+	})
+}
+
+// scheduleReason explains why a schedule does not apply to a line; empty
+// when it does.
+func scheduleReason(sch models.PriceSchedule, in PriceInput, d time.Time) string {
+	if !scheduleMatches(sch, in) {
+		return fmt.Sprintf("out of scope (%s %s)", sch.Scope, strings.Join(sch.ScopeRefs, ", "))
+	}
+	return scheduleWindowReason(sch, d)
+}
+
+func scheduleWindowReason(sch models.PriceSchedule, d time.Time) string {
+	if d.Before(sch.EffectiveFrom) {
+		return "not effective until " + sch.EffectiveFrom.Format(time.RFC3339)
+	}
+	if sch.EffectiveTo != nil && d.After(*sch.EffectiveTo) {
+		return "ended " + sch.EffectiveTo.Format(time.RFC3339)
+	}
+	return recurrenceReason(sch.Recurrence, d)
+}
+
+// setReason explains why a set is not eligible for this context at all:
+// window, conditions, then usage caps. Empty when eligible.
+func setReason(set models.PriceSet, pc PricingContext) string {
+	if r := setWindowReason(set, pc.Date); r != "" {
+		return r
+	}
+	if r := conditionsReason(set, pc); r != "" {
+		return r
+	}
+	return usageReason(set, pc)
+}
+
+func setWindowReason(set models.PriceSet, d time.Time) string {
+	if set.StartsAt != nil && d.Before(*set.StartsAt) {
+		return "not started (starts " + set.StartsAt.Format(time.RFC3339) + ")"
+	}
+	if set.EndsAt != nil && d.After(*set.EndsAt) {
+		return "ended " + set.EndsAt.Format(time.RFC3339)
+	}
+	return recurrenceReason(set.Recurrence, d)
+}
+
+func conditionsReason(set models.PriceSet, pc PricingContext) string {
+	c := set.Conditions
+	if c.CouponCode != "" || c.UniqueCodes {
+		shared := c.CouponCode != "" && strings.EqualFold(c.CouponCode, pc.CouponCode)
+		generated := c.UniqueCodes && pc.codeSetID != "" && pc.codeSetID == set.ID.Hex()
+		switch {
+		case pc.CouponCode == "":
+			return "requires a coupon code"
+		case !shared && !generated:
+			return fmt.Sprintf("coupon %q does not match", pc.CouponCode)
+		}
+	}
+	if c.MinSubtotal > 0 && pc.CartSubtotal.Amount < models.NewMoney(c.MinSubtotal).Amount {
+		return fmt.Sprintf("cart subtotal %s below minimum %.2f", pc.CartSubtotal, c.MinSubtotal)
+	}
+	if c.MinQuantity > 0 && pc.CartQuantity < c.MinQuantity {
+		return fmt.Sprintf("cart quantity %d below minimum %d", pc.CartQuantity, c.MinQuantity)
+	}
+	if c.CustomerTier != "" && c.CustomerTier != pc.CustomerTier {
+		return fmt.Sprintf("customer tier %q is not %q", pc.CustomerTier, c.CustomerTier)
+	}
+	if len(c.CustomerIDs) > 0 && (pc.CustomerID == "" || !containsStr(c.CustomerIDs, pc.CustomerID)) {
+		return "customer not in the set's customer list"
+	}
+	if c.NeedsCustomerStats() {
+		return customerStatsReason(c, pc)
+	}
+	return ""
+}
+
+func customerStatsReason(c models.PriceConditions, pc PricingContext) string {
+	stats := pc.CustomerStats
+	if stats == nil {
+		return "customer order history unknown"
+	}
+	if c.FirstOrder && stats.OrderCount > 0 {
+		return fmt.Sprintf("not a first order (%d previous)", stats.OrderCount)
+	}
+	if c.FirstOrder && stats.PendingOrders > 0 {
+		return fmt.Sprintf("not a first order (%d awaiting payment)", stats.PendingOrders)
+	}
+	if c.MinOrders > 0 && stats.OrderCount < c.MinOrders {
+		return fmt.Sprintf("customer has %d orders, needs at least %d", stats.OrderCount, c.MinOrders)
+	}
+	if c.MaxOrders > 0 && stats.OrderCount > c.MaxOrders {
+		return fmt.Sprintf("customer has %d orders, allows at most %d", stats.OrderCount, c.MaxOrders)
+	}
+	if c.MinLifetimeSpend > 0 && stats.LifetimeSpend.Amount < models.NewMoney(c.MinLifetimeSpend).Amount {
+		return fmt.Sprintf("lifetime spend %s below %.2f", stats.LifetimeSpend, c.MinLifetimeSpend)
+	}
+	if c.InactiveDays > 0 {
+		if stats.LastOrderAt == nil {
+			return "no previous order to win back"
+		}
+		if since := pc.Date.Sub(*stats.LastOrderAt); since < time.Duration(c.InactiveDays)*24*time.Hour {
+			return fmt.Sprintf("last order %s is within %d days", stats.LastOrderAt.Format(time.RFC3339), c.InactiveDays)
+		}
+	}
+	return ""
+}
+
+// usageReason checks the caps against the live usage ResolvePrices read,
+// falling back to the set's own counters when there is none.
+func usageReason(set models.PriceSet, pc PricingContext) string {
+	used := set.UsedCount + set.ReservedCount
+	customerUsed := set.CustomerUsage[pc.CustomerID] + set.CustomerReserved[pc.CustomerID]
+	if u, ok := pc.usage[set.ID.Hex()]; ok {
+		used, customerUsed = u.used, u.customerUsed
+	}
+	if set.MaxUses > 0 && used >= set.MaxUses {
+		return fmt.Sprintf("usage cap reached (%d/%d)", used, set.MaxUses)
+	}
+	if set.MaxUsesPerCustomer > 0 && pc.CustomerID != "" && customerUsed >= set.MaxUsesPerCustomer {
+		return fmt.Sprintf("per-customer cap reached (%d/%d)", customerUsed, set.MaxUsesPerCustomer)
+	}
+	return ""
+}
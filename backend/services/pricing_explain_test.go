@@ -0,0 +1,80 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+)
+
+func TestResolveExplainTrace(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	product := &Product{ID: primitive.NewObjectID(), Category: "dairy", BasePrice: 100}
+
+	schedules := []models.PriceSchedule{
+		{ID: primitive.NewObjectID(), Name: "inflation", Scope: models.ScheduleScopeGlobal, Mode: models.ScheduleModePercentage, Value: 10, EffectiveFrom: now.Add(-time.Hour)},
+		{ID: primitive.NewObjectID(), Name: "future", Scope: models.ScheduleScopeGlobal, Mode: models.ScheduleModeFixed, Value: 1, Priority: 1, EffectiveFrom: later},
+		{ID: primitive.NewObjectID(), Name: "bakery", Scope: models.ScheduleScopeCategory, ScopeRefs: []string{"bakery"}, Mode: models.ScheduleModeFixed, Value: 1, Priority: 2, EffectiveFrom: now.Add(-time.Hour)},
+	}
+	all := []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 10}}
+	sets := []models.PriceSet{
+		{ID: primitive.NewObjectID(), Name: "coupon", Priority: 1, Conditions: models.PriceConditions{CouponCode: "SAVE"}, Rules: all},
+		{ID: primitive.NewObjectID(), Name: "exhausted", Priority: 2, MaxUses: 3, UsedCount: 3, Rules: all},
+		{ID: primitive.NewObjectID(), Name: "not yet", Priority: 3, StartsAt: &later, Rules: all},
+		{ID: primitive.NewObjectID(), Name: "toys only", Priority: 4, Rules: []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeCategory, ScopeRefs: []string{"toys"}, Amount: 50}}},
+		{ID: primitive.NewObjectID(), Name: "house", Priority: 5, StopFurtherRules: true, Rules: all},
+		{ID: primitive.NewObjectID(), Name: "after stop", Priority: 6, Rules: all},
+	}
+	snap := compileSnapshot(sets, schedules, 0, now)
+
+	res, err := snap.resolve([]PriceInput{{Product: product, Quantity: 2}}, PricingContext{Date: now, Explain: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace := res.Lines[0].Trace
+
+	want := []struct {
+		name    string
+		matched bool
+		reason  string
+		unit    float64
+	}{
+		{"base price", true, "", 100},
+		{"inflation", true, "", 110},
+		{"future", false, "not effective until", 110},
+		{"bakery", false, "out of scope", 110},
+		{"coupon", false, "requires a coupon code", 110},
+		{"exhausted", false, "usage cap reached (3/3)", 110},
+		{"not yet", false, "not started", 110},
+		{"toys only", false, "no rule applied", 110},
+		{"house", true, "", 99},
+		{"after stop", false, `"house" stops further rules`, 99},
+	}
+	if len(trace) != len(want) {
+		t.Fatalf("expected %d steps, got %d: %+v", len(want), len(trace), trace)
+	}
+	for i, w := range want {
+		step := trace[i]
+		if step.Name != w.name || step.Matched != w.matched || !strings.Contains(step.Reason, w.reason) || step.UnitPrice != mxn(w.unit) {
+			t.Errorf("step %d: expected %s matched=%v reason~%q unit=%v, got %+v", i, w.name, w.matched, w.reason, w.unit, step)
+		}
+	}
+	if trace[8].Discount != mxn(22) || trace[8].LineTotal != mxn(198) {
+		t.Errorf("expected the house set to take 22 off the line, got %+v", trace[8])
+	}
+	if res.Total != mxn(198) {
+		t.Errorf("explain must not change the result, got %v", res.Total)
+	}
+
+	plain, err := snap.resolve([]PriceInput{{Product: product, Quantity: 2}}, PricingContext{Date: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.Total != res.Total || plain.Lines[0].Trace != nil {
+		t.Errorf("without explain: expected the same total and no trace, got %v / %d steps", plain.Total, len(plain.Lines[0].Trace))
+	}
+}
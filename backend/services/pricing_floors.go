@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"mercadomio-backend/models"
+)
+
+// floorFor returns the lowest unit price discounts may take a line to: its
+// cost grossed up so the minimum margin (on the selling price) survives. The
+// product's own policy wins over its categories'. A line without a cost
+// price has no floor beyond zero.
+func (snap *pricingSnapshot) floorFor(in PriceInput) models.Money {
+	cost := models.NewMoney(in.Product.CostFor(in.Variant))
+	if cost.Amount <= 0 {
+		return models.Money{}
+	}
+	margin := in.Product.MinMarginPercent
+	if margin == 0 {
+		margin = snap.categories.marginFor(categoryKeys(in.Product))
+	}
+	return marginFloor(cost, margin)
+}
+
+// marginFloor is the price at which cost leaves margin percent of it, rounded
+// up to the minor unit. Margins outside [0, 100) fall back to cost.
+func marginFloor(cost models.Money, margin float64) models.Money {
+	if margin <= 0 || margin >= 100 {
+		return cost
+	}
+	floor := math.Ceil(float64(cost.Amount)*100/(100-margin) - 1e-9)
+	return models.Money{Amount: int64(floor), Currency: cost.Currency}
+}
+
+// unitRoom is how much a discount may take off the line's unit price before
+// it hits the floor; ok is false when the line has no floor.
+func (l *PricedLine) unitRoom() (models.Money, bool) {
+	if l.floor.Amount <= 0 {
+		return models.Money{}, false
+	}
+	room := l.UnitPrice.Sub(l.floor)
+	if room.Amount < 0 {
+		room.Amount = 0
+	}
+	return room, true
+}
+
+// lineRoom is unitRoom for the whole line total.
+func (l *PricedLine) lineRoom() (models.Money, bool) {
+	if l.floor.Amount <= 0 {
+		return models.Money{}, false
+	}
+	room := l.LineTotal.Sub(l.floor.Mul(l.Quantity))
+	if room.Amount < 0 {
+		room.Amount = 0
+	}
+	return room, true
+}
+
+// recordClamp notes that a promotion asked for more than the floor allowed.
+func (l *PricedLine) recordClamp(source, id, name string, requested, allowed models.Money) {
+	l.Clamps = append(l.Clamps, models.PriceClamp{
+		Source:    source,
+		ID:        id,
+		Name:      name,
+		ProductID: l.ProductID,
+		VariantID: l.VariantID,
+		Floor:     l.floor,
+		Requested: requested,
+		Allowed:   allowed,
+	})
+}
+
+// groupClamps gathers clamps per promotion, in the order they first appear.
+func groupClamps(clamps []models.PriceClamp) []models.PromotionClamps {
+	var out []models.PromotionClamps
+	at := map[string]int{}
+	for _, c := range clamps {
+		key := c.Source + ":" + c.ID
+		i, ok := at[key]
+		if !ok {
+			i = len(out)
+			at[key] = i
+			out = append(out, models.PromotionClamps{Source: c.Source, ID: c.ID, Name: c.Name, Lost: models.MoneyFromMinor(0)})
+		}
+		out[i].Lost = out[i].Lost.Add(c.Requested.Sub(c.Allowed))
+		out[i].Clamps = append(out[i].Clamps, c)
+	}
+	return out
+}
+
+// ClampReport lists the active schedules and sets whose discounts are being
+// cut short by a price floor right now. Every product with a cost price is
+// priced on its own, once per variant, against each set that is in its
+// window; customer conditions and usage caps are ignored, so the report
+// shows every promotion that would be clamped for someone. Multi-line rules
+// depend on the cart and are left out.
+func (s *PricingService) ClampReport(ctx context.Context) ([]models.PromotionClamps, error) {
+	snap, err := s.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := s.db.Collection("products").Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"costPrice": bson.M{"$gt": 0}},
+		bson.M{"variants.costPrice": bson.M{"$gt": 0}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	var products []Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+	return snap.clampReport(products, time.Now()), nil
+}
+
+func (snap *pricingSnapshot) clampReport(products []Product, now time.Time) []models.PromotionClamps {
+	var clamps []models.PriceClamp
+	for pi := range products {
+		p := &products[pi]
+		for _, v := range variantsOf(p) {
+			in := PriceInput{Product: p, Variant: v, Quantity: 1}
+			in.categoryKeys = snap.categoryKeysFor(p)
+			line, _ := snap.priceLine(in, models.NewMoney(p.PriceFor(v)), now, false)
+			clamps = append(clamps, line.Clamps...)
+			if line.floor.Amount <= 0 {
+				continue
+			}
+			for _, set := range snap.setsFor([]PricedLine{line}) {
+				if setWindowReason(set, now) != "" {
+					continue
+				}
+				set.Rules = unitRules(set.Rules)
+				trial := cloneLines([]PricedLine{line})
+				trial[0].Clamps = nil
+				applySetRules(set, &trial)
+				clamps = append(clamps, trial[0].Clamps...)
+			}
+		}
+	}
+	report := groupClamps(clamps)
+	sort.SliceStable(report, func(i, j int) bool { return report[i].Lost.Amount > report[j].Lost.Amount })
+	return report
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+)
+
+func TestMarginFloor(t *testing.T) {
+	cases := []struct {
+		cost   float64
+		margin float64
+		want   models.Money
+	}{
+		{80, 0, mxn(80)},
+		{80, 20, mxn(100)},
+		{10, 30, mxn(14.29)}, // 14.2857..., rounded up
+		{80, 100, mxn(80)},   // nonsense margins fall back to cost
+	}
+	for _, tc := range cases {
+		if got := marginFloor(mxn(tc.cost), tc.margin); got != tc.want {
+			t.Errorf("marginFloor(%v, %v) = %s, want %s", tc.cost, tc.margin, got, tc.want)
+		}
+	}
+}
+
+func TestResolveClampsDiscountsAtFloor(t *testing.T) {
+	sale := models.PriceSchedule{ID: primitive.NewObjectID(), Name: "liquidación", Scope: models.ScheduleScopeGlobal,
+		Mode: models.ScheduleModePercentage, Value: -30, EffectiveFrom: time.Now().Add(-time.Hour)}
+	coupon := models.PriceSet{ID: primitive.NewObjectID(), Name: "extra 10", Active: true,
+		Rules: []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 10}}}
+	snap := compileSnapshot([]models.PriceSet{coupon}, []models.PriceSchedule{sale}, 0, time.Now())
+
+	// Cost 70 with a 12.5% margin: floor 80. The schedule alone wants 70.
+	p := &Product{ID: primitive.NewObjectID(), BasePrice: 100, CostPrice: 70, MinMarginPercent: 12.5}
+	res, err := snap.resolve([]PriceInput{{Product: p, Quantity: 2}}, PricingContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := res.Lines[0]
+	if line.UnitPrice != mxn(80) || line.LineTotal != mxn(160) {
+		t.Fatalf("expected the line held at the 80 floor, got %s / %s", line.UnitPrice, line.LineTotal)
+	}
+	if len(line.Clamps) != 2 {
+		t.Fatalf("expected the schedule and the set clamped, got %+v", line.Clamps)
+	}
+	if c := line.Clamps[0]; c.Source != models.ClampSourceSchedule || c.Requested != mxn(60) || c.Allowed != mxn(40) {
+		t.Errorf("unexpected schedule clamp %+v", c)
+	}
+	if c := line.Clamps[1]; c.Source != models.ClampSourceSet || c.Requested != mxn(16) || !c.Allowed.IsZero() {
+		t.Errorf("unexpected set clamp %+v", c)
+	}
+	if len(res.Clamps) != 2 || res.Clamps[0].Lost != mxn(20) || res.Clamps[1].Lost != mxn(16) {
+		t.Errorf("unexpected clamp summary %+v", res.Clamps)
+	}
+
+	// Without a cost price only the zero floor applies.
+	free := &Product{ID: primitive.NewObjectID(), BasePrice: 100}
+	res, _ = snap.resolve([]PriceInput{{Product: free, Quantity: 1}}, PricingContext{})
+	if res.Lines[0].UnitPrice != mxn(63) || len(res.Clamps) != 0 {
+		t.Errorf("expected 100 -30%% -10%% = 63 unclamped, got %s %+v", res.Lines[0].UnitPrice, res.Clamps)
+	}
+}
+
+func TestResolveClampsMultiLineShares(t *testing.T) {
+	threeForTwo := models.PriceSet{ID: primitive.NewObjectID(), Name: "3x2", Active: true,
+		Rules: []models.PriceRule{{Kind: models.RuleKindBuyXGetY, Scope: models.RuleScopeAll, BuyQuantity: 2, GetQuantity: 1}}}
+	snap := compileSnapshot([]models.PriceSet{threeForTwo}, nil, 0, time.Now())
+
+	// The free unit would take 30 off a line of 90 with a floor of 25 a unit.
+	p := &Product{ID: primitive.NewObjectID(), BasePrice: 30, CostPrice: 25}
+	res, err := snap.resolve([]PriceInput{{Product: p, Quantity: 3}}, PricingContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != mxn(75) {
+		t.Errorf("expected the 3x2 held at 3 x 25 = 75, got %s", res.Total)
+	}
+	if len(res.AppliedSets) != 1 || res.AppliedSets[0].Discount != mxn(15) {
+		t.Errorf("expected the applied set to report the clamped 15, got %+v", res.AppliedSets)
+	}
+}
+
+func TestCategoryMarginPolicy(t *testing.T) {
+	groceries := Category{ID: primitive.NewObjectID(), Name: "Abarrotes", MinMarginPercent: 20}
+	oils := Category{ID: primitive.NewObjectID(), Name: "Aceites", ParentID: &groceries.ID}
+	spirits := Category{ID: primitive.NewObjectID(), Name: "Licores", MinMarginPercent: 50}
+
+	snap := compileSnapshot(nil, nil, 0, time.Now())
+	snap.categories = newCategoryTree([]Category{groceries, oils, spirits})
+
+	oil := &Product{ID: primitive.NewObjectID(), Categories: []primitive.ObjectID{oils.ID}, CostPrice: 40}
+	if got := snap.floorFor(PriceInput{Product: oil}); got != mxn(50) {
+		t.Errorf("inherited 20%% margin on 40: expected floor 50, got %s", got)
+	}
+	both := &Product{ID: primitive.NewObjectID(), Category: "Licores", Categories: []primitive.ObjectID{oils.ID}, CostPrice: 40}
+	if got := snap.floorFor(PriceInput{Product: both}); got != mxn(80) {
+		t.Errorf("strictest margin should win: expected floor 80, got %s", got)
+	}
+	own := &Product{ID: primitive.NewObjectID(), Categories: []primitive.ObjectID{oils.ID}, CostPrice: 40, MinMarginPercent: 5}
+	if got := snap.floorFor(PriceInput{Product: own}); got != mxn(42.11) {
+		t.Errorf("product policy should override its category: expected 42.11, got %s", got)
+	}
+	variant := &Variant{VariantID: "5l", CostPrice: 160}
+	if got := snap.floorFor(PriceInput{Product: oil, Variant: variant}); got != mxn(200) {
+		t.Errorf("variant cost should override product cost: expected 200, got %s", got)
+	}
+}
+
+func TestClampReport(t *testing.T) {
+	deep := models.PriceSet{ID: primitive.NewObjectID(), Name: "mitad de precio", Active: true,
+		Rules: []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 50}}}
+	shallow := models.PriceSet{ID: primitive.NewObjectID(), Name: "5 off", Active: true,
+		Rules: []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 5}}}
+	vip := models.PriceSet{ID: primitive.NewObjectID(), Name: "vip", Active: true,
+		Conditions: models.PriceConditions{CustomerTier: "gold"},
+		Rules:      []models.PriceRule{{Kind: models.RuleKindOverride, Scope: models.RuleScopeAll, Amount: 1}}}
+	snap := compileSnapshot([]models.PriceSet{deep, shallow, vip}, nil, 0, time.Now())
+
+	products := []Product{{ID: primitive.NewObjectID(), BasePrice: 100, CostPrice: 60}}
+	report := snap.clampReport(products, time.Now())
+	if len(report) != 2 {
+		t.Fatalf("expected the 50%% set and the gated vip set, got %+v", report)
+	}
+	if report[0].Name != "vip" || report[0].Lost != mxn(59) {
+		t.Errorf("vip override to 1 should lose 59, got %+v", report[0])
+	}
+	if report[1].Name != "mitad de precio" || report[1].Lost != mxn(10) {
+		t.Errorf("50%% off with a 60 floor should lose 10, got %+v", report[1])
+	}
+}
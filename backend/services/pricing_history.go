@@ -0,0 +1,288 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"mercadomio-backend/models"
+)
+
+// historyWatermarkID is the pricing_settings document holding the instant up
+// to which window boundaries have been recorded.
+const historyWatermarkID = "history"
+
+// boundaryEpsilon is how far either side of a boundary prices are compared.
+const boundaryEpsilon = time.Millisecond
+
+// priceBoundary is a moment a schedule or set opens or closes.
+type priceBoundary struct {
+	At        time.Time
+	Activated bool
+	Schedule  *models.PriceSchedule // one of Schedule and Set is set
+	Set       *models.PriceSet
+}
+
+// StartHistory subscribes the price history to list price edits.
+func (s *PricingService) StartHistory(eventBus EventBus) {
+	eventBus.Subscribe("product.price.changed", s.handlePriceChanged)
+}
+
+// handlePriceChanged records the effective price change a list price edit
+// caused: both prices go through the schedules in force (and rounding), so
+// the entry shows what customers actually see.
+func (s *PricingService) handlePriceChanged(ctx context.Context, event DomainEvent) error {
+	e, ok := event.(ProductPriceChanged)
+	if !ok {
+		return nil
+	}
+	entry := models.PriceHistoryEntry{
+		ProductID:   e.ProductID,
+		VariantID:   e.VariantID,
+		OldPrice:    e.OldPrice,
+		NewPrice:    e.NewPrice,
+		Reason:      "base price updated",
+		SourceType:  models.PriceHistorySourceBasePrice,
+		SourceRef:   e.ProductID,
+		EffectiveAt: e.Timestamp,
+	}
+	if snap, err := s.snapshot(ctx); err == nil && s.productService != nil {
+		if p, err := s.productService.GetProduct(ctx, e.ProductID); err == nil {
+			in := PriceInput{Product: p, Variant: p.FindVariant(e.VariantID), Quantity: 1}
+			in.categoryKeys = snap.categoryKeysFor(p)
+			before, _ := snap.priceLine(in, models.NewMoney(e.OldPrice), e.Timestamp, false)
+			after, _ := snap.priceLine(in, models.NewMoney(e.NewPrice), e.Timestamp, false)
+			entry.OldPrice = before.UnitPrice.Float()
+			entry.NewPrice = after.UnitPrice.Float()
+			entry.Rounding = after.Rounding
+		}
+	}
+	if entry.OldPrice == entry.NewPrice {
+		return nil
+	}
+	return s.RecordPriceHistory(ctx, []models.PriceHistoryEntry{entry})
+}
+
+// StartHistoryRoutine periodically records the price changes caused by
+// schedules and sets opening or closing.
+func (s *PricingService) StartHistoryRoutine(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.RecordWindowChanges(context.Background(), time.Now()); err != nil {
+			log.Printf("Failed to record price window changes: %v", err)
+		}
+	}
+}
+
+// RecordWindowChanges records a history entry for every product whose price
+// changed because a schedule or set window opened or closed since the last
+// run. The watermark moves forward with a compare-and-swap, so with several
+// replicas each interval is recorded once; the first run only sets it.
+func (s *PricingService) RecordWindowChanges(ctx context.Context, now time.Time) error {
+	settings := s.db.Collection("pricing_settings")
+	var mark struct {
+		At time.Time `bson:"at"`
+	}
+	err := settings.FindOne(ctx, bson.M{"_id": historyWatermarkID}).Decode(&mark)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		_, err = settings.InsertOne(ctx, bson.M{"_id": historyWatermarkID, "at": now})
+		if mongo.IsDuplicateKeyError(err) {
+			return nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if !now.After(mark.At) {
+		return nil
+	}
+	res, err := settings.UpdateOne(ctx,
+		bson.M{"_id": historyWatermarkID, "at": mark.At},
+		bson.M{"$set": bson.M{"at": now}})
+	if err != nil || res.ModifiedCount == 0 {
+		return err // another replica took this interval
+	}
+
+	snap, err := s.snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	boundaries := snap.windowBoundaries(mark.At, now)
+	if len(boundaries) == 0 {
+		return nil
+	}
+	cursor, err := s.db.Collection("products").Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var products []Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return err
+	}
+	return s.RecordPriceHistory(ctx, snap.boundaryEntries(boundaries, products))
+}
+
+// windowBoundaries lists the boundaries in (from, to], oldest first.
+func (snap *pricingSnapshot) windowBoundaries(from, to time.Time) []priceBoundary {
+	var out []priceBoundary
+	add := func(b priceBoundary) {
+		if b.At.After(from) && !b.At.After(to) {
+			out = append(out, b)
+		}
+	}
+	for i := range snap.schedules {
+		sch := &snap.schedules[i]
+		if sch.Recurrence == nil {
+			add(priceBoundary{At: sch.EffectiveFrom, Activated: true, Schedule: sch})
+			if sch.EffectiveTo != nil {
+				add(priceBoundary{At: *sch.EffectiveTo, Schedule: sch})
+			}
+			continue
+		}
+		for _, w := range windowsBetween(sch.Recurrence, &sch.EffectiveFrom, sch.EffectiveTo, from, to) {
+			add(priceBoundary{At: w.Start, Activated: true, Schedule: sch})
+			add(priceBoundary{At: w.End, Schedule: sch})
+		}
+	}
+	for i := range snap.sets {
+		set := &snap.sets[i]
+		if set.Recurrence == nil {
+			if set.StartsAt != nil {
+				add(priceBoundary{At: *set.StartsAt, Activated: true, Set: set})
+			}
+			if set.EndsAt != nil {
+				add(priceBoundary{At: *set.EndsAt, Set: set})
+			}
+			continue
+		}
+		for _, w := range windowsBetween(set.Recurrence, set.StartsAt, set.EndsAt, from, to) {
+			add(priceBoundary{At: w.Start, Activated: true, Set: set})
+			add(priceBoundary{At: w.End, Set: set})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].At.Before(out[j].At) })
+	return out
+}
+
+// windowsBetween returns the recurrence windows that touch (from, to].
+func windowsBetween(r *models.Recurrence, startsAt, endsAt *time.Time, from, to time.Time) []ActivationWindow {
+	var out []ActivationWindow
+	for _, w := range nextActivations(r, startsAt, endsAt, from, 64) {
+		if w.Start.After(to) {
+			break
+		}
+		out = append(out, w)
+	}
+	return out
+}
+
+// boundaryEntries prices every product (once per variant) just before and
+// just after each boundary and returns an entry wherever the price moved.
+// Schedules are compared through the full schedule chain; a set's entry shows
+// what its unit rules do to the scheduled price, ignoring its conditions,
+// since who qualifies is only known at checkout.
+func (snap *pricingSnapshot) boundaryEntries(boundaries []priceBoundary, products []Product) []models.PriceHistoryEntry {
+	var entries []models.PriceHistoryEntry
+	for pi := range products {
+		p := &products[pi]
+		for _, v := range variantsOf(p) {
+			in := PriceInput{Product: p, Variant: v, Quantity: 1}
+			in.categoryKeys = snap.categoryKeysFor(p)
+			base := models.NewMoney(p.PriceFor(v))
+			for _, b := range boundaries {
+				var entry models.PriceHistoryEntry
+				var changed bool
+				if b.Schedule != nil {
+					entry, changed = snap.scheduleEntry(b, in, base)
+				} else {
+					entry, changed = snap.setEntry(b, in, base)
+				}
+				if !changed {
+					continue
+				}
+				entry.ProductID = p.ID.Hex()
+				if v != nil {
+					entry.VariantID = v.VariantID
+				}
+				entry.EffectiveAt = b.At
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries
+}
+
+func (snap *pricingSnapshot) scheduleEntry(b priceBoundary, in PriceInput, base models.Money) (models.PriceHistoryEntry, bool) {
+	if !scheduleMatches(*b.Schedule, in) {
+		return models.PriceHistoryEntry{}, false
+	}
+	before, _ := snap.priceLine(in, base, b.At.Add(-boundaryEpsilon), false)
+	after, _ := snap.priceLine(in, base, b.At.Add(boundaryEpsilon), false)
+	if before.UnitPrice == after.UnitPrice {
+		return models.PriceHistoryEntry{}, false
+	}
+	return models.PriceHistoryEntry{
+		OldPrice:   before.UnitPrice.Float(),
+		NewPrice:   after.UnitPrice.Float(),
+		Reason:     fmt.Sprintf("schedule %q %s", b.Schedule.Name, boundaryVerb(b)),
+		SourceType: models.PriceHistorySourceSchedule,
+		SourceRef:  b.Schedule.ID.Hex(),
+		Rounding:   after.Rounding,
+	}, true
+}
+
+func (snap *pricingSnapshot) setEntry(b priceBoundary, in PriceInput, base models.Money) (models.PriceHistoryEntry, bool) {
+	line, _ := snap.priceLine(in, base, b.At, false)
+	set := *b.Set
+	set.Rules = unitRules(set.Rules)
+	trial := cloneLines([]PricedLine{line})
+	applySetRules(set, &trial)
+	if trial[0].UnitPrice == line.UnitPrice {
+		return models.PriceHistoryEntry{}, false
+	}
+	trial[0].round(models.RoundingStageSets, line.UnitPrice)
+
+	without, with := line.UnitPrice.Float(), trial[0].UnitPrice.Float()
+	entry := models.PriceHistoryEntry{
+		OldPrice:   without,
+		NewPrice:   with,
+		Reason:     fmt.Sprintf("price set %q %s", set.Name, boundaryVerb(b)),
+		SourceType: models.PriceHistorySourceSet,
+		SourceRef:  set.ID.Hex(),
+		Rounding:   trial[0].Rounding,
+	}
+	if !b.Activated {
+		entry.OldPrice, entry.NewPrice = with, without
+		entry.Rounding = line.Rounding
+	}
+	return entry, true
+}
+
+func boundaryVerb(b priceBoundary) string {
+	if b.Activated {
+		return "activated"
+	}
+	return "expired"
+}
+
+// variantsOf returns the product's variants, or a single nil for a product
+// sold without them.
+func variantsOf(p *Product) []*Variant {
+	if len(p.Variants) == 0 {
+		return []*Variant{nil}
+	}
+	out := make([]*Variant, 0, len(p.Variants))
+	for i := range p.Variants {
+		out = append(out, &p.Variants[i])
+	}
+	return out
+}
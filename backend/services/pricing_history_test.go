@@ -0,0 +1,109 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+)
+
+func TestWindowBoundaries(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	ends := now.Add(30 * time.Minute)
+	sale := models.PriceSchedule{ID: primitive.NewObjectID(), Name: "hot sale", Scope: models.ScheduleScopeGlobal,
+		Mode: models.ScheduleModePercentage, Value: -10, EffectiveFrom: now.Add(-10 * time.Minute), EffectiveTo: &ends}
+	old := models.PriceSchedule{ID: primitive.NewObjectID(), Name: "viejo", Scope: models.ScheduleScopeGlobal,
+		Mode: models.ScheduleModePercentage, Value: 5, EffectiveFrom: now.Add(-48 * time.Hour)}
+	happyHour := models.PriceSet{ID: primitive.NewObjectID(), Name: "happy hour", Active: true,
+		Recurrence: &models.Recurrence{Windows: []models.TimeWindow{{Start: "12:15", End: "13:00"}}, Timezone: "UTC"},
+		Rules:      []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 20}}}
+	snap := compileSnapshot([]models.PriceSet{happyHour}, []models.PriceSchedule{sale, old}, 0, now)
+
+	got := snap.windowBoundaries(now.Add(-time.Hour), now.Add(time.Hour))
+	want := []struct {
+		at        time.Time
+		name      string
+		activated bool
+	}{
+		{now.Add(-10 * time.Minute), "hot sale", true},
+		{now.Add(15 * time.Minute), "happy hour", true},
+		{now.Add(30 * time.Minute), "hot sale", false},
+		{now.Add(time.Hour), "happy hour", false},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d boundaries, got %+v", len(want), got)
+	}
+	for i, w := range want {
+		b := got[i]
+		name := ""
+		if b.Schedule != nil {
+			name = b.Schedule.Name
+		} else {
+			name = b.Set.Name
+		}
+		if !b.At.Equal(w.at) || name != w.name || b.Activated != w.activated {
+			t.Errorf("boundary %d: expected %s %s activated=%v, got %s %s activated=%v", i, w.at, w.name, w.activated, b.At, name, b.Activated)
+		}
+	}
+
+	// The range is half-open: a boundary at from was recorded by the last run.
+	if got := snap.windowBoundaries(now.Add(-10*time.Minute), now); len(got) != 0 {
+		t.Errorf("expected nothing in (from, to], got %+v", got)
+	}
+}
+
+func TestBoundaryEntries(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	ends := now.Add(time.Hour)
+	sale := models.PriceSchedule{ID: primitive.NewObjectID(), Name: "hot sale", Scope: models.ScheduleScopeProduct,
+		Mode: models.ScheduleModePercentage, Value: -10, EffectiveFrom: now, EffectiveTo: &ends}
+	coupon := models.PriceSet{ID: primitive.NewObjectID(), Name: "cupón", Active: true, EndsAt: &ends,
+		Conditions: models.PriceConditions{CouponCode: "VERANO"},
+		Rules:      []models.PriceRule{{Kind: models.RuleKindAbsolute, Scope: models.RuleScopeAll, Amount: 5}}}
+
+	shirt := Product{ID: primitive.NewObjectID(), BasePrice: 200,
+		Variants: []Variant{{VariantID: "s"}, {VariantID: "xl", PriceAdjustment: 20}}}
+	mug := Product{ID: primitive.NewObjectID(), BasePrice: 100}
+	sale.ScopeRefs = []string{shirt.ID.Hex()}
+	snap := compileSnapshot([]models.PriceSet{coupon}, []models.PriceSchedule{sale}, 0, now)
+
+	entries := snap.boundaryEntries(snap.windowBoundaries(now.Add(-time.Minute), ends), []Product{shirt, mug})
+
+	type key struct{ product, variant, source string }
+	got := map[key]models.PriceHistoryEntry{}
+	for _, e := range entries {
+		got[key{e.ProductID, e.VariantID, e.SourceType + boolWord(e.EffectiveAt.Equal(now))}] = e
+	}
+	if len(entries) != 7 {
+		t.Fatalf("expected 4 schedule and 3 set entries, got %d: %+v", len(entries), entries)
+	}
+	// The schedule only touches the shirt, both ways.
+	e := got[key{shirt.ID.Hex(), "xl", models.PriceHistorySourceSchedule + "start"}]
+	if e.OldPrice != 220 || e.NewPrice != 198 || e.SourceRef != sale.ID.Hex() || e.Reason != `schedule "hot sale" activated` {
+		t.Errorf("unexpected activation entry %+v", e)
+	}
+	e = got[key{shirt.ID.Hex(), "s", models.PriceHistorySourceSchedule + "end"}]
+	if e.OldPrice != 180 || e.NewPrice != 200 || !e.EffectiveAt.Equal(ends) {
+		t.Errorf("unexpected expiry entry %+v", e)
+	}
+	if _, ok := got[key{mug.ID.Hex(), "", models.PriceHistorySourceSchedule + "start"}]; ok {
+		t.Error("the mug is out of the schedule's scope")
+	}
+	// The set expires on the scheduled price, coupon condition or not.
+	e = got[key{shirt.ID.Hex(), "xl", models.PriceHistorySourceSet + "end"}]
+	if e.OldPrice != 193 || e.NewPrice != 198 || e.SourceRef != coupon.ID.Hex() || e.Reason != `price set "cupón" expired` {
+		t.Errorf("unexpected set expiry entry %+v", e)
+	}
+	if e := got[key{mug.ID.Hex(), "", models.PriceHistorySourceSet + "end"}]; e.OldPrice != 95 || e.NewPrice != 100 {
+		t.Errorf("unexpected mug set expiry %+v", e)
+	}
+}
+
+func boolWord(start bool) string {
+	if start {
+		return "start"
+	}
+	return "end"
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"sort"
+
+	"mercadomio-backend/models"
+)
+
+// promoUnit is a single unit of a line that a multi-line rule can claim.
+type promoUnit struct {
+	line  int
+	price int64 // minor units
+}
+
+// multiLineResult accumulates what a multi-line rule did to each line.
+// Discounts are in minor units.
+type multiLineResult struct {
+	used     map[int]int
+	discount map[int]int64
+}
+
+func newMultiLineResult() *multiLineResult {
+	return &multiLineResult{used: map[int]int{}, discount: map[int]int64{}}
+}
+
+// applyMultiLineRule applies a buy_x_get_y, bundle or cheapest_free rule across
+// every line in scope. Units claimed by one multi-line rule cannot be claimed
+// again by another, so a yogurt in a 3x2 is not also part of a bundle.
+func applyMultiLineRule(set models.PriceSet, rule models.PriceRule, lines []PricedLine) []models.AppliedPriceRule {
+	var res *multiLineResult
+	switch rule.Kind {
+	case models.RuleKindBuyXGetY:
+		if rule.BuyQuantity <= 0 || rule.GetQuantity <= 0 {
+			return nil
+		}
+		res = freeUnits(rule, lines, rule.BuyQuantity+rule.GetQuantity, rule.GetQuantity)
+	case models.RuleKindCheapestFree:
+		if rule.BuyQuantity < 2 {
+			return nil
+		}
+		res = freeUnits(rule, lines, rule.BuyQuantity, 1)
+	case models.RuleKindBundle:
+		res = bundleUnits(rule, lines)
+	}
+	if res == nil || len(res.used) == 0 {
+		return nil
+	}
+
+	idx := make([]int, 0, len(res.used))
+	for li := range res.used {
+		idx = append(idx, li)
+	}
+	sort.Ints(idx)
+
+	total := int64(0)
+	for _, d := range res.discount {
+		total += d
+	}
+	if total <= 0 {
+		return nil
+	}
+	if maxDiscount := models.NewMoney(rule.MaxDiscount).Amount; rule.MaxDiscount > 0 && total > maxDiscount {
+		// Scale every line's share down to the cap, keeping the shares
+		// summing exactly to it.
+		weights := make([]int64, len(idx))
+		for i, li := range idx {
+			weights[i] = res.discount[li]
+		}
+		for i, share := range models.MoneyFromMinor(maxDiscount).Allocate(weights) {
+			res.discount[idx[i]] = share.Amount
+		}
+		total = maxDiscount
+	}
+
+	// Shares that would take a line below its price floor are cut to what
+	// the floor leaves room for.
+	for _, li := range idx {
+		d := models.MoneyFromMinor(res.discount[li])
+		if room, ok := lines[li].lineRoom(); ok && d.Amount > room.Amount {
+			lines[li].recordClamp(models.ClampSourceSet, set.ID.Hex(), set.Name, d, room)
+			total -= d.Amount - room.Amount
+			res.discount[li] = room.Amount
+		}
+	}
+	if total <= 0 {
+		return nil
+	}
+
+	consumed := make([]models.AppliedLine, 0, len(idx))
+	for _, li := range idx {
+		consumed = append(consumed, models.AppliedLine{
+			ProductID: lines[li].ProductID,
+			VariantID: lines[li].VariantID,
+			Quantity:  res.used[li],
+			Discount:  models.MoneyFromMinor(res.discount[li]),
+		})
+	}
+
+	for _, li := range idx {
+		line := &lines[li]
+		line.consumed += res.used[li]
+		if d := models.MoneyFromMinor(res.discount[li]); d.Amount > 0 {
+			line.LineTotal = line.LineTotal.Sub(d.Min(line.LineTotal))
+			if line.Quantity > 0 {
+				line.UnitPrice = line.LineTotal.Div(line.Quantity)
+			}
+		}
+		line.AppliedSets = append(line.AppliedSets, models.AppliedPriceRule{
+			SetID:      set.ID.Hex(),
+			SetName:    set.Name,
+			SetVersion: set.Version,
+			Kind:       rule.Kind,
+			Amount:     rule.Amount,
+			Discount:   models.MoneyFromMinor(res.discount[li]),
+			Lines:      consumed,
+		})
+	}
+
+	return []models.AppliedPriceRule{{
+		SetID:      set.ID.Hex(),
+		SetName:    set.Name,
+		SetVersion: set.Version,
+		Kind:       rule.Kind,
+		Amount:     rule.Amount,
+		Discount:   models.MoneyFromMinor(total),
+		Lines:      consumed,
+	}}
+}
+
+// freeUnits groups the pooled units most-expensive first into groups of size
+// and makes the cheapest free units of every complete group free. Leftover
+// units that do not fill a group are not consumed.
+func freeUnits(rule models.PriceRule, lines []PricedLine, size, free int) *multiLineResult {
+	pool := unitPool(lines, func(l *PricedLine) bool { return ruleMatches(rule, l) })
+	groups := len(pool) / size
+	if groups == 0 {
+		return nil
+	}
+
+	res := newMultiLineResult()
+	for g := 0; g < groups; g++ {
+		group := pool[g*size : (g+1)*size]
+		for i, u := range group {
+			res.used[u.line]++
+			if i >= size-free {
+				res.discount[u.line] += u.price
+			}
+		}
+	}
+	return res
+}
+
+// bundleUnits fills as many complete bundles as the lines allow. Each bundle
+// takes its components in order, most expensive matching units first, and is
+// only formed while the bundle price is below what the units cost separately.
+// A bundle's discount is split across its units in proportion to their price,
+// to the cent, so the shares always add up to the bundle discount.
+func bundleUnits(rule models.PriceRule, lines []PricedLine) *multiLineResult {
+	components := rule.Components
+	if len(components) == 0 {
+		components = []models.BundleComponent{{Scope: rule.Scope, ScopeRefs: rule.ScopeRefs, Quantity: rule.BuyQuantity}}
+	}
+	for _, c := range components {
+		if c.Quantity <= 0 {
+			return nil
+		}
+	}
+
+	avail := make([]int, len(lines))
+	for i := range lines {
+		avail[i] = lines[i].Quantity - lines[i].consumed
+	}
+	order := linesByPrice(lines)
+	bundlePrice := models.NewMoney(rule.Amount).Amount
+
+	res := newMultiLineResult()
+	for {
+		var picked []promoUnit
+		complete := true
+		for _, c := range components {
+			cr := models.PriceRule{Scope: c.Scope, ScopeRefs: c.ScopeRefs, ExactCategory: c.ExactCategory}
+			need := c.Quantity
+			for _, li := range order {
+				for need > 0 && avail[li] > 0 && ruleMatches(cr, &lines[li]) {
+					avail[li]--
+					need--
+					picked = append(picked, promoUnit{line: li, price: lines[li].UnitPrice.Amount})
+				}
+			}
+			if need > 0 {
+				complete = false
+				break
+			}
+		}
+
+		regular := int64(0)
+		weights := make([]int64, len(picked))
+		for i, u := range picked {
+			regular += u.price
+			weights[i] = u.price
+		}
+		if !complete || regular <= bundlePrice {
+			break
+		}
+
+		shares := models.MoneyFromMinor(regular - bundlePrice).Allocate(weights)
+		for i, u := range picked {
+			res.used[u.line]++
+			res.discount[u.line] += shares[i].Amount
+		}
+	}
+	return res
+}
+
+// unitPool expands the unclaimed units of matching lines, most expensive
+// first; ties keep line order so resolution stays deterministic.
+func unitPool(lines []PricedLine, match func(*PricedLine) bool) []promoUnit {
+	var pool []promoUnit
+	for _, li := range linesByPrice(lines) {
+		line := &lines[li]
+		if !match(line) {
+			continue
+		}
+		for n := line.consumed; n < line.Quantity; n++ {
+			pool = append(pool, promoUnit{line: li, price: line.UnitPrice.Amount})
+		}
+	}
+	return pool
+}
+
+// linesByPrice returns line indexes ordered by unit price, highest first.
+func linesByPrice(lines []PricedLine) []int {
+	order := make([]int, len(lines))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return lines[order[a]].UnitPrice.Amount > lines[order[b]].UnitPrice.Amount
+	})
+	return order
+}
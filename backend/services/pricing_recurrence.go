@@ -0,0 +1,248 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"mercadomio-backend/models"
+)
+
+// maxActivationDays bounds how far ahead nextActivations looks.
+const maxActivationDays = 400
+
+// ActivationWindow is one concrete period during which a recurring set or
+// schedule is active.
+type ActivationWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// RecurringActivations lists the upcoming windows of one recurring set or
+// schedule.
+type RecurringActivations struct {
+	Source  string // set or schedule
+	ID      string
+	Name    string
+	Windows []ActivationWindow
+}
+
+// DefaultStoreTimezone is the store's timezone unless SetStoreTimezone
+// says otherwise.
+const DefaultStoreTimezone = "America/Mexico_City"
+
+var (
+	locations     sync.Map // IANA name -> *time.Location
+	storeTimezone = DefaultStoreTimezone
+)
+
+// SetStoreTimezone sets the timezone of recurrences that name none. It is
+// meant to be called once, at startup.
+func SetStoreTimezone(name string) error {
+	if _, err := recurrenceLocation(name); err != nil || name == "" {
+		return fmt.Errorf("invalid timezone %q", name)
+	}
+	storeTimezone = name
+	return nil
+}
+
+// StoreTimezone returns the timezone of recurrences that name none.
+func StoreTimezone() string {
+	return storeTimezone
+}
+
+// recurrenceLocation loads (and caches) a recurrence's timezone; an empty
+// name is the store's.
+func recurrenceLocation(name string) (*time.Location, error) {
+	if name == "" {
+		name = storeTimezone
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// parseClock turns "HH:MM" into minutes after midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// validateRecurrence rejects recurrences the engine could not evaluate.
+func validateRecurrence(r *models.Recurrence) error {
+	if r == nil {
+		return nil
+	}
+	if _, err := recurrenceLocation(r.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q", r.Timezone)
+	}
+	for _, d := range r.DaysOfWeek {
+		if d < time.Sunday || d > time.Saturday {
+			return errors.New("days of week must be between 0 (Sunday) and 6 (Saturday)")
+		}
+	}
+	for _, w := range r.Windows {
+		if _, err := parseClock(w.Start); err != nil {
+			return err
+		}
+		if _, err := parseClock(w.End); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dailyWindow is a TimeWindow in minutes; end > start, and end goes past
+// 1440 for windows that run over midnight.
+type dailyWindow struct{ start, end int }
+
+func dailyWindows(r *models.Recurrence) ([]dailyWindow, error) {
+	if len(r.Windows) == 0 {
+		return []dailyWindow{{0, 24 * 60}}, nil
+	}
+	out := make([]dailyWindow, 0, len(r.Windows))
+	for _, w := range r.Windows {
+		start, err := parseClock(w.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(w.End)
+		if err != nil {
+			return nil, err
+		}
+		if end <= start {
+			end += 24 * 60
+		}
+		out = append(out, dailyWindow{start, end})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].start < out[j].start })
+	return out, nil
+}
+
+func dayAllowed(r *models.Recurrence, d time.Weekday) bool {
+	if len(r.DaysOfWeek) == 0 {
+		return true
+	}
+	for _, allowed := range r.DaysOfWeek {
+		if allowed == d {
+			return true
+		}
+	}
+	return false
+}
+
+// recurrenceReason explains why d falls outside a recurrence; empty when it
+// is inside (or there is no recurrence).
+func recurrenceReason(r *models.Recurrence, d time.Time) string {
+	if r == nil {
+		return ""
+	}
+	loc, err := recurrenceLocation(r.Timezone)
+	if err != nil {
+		return fmt.Sprintf("invalid timezone %q", r.Timezone)
+	}
+	windows, err := dailyWindows(r)
+	if err != nil {
+		return err.Error()
+	}
+
+	local := d.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	today := local.Weekday()
+	yesterday := (today + 6) % 7
+	for _, w := range windows {
+		if dayAllowed(r, today) && minute >= w.start && minute < w.end {
+			return ""
+		}
+		// The tail of yesterday's window that ran past midnight.
+		if dayAllowed(r, yesterday) && minute+24*60 < w.end {
+			return ""
+		}
+	}
+	return "outside recurrence (" + describeRecurrence(r) + ")"
+}
+
+// describeRecurrence renders a recurrence as e.g. "Tue 18:00-20:00 America/Mexico_City".
+func describeRecurrence(r *models.Recurrence) string {
+	var parts []string
+	if len(r.DaysOfWeek) > 0 {
+		days := make([]string, len(r.DaysOfWeek))
+		for i, d := range r.DaysOfWeek {
+			days[i] = d.String()[:3]
+		}
+		parts = append(parts, strings.Join(days, ","))
+	}
+	if len(r.Windows) > 0 {
+		windows := make([]string, len(r.Windows))
+		for i, w := range r.Windows {
+			windows[i] = w.Start + "-" + w.End
+		}
+		parts = append(parts, strings.Join(windows, ","))
+	}
+	tz := r.Timezone
+	if tz == "" {
+		tz = storeTimezone
+	}
+	return strings.Join(append(parts, tz), " ")
+}
+
+// nextActivations returns up to n windows of a recurrence that end after
+// from, clipped to the absolute [startsAt, endsAt] range (nil = unbounded).
+// A window already in progress at from is included.
+func nextActivations(r *models.Recurrence, startsAt, endsAt *time.Time, from time.Time, n int) []ActivationWindow {
+	if r == nil || n <= 0 {
+		return nil
+	}
+	loc, err := recurrenceLocation(r.Timezone)
+	if err != nil {
+		return nil
+	}
+	windows, err := dailyWindows(r)
+	if err != nil {
+		return nil
+	}
+
+	var out []ActivationWindow
+	local := from.In(loc)
+	// Start a day early to catch a window that began yesterday and runs past midnight.
+	day := time.Date(local.Year(), local.Month(), local.Day()-1, 0, 0, 0, 0, loc)
+	for i := 0; i < maxActivationDays && len(out) < n; i++ {
+		date := day.AddDate(0, 0, i)
+		if endsAt != nil && date.After(*endsAt) {
+			break
+		}
+		if !dayAllowed(r, date.Weekday()) {
+			continue
+		}
+		for _, w := range windows {
+			start := time.Date(date.Year(), date.Month(), date.Day(), w.start/60, w.start%60, 0, 0, loc)
+			end := time.Date(date.Year(), date.Month(), date.Day(), w.end/60, w.end%60, 0, 0, loc)
+			if startsAt != nil && start.Before(*startsAt) {
+				start = *startsAt
+			}
+			if endsAt != nil && end.After(*endsAt) {
+				end = *endsAt
+			}
+			if !end.After(from) || !end.After(start) {
+				continue
+			}
+			out = append(out, ActivationWindow{Start: start, End: end})
+			if len(out) == n {
+				break
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"mercadomio-backend/models"
+)
+
+func TestRecurrenceReason(t *testing.T) {
+	mx, err := time.LoadLocation("America/Mexico_City")
+	if err != nil {
+		t.Skip("tzdata not available:", err)
+	}
+	happyHour := &models.Recurrence{
+		DaysOfWeek: []time.Weekday{time.Tuesday},
+		Windows:    []models.TimeWindow{{Start: "18:00", End: "20:00"}},
+		Timezone:   "America/Mexico_City",
+	}
+	overnight := &models.Recurrence{
+		DaysOfWeek: []time.Weekday{time.Friday},
+		Windows:    []models.TimeWindow{{Start: "22:00", End: "02:00"}},
+		Timezone:   "America/Mexico_City",
+	}
+	// 2026-10-20 is a Tuesday.
+	at := func(day, hour, min int) time.Time { return time.Date(2026, 10, day, hour, min, 0, 0, mx) }
+
+	cases := []struct {
+		name   string
+		r      *models.Recurrence
+		d      time.Time
+		active bool
+	}{
+		{"no recurrence", nil, at(20, 3, 0), true},
+		{"inside the window", happyHour, at(20, 18, 0), true},
+		{"end is exclusive", happyHour, at(20, 20, 0), false},
+		{"right time, wrong day", happyHour, at(21, 19, 0), false},
+		{"evaluated in the set's timezone", happyHour, at(20, 19, 30).UTC(), true},
+		{"overnight, before midnight", overnight, at(23, 23, 0), true},
+		{"overnight tail belongs to the start day", overnight, at(24, 1, 30), true},
+		{"overnight tail on the wrong day", overnight, at(25, 1, 30), false},
+		{"every day when no days are given", &models.Recurrence{Windows: []models.TimeWindow{{Start: "08:00", End: "09:00"}}}, at(18, 8, 30), true},
+		{"the store's timezone when none is given", &models.Recurrence{Windows: []models.TimeWindow{{Start: "08:00", End: "09:00"}}}, time.Date(2026, 10, 18, 8, 30, 0, 0, time.UTC), false},
+	}
+	for _, tc := range cases {
+		reason := recurrenceReason(tc.r, tc.d)
+		if (reason == "") != tc.active {
+			t.Errorf("%s: expected active=%v, got reason %q", tc.name, tc.active, reason)
+		}
+	}
+	if r := recurrenceReason(happyHour, at(21, 19, 0)); !strings.Contains(r, "Tue 18:00-20:00 America/Mexico_City") {
+		t.Errorf("expected the recurrence described in the reason, got %q", r)
+	}
+}
+
+func TestNextActivations(t *testing.T) {
+	mx, err := time.LoadLocation("America/Mexico_City")
+	if err != nil {
+		t.Skip("tzdata not available:", err)
+	}
+	produce := &models.Recurrence{DaysOfWeek: []time.Weekday{time.Tuesday}, Timezone: "America/Mexico_City"}
+	from := time.Date(2026, 10, 20, 12, 0, 0, 0, mx) // a Tuesday, midday
+	endsAt := time.Date(2026, 11, 5, 0, 0, 0, 0, mx)
+
+	got := nextActivations(produce, nil, &endsAt, from, 5)
+	want := []time.Time{
+		time.Date(2026, 10, 20, 0, 0, 0, 0, mx), // in progress
+		time.Date(2026, 10, 27, 0, 0, 0, 0, mx),
+		time.Date(2026, 11, 3, 0, 0, 0, 0, mx),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d windows before the end date, got %+v", len(want), got)
+	}
+	for i, w := range want {
+		if !got[i].Start.Equal(w) || !got[i].End.Equal(w.AddDate(0, 0, 1)) {
+			t.Errorf("window %d: expected %v for a day, got %v-%v", i, w, got[i].Start, got[i].End)
+		}
+	}
+
+	hh := &models.Recurrence{Windows: []models.TimeWindow{{Start: "18:00", End: "20:00"}}, Timezone: "America/Mexico_City"}
+	got = nextActivations(hh, nil, nil, from, 2)
+	if len(got) != 2 || !got[0].Start.Equal(time.Date(2026, 10, 20, 18, 0, 0, 0, mx)) || !got[1].Start.Equal(time.Date(2026, 10, 21, 18, 0, 0, 0, mx)) {
+		t.Errorf("expected today's and tomorrow's happy hour, got %+v", got)
+	}
+}
+
+func TestValidateRecurrence(t *testing.T) {
+	bad := []*models.Recurrence{
+		{Timezone: "Mars/Olympus_Mons"},
+		{DaysOfWeek: []time.Weekday{7}},
+		{Windows: []models.TimeWindow{{Start: "25:00", End: "26:00"}}},
+	}
+	for _, r := range bad {
+		if validateRecurrence(r) == nil {
+			t.Errorf("expected %+v to be rejected", r)
+		}
+	}
+	if err := validateRecurrence(&models.Recurrence{DaysOfWeek: []time.Weekday{time.Tuesday}, Windows: []models.TimeWindow{{Start: "22:00", End: "02:00"}}, Timezone: "America/Mexico_City"}); err != nil {
+		t.Errorf("expected a valid recurrence, got %v", err)
+	}
+}
+
+func TestSetStoreTimezone(t *testing.T) {
+	defer SetStoreTimezone(DefaultStoreTimezone)
+	if SetStoreTimezone("Mars/Olympus_Mons") == nil || SetStoreTimezone("") == nil {
+		t.Fatal("expected an invalid store timezone to be rejected")
+	}
+	if err := SetStoreTimezone("UTC"); err != nil {
+		t.Fatal(err)
+	}
+	r := &models.Recurrence{Windows: []models.TimeWindow{{Start: "08:00", End: "09:00"}}}
+	if reason := recurrenceReason(r, time.Date(2026, 10, 18, 8, 30, 0, 0, time.UTC)); reason != "" {
+		t.Errorf("expected the window evaluated in the store's timezone, got %q", reason)
+	}
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"mercadomio-backend/models"
+)
+
+// pricingSettingsID is the _id of the single pricing_settings document.
+const pricingSettingsID = "global"
+
+// GetPricingSettings returns the store-wide pricing settings; defaults when
+// none were saved.
+func (s *PricingService) GetPricingSettings(ctx context.Context) (*models.PricingSettings, error) {
+	var settings models.PricingSettings
+	err := s.db.Collection("pricing_settings").FindOne(ctx, bson.M{"_id": pricingSettingsID}).Decode(&settings)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &models.PricingSettings{Rounding: models.RoundingCents}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// UpdatePricingSettings saves the store-wide pricing settings.
+func (s *PricingService) UpdatePricingSettings(ctx context.Context, settings *models.PricingSettings) error {
+	if !settings.Rounding.Valid() {
+		return errors.New("unknown rounding strategy " + string(settings.Rounding))
+	}
+	if settings.Rounding == "" {
+		settings.Rounding = models.RoundingCents
+	}
+	settings.UpdatedAt = time.Now()
+	_, err := s.db.Collection("pricing_settings").ReplaceOne(ctx, bson.M{"_id": pricingSettingsID}, settings, options.Replace().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	s.invalidatePricing(ctx)
+	return nil
+}
+
+// roundingFor returns the strategy for a product: its categories', else the
+// store-wide one.
+func (snap *pricingSnapshot) roundingFor(p *Product) models.RoundingStrategy {
+	if r := snap.categories.roundingFor(categoryKeys(p)); r != "" {
+		return r
+	}
+	return snap.rounding
+}
+
+// roundUnitPrice applies a strategy to a unit price, keeping the result
+// within [lo, hi]: a rounding never takes a price below its floor, and never
+// turns a discount into a price above where the discount started. A zero
+// bound is no bound. When neither direction fits, the price is left as is.
+func roundUnitPrice(strategy models.RoundingStrategy, price, lo, hi models.Money) models.Money {
+	// preferred is what the strategy asks for; fallback is the same
+	// rounding in the other direction.
+	var preferred, fallback models.Money
+	switch strategy {
+	case models.RoundingUp90:
+		preferred, fallback = roundToEnding(price, 90)
+	case models.RoundingUp99:
+		preferred, fallback = roundToEnding(price, 99)
+	case models.RoundingNearest50:
+		down := models.Money{Amount: price.Amount - price.Amount%50, Currency: price.Currency}
+		up := down
+		if price.Amount%50 != 0 {
+			up.Amount += 50
+		}
+		preferred, fallback = up, down
+		if price.Amount-down.Amount < up.Amount-price.Amount {
+			preferred, fallback = down, up
+		}
+	default:
+		return price
+	}
+	fits := func(m models.Money) bool {
+		return m.Amount >= 0 && (lo.Amount <= 0 || m.Amount >= lo.Amount) && (hi.Amount <= 0 || m.Amount <= hi.Amount)
+	}
+	if fits(preferred) {
+		return preferred
+	}
+	if fits(fallback) {
+		return fallback
+	}
+	return price
+}
+
+// roundToEnding returns the nearest prices at or above and below price whose
+// cents are ending (e.g. 37.42 -> 37.90 and 36.90 for 90).
+func roundToEnding(price models.Money, ending int64) (models.Money, models.Money) {
+	whole := price.Amount - price.Amount%100
+	up := models.Money{Amount: whole + ending, Currency: price.Currency}
+	if up.Amount < price.Amount {
+		up.Amount += 100
+	}
+	down := models.Money{Amount: up.Amount - 100, Currency: price.Currency}
+	return up, down
+}
+
+// round applies the line's rounding strategy to its unit price at a stage and
+// records the step when it moved the price. hi caps the result (zero: no cap).
+func (l *PricedLine) round(stage string, hi models.Money) {
+	if l.rounding == "" || l.rounding == models.RoundingCents {
+		return
+	}
+	before := l.UnitPrice
+	after := roundUnitPrice(l.rounding, before, l.floor, hi)
+	if after == before {
+		return
+	}
+	l.UnitPrice = after
+	l.LineTotal = after.Mul(l.Quantity)
+	l.Rounding = append(l.Rounding, models.RoundingStep{Stage: stage, Strategy: l.rounding, Before: before, After: after})
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+)
+
+func TestRoundUnitPrice(t *testing.T) {
+	none := models.Money{}
+	cases := []struct {
+		name     string
+		strategy models.RoundingStrategy
+		price    models.Money
+		lo, hi   models.Money
+		want     models.Money
+	}{
+		{"cents leaves the price", models.RoundingCents, mxn(37.42), none, none, mxn(37.42)},
+		{"up to .90", models.RoundingUp90, mxn(37.42), none, none, mxn(37.90)},
+		{"past .90 goes to the next one", models.RoundingUp90, mxn(37.95), none, none, mxn(38.90)},
+		{"already .90", models.RoundingUp90, mxn(37.90), none, none, mxn(37.90)},
+		{"up to .99", models.RoundingUp99, mxn(37.42), none, none, mxn(37.99)},
+		{"nearest .50 down", models.RoundingNearest50, mxn(37.24), none, none, mxn(37.00)},
+		{"nearest .50 up", models.RoundingNearest50, mxn(37.26), none, none, mxn(37.50)},
+		{"tie rounds up", models.RoundingNearest50, mxn(37.25), none, none, mxn(37.50)},
+		{"a discount is not rounded above where it started", models.RoundingUp99, mxn(37.96), none, mxn(37.98), mxn(36.99)},
+		{"never below the floor", models.RoundingNearest50, mxn(37.10), mxn(37.05), none, mxn(37.50)},
+		{"left alone when nothing fits", models.RoundingUp99, mxn(37.50), mxn(37.40), mxn(37.60), mxn(37.50)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := roundUnitPrice(tc.strategy, tc.price, tc.lo, tc.hi); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolveRoundsAfterSchedulesAndSets(t *testing.T) {
+	produce := Category{ID: primitive.NewObjectID(), Name: "Frutas y verduras", Rounding: models.RoundingNearest50}
+	inflation := models.PriceSchedule{ID: primitive.NewObjectID(), Name: "inflación", Scope: models.ScheduleScopeGlobal,
+		Mode: models.ScheduleModePercentage, Value: 3.7, EffectiveFrom: time.Now().Add(-time.Hour)}
+	promo := models.PriceSet{ID: primitive.NewObjectID(), Name: "7% off", Active: true,
+		Rules: []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 7}}}
+
+	snap := compileSnapshot([]models.PriceSet{promo}, []models.PriceSchedule{inflation}, 0, time.Now())
+	snap.categories = newCategoryTree([]Category{produce})
+	snap.rounding = models.RoundingUp90
+
+	rice := &Product{ID: primitive.NewObjectID(), BasePrice: 36.08}
+	avocado := &Product{ID: primitive.NewObjectID(), BasePrice: 36.08, Categories: []primitive.ObjectID{produce.ID}}
+	res, err := snap.resolve([]PriceInput{{Product: rice, Quantity: 2}, {Product: avocado, Quantity: 1}}, PricingContext{Explain: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Store-wide up_90: 36.08 +3.7% = 37.41 -> 37.90; -7% = 35.25 -> 35.90.
+	r := res.Lines[0]
+	if r.UnitPrice != mxn(35.90) || r.LineTotal != mxn(71.80) {
+		t.Errorf("rice: expected 35.90 / 71.80, got %s / %s", r.UnitPrice, r.LineTotal)
+	}
+	want := []models.RoundingStep{
+		{Stage: models.RoundingStageSchedules, Strategy: models.RoundingUp90, Before: mxn(37.41), After: mxn(37.90)},
+		{Stage: models.RoundingStageSets, Strategy: models.RoundingUp90, Before: mxn(35.25), After: mxn(35.90)},
+	}
+	if len(r.Rounding) != len(want) || r.Rounding[0] != want[0] || r.Rounding[1] != want[1] {
+		t.Errorf("rice: expected rounding %+v, got %+v", want, r.Rounding)
+	}
+	rounded := 0
+	for _, step := range r.Trace {
+		if step.Source == TraceSourceRounding {
+			rounded++
+		}
+	}
+	if rounded != 2 {
+		t.Errorf("expected both roundings in the trace, got %+v", r.Trace)
+	}
+
+	// The category's nearest_50: 37.41 -> 37.50; -7% = 34.88 -> 35.00.
+	if a := res.Lines[1]; a.UnitPrice != mxn(35.00) {
+		t.Errorf("avocado: expected 35.00, got %s (%+v)", a.UnitPrice, a.Rounding)
+	}
+}
+
+func TestResolveLeavesUnchangedPricesUnrounded(t *testing.T) {
+	snap := compileSnapshot(nil, nil, 0, time.Now())
+	snap.rounding = models.RoundingUp99
+
+	p := &Product{ID: primitive.NewObjectID(), BasePrice: 36.08}
+	res, err := snap.resolve([]PriceInput{{Product: p, Quantity: 1}}, PricingContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Lines[0].UnitPrice != mxn(36.08) || len(res.Lines[0].Rounding) != 0 {
+		t.Errorf("list prices no rule touched must stay as they are, got %s", res.Lines[0].UnitPrice)
+	}
+}
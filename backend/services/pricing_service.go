@@ -3,8 +3,8 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sort"
-	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -20,9 +20,29 @@ type PricingContext struct {
 	Date         time.Time
 	CustomerID   string
 	CustomerTier string
+	// CustomerType picks the price lists assigned to the customer's user type;
+	// take it from the authenticated user, never from the request body.
+	CustomerType models.UserType
 	CouponCode   string
-	CartSubtotal float64
-	CartQuantity int
+	// CustomerStats is the customer's order history, needed by the
+	// behaviour conditions (first order, order count, spend, inactivity).
+	CustomerStats *models.CustomerStats
+	CartSubtotal  models.Money
+	CartQuantity  int
+	// UnitPricesOnly skips multi-line rules (buy X get Y, bundles, cheapest
+	// free). Catalog listings price unrelated products side by side, so those
+	// promotions only make sense once the items are in a cart.
+	UnitPricesOnly bool
+	// Explain records on every line each schedule and set considered, with
+	// the reason it was rejected and the running price (PricedLine.Trace).
+	Explain bool
+
+	// codeSetID is the set a generated coupon code in CouponCode belongs to,
+	// looked up by ResolvePrices.
+	codeSetID string
+	// usage is the live usage of the snapshot's capped sets, by set id, read
+	// by ResolvePrices.
+	usage map[string]setUsage
 }
 
 // PriceInput is a single purchasable line to price.
@@ -30,32 +50,58 @@ type PriceInput struct {
 	Product  *Product
 	Variant  *Variant
 	Quantity int
+
+	categoryKeys []string           // own and ancestor category ids/names, from the snapshot
+	priceList    *compiledPriceList // the list the base price came from, if any
 }
 
-// PricedLine is the resolved result for a single line.
+// PricedLine is the resolved result for a single line. Amounts are exact
+// minor units; LineTotal is authoritative, UnitPrice is LineTotal/qty rounded
+// once a multi-line rule has split a discount unevenly across units.
 type PricedLine struct {
 	ProductID   string
 	VariantID   string
 	SKU         string
 	Category    string
-	Categories  []string // parent category ids (hex)
-	BasePrice   float64
-	UnitPrice   float64
+	Categories  []string     // parent category ids (hex)
+	BasePrice   models.Money // from the customer's price list when one names the line
+	PriceListID string       // the price list BasePrice came from; empty for the catalog price
+	PriceList   string       // its name
+	UnitPrice   models.Money
 	Quantity    int
-	Subtotal    float64 // base * qty
-	LineTotal   float64 // unit * qty
-	Discount    float64 // subtotal - linetotal
+	Subtotal    models.Money // base * qty
+	LineTotal   models.Money // unit * qty
+	Discount    models.Money // subtotal - linetotal
 	AppliedSets []models.AppliedPriceRule
+	Tiers       []models.PriceTier // quantity breaks offered for this line, if any
+	ActiveTier  *models.PriceTier  // the break the line's quantity landed in
+	Trace       []PriceTraceStep   // only when PricingContext.Explain is set
+	TaxCategory string
+	Tax         models.Money        // taxes on LineTotal, when a tax service is set
+	Taxes       []models.TaxLine    // Tax broken down per tax
+	Clamps      []models.PriceClamp // discounts cut short by the price floor
+	Rounding    []models.RoundingStep
+
+	consumed     int          // units already claimed by a multi-line rule
+	categoryKeys []string     // own and ancestor category ids/names, from the snapshot
+	floor        models.Money // lowest unit price discounts may reach; zero when there is none
+	rounding     models.RoundingStrategy
 }
 
 // PriceResult is the aggregate resolution for an order.
 type PriceResult struct {
-	Subtotal             float64
-	Discount             float64
-	Total                float64
+	Subtotal             models.Money
+	Discount             models.Money
+	Total                models.Money
 	Lines                []PricedLine
 	AppliedSets          []models.AppliedPriceRule
 	AppliedScheduleNames []string
+	Stacking             []models.StackingDecision // how exclusive stacking groups were resolved
+	Activations          []RecurringActivations    // upcoming windows of recurring sets/schedules, when requested
+	Tax                  models.Money              // taxes on Total, when a tax service is set
+	Taxes                []models.TaxLine          // Tax per tax and rate
+	TotalWithTax         models.Money
+	Clamps               []models.PromotionClamps // promotions held back by price floors
 }
 
 // PricingService exposes CRUD + resolution.
@@ -64,7 +110,13 @@ type PricingService struct {
 	sets           *mongo.Collection // price_sets
 	schedules      *mongo.Collection // price_schedules
 	history        *mongo.Collection // price_history
+	reservations   *mongo.Collection // price_set_reservations
+	coupons        *mongo.Collection // coupon_codes
+	redemptions    *mongo.Collection // coupon_redemptions
+	firstOrders    *mongo.Collection // first_order_claims
 	productService ProductService
+	taxService     *TaxService
+	cache          pricingCache // compiled snapshot of active sets/schedules
 }
 
 func NewPricingService(db *mongo.Database, productService ProductService) *PricingService {
@@ -73,6 +125,10 @@ func NewPricingService(db *mongo.Database, productService ProductService) *Prici
 		sets:           db.Collection("price_sets"),
 		schedules:      db.Collection("price_schedules"),
 		history:        db.Collection("price_history"),
+		reservations:   db.Collection("price_set_reservations"),
+		coupons:        db.Collection("coupon_codes"),
+		redemptions:    db.Collection("coupon_redemptions"),
+		firstOrders:    db.Collection("first_order_claims"),
 		productService: productService,
 	}
 }
@@ -121,8 +177,15 @@ func (s *PricingService) GetPriceSet(ctx context.Context, id string) (*models.Pr
 	return &set, nil
 }
 
+// CreatePriceSet puts a new set live at once, as version 1, without a draft.
+// Admins create sets through CreateNewPriceSetDraft; this is for sets the
+// store sets up itself.
 func (s *PricingService) CreatePriceSet(ctx context.Context, set *models.PriceSet) (*models.PriceSet, error) {
+	if err := validateRecurrence(set.Recurrence); err != nil {
+		return nil, err
+	}
 	set.ID = primitive.NewObjectID()
+	set.Version = 1
 	now := time.Now()
 	set.CreatedAt = now
 	set.UpdatedAt = now
@@ -130,9 +193,17 @@ func (s *PricingService) CreatePriceSet(ctx context.Context, set *models.PriceSe
 	if err != nil {
 		return nil, err
 	}
+	s.invalidatePricing(ctx)
+	v := models.PricingVersion{Kind: models.VersionKindSet, TargetID: set.ID.Hex(), Set: setDefinition(set, set.ID)}
+	if err := s.recordCreatedVersion(ctx, v, now); err != nil {
+		return nil, err
+	}
 	return set, nil
 }
 
+// UpdatePriceSet changes a live set directly, without a draft or a new
+// version. Admin edits go through CreatePriceSetDraft; this is for internal
+// flags such as Conditions.UniqueCodes.
 func (s *PricingService) UpdatePriceSet(ctx context.Context, id string, update bson.M) error {
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -146,21 +217,7 @@ func (s *PricingService) UpdatePriceSet(ctx context.Context, id string, update b
 	if res.MatchedCount == 0 {
 		return errors.New("price set not found")
 	}
-	return nil
-}
-
-func (s *PricingService) DeletePriceSet(ctx context.Context, id string) error {
-	objID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return err
-	}
-	res, err := s.sets.DeleteOne(ctx, bson.M{"_id": objID})
-	if err != nil {
-		return err
-	}
-	if res.DeletedCount == 0 {
-		return errors.New("price set not found")
-	}
+	s.invalidatePricing(ctx)
 	return nil
 }
 
@@ -198,8 +255,15 @@ func (s *PricingService) GetPriceSchedule(ctx context.Context, id string) (*mode
 	return &sch, nil
 }
 
+// CreatePriceSchedule puts a new schedule live at once, as version 1,
+// without a draft. Admins create schedules through
+// CreateNewPriceScheduleDraft.
 func (s *PricingService) CreatePriceSchedule(ctx context.Context, sch *models.PriceSchedule) (*models.PriceSchedule, error) {
+	if err := validateRecurrence(sch.Recurrence); err != nil {
+		return nil, err
+	}
 	sch.ID = primitive.NewObjectID()
+	sch.Version = 1
 	now := time.Now()
 	sch.CreatedAt = now
 	sch.UpdatedAt = now
@@ -207,9 +271,16 @@ func (s *PricingService) CreatePriceSchedule(ctx context.Context, sch *models.Pr
 	if err != nil {
 		return nil, err
 	}
+	s.invalidatePricing(ctx)
+	v := models.PricingVersion{Kind: models.VersionKindSchedule, TargetID: sch.ID.Hex(), Schedule: scheduleDefinition(sch, sch.ID)}
+	if err := s.recordCreatedVersion(ctx, v, now); err != nil {
+		return nil, err
+	}
 	return sch, nil
 }
 
+// UpdatePriceSchedule changes a live schedule directly, without a draft or a
+// new version. Admin edits go through CreatePriceScheduleDraft.
 func (s *PricingService) UpdatePriceSchedule(ctx context.Context, id string, update bson.M) error {
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -223,21 +294,7 @@ func (s *PricingService) UpdatePriceSchedule(ctx context.Context, id string, upd
 	if res.MatchedCount == 0 {
 		return errors.New("price schedule not found")
 	}
-	return nil
-}
-
-func (s *PricingService) DeletePriceSchedule(ctx context.Context, id string) error {
-	objID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return err
-	}
-	res, err := s.schedules.DeleteOne(ctx, bson.M{"_id": objID})
-	if err != nil {
-		return err
-	}
-	if res.DeletedCount == 0 {
-		return errors.New("price schedule not found")
-	}
+	s.invalidatePricing(ctx)
 	return nil
 }
 
@@ -281,29 +338,81 @@ func (s *PricingService) RecordPriceHistory(ctx context.Context, entries []model
 	return err
 }
 
-// IncrementSetUsage bumps the usage counters for applied sets after an order is
-// persisted, enforcing MaxUses and MaxUsesPerCustomer caps.
-func (s *PricingService) IncrementSetUsage(ctx context.Context, setIDs []string, customerID string) error {
-	for _, id := range setIDs {
-		objID, err := primitive.ObjectIDFromHex(id)
-		if err != nil {
-			continue
-		}
-		inc := bson.M{"usedCount": 1}
-		if customerID != "" {
-			inc["customerUsage."+customerID] = 1
-		}
-		if _, err := s.sets.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$inc": inc}); err != nil {
-			return err
-		}
-	}
-	return nil
+// SetTaxService wires the tax step that runs after pricing.
+func (s *PricingService) SetTaxService(taxService *TaxService) {
+	s.taxService = taxService
 }
 
 // ---- PricingEngine ----
 
-// ResolvePrices prices all lines through schedules and eligible sets.
+// ResolvePrices prices all lines through schedules and eligible sets. Rules
+// come from the compiled snapshot, so a resolve costs no Mongo round trips
+// unless the snapshot has to be rebuilt or a set has a usage cap to check.
 func (s *PricingService) ResolvePrices(ctx context.Context, inputs []PriceInput, pc PricingContext) (*PriceResult, error) {
+	snap, err := s.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pc.usage, err = s.loadSetUsage(ctx, snap.capped, pc.CustomerID); err != nil {
+		return nil, err
+	}
+	if pc.CouponCode != "" && pc.codeSetID == "" {
+		pc.codeSetID = s.couponCodeSet(ctx, pc.CouponCode)
+	}
+	res, err := snap.resolve(inputs, pc)
+	if err == nil && s.taxService != nil {
+		s.taxService.apply(res)
+	}
+	return res, err
+}
+
+// NextActivations lists the next n activation windows, from the given time,
+// of every recurring set and schedule that could touch the inputs.
+func (s *PricingService) NextActivations(ctx context.Context, inputs []PriceInput, from time.Time, n int) ([]RecurringActivations, error) {
+	snap, err := s.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return snap.nextActivations(inputs, from, n), nil
+}
+
+func (snap *pricingSnapshot) nextActivations(inputs []PriceInput, from time.Time, n int) []RecurringActivations {
+	var out []RecurringActivations
+	seen := map[string]bool{}
+	lines := make([]PricedLine, 0, len(inputs))
+	for _, in := range inputs {
+		in.categoryKeys = snap.categoryKeysFor(in.Product)
+		for _, sch := range snap.schedulesFor(in) {
+			if sch.Recurrence == nil || seen[sch.ID.Hex()] {
+				continue
+			}
+			seen[sch.ID.Hex()] = true
+			out = append(out, RecurringActivations{
+				Source:  TraceSourceSchedule,
+				ID:      sch.ID.Hex(),
+				Name:    sch.Name,
+				Windows: nextActivations(sch.Recurrence, &sch.EffectiveFrom, sch.EffectiveTo, from, n),
+			})
+		}
+		line, _ := snap.priceLine(in, models.MoneyFromMinor(0), from, false)
+		lines = append(lines, line)
+	}
+	for _, set := range snap.setsFor(lines) {
+		if set.Recurrence == nil {
+			continue
+		}
+		out = append(out, RecurringActivations{
+			Source:  TraceSourceSet,
+			ID:      set.ID.Hex(),
+			Name:    set.Name,
+			Windows: nextActivations(set.Recurrence, set.StartsAt, set.EndsAt, from, n),
+		})
+	}
+	return out
+}
+
+// resolve is the pure pricing path: schedules, then sets, from the snapshot.
+func (snap *pricingSnapshot) resolve(inputs []PriceInput, pc PricingContext) (*PriceResult, error) {
 	if pc.Date.IsZero() {
 		pc.Date = time.Now()
 	}
@@ -311,11 +420,11 @@ func (s *PricingService) ResolvePrices(ctx context.Context, inputs []PriceInput,
 	lines := make([]PricedLine, 0, len(inputs))
 	var schedules []models.PriceSchedule
 	for i := range inputs {
-		base := inputs[i].Product.BasePrice
-		priced, sch, err := s.priceLine(ctx, inputs[i], base, pc.Date)
-		if err != nil {
-			return nil, err
-		}
+		in := inputs[i]
+		base, list := snap.basePrice(in, pc)
+		in.priceList = list
+		in.categoryKeys = snap.categoryKeysFor(in.Product)
+		priced, sch := snap.priceLine(in, base, pc.Date, pc.Explain)
 		schedules = append(schedules, sch...)
 		lines = append(lines, priced)
 	}
@@ -323,31 +432,51 @@ func (s *PricingService) ResolvePrices(ctx context.Context, inputs []PriceInput,
 	pc.CartSubtotal = subtotalOf(lines)
 	pc.CartQuantity = quantityOf(inputs)
 
-	allSets, err := s.loadActiveSets(ctx)
-	if err != nil {
-		return nil, err
+	// Explain looks at every set so out-of-scope ones show up in the trace.
+	sets := snap.setsFor(lines)
+	if pc.Explain {
+		sets = append([]models.PriceSet(nil), snap.sets...)
 	}
-	applied, stopErr := applySets(allSets, &lines, pc)
+	beforeSets := make([]models.Money, len(lines))
+	for i := range lines {
+		beforeSets[i] = lines[i].UnitPrice
+	}
+	applied, stacking, stopErr := applySets(sets, &lines, pc)
 	if stopErr != nil {
 		return nil, stopErr
 	}
+	// Round what the sets left, except lines a multi-line rule split unevenly
+	// across units.
+	for i := range lines {
+		if lines[i].UnitPrice != beforeSets[i] && lines[i].consumed == 0 {
+			lines[i].roundTraced(models.RoundingStageSets, beforeSets[i], pc.Explain)
+		}
+	}
 
 	res := &PriceResult{
+		Subtotal:             models.MoneyFromMinor(0),
+		Discount:             models.MoneyFromMinor(0),
+		Total:                models.MoneyFromMinor(0),
 		Lines:                lines,
 		AppliedSets:          applied,
 		AppliedScheduleNames: scheduleNames(schedules),
+		Stacking:             stacking,
 	}
+	var clamps []models.PriceClamp
 	for i := range lines {
-		lines[i].Discount = lines[i].Subtotal - lines[i].LineTotal
-		res.Subtotal += lines[i].Subtotal
-		res.Discount += lines[i].Discount
-		res.Total += lines[i].LineTotal
-	}
+		lines[i].Discount = lines[i].Subtotal.Sub(lines[i].LineTotal)
+		res.Subtotal = res.Subtotal.Add(lines[i].Subtotal)
+		res.Discount = res.Discount.Add(lines[i].Discount)
+		res.Total = res.Total.Add(lines[i].LineTotal)
+		clamps = append(clamps, lines[i].Clamps...)
+	}
+	res.TotalWithTax = res.Total
+	res.Clamps = groupClamps(clamps)
 	return res, nil
 }
 
 // priceLine returns the resolved line (schedules already applied to unit price).
-func (s *PricingService) priceLine(ctx context.Context, in PriceInput, base float64, date time.Time) (PricedLine, []models.PriceSchedule, error) {
+func (snap *pricingSnapshot) priceLine(in PriceInput, base models.Money, date time.Time, explain bool) (PricedLine, []models.PriceSchedule) {
 	line := PricedLine{
 		ProductID: in.Product.ID.Hex(),
 		VariantID: variantIDOf(in.Variant),
@@ -356,6 +485,15 @@ func (s *PricingService) priceLine(ctx context.Context, in PriceInput, base floa
 		BasePrice: base,
 		UnitPrice: base,
 		Quantity:  in.Quantity,
+
+		TaxCategory:  in.Product.TaxCategory,
+		categoryKeys: in.categoryKeys,
+		floor:        snap.floorFor(in),
+		rounding:     snap.roundingFor(in.Product),
+	}
+	if in.priceList != nil {
+		line.PriceListID = in.priceList.id
+		line.PriceList = in.priceList.name
 	}
 	if len(in.Product.Categories) > 0 {
 		cats := make([]string, 0, len(in.Product.Categories))
@@ -365,37 +503,64 @@ func (s *PricingService) priceLine(ctx context.Context, in PriceInput, base floa
 		line.Categories = cats
 	}
 
-	all, err := s.loadActiveSchedules(ctx)
-	if err != nil {
-		return line, nil, err
+	candidates := snap.schedulesFor(in)
+	if explain {
+		line.traceBase()
+		candidates = snap.schedules
 	}
-	sort.SliceStable(all, func(i, j int) bool { return all[i].Priority < all[j].Priority })
 
 	var applied []models.PriceSchedule
 	price := base
-	for _, sch := range all {
-		if !scheduleInWindow(sch, date) || !scheduleMatches(sch, in) {
+	for _, sch := range candidates {
+		if reason := scheduleReason(sch, in, date); reason != "" {
+			if explain {
+				line.traceSchedule(sch, price, price, reason)
+			}
 			continue
 		}
 		applied = append(applied, sch)
-		switch sch.Mode {
-		case models.ScheduleModePercentage:
-			price *= 1 + sch.Value/100
-		case models.ScheduleModeAbsolute:
-			price += sch.Value
-		case models.ScheduleModeFixed:
-			price = sch.Value
+		before := price
+		price = applySchedule(sch, price)
+		// A schedule may lower the price to the floor, never below it.
+		if limit := line.floor.Min(before); line.floor.Amount > 0 && price.Amount < limit.Amount {
+			line.recordClamp(models.ClampSourceSchedule, sch.ID.Hex(), sch.Name, before.Sub(price).Mul(in.Quantity), before.Sub(limit).Mul(in.Quantity))
+			price = limit
 		}
-		if price < 0 {
-			price = 0
+		if explain {
+			line.traceSchedule(sch, before, price, "")
 		}
 	}
 
 	line.UnitPrice = price
-	line.Subtotal = base * float64(in.Quantity)
-	line.LineTotal = price * float64(in.Quantity)
-	line.Discount = line.Subtotal - line.LineTotal
-	return line, applied, nil
+	line.Subtotal = base.Mul(in.Quantity)
+	line.LineTotal = price.Mul(in.Quantity)
+	if len(applied) > 0 {
+		// A schedule that discounted must not be rounded back above base.
+		hi := models.Money{}
+		if price.Amount < base.Amount {
+			hi = base
+		}
+		line.roundTraced(models.RoundingStageSchedules, hi, explain)
+	}
+	line.Discount = line.Subtotal.Sub(line.LineTotal)
+	return line, applied
+}
+
+// applySchedule moves a unit price by one schedule. Percentage schedules are
+// rounded half away from zero to the minor unit; prices never go negative.
+func applySchedule(sch models.PriceSchedule, price models.Money) models.Money {
+	switch sch.Mode {
+	case models.ScheduleModePercentage:
+		price = price.Add(price.Percent(sch.Value))
+	case models.ScheduleModeAbsolute:
+		price = price.Add(models.NewMoney(sch.Value))
+	case models.ScheduleModeFixed:
+		price = models.NewMoney(sch.Value)
+	}
+	if price.Amount < 0 {
+		price.Amount = 0
+	}
+	return price
 }
 
 func (s *PricingService) loadActiveSchedules(ctx context.Context) ([]models.PriceSchedule, error) {
@@ -410,8 +575,12 @@ func (s *PricingService) loadActiveSchedules(ctx context.Context) ([]models.Pric
 	return out, nil
 }
 
+// loadActiveSets loads the active sets without their usage counters, which
+// ResolvePrices reads live.
 func (s *PricingService) loadActiveSets(ctx context.Context) ([]models.PriceSet, error) {
-	cursor, err := s.sets.Find(ctx, bson.M{"active": true})
+	cursor, err := s.sets.Find(ctx, bson.M{"active": true}, options.Find().SetProjection(bson.M{
+		"usedCount": 0, "reservedCount": 0, "customerUsage": 0, "customerReserved": 0,
+	}))
 	if err != nil {
 		return nil, err
 	}
@@ -423,13 +592,7 @@ func (s *PricingService) loadActiveSets(ctx context.Context) ([]models.PriceSet,
 }
 
 func scheduleInWindow(sch models.PriceSchedule, d time.Time) bool {
-	if d.Before(sch.EffectiveFrom) {
-		return false
-	}
-	if sch.EffectiveTo != nil && d.After(*sch.EffectiveTo) {
-		return false
-	}
-	return true
+	return scheduleWindowReason(sch, d) == ""
 }
 
 func scheduleMatches(sch models.PriceSchedule, in PriceInput) bool {
@@ -441,17 +604,7 @@ func scheduleMatches(sch models.PriceSchedule, in PriceInput) bool {
 	case models.ScheduleScopeVariant:
 		return in.Variant != nil && containsStr(sch.ScopeRefs, in.Variant.VariantID)
 	case models.ScheduleScopeCategory:
-		if in.Product == nil {
-			return false
-		}
-		if containsStr(sch.ScopeRefs, in.Product.Category) {
-			return true
-		}
-		for _, c := range in.Product.Categories {
-			if containsStr(sch.ScopeRefs, c.Hex()) {
-				return true
-			}
-		}
+		return in.inCategory(sch.ScopeRefs, sch.ExactCategory)
 	}
 	return false
 }
@@ -483,10 +636,18 @@ func skuOf(in PriceInput) string {
 	return in.Product.SKU
 }
 
-func subtotalOf(lines []PricedLine) float64 {
-	t := 0.0
+func subtotalOf(lines []PricedLine) models.Money {
+	t := models.MoneyFromMinor(0)
+	for _, l := range lines {
+		t = t.Add(l.Subtotal)
+	}
+	return t
+}
+
+func totalOf(lines []PricedLine) models.Money {
+	t := models.MoneyFromMinor(0)
 	for _, l := range lines {
-		t += l.Subtotal
+		t = t.Add(l.LineTotal)
 	}
 	return t
 }
@@ -508,71 +669,244 @@ func containsStr(list []string, s string) bool {
 	return false
 }
 
-// applySets applies eligible sets to lines; increments usage for coupon sets.
-func applySets(all []models.PriceSet, lines *[]PricedLine, pc PricingContext) ([]models.AppliedPriceRule, error) {
-	sort.SliceStable(all, func(i, j int) bool { return all[i].Priority < all[j].Priority })
+// applySets applies eligible sets to lines and reports how exclusive stacking
+// groups were resolved. Sets are ordered by priority, then by id, so equal
+// priorities still resolve the same way regardless of the order Mongo
+// returned them in.
+func applySets(all []models.PriceSet, lines *[]PricedLine, pc PricingContext) ([]models.AppliedPriceRule, []models.StackingDecision, error) {
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].Priority != all[j].Priority {
+			return all[i].Priority < all[j].Priority
+		}
+		return all[i].ID.Hex() < all[j].ID.Hex()
+	})
 
-	var applied []models.AppliedPriceRule
+	// Exclusive groups are resolved as a whole at the position of their
+	// highest-priority set; additive groups apply set by set like ungrouped ones.
+	groups := map[string][]models.PriceSet{}
+	policies := map[string]models.StackingPolicy{}
 	for _, set := range all {
-		if !setInWindow(set, pc.Date) || !conditionsMatch(set.Conditions, pc) {
+		if set.StackingGroup == "" {
 			continue
 		}
-		if !usageAvailable(set, pc) {
+		if _, ok := policies[set.StackingGroup]; !ok {
+			policies[set.StackingGroup] = set.StackingPolicy
+		}
+		groups[set.StackingGroup] = append(groups[set.StackingGroup], set)
+	}
+
+	var applied []models.AppliedPriceRule
+	var decisions []models.StackingDecision
+	resolved := map[string]bool{}
+	stoppedBy := ""
+	for _, set := range all {
+		if stoppedBy != "" {
+			traceSetRejected(lines, set, fmt.Sprintf("skipped: %q stops further rules", stoppedBy))
 			continue
 		}
-		setApplied := applySetRules(set, lines)
-		if len(setApplied) > 0 {
-			applied = append(applied, setApplied...)
+
+		var setApplied []models.AppliedPriceRule
+		if policy := policies[set.StackingGroup]; set.StackingGroup != "" && policy != "" && policy != models.StackingAdditive {
+			if resolved[set.StackingGroup] {
+				continue
+			}
+			resolved[set.StackingGroup] = true
+			var decision *models.StackingDecision
+			set, setApplied, decision = applyStackingGroup(set.StackingGroup, policy, groups[set.StackingGroup], lines, pc)
+			if decision != nil {
+				decisions = append(decisions, *decision)
+			}
+		} else {
+			if reason := setReason(set, pc); reason != "" {
+				if pc.Explain {
+					traceSetRejected(lines, set, reason)
+				}
+				continue
+			}
+			setApplied = applySetTraced(set, lines, pc)
 		}
+
+		applied = append(applied, setApplied...)
 		if setApplied != nil && set.StopFurtherRules {
-			break
+			if !pc.Explain {
+				break
+			}
+			// Keep walking so the trace shows what the stop cut off.
+			stoppedBy = set.Name
 		}
 	}
-	return applied, nil
+	return applied, decisions, nil
 }
 
-// usageAvailable reports whether a set still has budget left under its caps.
-func usageAvailable(set models.PriceSet, pc PricingContext) bool {
-	if set.MaxUses > 0 && set.UsedCount >= set.MaxUses {
-		return false
+// applySetTraced applies one eligible set to the lines, recording its effect
+// on every line in explain mode.
+func applySetTraced(set models.PriceSet, lines *[]PricedLine, pc PricingContext) []models.AppliedPriceRule {
+	if pc.UnitPricesOnly {
+		set.Rules = unitRules(set.Rules)
 	}
-	if set.MaxUsesPerCustomer > 0 && pc.CustomerID != "" {
-		if set.CustomerUsage[pc.CustomerID] >= set.MaxUsesPerCustomer {
-			return false
-		}
+	if !pc.Explain {
+		return applySetRules(set, lines)
 	}
-	return true
-}
 
-func setInWindow(set models.PriceSet, d time.Time) bool {
-	if set.StartsAt != nil && d.Before(*set.StartsAt) {
-		return false
+	before := make([]models.Money, len(*lines))
+	seen := make([]int, len(*lines))
+	for i, l := range *lines {
+		before[i] = l.LineTotal
+		seen[i] = len(l.AppliedSets)
 	}
-	if set.EndsAt != nil && d.After(*set.EndsAt) {
-		return false
+	setApplied := applySetRules(set, lines)
+	for i := range *lines {
+		line := &(*lines)[i]
+		reason := ""
+		if len(line.AppliedSets) == seen[i] {
+			reason = "no rule applied to this line"
+		}
+		line.traceSet(set, before[i], reason)
+	}
+	return setApplied
+}
+
+// applyStackingGroup resolves an exclusive group: each eligible member is
+// tried on a copy of the lines, and only the winner (the first that applies
+// for first_match, the biggest discount for best_of) is kept. It returns the
+// winning set (or the first member when none applied) and the decision, which
+// is nil when no member was even eligible.
+func applyStackingGroup(group string, policy models.StackingPolicy, members []models.PriceSet, lines *[]PricedLine, pc PricingContext) (models.PriceSet, []models.AppliedPriceRule, *models.StackingDecision) {
+	type trial struct {
+		lines    []PricedLine
+		applied  []models.AppliedPriceRule
+		discount models.Money
+		reason   string
+	}
+	trials := make([]trial, len(members))
+	current := totalOf(*lines)
+	winner := -1
+	eligible := false
+	for i, set := range members {
+		if reason := setReason(set, pc); reason != "" {
+			trials[i].reason = reason
+			continue
+		}
+		eligible = true
+		if policy == models.StackingFirstMatch && winner >= 0 {
+			trials[i].reason = fmt.Sprintf("group %q: first match %q already applied", group, members[winner].Name)
+			continue
+		}
+		if pc.UnitPricesOnly {
+			set.Rules = unitRules(set.Rules)
+		}
+		t := &trials[i]
+		t.lines = cloneLines(*lines)
+		t.applied = applySetRules(set, &t.lines)
+		t.discount = current.Sub(totalOf(t.lines))
+		if t.applied == nil {
+			t.reason = "no rule applied"
+			continue
+		}
+		if winner < 0 || (policy == models.StackingBestOf && t.discount.Amount > trials[winner].discount.Amount) {
+			winner = i
+		}
+	}
+	if !eligible {
+		for i, set := range members {
+			traceSetRejected(lines, set, trials[i].reason)
+		}
+		return members[0], nil, nil
 	}
-	return true
-}
 
-func conditionsMatch(c models.PriceConditions, pc PricingContext) bool {
-	if c.CouponCode != "" {
-		if pc.CouponCode == "" || !strings.EqualFold(c.CouponCode, pc.CouponCode) {
-			return false
+	decision := &models.StackingDecision{Group: group, Policy: policy, Discount: models.MoneyFromMinor(0)}
+	for i, set := range members {
+		t := &trials[i]
+		if i == winner {
+			continue
+		}
+		if t.reason == "" {
+			t.reason = fmt.Sprintf("group %q: %q gives a bigger discount (%s)", group, members[winner].Name, trials[winner].discount)
+		}
+		decision.Rejected = append(decision.Rejected, models.StackingAlternative{
+			SetID:    set.ID.Hex(),
+			Name:     set.Name,
+			Discount: t.discount,
+			Reason:   t.reason,
+		})
+	}
+
+	// Walk the members in order so an explain trace reads like the other sets.
+	for i, set := range members {
+		if i != winner {
+			if pc.Explain {
+				traceSetRejected(lines, set, trials[i].reason)
+			}
+			continue
 		}
+		for li := range *lines {
+			line := &(*lines)[li]
+			before, seen := line.LineTotal, len(line.AppliedSets)
+			next := trials[i].lines[li]
+			next.Trace = line.Trace
+			*line = next
+			if pc.Explain {
+				reason := ""
+				if len(line.AppliedSets) == seen {
+					reason = "no rule applied to this line"
+				}
+				line.traceSet(set, before, reason)
+			}
+		}
+		decision.ChosenSetID = set.ID.Hex()
+		decision.ChosenName = set.Name
+		decision.Discount = trials[i].discount
 	}
-	if c.MinSubtotal > 0 && pc.CartSubtotal < c.MinSubtotal {
-		return false
+	if winner < 0 {
+		return members[0], nil, decision
 	}
-	if c.MinQuantity > 0 && pc.CartQuantity < c.MinQuantity {
-		return false
+	return members[winner], trials[winner].applied, decision
+}
+
+// cloneLines copies lines deeply enough for a trial application: the applied
+// and clamp lists are the only slices rules append to.
+func cloneLines(lines []PricedLine) []PricedLine {
+	out := make([]PricedLine, len(lines))
+	for i, l := range lines {
+		l.AppliedSets = append([]models.AppliedPriceRule(nil), l.AppliedSets...)
+		l.Clamps = append([]models.PriceClamp(nil), l.Clamps...)
+		l.Trace = nil
+		out[i] = l
 	}
-	if c.CustomerTier != "" && c.CustomerTier != pc.CustomerTier {
-		return false
+	return out
+}
+
+// traceSetRejected records a set that did not get to run on any line. It is
+// only called in explain mode.
+func traceSetRejected(lines *[]PricedLine, set models.PriceSet, reason string) {
+	for i := range *lines {
+		line := &(*lines)[i]
+		line.traceSet(set, line.LineTotal, reason)
 	}
-	if len(c.CustomerIDs) > 0 && (pc.CustomerID == "" || !containsStr(c.CustomerIDs, pc.CustomerID)) {
-		return false
+}
+
+// unitRules drops the multi-line rules from a set's rules.
+func unitRules(rules []models.PriceRule) []models.PriceRule {
+	out := make([]models.PriceRule, 0, len(rules))
+	for _, r := range rules {
+		if !r.Kind.IsMultiLine() {
+			out = append(out, r)
+		}
 	}
-	return true
+	return out
+}
+
+// usageAvailable reports whether a set still has budget left under its caps.
+func usageAvailable(set models.PriceSet, pc PricingContext) bool {
+	return usageReason(set, pc) == ""
+}
+
+func setInWindow(set models.PriceSet, d time.Time) bool {
+	return setWindowReason(set, d) == ""
+}
+
+func conditionsMatch(set models.PriceSet, pc PricingContext) bool {
+	return conditionsReason(set, pc) == ""
 }
 
 func applySetRules(set models.PriceSet, lines *[]PricedLine) []models.AppliedPriceRule {
@@ -580,44 +914,89 @@ func applySetRules(set models.PriceSet, lines *[]PricedLine) []models.AppliedPri
 	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Priority < rules[j].Priority })
 
 	var applied []models.AppliedPriceRule
-	for li := range *lines {
-		line := &(*lines)[li]
-		for _, rule := range rules {
+	for _, rule := range rules {
+		if rule.Kind.IsMultiLine() {
+			applied = append(applied, applyMultiLineRule(set, rule, *lines)...)
+			continue
+		}
+		for li := range *lines {
+			line := &(*lines)[li]
 			if !ruleMatches(rule, line) {
 				continue
 			}
-			discount := 0.0
+			// Discounts are per unit, in exact minor units: a percentage is
+			// rounded half away from zero on each unit before it is
+			// multiplied by the quantity, so the line always splits evenly.
+			var discount models.Money
 			switch rule.Kind {
 			case models.RuleKindPercentage:
-				discount = line.UnitPrice * rule.Amount / 100
+				discount = line.UnitPrice.Percent(rule.Amount)
 			case models.RuleKindAbsolute:
-				discount = rule.Amount
+				discount = models.NewMoney(rule.Amount)
 			case models.RuleKindOverride:
-				discount = line.UnitPrice - rule.Amount
+				discount = line.UnitPrice.Sub(models.NewMoney(rule.Amount))
+			case models.RuleKindTiered:
+				line.Tiers = sortedTiers(rule.Tiers)
+				tier := tierFor(line.Tiers, line.Quantity)
+				if tier == nil {
+					continue
+				}
+				line.ActiveTier = tier
+				discount = line.UnitPrice.Sub(models.NewMoney(tier.UnitPrice))
 			}
-			if rule.MaxDiscount > 0 && discount > rule.MaxDiscount {
-				discount = rule.MaxDiscount
+			if rule.MaxDiscount > 0 {
+				discount = discount.Min(models.NewMoney(rule.MaxDiscount))
 			}
-			if discount < 0 || discount > line.UnitPrice {
-				discount = line.UnitPrice
-			}
-			if discount <= 0 {
+			// An override or tier above the current price is not a discount;
+			// it must not be mistaken for one and zero the line out.
+			discount = discount.Min(line.UnitPrice)
+			if discount.Amount <= 0 {
 				continue
 			}
-			line.UnitPrice -= discount
-			line.LineTotal = line.UnitPrice * float64(line.Quantity)
-			applied = append(applied, models.AppliedPriceRule{
-				SetID:    set.ID.Hex(),
-				SetName:  set.Name,
-				Kind:     rule.Kind,
-				Amount:   rule.Amount,
-				Discount: discount * float64(line.Quantity),
-			})
+			lineDiscount := discount.Mul(line.Quantity).Min(line.LineTotal)
+			if room, ok := line.lineRoom(); ok && lineDiscount.Amount > room.Amount {
+				unitRoom, _ := line.unitRoom()
+				line.recordClamp(models.ClampSourceSet, set.ID.Hex(), set.Name, lineDiscount, room)
+				discount, lineDiscount = discount.Min(unitRoom), room
+				if lineDiscount.Amount <= 0 {
+					continue
+				}
+			}
+			line.UnitPrice = line.UnitPrice.Sub(discount)
+			line.LineTotal = line.LineTotal.Sub(lineDiscount)
+			a := models.AppliedPriceRule{
+				SetID:      set.ID.Hex(),
+				SetName:    set.Name,
+				SetVersion: set.Version,
+				Kind:       rule.Kind,
+				Amount:     rule.Amount,
+				Discount:   lineDiscount,
+			}
+			line.AppliedSets = append(line.AppliedSets, a)
+			applied = append(applied, a)
 		}
 	}
 	return applied
 }
 
+// sortedTiers returns a copy of tiers ordered by minimum quantity.
+func sortedTiers(tiers []models.PriceTier) []models.PriceTier {
+	out := append([]models.PriceTier(nil), tiers...)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].MinQuantity < out[j].MinQuantity })
+	return out
+}
+
+// tierFor returns the highest break whose range contains qty.
+func tierFor(tiers []models.PriceTier, qty int) *models.PriceTier {
+	var found *models.PriceTier
+	for i := range tiers {
+		if tiers[i].Contains(qty) {
+			found = &tiers[i]
+		}
+	}
+	return found
+}
+
 func ruleMatches(rule models.PriceRule, line *PricedLine) bool {
 	switch rule.Scope {
 	case models.RuleScopeAll:
@@ -629,15 +1008,7 @@ func ruleMatches(rule models.PriceRule, line *PricedLine) bool {
 	case models.RuleScopeSKU:
 		return line.SKU != "" && containsStr(rule.ScopeRefs, line.SKU)
 	case models.RuleScopeCategory:
-		if line.Category != "" && containsStr(rule.ScopeRefs, line.Category) {
-			return true
-		}
-		for _, c := range line.Categories {
-			if containsStr(rule.ScopeRefs, c) {
-				return true
-			}
-		}
-		return false
+		return line.inCategory(rule.ScopeRefs, rule.ExactCategory)
 	}
 	return false
 }
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -12,57 +13,133 @@ import (
 func TestConditionsMatchCoupon(t *testing.T) {
 	c := models.PriceConditions{CouponCode: "SAVE10"}
 	pc := PricingContext{CouponCode: "save10"}
-	if !conditionsMatch(c, pc) {
+	if !conditionsMatch(models.PriceSet{Conditions: c}, pc) {
 		t.Error("coupon should match case-insensitively")
 	}
 	pc.CouponCode = ""
-	if conditionsMatch(c, pc) {
+	if conditionsMatch(models.PriceSet{Conditions: c}, pc) {
 		t.Error("coupon set should not match empty coupon")
 	}
 	pc.CouponCode = "OTHER"
-	if conditionsMatch(c, pc) {
+	if conditionsMatch(models.PriceSet{Conditions: c}, pc) {
 		t.Error("coupon set should not match different coupon")
 	}
 }
 
+func TestConditionsMatchGeneratedCode(t *testing.T) {
+	set := models.PriceSet{ID: primitive.NewObjectID(), Conditions: models.PriceConditions{CouponCode: "SHARED", UniqueCodes: true}}
+	if !conditionsMatch(set, PricingContext{CouponCode: "INF-7KQ2", codeSetID: set.ID.Hex()}) {
+		t.Error("a generated code of the set should match")
+	}
+	if !conditionsMatch(set, PricingContext{CouponCode: "shared"}) {
+		t.Error("the shared code should still match")
+	}
+	if conditionsMatch(set, PricingContext{CouponCode: "INF-7KQ2", codeSetID: primitive.NewObjectID().Hex()}) {
+		t.Error("a code generated for another set should not match")
+	}
+	set.Conditions.UniqueCodes = false
+	if conditionsMatch(set, PricingContext{CouponCode: "INF-7KQ2", codeSetID: set.ID.Hex()}) {
+		t.Error("generated codes should not match a set without unique codes")
+	}
+}
+
+func TestExpandCouponPattern(t *testing.T) {
+	code, err := expandCouponPattern("inf-", "XXXX-##")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != len("INF-XXXX-##") || !strings.HasPrefix(code, "INF-") || code[8] != '-' {
+		t.Fatalf("unexpected code shape %q", code)
+	}
+	for _, r := range code[4:8] {
+		if !strings.ContainsRune(couponAlphabet, r) {
+			t.Errorf("%q: %q is not in the code alphabet", code, r)
+		}
+	}
+	for _, r := range code[9:] {
+		if r < '0' || r > '9' {
+			t.Errorf("%q: %q is not a digit", code, r)
+		}
+	}
+}
+
 func TestConditionsMatchThresholds(t *testing.T) {
 	c := models.PriceConditions{MinSubtotal: 500, MinQuantity: 3}
-	if conditionsMatch(c, PricingContext{CartSubtotal: 499, CartQuantity: 5}) {
+	if conditionsMatch(models.PriceSet{Conditions: c}, PricingContext{CartSubtotal: mxn(499), CartQuantity: 5}) {
 		t.Error("subtotal below minimum should not match")
 	}
-	if conditionsMatch(c, PricingContext{CartSubtotal: 600, CartQuantity: 2}) {
+	if conditionsMatch(models.PriceSet{Conditions: c}, PricingContext{CartSubtotal: mxn(600), CartQuantity: 2}) {
 		t.Error("quantity below minimum should not match")
 	}
-	if !conditionsMatch(c, PricingContext{CartSubtotal: 600, CartQuantity: 3}) {
+	if !conditionsMatch(models.PriceSet{Conditions: c}, PricingContext{CartSubtotal: mxn(600), CartQuantity: 3}) {
 		t.Error("thresholds satisfied should match")
 	}
 	// zero thresholds are no-ops
-	if !conditionsMatch(models.PriceConditions{}, PricingContext{}) {
+	if !conditionsMatch(models.PriceSet{Conditions: models.PriceConditions{}}, PricingContext{}) {
 		t.Error("empty conditions should match")
 	}
 }
 
 func TestConditionsMatchCustomer(t *testing.T) {
 	tier := models.PriceConditions{CustomerTier: "gold"}
-	if conditionsMatch(tier, PricingContext{CustomerTier: "silver"}) {
+	if conditionsMatch(models.PriceSet{Conditions: tier}, PricingContext{CustomerTier: "silver"}) {
 		t.Error("wrong tier should not match")
 	}
-	if !conditionsMatch(tier, PricingContext{CustomerTier: "gold"}) {
+	if !conditionsMatch(models.PriceSet{Conditions: tier}, PricingContext{CustomerTier: "gold"}) {
 		t.Error("matching tier should match")
 	}
 
 	ids := models.PriceConditions{CustomerIDs: []string{"a", "b"}}
-	if conditionsMatch(ids, PricingContext{CustomerID: "c"}) {
+	if conditionsMatch(models.PriceSet{Conditions: ids}, PricingContext{CustomerID: "c"}) {
 		t.Error("unknown customer should not match")
 	}
-	if conditionsMatch(ids, PricingContext{}) {
+	if conditionsMatch(models.PriceSet{Conditions: ids}, PricingContext{}) {
 		t.Error("empty customer id should not match when list set")
 	}
-	if !conditionsMatch(ids, PricingContext{CustomerID: "b"}) {
+	if !conditionsMatch(models.PriceSet{Conditions: ids}, PricingContext{CustomerID: "b"}) {
 		t.Error("listed customer should match")
 	}
 }
 
+func TestConditionsMatchCustomerHistory(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	lastYear := now.AddDate(0, 0, -120)
+	lastWeek := now.AddDate(0, 0, -7)
+	newcomer := &models.CustomerStats{LifetimeSpend: mxn(0)}
+	unpaid := &models.CustomerStats{LifetimeSpend: mxn(0), PendingOrders: 1}
+	regular := &models.CustomerStats{OrderCount: 4, LifetimeSpend: mxn(2500), LastOrderAt: &lastWeek}
+	lapsed := &models.CustomerStats{OrderCount: 2, LifetimeSpend: mxn(800), LastOrderAt: &lastYear}
+	vip := &models.CustomerStats{OrderCount: 30, LifetimeSpend: mxn(10000), LastOrderAt: &lastWeek}
+
+	cases := []struct {
+		name  string
+		cond  models.PriceConditions
+		stats *models.CustomerStats
+		want  bool
+	}{
+		{"welcome: first order", models.PriceConditions{FirstOrder: true}, newcomer, true},
+		{"welcome: returning customer", models.PriceConditions{FirstOrder: true}, regular, false},
+		{"welcome: order awaiting payment", models.PriceConditions{FirstOrder: true}, unpaid, false},
+		{"welcome: unknown history", models.PriceConditions{FirstOrder: true}, nil, false},
+		{"win-back: lapsed", models.PriceConditions{InactiveDays: 90}, lapsed, true},
+		{"win-back: recent order", models.PriceConditions{InactiveDays: 90}, regular, false},
+		{"win-back: never ordered", models.PriceConditions{InactiveDays: 90}, newcomer, false},
+		{"vip: spend reached", models.PriceConditions{MinLifetimeSpend: 10000}, vip, true},
+		{"vip: spend short", models.PriceConditions{MinLifetimeSpend: 10000}, regular, false},
+		{"order count in range", models.PriceConditions{MinOrders: 2, MaxOrders: 5}, regular, true},
+		{"order count above max", models.PriceConditions{MaxOrders: 5}, vip, false},
+		{"order count below min", models.PriceConditions{MinOrders: 3}, lapsed, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pc := PricingContext{Date: now, CustomerStats: tc.stats}
+			if got := conditionsMatch(models.PriceSet{Conditions: tc.cond}, pc); got != tc.want {
+				t.Errorf("conditionsMatch = %v, want %v (%s)", got, tc.want, conditionsReason(models.PriceSet{Conditions: tc.cond}, pc))
+			}
+		})
+	}
+}
+
 func TestSetInWindow(t *testing.T) {
 	now := time.Now()
 	start := now.Add(-time.Hour)
@@ -149,16 +226,16 @@ func TestApplySetRulesPercentage(t *testing.T) {
 			{Kind: models.RuleKindPercentage, Amount: 10, Scope: models.RuleScopeAll},
 		},
 	}
-	lines := []PricedLine{{ProductID: "p1", UnitPrice: 100, Quantity: 2, Subtotal: 200, LineTotal: 200}}
+	lines := []PricedLine{{ProductID: "p1", UnitPrice: mxn(100), Quantity: 2, Subtotal: mxn(200), LineTotal: mxn(200)}}
 	out := applySetRules(set, &lines)
 
-	if lines[0].UnitPrice != 90 {
+	if lines[0].UnitPrice != mxn(90) {
 		t.Errorf("expected unit 90, got %v", lines[0].UnitPrice)
 	}
-	if lines[0].LineTotal != 180 {
+	if lines[0].LineTotal != mxn(180) {
 		t.Errorf("expected line total 180, got %v", lines[0].LineTotal)
 	}
-	if len(out) != 1 || out[0].Discount != 20 {
+	if len(out) != 1 || out[0].Discount != mxn(20) {
 		t.Errorf("expected per-line applied discount 20 (10*2), got %+v", out)
 	}
 }
@@ -172,9 +249,9 @@ func TestApplySetRulesAbsoluteAndCap(t *testing.T) {
 			{Kind: models.RuleKindAbsolute, Amount: 50, MaxDiscount: 20, Scope: models.RuleScopeAll},
 		},
 	}
-	lines := []PricedLine{{ProductID: "p1", UnitPrice: 100, Quantity: 1, Subtotal: 100, LineTotal: 100}}
+	lines := []PricedLine{{ProductID: "p1", UnitPrice: mxn(100), Quantity: 1, Subtotal: mxn(100), LineTotal: mxn(100)}}
 	applySetRules(set, &lines)
-	if lines[0].UnitPrice != 80 {
+	if lines[0].UnitPrice != mxn(80) {
 		t.Errorf("expected unit 80 after cap, got %v", lines[0].UnitPrice)
 	}
 }
@@ -187,9 +264,9 @@ func TestApplySetRulesNeverNegative(t *testing.T) {
 			{Kind: models.RuleKindPercentage, Amount: 200, Scope: models.RuleScopeAll},
 		},
 	}
-	lines := []PricedLine{{ProductID: "p1", UnitPrice: 10, Quantity: 1, Subtotal: 10, LineTotal: 10}}
+	lines := []PricedLine{{ProductID: "p1", UnitPrice: mxn(10), Quantity: 1, Subtotal: mxn(10), LineTotal: mxn(10)}}
 	applySetRules(set, &lines)
-	if lines[0].UnitPrice != 0 {
+	if lines[0].UnitPrice != mxn(0) {
 		t.Errorf("expected unit clamped to 0, got %v", lines[0].UnitPrice)
 	}
 }
@@ -211,14 +288,14 @@ func TestApplySetsStopFurtherRules(t *testing.T) {
 		Conditions: models.PriceConditions{CustomerTier: "gold"},
 		Rules:      []models.PriceRule{{Kind: models.RuleKindAbsolute, Amount: 5, Scope: models.RuleScopeAll}},
 	}
-	lines := []PricedLine{{ProductID: pid, UnitPrice: 100, Quantity: 1, Subtotal: 100, LineTotal: 100}}
+	lines := []PricedLine{{ProductID: pid, UnitPrice: mxn(100), Quantity: 1, Subtotal: mxn(100), LineTotal: mxn(100)}}
 	pc := PricingContext{CustomerTier: "gold"}
 
-	applied, err := applySets([]models.PriceSet{second, first}, &lines, pc)
+	applied, _, err := applySets([]models.PriceSet{second, first}, &lines, pc)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if lines[0].UnitPrice != 90 {
+	if lines[0].UnitPrice != mxn(90) {
 		t.Errorf("expected unit 90 (second set skipped by stop), got %v", lines[0].UnitPrice)
 	}
 	if len(applied) != 1 {
@@ -234,38 +311,38 @@ func TestApplySetsOnlyWhenEligible(t *testing.T) {
 		Conditions: models.PriceConditions{CouponCode: "SAVE10"},
 		Rules:      []models.PriceRule{{Kind: models.RuleKindPercentage, Amount: 10, Scope: models.RuleScopeAll}},
 	}
-	lines := []PricedLine{{ProductID: "p1", UnitPrice: 100, Quantity: 1, Subtotal: 100, LineTotal: 100}}
+	lines := []PricedLine{{ProductID: "p1", UnitPrice: mxn(100), Quantity: 1, Subtotal: mxn(100), LineTotal: mxn(100)}}
 
 	// wrong coupon → no discount
 	applySets([]models.PriceSet{couponSet}, &lines, PricingContext{CouponCode: "WRONG"})
-	if lines[0].UnitPrice != 100 {
+	if lines[0].UnitPrice != mxn(100) {
 		t.Errorf("coupon set should not apply with wrong coupon, got %v", lines[0].UnitPrice)
 	}
 
 	// correct coupon → discount applies
 	applySets([]models.PriceSet{couponSet}, &lines, PricingContext{CouponCode: "save10"})
-	if lines[0].UnitPrice != 90 {
+	if lines[0].UnitPrice != mxn(90) {
 		t.Errorf("expected unit 90 with coupon, got %v", lines[0].UnitPrice)
 	}
 }
 
 func TestPriceLineSchedules(t *testing.T) {
-	// schedule math is DB-independent: test via fake active schedules is not
-	// possible without a PricingService, so cover the operators via direct math.
-	s := &models.PriceSchedule{Mode: models.ScheduleModePercentage, Value: 10}
-	p := 100.0
-	p *= 1 + s.Value/100
-	if absDiff(p, 110) > 1e-9 {
-		t.Errorf("expected ~110 after +10%% schedule, got %v", p)
-	}
-	s = &models.PriceSchedule{Mode: models.ScheduleModeAbsolute, Value: -5}
-	p = 100 + s.Value
-	if p != 95 {
-		t.Errorf("expected 95 after -5 absolute, got %v", p)
-	}
-	s = &models.PriceSchedule{Mode: models.ScheduleModeFixed, Value: 80}
-	if s.Value != 80 {
-		t.Errorf("expected fixed 80, got %v", s.Value)
+	cases := []struct {
+		sch  models.PriceSchedule
+		in   float64
+		want float64
+	}{
+		{models.PriceSchedule{Mode: models.ScheduleModePercentage, Value: 10}, 100, 110},
+		{models.PriceSchedule{Mode: models.ScheduleModeAbsolute, Value: -5}, 100, 95},
+		{models.PriceSchedule{Mode: models.ScheduleModeFixed, Value: 80}, 100, 80},
+		// 19.99 * 1.075 = 21.48925 → 21.49, half away from zero to the cent
+		{models.PriceSchedule{Mode: models.ScheduleModePercentage, Value: 7.5}, 19.99, 21.49},
+		{models.PriceSchedule{Mode: models.ScheduleModeAbsolute, Value: -50}, 10, 0},
+	}
+	for _, tc := range cases {
+		if got := applySchedule(tc.sch, mxn(tc.in)); got != mxn(tc.want) {
+			t.Errorf("%s %v on %v: expected %v, got %v", tc.sch.Mode, tc.sch.Value, tc.in, tc.want, got)
+		}
 	}
 }
 
@@ -284,6 +361,10 @@ func TestContainsStrAndVariantID(t *testing.T) {
 	}
 }
 
+func mxn(major float64) models.Money {
+	return models.NewMoney(major)
+}
+
 func absDiff(a, b float64) float64 {
 	if a > b {
 		return a - b
@@ -302,11 +383,11 @@ func TestOrderModelPricingValidate(t *testing.T) {
 	// Order.Validate must still accept a discount order (Total>0, Subtotal>0)
 	order := &models.Order{
 		UserID:   primitive.NewObjectID(),
-		Subtotal: 100,
-		Discount: 10,
-		Total:    90,
+		Subtotal: mxn(100),
+		Discount: mxn(10),
+		Total:    mxn(90),
 		Items: []models.OrderItem{
-			{ProductID: primitive.NewObjectID(), Quantity: 1, Price: 10},
+			{ProductID: primitive.NewObjectID(), Quantity: 1, Price: mxn(10)},
 		},
 	}
 	if err := order.Validate(); err != nil {
@@ -351,25 +432,54 @@ func TestApplySetsUsageCaps(t *testing.T) {
 		UsedCount: 5,
 		Rules:     []models.PriceRule{{Kind: models.RuleKindPercentage, Amount: 10, Scope: models.RuleScopeAll}},
 	}
-	lines := []PricedLine{{ProductID: "p1", UnitPrice: 100, Quantity: 1, Subtotal: 100, LineTotal: 100}}
-	applied, err := applySets([]models.PriceSet{exhausted}, &lines, PricingContext{})
+	lines := []PricedLine{{ProductID: "p1", UnitPrice: mxn(100), Quantity: 1, Subtotal: mxn(100), LineTotal: mxn(100)}}
+	applied, _, err := applySets([]models.PriceSet{exhausted}, &lines, PricingContext{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(applied) != 0 || lines[0].UnitPrice != 100 {
+	if len(applied) != 0 || lines[0].UnitPrice != mxn(100) {
 		t.Errorf("exhausted set should not apply, got applied=%d unit=%v", len(applied), lines[0].UnitPrice)
 	}
 
 	// within budget → applies
 	exhausted.UsedCount = 4
-	lines = []PricedLine{{ProductID: "p1", UnitPrice: 100, Quantity: 1, Subtotal: 100, LineTotal: 100}}
-	applied, err = applySets([]models.PriceSet{exhausted}, &lines, PricingContext{})
+	lines = []PricedLine{{ProductID: "p1", UnitPrice: mxn(100), Quantity: 1, Subtotal: mxn(100), LineTotal: mxn(100)}}
+	applied, _, err = applySets([]models.PriceSet{exhausted}, &lines, PricingContext{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(applied) != 1 || lines[0].UnitPrice != 90 {
+	if len(applied) != 1 || lines[0].UnitPrice != mxn(90) {
 		t.Errorf("set within budget should apply, got applied=%d unit=%v", len(applied), lines[0].UnitPrice)
 	}
+
+	// uses held by pending orders count against the cap
+	exhausted.ReservedCount = 1
+	lines = []PricedLine{{ProductID: "p1", UnitPrice: mxn(100), Quantity: 1, Subtotal: mxn(100), LineTotal: mxn(100)}}
+	applied, _, err = applySets([]models.PriceSet{exhausted}, &lines, PricingContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("set fully reserved should not apply, got applied=%d", len(applied))
+	}
+
+	// live usage read at resolve time wins over the set's own counters
+	lines = []PricedLine{{ProductID: "p1", UnitPrice: mxn(100), Quantity: 1, Subtotal: mxn(100), LineTotal: mxn(100)}}
+	live := PricingContext{usage: map[string]setUsage{exhausted.ID.Hex(): {used: 2}}}
+	applied, _, err = applySets([]models.PriceSet{exhausted}, &lines, live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 {
+		t.Errorf("set with live budget left should apply, got applied=%d", len(applied))
+	}
+
+	// only capped sets are checked live
+	uncapped := models.PriceSet{ID: primitive.NewObjectID(), Name: "uncapped", Rules: exhausted.Rules}
+	snap := compileSnapshot([]models.PriceSet{exhausted, uncapped}, nil, 0, time.Now())
+	if len(snap.capped) != 1 || snap.capped[0] != exhausted.ID {
+		t.Errorf("expected only the capped set to be checked live, got %v", snap.capped)
+	}
 }
 
 func TestApplySetsPerCustomerCap(t *testing.T) {
@@ -383,22 +493,311 @@ func TestApplySetsPerCustomerCap(t *testing.T) {
 		Rules:              []models.PriceRule{{Kind: models.RuleKindPercentage, Amount: 10, Scope: models.RuleScopeAll}},
 	}
 
-	lines := []PricedLine{{ProductID: "p1", UnitPrice: 100, Quantity: 1, Subtotal: 100, LineTotal: 100}}
-	applied, err := applySets([]models.PriceSet{set}, &lines, PricingContext{CustomerID: "cust-1"})
+	lines := []PricedLine{{ProductID: "p1", UnitPrice: mxn(100), Quantity: 1, Subtotal: mxn(100), LineTotal: mxn(100)}}
+	applied, _, err := applySets([]models.PriceSet{set}, &lines, PricingContext{CustomerID: "cust-1"})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(applied) != 0 || lines[0].UnitPrice != 100 {
+	if len(applied) != 0 || lines[0].UnitPrice != mxn(100) {
 		t.Errorf("set over per-customer cap should not apply, got applied=%d unit=%v", len(applied), lines[0].UnitPrice)
 	}
 
 	// different customer within budget → applies
-	lines = []PricedLine{{ProductID: "p1", UnitPrice: 100, Quantity: 1, Subtotal: 100, LineTotal: 100}}
-	applied, err = applySets([]models.PriceSet{set}, &lines, PricingContext{CustomerID: "cust-2"})
+	lines = []PricedLine{{ProductID: "p1", UnitPrice: mxn(100), Quantity: 1, Subtotal: mxn(100), LineTotal: mxn(100)}}
+	applied, _, err = applySets([]models.PriceSet{set}, &lines, PricingContext{CustomerID: "cust-2"})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(applied) != 1 || lines[0].UnitPrice != 90 {
+	if len(applied) != 1 || lines[0].UnitPrice != mxn(90) {
 		t.Errorf("set within per-customer budget should apply, got applied=%d unit=%v", len(applied), lines[0].UnitPrice)
 	}
 }
+
+func TestApplySetRulesBuyXGetY(t *testing.T) {
+	// 3x2 on yogurt across two lines: 4 units of the 20 flavour, 2 of the 15
+	// flavour. Sorted high→low the groups are [20,20,20] and [20,15,15], so
+	// one 20 and one 15 are free; nothing is left over.
+	set := models.PriceSet{
+		ID:   primitive.NewObjectID(),
+		Name: "3x2 yogurt",
+		Rules: []models.PriceRule{{
+			Kind: models.RuleKindBuyXGetY, Scope: models.RuleScopeCategory, ScopeRefs: []string{"yogurt"},
+			BuyQuantity: 2, GetQuantity: 1,
+		}},
+	}
+	lines := []PricedLine{
+		{ProductID: "strawberry", Category: "yogurt", UnitPrice: mxn(20), Quantity: 4, Subtotal: mxn(80), LineTotal: mxn(80)},
+		{ProductID: "plain", Category: "yogurt", UnitPrice: mxn(15), Quantity: 2, Subtotal: mxn(30), LineTotal: mxn(30)},
+		{ProductID: "bread", Category: "bakery", UnitPrice: mxn(40), Quantity: 1, Subtotal: mxn(40), LineTotal: mxn(40)},
+	}
+	out := applySetRules(set, &lines)
+
+	if len(out) != 1 || out[0].Discount != mxn(35) {
+		t.Fatalf("expected one application worth 35, got %+v", out)
+	}
+	if len(out[0].Lines) != 2 {
+		t.Errorf("expected both yogurt lines consumed, got %+v", out[0].Lines)
+	}
+	if lines[0].LineTotal != mxn(60) || lines[1].LineTotal != mxn(15) {
+		t.Errorf("expected line totals 60/15, got %v/%v", lines[0].LineTotal, lines[1].LineTotal)
+	}
+	if lines[2].LineTotal != mxn(40) || len(lines[2].AppliedSets) != 0 {
+		t.Error("out-of-scope line must not be touched")
+	}
+	if len(lines[0].AppliedSets) != 1 || lines[0].AppliedSets[0].Discount != mxn(20) {
+		t.Errorf("expected strawberry line to record its 20 share, got %+v", lines[0].AppliedSets)
+	}
+}
+
+func TestApplySetRulesBundle(t *testing.T) {
+	// shampoo + conditioner for 199; a second shampoo has no partner.
+	set := models.PriceSet{
+		ID:   primitive.NewObjectID(),
+		Name: "hair care bundle",
+		Rules: []models.PriceRule{{
+			Kind:   models.RuleKindBundle,
+			Amount: 199,
+			Components: []models.BundleComponent{
+				{Scope: models.RuleScopeSKU, ScopeRefs: []string{"SHAMPOO"}, Quantity: 1},
+				{Scope: models.RuleScopeSKU, ScopeRefs: []string{"COND"}, Quantity: 1},
+			},
+		}},
+	}
+	lines := []PricedLine{
+		{ProductID: "p1", SKU: "SHAMPOO", UnitPrice: mxn(150), Quantity: 2, Subtotal: mxn(300), LineTotal: mxn(300)},
+		{ProductID: "p2", SKU: "COND", UnitPrice: mxn(100), Quantity: 1, Subtotal: mxn(100), LineTotal: mxn(100)},
+	}
+	out := applySetRules(set, &lines)
+
+	if len(out) != 1 || out[0].Discount != mxn(51) {
+		t.Fatalf("expected one bundle worth 51, got %+v", out)
+	}
+	if got := lines[0].LineTotal.Add(lines[1].LineTotal); got != mxn(199+150) {
+		t.Errorf("expected bundle 199 plus one full-price shampoo, got %v", got)
+	}
+	if out[0].Lines[0].Quantity != 1 || out[0].Lines[1].Quantity != 1 {
+		t.Errorf("expected one unit consumed per line, got %+v", out[0].Lines)
+	}
+}
+
+func TestApplySetRulesBundleNotCheaper(t *testing.T) {
+	set := models.PriceSet{
+		ID: primitive.NewObjectID(),
+		Rules: []models.PriceRule{{
+			Kind: models.RuleKindBundle, Amount: 50, Scope: models.RuleScopeAll, BuyQuantity: 2,
+		}},
+	}
+	lines := []PricedLine{{ProductID: "p1", UnitPrice: mxn(20), Quantity: 2, Subtotal: mxn(40), LineTotal: mxn(40)}}
+	if out := applySetRules(set, &lines); len(out) != 0 || lines[0].LineTotal != mxn(40) {
+		t.Errorf("bundle dearer than its parts must not apply, got %+v", out)
+	}
+}
+
+func TestApplySetRulesCheapestFree(t *testing.T) {
+	set := models.PriceSet{
+		ID:    primitive.NewObjectID(),
+		Rules: []models.PriceRule{{Kind: models.RuleKindCheapestFree, Scope: models.RuleScopeAll, BuyQuantity: 3}},
+	}
+	lines := []PricedLine{
+		{ProductID: "a", UnitPrice: mxn(50), Quantity: 1, Subtotal: mxn(50), LineTotal: mxn(50)},
+		{ProductID: "b", UnitPrice: mxn(30), Quantity: 1, Subtotal: mxn(30), LineTotal: mxn(30)},
+		{ProductID: "c", UnitPrice: mxn(10), Quantity: 1, Subtotal: mxn(10), LineTotal: mxn(10)},
+	}
+	out := applySetRules(set, &lines)
+	if len(out) != 1 || out[0].Discount != mxn(10) || lines[2].LineTotal != mxn(0) {
+		t.Errorf("expected the 10 item free, got %+v", out)
+	}
+	if len(out[0].Lines) != 3 {
+		t.Errorf("expected all three lines consumed, got %d", len(out[0].Lines))
+	}
+}
+
+func TestMultiLineRulesDoNotReuseUnits(t *testing.T) {
+	// Two sets both want the same three units; the second finds them claimed.
+	rule := models.PriceRule{Kind: models.RuleKindCheapestFree, Scope: models.RuleScopeAll, BuyQuantity: 3}
+	first := models.PriceSet{ID: primitive.NewObjectID(), Priority: 1, Rules: []models.PriceRule{rule}}
+	second := models.PriceSet{ID: primitive.NewObjectID(), Priority: 2, Rules: []models.PriceRule{rule}}
+	lines := []PricedLine{{ProductID: "a", UnitPrice: mxn(10), Quantity: 3, Subtotal: mxn(30), LineTotal: mxn(30)}}
+
+	applied, _, err := applySets([]models.PriceSet{second, first}, &lines, PricingContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0].SetID != first.ID.Hex() || lines[0].LineTotal != mxn(20) {
+		t.Errorf("expected only the first set to claim the units, got %+v", applied)
+	}
+}
+
+func TestApplySetRulesTiered(t *testing.T) {
+	set := models.PriceSet{
+		ID:   primitive.NewObjectID(),
+		Name: "wholesale breaks",
+		Rules: []models.PriceRule{{
+			Kind: models.RuleKindTiered, Scope: models.RuleScopeProduct, ScopeRefs: []string{"p1"},
+			Tiers: []models.PriceTier{
+				{MinQuantity: 48, UnitPrice: 16},
+				{MinQuantity: 1, MaxQuantity: 11, UnitPrice: 20},
+				{MinQuantity: 12, MaxQuantity: 47, UnitPrice: 18},
+			},
+		}},
+	}
+
+	cases := []struct {
+		qty  int
+		want float64
+	}{{1, 20}, {11, 20}, {12, 18}, {47, 18}, {48, 16}, {500, 16}}
+	for _, tc := range cases {
+		lines := []PricedLine{{ProductID: "p1", UnitPrice: mxn(20), Quantity: tc.qty}}
+		lines[0].Subtotal = mxn(20).Mul(tc.qty)
+		lines[0].LineTotal = lines[0].Subtotal
+		applySetRules(set, &lines)
+		if lines[0].UnitPrice != mxn(tc.want) {
+			t.Errorf("qty %d: expected unit %v, got %v", tc.qty, tc.want, lines[0].UnitPrice)
+		}
+		if len(lines[0].Tiers) != 3 || lines[0].Tiers[0].MinQuantity != 1 {
+			t.Errorf("qty %d: expected the sorted tier table on the line, got %+v", tc.qty, lines[0].Tiers)
+		}
+		if lines[0].ActiveTier == nil || lines[0].ActiveTier.UnitPrice != tc.want {
+			t.Errorf("qty %d: expected active tier at %v, got %+v", tc.qty, tc.want, lines[0].ActiveTier)
+		}
+	}
+}
+
+func TestApplySetRulesTierAboveCurrentPrice(t *testing.T) {
+	// A tier (or override) above the already-discounted price is ignored
+	// rather than treated as a 100% discount.
+	set := models.PriceSet{
+		ID: primitive.NewObjectID(),
+		Rules: []models.PriceRule{{
+			Kind: models.RuleKindTiered, Scope: models.RuleScopeAll,
+			Tiers: []models.PriceTier{{MinQuantity: 1, UnitPrice: 25}},
+		}},
+	}
+	lines := []PricedLine{{ProductID: "p1", UnitPrice: mxn(20), Quantity: 2, Subtotal: mxn(40), LineTotal: mxn(40)}}
+	if out := applySetRules(set, &lines); len(out) != 0 || lines[0].UnitPrice != mxn(20) {
+		t.Errorf("tier above current price must not apply, got unit %v", lines[0].UnitPrice)
+	}
+}
+
+func TestProductPriceFor(t *testing.T) {
+	p := &Product{
+		BasePrice: 80,
+		Variants: []Variant{
+			{VariantID: "250g"},
+			{VariantID: "1kg", PriceAdjustment: 170},
+		},
+	}
+	if got := p.PriceFor(nil); got != 80 {
+		t.Errorf("no variant: expected 80, got %v", got)
+	}
+	if got := p.PriceFor(p.FindVariant("1kg")); got != 250 {
+		t.Errorf("1kg variant: expected 250, got %v", got)
+	}
+	if p.FindVariant("") != nil || p.FindVariant("missing") != nil {
+		t.Error("FindVariant should return nil for empty or unknown ids")
+	}
+}
+
+func TestApplySetsUnitPricesOnly(t *testing.T) {
+	// Catalog pricing must not pair unrelated listing products into a
+	// cheapest-free promotion, but per-unit rules in the same set still apply.
+	set := models.PriceSet{
+		ID:   primitive.NewObjectID(),
+		Name: "2x1 + 10%",
+		Rules: []models.PriceRule{
+			{Kind: models.RuleKindCheapestFree, Scope: models.RuleScopeAll, BuyQuantity: 2},
+			{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 10, Priority: 1},
+		},
+	}
+	lines := []PricedLine{
+		{ProductID: "a", UnitPrice: mxn(50), Quantity: 1, Subtotal: mxn(50), LineTotal: mxn(50)},
+		{ProductID: "b", UnitPrice: mxn(30), Quantity: 1, Subtotal: mxn(30), LineTotal: mxn(30)},
+	}
+	if _, _, err := applySets([]models.PriceSet{set}, &lines, PricingContext{UnitPricesOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	if lines[0].UnitPrice != mxn(45) || lines[1].UnitPrice != mxn(27) {
+		t.Errorf("expected only the percentage rule, got %v/%v", lines[0].UnitPrice, lines[1].UnitPrice)
+	}
+}
+
+func TestApplySetsStackingGroups(t *testing.T) {
+	pct := func(amount float64) []models.PriceRule {
+		return []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: amount}}
+	}
+	abs := func(amount float64) []models.PriceRule {
+		return []models.PriceRule{{Kind: models.RuleKindAbsolute, Scope: models.RuleScopeAll, Amount: amount}}
+	}
+	coupon := func(name string, priority int, policy models.StackingPolicy, rules []models.PriceRule) models.PriceSet {
+		return models.PriceSet{ID: primitive.NewObjectID(), Name: name, Priority: priority,
+			StackingGroup: "coupons", StackingPolicy: policy, Rules: rules}
+	}
+	tier := models.PriceSet{ID: primitive.NewObjectID(), Name: "gold tier", Priority: 10, Rules: pct(5)}
+	needsCode := coupon("needs code", 1, models.StackingFirstMatch, pct(50))
+	needsCode.Conditions.CouponCode = "HALF"
+	newLines := func() []PricedLine {
+		return []PricedLine{{ProductID: "p1", UnitPrice: mxn(100), Quantity: 2, Subtotal: mxn(200), LineTotal: mxn(200)}}
+	}
+
+	cases := []struct {
+		name    string
+		sets    []models.PriceSet
+		total   float64
+		chosen  string
+		applied []string
+	}{
+		{
+			name:    "best of picks the biggest discount and still stacks with tier pricing",
+			sets:    []models.PriceSet{coupon("10%", 1, models.StackingBestOf, pct(10)), coupon("15 off", 2, "", abs(15)), coupon("5%", 3, "", pct(5)), tier},
+			total:   161.5, // 200 - 2*15 = 170, then 5% tier
+			chosen:  "15 off",
+			applied: []string{"15 off", "gold tier"},
+		},
+		{
+			name:    "first match keeps the highest-priority set that applies",
+			sets:    []models.PriceSet{needsCode, coupon("10%", 2, "", pct(10)), coupon("15 off", 3, "", abs(15)), tier},
+			total:   171, // 10% → 180, then 5% tier
+			chosen:  "10%",
+			applied: []string{"10%", "gold tier"},
+		},
+		{
+			name:    "additive groups apply every set",
+			sets:    []models.PriceSet{coupon("10%", 1, models.StackingAdditive, pct(10)), coupon("15 off", 2, "", abs(15))},
+			total:   150,
+			applied: []string{"10%", "15 off"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			lines := newLines()
+			applied, decisions, err := applySets(tc.sets, &lines, PricingContext{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lines[0].LineTotal != mxn(tc.total) {
+				t.Errorf("expected total %v, got %v", tc.total, lines[0].LineTotal)
+			}
+			var names []string
+			for _, a := range applied {
+				names = append(names, a.SetName)
+			}
+			if strings.Join(names, ",") != strings.Join(tc.applied, ",") {
+				t.Errorf("expected applied %v, got %v", tc.applied, names)
+			}
+			if tc.chosen == "" {
+				if len(decisions) != 0 {
+					t.Errorf("additive groups record no decision, got %+v", decisions)
+				}
+				return
+			}
+			if len(decisions) != 1 || decisions[0].ChosenName != tc.chosen || len(decisions[0].Rejected) != 2 {
+				t.Fatalf("expected %q chosen over two alternatives, got %+v", tc.chosen, decisions)
+			}
+			for _, r := range decisions[0].Rejected {
+				if r.Reason == "" {
+					t.Errorf("rejected alternative %q has no reason", r.Name)
+				}
+			}
+		})
+	}
+}
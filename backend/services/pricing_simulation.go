@@ -0,0 +1,379 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"mercadomio-backend/models"
+)
+
+const (
+	defaultSimulationOrders   = 200
+	maxSimulationOrders       = 1000
+	defaultSimulationLookback = 30 // days of sales weighting a catalog simulation
+)
+
+// simulationCouponCode stands in for a generated code when the draft set is
+// redeemed with unique codes.
+const simulationCouponCode = "SIMULATION"
+
+// SimulationRequest asks what one unsaved set or schedule would do. A draft
+// carrying the id of an existing one replaces it, so edits can be simulated
+// too.
+type SimulationRequest struct {
+	Set          *models.PriceSet
+	Schedule     *models.PriceSchedule
+	Date         time.Time // defaults to now
+	Mode         string    // models.SimulateCatalog (default) or models.SimulateOrders
+	Orders       int       // orders mode: how many recent orders to reprice
+	LookbackDays int       // catalog mode: days of sales that weight revenue
+	CustomerTier string    // catalog mode: the tier to price for
+}
+
+// simCart is one resolve of a simulation: a cart, its context, and how many
+// times it counts towards revenue.
+type simCart struct {
+	inputs []PriceInput
+	pc     PricingContext
+	weight int
+}
+
+// Simulate prices the catalog, or a sample of recent orders, twice through
+// the engine: with the rules active now and with the draft added. Coupon
+// gated drafts are assumed redeemed (an order keeps its own coupon if it had
+// one); other conditions are checked as usual, so a draft for a tier only
+// shows up when simulating that tier.
+func (s *PricingService) Simulate(ctx context.Context, req SimulationRequest) (*models.PricingSimulation, error) {
+	if (req.Set == nil) == (req.Schedule == nil) {
+		return nil, errors.New("simulate exactly one price set or price schedule")
+	}
+	if req.Date.IsZero() {
+		req.Date = time.Now()
+	}
+	if req.Mode == "" {
+		req.Mode = models.SimulateCatalog
+	}
+	draftCoupon := ""
+	var codeSetID string
+	if set := req.Set; set != nil {
+		if err := validateRecurrence(set.Recurrence); err != nil {
+			return nil, err
+		}
+		if set.ID.IsZero() {
+			set.ID = primitive.NewObjectID()
+		}
+		draftCoupon = set.Conditions.CouponCode
+		if set.Conditions.UniqueCodes {
+			codeSetID = set.ID.Hex()
+			if draftCoupon == "" {
+				draftCoupon = simulationCouponCode
+			}
+		}
+	} else if err := validateRecurrence(req.Schedule.Recurrence); err != nil {
+		return nil, err
+	}
+
+	current, err := s.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	draft := current.withDraft(req.Set, req.Schedule, time.Now())
+
+	var carts []simCart
+	evaluated := 0
+	switch req.Mode {
+	case models.SimulateCatalog:
+		carts, err = s.catalogCarts(ctx, req)
+		evaluated = len(carts)
+	case models.SimulateOrders:
+		carts, evaluated, err = s.orderCarts(ctx, req)
+	default:
+		return nil, errors.New("unknown simulation mode " + req.Mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range carts {
+		if carts[i].pc.CouponCode == "" {
+			carts[i].pc.CouponCode = draftCoupon
+			carts[i].pc.codeSetID = codeSetID
+		}
+	}
+
+	sim := simulate(current, draft, carts)
+	sim.Mode = req.Mode
+	sim.Date = req.Date
+	sim.Evaluated = evaluated
+	return sim, nil
+}
+
+// withDraft returns a snapshot of the same rules plus the draft, replacing a
+// set or schedule with the same id.
+func (snap *pricingSnapshot) withDraft(set *models.PriceSet, sch *models.PriceSchedule, now time.Time) *pricingSnapshot {
+	sets, schedules := snap.sets, snap.schedules
+	if set != nil {
+		d := *set
+		d.Active = true
+		sets = make([]models.PriceSet, 0, len(snap.sets)+1)
+		for _, s := range snap.sets {
+			if s.ID != d.ID {
+				sets = append(sets, s)
+			}
+		}
+		sets = append(sets, d)
+	}
+	if sch != nil {
+		d := *sch
+		d.Active = true
+		if d.ID.IsZero() {
+			d.ID = primitive.NewObjectID()
+		}
+		schedules = make([]models.PriceSchedule, 0, len(snap.schedules)+1)
+		for _, s := range snap.schedules {
+			if s.ID != d.ID {
+				schedules = append(schedules, s)
+			}
+		}
+		schedules = append(schedules, d)
+	}
+	out := compileSnapshot(sets, schedules, -1, now)
+	out.categories = snap.categories
+	out.rounding = snap.rounding
+	return out
+}
+
+// catalogCarts prices every product and variant on its own, one unit, and
+// weights it by the units sold over the lookback window.
+func (s *PricingService) catalogCarts(ctx context.Context, req SimulationRequest) ([]simCart, error) {
+	days := req.LookbackDays
+	if days <= 0 {
+		days = defaultSimulationLookback
+	}
+	sold, err := s.unitsSold(ctx, req.Date.AddDate(0, 0, -days), req.Date)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := s.db.Collection("products").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var products []Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+
+	pc := PricingContext{Date: req.Date, CustomerTier: req.CustomerTier, UnitPricesOnly: true}
+	var carts []simCart
+	for pi := range products {
+		p := &products[pi]
+		for _, v := range variantsOf(p) {
+			key := p.ID.Hex()
+			if v != nil {
+				key += "/" + v.VariantID
+			}
+			carts = append(carts, simCart{
+				inputs: []PriceInput{{Product: p, Variant: v, Quantity: 1}},
+				pc:     pc,
+				weight: sold[key],
+			})
+		}
+	}
+	return carts, nil
+}
+
+// unitsSold sums the units of paid orders placed in [from, to), keyed by
+// product id, or product id/variant id.
+func (s *PricingService) unitsSold(ctx context.Context, from, to time.Time) (map[string]int, error) {
+	cursor, err := s.db.Collection("orders").Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{
+			"status":    bson.M{"$in": paidOrderStatuses()},
+			"createdAt": bson.M{"$gte": from, "$lt": to},
+		}},
+		bson.M{"$unwind": "$items"},
+		bson.M{"$group": bson.M{
+			"_id":   bson.M{"productId": "$items.productId", "variantId": "$items.variantId"},
+			"units": bson.M{"$sum": "$items.quantity"},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID struct {
+			ProductID primitive.ObjectID `bson:"productId"`
+			VariantID string             `bson:"variantId"`
+		} `bson:"_id"`
+		Units int `bson:"units"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	sold := make(map[string]int, len(rows))
+	for _, r := range rows {
+		key := r.ID.ProductID.Hex()
+		if r.ID.VariantID != "" {
+			key += "/" + r.ID.VariantID
+		}
+		sold[key] += r.Units
+	}
+	return sold, nil
+}
+
+// orderCarts rebuilds the carts of the most recent paid orders before the
+// simulation date, in each order's own context. Items whose product is gone
+// are dropped; it also returns how many orders were used.
+func (s *PricingService) orderCarts(ctx context.Context, req SimulationRequest) ([]simCart, int, error) {
+	n := req.Orders
+	if n <= 0 {
+		n = defaultSimulationOrders
+	}
+	n = min(n, maxSimulationOrders)
+	cursor, err := s.db.Collection("orders").Find(ctx,
+		bson.M{"status": bson.M{"$in": paidOrderStatuses()}, "createdAt": bson.M{"$lt": req.Date}},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(int64(n)))
+	if err != nil {
+		return nil, 0, err
+	}
+	var orders []models.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, 0, err
+	}
+
+	products := map[primitive.ObjectID]*Product{}
+	var carts []simCart
+	for _, o := range orders {
+		var inputs []PriceInput
+		for _, item := range o.Items {
+			p, ok := products[item.ProductID]
+			if !ok {
+				var loaded Product
+				if err := s.db.Collection("products").FindOne(ctx, bson.M{"_id": item.ProductID}).Decode(&loaded); err == nil {
+					p = &loaded
+				}
+				products[item.ProductID] = p
+			}
+			if p == nil || item.Quantity <= 0 {
+				continue
+			}
+			inputs = append(inputs, PriceInput{Product: p, Variant: p.FindVariant(item.VariantID), Quantity: item.Quantity})
+		}
+		if len(inputs) == 0 {
+			continue
+		}
+		pc := PricingContext{Date: req.Date, CustomerID: o.UserID.Hex()}
+		pc.CouponCode, _ = o.Pricing["couponCode"].(string)
+		pc.CustomerTier, _ = o.Pricing["customerTier"].(string)
+		if pc.CouponCode != "" {
+			pc.codeSetID = s.couponCodeSet(ctx, pc.CouponCode)
+		}
+		carts = append(carts, simCart{inputs: inputs, pc: pc, weight: 1})
+	}
+	return carts, len(carts), nil
+}
+
+func paidOrderStatuses() bson.A {
+	return bson.A{models.OrderStatusPaid, models.OrderStatusShipped, models.OrderStatusCompleted}
+}
+
+// simRow accumulates one product's observed unit prices.
+type simRow struct {
+	product  models.SimulatedProduct
+	current  int64 // sum of unit prices, minor units
+	draft    int64
+	observed int
+}
+
+// simulate resolves every cart against both snapshots and summarises the
+// difference. Carts the engine rejects are skipped.
+func simulate(current, draft *pricingSnapshot, carts []simCart) *models.PricingSimulation {
+	sim := &models.PricingSimulation{
+		Affected:        []models.SimulatedProduct{},
+		AverageDiscount: models.MoneyFromMinor(0),
+		MaxDiscount:     models.MoneyFromMinor(0),
+		CurrentRevenue:  models.MoneyFromMinor(0),
+		DraftRevenue:    models.MoneyFromMinor(0),
+	}
+	rows := map[string]*simRow{}
+	var order []string
+	for _, cart := range carts {
+		before, err := current.resolve(cart.inputs, cart.pc)
+		if err != nil {
+			continue
+		}
+		after, err := draft.resolve(cart.inputs, cart.pc)
+		if err != nil {
+			continue
+		}
+		for i, in := range cart.inputs {
+			cur, drf := before.Lines[i], after.Lines[i]
+			sim.CurrentRevenue = sim.CurrentRevenue.Add(cur.LineTotal.Mul(cart.weight))
+			sim.DraftRevenue = sim.DraftRevenue.Add(drf.LineTotal.Mul(cart.weight))
+
+			key := cur.ProductID + "/" + cur.VariantID
+			row, ok := rows[key]
+			if !ok {
+				row = &simRow{product: models.SimulatedProduct{
+					ProductID: cur.ProductID,
+					VariantID: cur.VariantID,
+					Name:      in.Product.Name,
+					SKU:       in.Product.SKU,
+				}}
+				if in.Variant != nil && in.Variant.SKU != "" {
+					row.product.SKU = in.Variant.SKU
+				}
+				rows[key] = row
+				order = append(order, key)
+			}
+			row.current += cur.LineTotal.Div(in.Quantity).Amount
+			row.draft += drf.LineTotal.Div(in.Quantity).Amount
+			row.observed++
+			row.product.Units += in.Quantity * cart.weight
+		}
+	}
+	sim.RevenueImpact = sim.DraftRevenue.Sub(sim.CurrentRevenue)
+
+	var discountSum int64
+	var percentSum float64
+	for _, key := range order {
+		row := rows[key]
+		p := row.product
+		p.CurrentPrice = models.MoneyFromMinor(row.current).Div(row.observed)
+		p.DraftPrice = models.MoneyFromMinor(row.draft).Div(row.observed)
+		if p.CurrentPrice == p.DraftPrice {
+			continue
+		}
+		p.Discount = p.CurrentPrice.Sub(p.DraftPrice)
+		if p.CurrentPrice.Amount != 0 {
+			p.DiscountPercent = roundPercent(float64(p.Discount.Amount) * 100 / float64(p.CurrentPrice.Amount))
+		}
+		sim.Affected = append(sim.Affected, p)
+		discountSum += p.Discount.Amount
+		percentSum += p.DiscountPercent
+		if len(sim.Affected) == 1 || p.Discount.Amount > sim.MaxDiscount.Amount {
+			sim.MaxDiscount = p.Discount
+		}
+		if len(sim.Affected) == 1 || p.DiscountPercent > sim.MaxDiscountPercent {
+			sim.MaxDiscountPercent = p.DiscountPercent
+		}
+	}
+	if n := len(sim.Affected); n > 0 {
+		sim.AverageDiscount = models.MoneyFromMinor(discountSum).Div(n)
+		sim.AverageDiscountPercent = roundPercent(percentSum / float64(n))
+	}
+	sort.SliceStable(sim.Affected, func(i, j int) bool {
+		return sim.Affected[i].Discount.Amount > sim.Affected[j].Discount.Amount
+	})
+	return sim
+}
+
+// roundPercent keeps two decimals.
+func roundPercent(p float64) float64 {
+	return math.Round(p*100) / 100
+}
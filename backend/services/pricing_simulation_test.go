@@ -0,0 +1,81 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+)
+
+func TestSimulateDraftSet(t *testing.T) {
+	now := time.Now()
+	running := models.PriceSet{ID: primitive.NewObjectID(), Name: "5% todo", Active: true,
+		Rules: []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 5}}}
+	current := compileSnapshot([]models.PriceSet{running}, nil, 0, now)
+
+	milk := &Product{ID: primitive.NewObjectID(), Name: "Leche", SKU: "LECHE", BasePrice: 100, Category: "Lácteos"}
+	cheese := &Product{ID: primitive.NewObjectID(), Name: "Queso", SKU: "QUESO", BasePrice: 200, Category: "Lácteos"}
+	soap := &Product{ID: primitive.NewObjectID(), Name: "Jabón", SKU: "JABON", BasePrice: 50}
+
+	// The draft takes 20% off dairy, stacking on the running 5%.
+	dairy := models.PriceSet{ID: primitive.NewObjectID(), Name: "lácteos 20", Priority: 1,
+		Rules: []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeCategory, ScopeRefs: []string{"Lácteos"}, Amount: 20}}}
+	draft := current.withDraft(&dairy, nil, now)
+	if len(draft.sets) != 2 || len(current.sets) != 1 {
+		t.Fatalf("the draft must be added to a copy, got %d / %d sets", len(draft.sets), len(current.sets))
+	}
+
+	pc := PricingContext{Date: now, UnitPricesOnly: true}
+	carts := []simCart{
+		{inputs: []PriceInput{{Product: milk, Quantity: 1}}, pc: pc, weight: 10},
+		{inputs: []PriceInput{{Product: cheese, Quantity: 1}}, pc: pc, weight: 0},
+		{inputs: []PriceInput{{Product: soap, Quantity: 1}}, pc: pc, weight: 4},
+	}
+	sim := simulate(current, draft, carts)
+
+	if len(sim.Affected) != 2 || sim.Affected[0].SKU != "QUESO" || sim.Affected[1].SKU != "LECHE" {
+		t.Fatalf("expected cheese then milk affected, got %+v", sim.Affected)
+	}
+	// Milk: 95 now; 100 -20% -5% = 76 with the draft.
+	m := sim.Affected[1]
+	if m.CurrentPrice != mxn(95) || m.DraftPrice != mxn(76) || m.Discount != mxn(19) || m.DiscountPercent != 20 || m.Units != 10 {
+		t.Errorf("unexpected milk row %+v", m)
+	}
+	if sim.MaxDiscount != mxn(38) || sim.AverageDiscount != mxn(28.50) || sim.AverageDiscountPercent != 20 {
+		t.Errorf("unexpected summary: max %s avg %s (%v%%)", sim.MaxDiscount, sim.AverageDiscount, sim.AverageDiscountPercent)
+	}
+	// Revenue is weighted by units sold: 10 milks and 4 soaps, no cheese.
+	if sim.CurrentRevenue != mxn(950+190) || sim.DraftRevenue != mxn(760+190) || sim.RevenueImpact != mxn(-190) {
+		t.Errorf("unexpected revenue %s -> %s (%s)", sim.CurrentRevenue, sim.DraftRevenue, sim.RevenueImpact)
+	}
+}
+
+func TestSimulateDraftReplacesExisting(t *testing.T) {
+	now := time.Now()
+	sale := models.PriceSchedule{ID: primitive.NewObjectID(), Name: "rebaja", Scope: models.ScheduleScopeGlobal,
+		Mode: models.ScheduleModePercentage, Value: -10, EffectiveFrom: now.Add(-time.Hour), Active: true}
+	current := compileSnapshot(nil, []models.PriceSchedule{sale}, 0, now)
+
+	edited := sale
+	edited.Value = -25
+	draft := current.withDraft(nil, &edited, now)
+	if len(draft.schedules) != 1 {
+		t.Fatalf("an edit must replace the schedule, got %d", len(draft.schedules))
+	}
+
+	p := &Product{ID: primitive.NewObjectID(), BasePrice: 80}
+	// Two past orders of 3 units each.
+	carts := []simCart{
+		{inputs: []PriceInput{{Product: p, Quantity: 3}}, pc: PricingContext{Date: now}, weight: 1},
+		{inputs: []PriceInput{{Product: p, Quantity: 3}}, pc: PricingContext{Date: now}, weight: 1},
+	}
+	sim := simulate(current, draft, carts)
+	if len(sim.Affected) != 1 || sim.Affected[0].Units != 6 || sim.Affected[0].CurrentPrice != mxn(72) || sim.Affected[0].DraftPrice != mxn(60) {
+		t.Fatalf("unexpected rows %+v", sim.Affected)
+	}
+	if sim.RevenueImpact != mxn(-72) {
+		t.Errorf("expected 6 units x 12 less = -72, got %s", sim.RevenueImpact)
+	}
+}
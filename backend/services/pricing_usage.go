@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"mercadomio-backend/models"
+)
+
+// ErrSetUsageExhausted is returned by ReserveSetUsage when a price set has no
+// uses left (globally or for the customer) by the time the order is placed.
+var ErrSetUsageExhausted = errors.New("price set usage cap reached")
+
+// capAvailable builds an aggregation expression that is true when a capped
+// counter pair still has room: cap <= 0 (uncapped) or used+reserved < cap.
+func capAvailable(capField, usedField, reservedField string) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"$lte": bson.A{bson.M{"$ifNull": bson.A{"$" + capField, 0}}, 0}},
+		bson.M{"$lt": bson.A{
+			bson.M{"$add": bson.A{
+				bson.M{"$ifNull": bson.A{"$" + usedField, 0}},
+				bson.M{"$ifNull": bson.A{"$" + reservedField, 0}},
+			}},
+			"$" + capField,
+		}},
+	}}
+}
+
+// ReserveSetUsage holds one use of each set for an order that has not been
+// paid yet. Each hold is a single conditional update, so concurrent orders
+// cannot push a set past MaxUses or MaxUsesPerCustomer. A first-order set
+// also claims the customer's first order, so two orders placed at once cannot
+// both take it. If any set is exhausted the holds already taken for the order
+// are released and ErrSetUsageExhausted is returned.
+func (s *PricingService) ReserveSetUsage(ctx context.Context, orderID string, setIDs []string, customerID string, expiresAt time.Time) error {
+	for _, id := range setIDs {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			continue
+		}
+
+		conds := bson.A{capAvailable("maxUses", "usedCount", "reservedCount")}
+		inc := bson.M{"reservedCount": 1}
+		if customerID != "" {
+			conds = append(conds, capAvailable("maxUsesPerCustomer", "customerUsage."+customerID, "customerReserved."+customerID))
+			inc["customerReserved."+customerID] = 1
+		}
+		var set models.PriceSet
+		err = s.sets.FindOneAndUpdate(ctx,
+			bson.M{"_id": objID, "$expr": bson.M{"$and": conds}},
+			bson.M{"$inc": inc},
+			options.FindOneAndUpdate().SetProjection(bson.M{"conditions.firstOrder": 1})).Decode(&set)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			err = ErrSetUsageExhausted
+		}
+		if err != nil {
+			s.ReleaseSetUsage(ctx, orderID)
+			return err
+		}
+
+		now := time.Now()
+		_, err = s.reservations.InsertOne(ctx, models.PriceSetReservation{
+			OrderID:    orderID,
+			SetID:      id,
+			CustomerID: customerID,
+			Status:     models.ReservationHeld,
+			ExpiresAt:  expiresAt,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		})
+		if err != nil {
+			s.moveSetUsage(ctx, objID, customerID, false)
+			s.ReleaseSetUsage(ctx, orderID)
+			return err
+		}
+
+		if set.Conditions.FirstOrder && customerID != "" {
+			if err := s.claimFirstOrder(ctx, orderID, customerID); err != nil {
+				s.ReleaseSetUsage(ctx, orderID)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// claimFirstOrder takes the customer's first-order claim for the order. A
+// claim held by another order, or spent by a paid one, leaves
+// ErrSetUsageExhausted; the order's own claim is kept.
+func (s *PricingService) claimFirstOrder(ctx context.Context, orderID, customerID string) error {
+	now := time.Now()
+	_, err := s.firstOrders.UpdateOne(ctx,
+		bson.M{"_id": customerID, "$or": bson.A{
+			bson.M{"status": models.ReservationReleased},
+			bson.M{"orderId": orderID},
+		}},
+		bson.M{
+			"$set":         bson.M{"orderId": orderID, "status": models.ReservationHeld, "updatedAt": now},
+			"$setOnInsert": bson.M{"createdAt": now},
+		},
+		options.Update().SetUpsert(true))
+	// The filter misses a claim that is not ours to take, so the upsert
+	// collides with its _id.
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrSetUsageExhausted
+	}
+	return err
+}
+
+// settleFirstOrderClaim moves the order's held first-order claim to status.
+func (s *PricingService) settleFirstOrderClaim(ctx context.Context, orderID, status string) error {
+	if s.firstOrders == nil {
+		return nil
+	}
+	_, err := s.firstOrders.UpdateOne(ctx,
+		bson.M{"orderId": orderID, "status": models.ReservationHeld},
+		bson.M{"$set": bson.M{"status": status, "updatedAt": time.Now()}})
+	return err
+}
+
+// ExtendSetUsage moves an order's held uses to expire at expiresAt.
+func (s *PricingService) ExtendSetUsage(ctx context.Context, orderID string, expiresAt time.Time) error {
+	_, err := s.reservations.UpdateMany(ctx,
+		bson.M{"orderId": orderID, "status": models.ReservationHeld},
+		bson.M{"$set": bson.M{"expiresAt": expiresAt, "updatedAt": time.Now()}})
+	return err
+}
+
+// CommitSetUsage turns an order's held uses into used ones once it is paid,
+// redeeming any generated coupon code it reserved.
+func (s *PricingService) CommitSetUsage(ctx context.Context, orderID string) error {
+	if err := s.settleSetUsage(ctx, orderID, models.ReservationCommitted); err != nil {
+		return err
+	}
+	if err := s.settleFirstOrderClaim(ctx, orderID, models.ReservationCommitted); err != nil {
+		return err
+	}
+	return s.settleCouponRedemptions(ctx, orderID, models.ReservationCommitted)
+}
+
+// ReleaseSetUsage gives back an order's held uses, and frees its coupon code,
+// when it is cancelled or its hold expires. Uses already committed by a
+// payment are kept.
+func (s *PricingService) ReleaseSetUsage(ctx context.Context, orderID string) error {
+	if err := s.settleSetUsage(ctx, orderID, models.ReservationReleased); err != nil {
+		return err
+	}
+	if err := s.settleFirstOrderClaim(ctx, orderID, models.ReservationReleased); err != nil {
+		return err
+	}
+	return s.settleCouponRedemptions(ctx, orderID, models.ReservationReleased)
+}
+
+// ExpiredSetReservations returns the IDs of orders holding set uses past
+// their expiry.
+func (s *PricingService) ExpiredSetReservations(ctx context.Context, now time.Time) ([]string, error) {
+	ids, err := s.reservations.Distinct(ctx, "orderId", bson.M{
+		"status":    models.ReservationHeld,
+		"expiresAt": bson.M{"$lte": now},
+	})
+	if err != nil {
+		return nil, err
+	}
+	orderIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if orderID, ok := id.(string); ok {
+			orderIDs = append(orderIDs, orderID)
+		}
+	}
+	return orderIDs, nil
+}
+
+// settleSetUsage moves each held reservation of an order to status. The
+// reservation is flipped first and the set's counters only change when that
+// flip succeeded, so a retried or concurrent settle never counts twice.
+func (s *PricingService) settleSetUsage(ctx context.Context, orderID, status string) error {
+	cursor, err := s.reservations.Find(ctx, bson.M{"orderId": orderID, "status": models.ReservationHeld})
+	if err != nil {
+		return err
+	}
+	var held []models.PriceSetReservation
+	if err := cursor.All(ctx, &held); err != nil {
+		return err
+	}
+
+	for _, r := range held {
+		res, err := s.reservations.UpdateOne(ctx,
+			bson.M{"_id": r.ID, "status": models.ReservationHeld},
+			bson.M{"$set": bson.M{"status": status, "updatedAt": time.Now()}})
+		if err != nil {
+			return err
+		}
+		if res.ModifiedCount == 0 {
+			continue
+		}
+		setID, err := primitive.ObjectIDFromHex(r.SetID)
+		if err != nil {
+			continue
+		}
+		if err := s.moveSetUsage(ctx, setID, r.CustomerID, status == models.ReservationCommitted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setUsage is a capped set's live usage: uses taken or held in total, and
+// by the customer being priced.
+type setUsage struct {
+	used         int
+	customerUsed int
+}
+
+// loadSetUsage reads the live counters of the given capped sets. Usage moves
+// with every order, so it is kept out of the snapshot (which would otherwise
+// be rebuilt on every checkout) and read per resolve instead, only for sets
+// that have a cap.
+func (s *PricingService) loadSetUsage(ctx context.Context, setIDs []primitive.ObjectID, customerID string) (map[string]setUsage, error) {
+	if len(setIDs) == 0 || s.sets == nil {
+		return nil, nil
+	}
+	projection := bson.M{"usedCount": 1, "reservedCount": 1}
+	if customerID != "" && !strings.ContainsAny(customerID, ".$") {
+		projection["customerUsage."+customerID] = 1
+		projection["customerReserved."+customerID] = 1
+	}
+	cursor, err := s.sets.Find(ctx, bson.M{"_id": bson.M{"$in": setIDs}}, options.Find().SetProjection(projection))
+	if err != nil {
+		return nil, err
+	}
+	var sets []models.PriceSet
+	if err := cursor.All(ctx, &sets); err != nil {
+		return nil, err
+	}
+	usage := make(map[string]setUsage, len(sets))
+	for _, set := range sets {
+		usage[set.ID.Hex()] = setUsage{
+			used:         set.UsedCount + set.ReservedCount,
+			customerUsed: set.CustomerUsage[customerID] + set.CustomerReserved[customerID],
+		}
+	}
+	return usage, nil
+}
+
+// moveSetUsage drops one held use from a set and, when commit is true, counts
+// it as used.
+func (s *PricingService) moveSetUsage(ctx context.Context, setID primitive.ObjectID, customerID string, commit bool) error {
+	inc := bson.M{"reservedCount": -1}
+	if commit {
+		inc["usedCount"] = 1
+	}
+	if customerID != "" {
+		inc["customerReserved."+customerID] = -1
+		if commit {
+			inc["customerUsage."+customerID] = 1
+		}
+	}
+	_, err := s.sets.UpdateOne(ctx, bson.M{"_id": setID}, bson.M{"$inc": inc})
+	return err
+}
@@ -0,0 +1,610 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"mercadomio-backend/models"
+)
+
+// ErrVersionConflict is returned when a draft is published after the live
+// set or schedule moved past the version the draft was written against.
+var ErrVersionConflict = errors.New("the live version changed since this draft was written; create a new draft")
+
+// Fields of a live price set that belong to its usage, not its definition:
+// publishing a version never touches them.
+var setUsageFields = []string{"usedCount", "customerUsage", "reservedCount", "customerReserved"}
+
+// Conditions the store manages rather than the admin: publishing a version
+// keeps their live value. uniqueCodes is switched on by GenerateCouponCodes.
+var setManagedConditions = []string{"uniqueCodes"}
+
+// Every field of a set's conditions. Versions write conditions field by
+// field, so the managed ones survive and the others a version leaves out are
+// cleared.
+var setConditionFields = []string{
+	"couponCode", "minSubtotal", "minQuantity", "customerTier", "customerIDs", "uniqueCodes",
+	"firstOrder", "minOrders", "maxOrders", "minLifetimeSpend", "inactiveDays",
+}
+
+// Optional (omitempty) definition fields, cleared when a version leaves them
+// out.
+var (
+	setOptionalFields      = []string{"description", "stackingGroup", "stackingPolicy", "maxUses", "maxUsesPerCustomer", "startsAt", "endsAt", "recurrence"}
+	scheduleOptionalFields = []string{"description", "scopeRefs", "exactCategory", "effectiveTo", "recurrence"}
+)
+
+// ---- Drafts, approval and version history ----
+
+func (s *PricingService) versions() *mongo.Collection {
+	return s.db.Collection("pricing_versions")
+}
+
+// CreatePriceSetDraft stores a new definition of a live price set as a draft.
+// It goes live when another admin approves it (ApproveVersion).
+func (s *PricingService) CreatePriceSetDraft(ctx context.Context, id string, def *models.PriceSet, note, author string) (*models.PricingVersion, error) {
+	if author == "" {
+		return nil, errors.New("drafts need an authenticated author")
+	}
+	if err := validateRecurrence(def.Recurrence); err != nil {
+		return nil, err
+	}
+	live, err := s.GetPriceSet(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	v := &models.PricingVersion{
+		Kind:        models.VersionKindSet,
+		TargetID:    live.ID.Hex(),
+		Action:      models.VersionActionUpdate,
+		BaseVersion: live.Version,
+		Set:         setDefinition(def, live.ID),
+		Note:        note,
+		CreatedBy:   author,
+	}
+	return v, s.insertDraft(ctx, v)
+}
+
+// CreatePriceScheduleDraft stores a new definition of a live price schedule
+// as a draft.
+func (s *PricingService) CreatePriceScheduleDraft(ctx context.Context, id string, def *models.PriceSchedule, note, author string) (*models.PricingVersion, error) {
+	if author == "" {
+		return nil, errors.New("drafts need an authenticated author")
+	}
+	if err := validateRecurrence(def.Recurrence); err != nil {
+		return nil, err
+	}
+	live, err := s.GetPriceSchedule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	v := &models.PricingVersion{
+		Kind:        models.VersionKindSchedule,
+		TargetID:    live.ID.Hex(),
+		Action:      models.VersionActionUpdate,
+		BaseVersion: live.Version,
+		Schedule:    scheduleDefinition(def, live.ID),
+		Note:        note,
+		CreatedBy:   author,
+	}
+	return v, s.insertDraft(ctx, v)
+}
+
+// CreateNewPriceSetDraft stores a price set that does not exist yet as a
+// draft. Its id is reserved now; the set is created, as version 1, when
+// another admin approves the draft.
+func (s *PricingService) CreateNewPriceSetDraft(ctx context.Context, def *models.PriceSet, note, author string) (*models.PricingVersion, error) {
+	if author == "" {
+		return nil, errors.New("drafts need an authenticated author")
+	}
+	if err := validateRecurrence(def.Recurrence); err != nil {
+		return nil, err
+	}
+	id := primitive.NewObjectID()
+	v := &models.PricingVersion{
+		Kind:      models.VersionKindSet,
+		TargetID:  id.Hex(),
+		Action:    models.VersionActionCreate,
+		Set:       setDefinition(def, id),
+		Note:      note,
+		CreatedBy: author,
+	}
+	return v, s.insertDraft(ctx, v)
+}
+
+// CreateNewPriceScheduleDraft stores a price schedule that does not exist
+// yet as a draft.
+func (s *PricingService) CreateNewPriceScheduleDraft(ctx context.Context, def *models.PriceSchedule, note, author string) (*models.PricingVersion, error) {
+	if author == "" {
+		return nil, errors.New("drafts need an authenticated author")
+	}
+	if err := validateRecurrence(def.Recurrence); err != nil {
+		return nil, err
+	}
+	id := primitive.NewObjectID()
+	v := &models.PricingVersion{
+		Kind:      models.VersionKindSchedule,
+		TargetID:  id.Hex(),
+		Action:    models.VersionActionCreate,
+		Schedule:  scheduleDefinition(def, id),
+		Note:      note,
+		CreatedBy: author,
+	}
+	return v, s.insertDraft(ctx, v)
+}
+
+// DeletePriceSetDraft stores the deletion of a live price set as a draft.
+// The set stays live until another admin approves it.
+func (s *PricingService) DeletePriceSetDraft(ctx context.Context, id, note, author string) (*models.PricingVersion, error) {
+	if author == "" {
+		return nil, errors.New("drafts need an authenticated author")
+	}
+	live, err := s.GetPriceSet(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	v := &models.PricingVersion{
+		Kind:        models.VersionKindSet,
+		TargetID:    live.ID.Hex(),
+		Action:      models.VersionActionDelete,
+		BaseVersion: live.Version,
+		Set:         setDefinition(live, live.ID),
+		Note:        note,
+		CreatedBy:   author,
+	}
+	return v, s.insertDraft(ctx, v)
+}
+
+// DeletePriceScheduleDraft stores the deletion of a live price schedule as a
+// draft.
+func (s *PricingService) DeletePriceScheduleDraft(ctx context.Context, id, note, author string) (*models.PricingVersion, error) {
+	if author == "" {
+		return nil, errors.New("drafts need an authenticated author")
+	}
+	live, err := s.GetPriceSchedule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	v := &models.PricingVersion{
+		Kind:        models.VersionKindSchedule,
+		TargetID:    live.ID.Hex(),
+		Action:      models.VersionActionDelete,
+		BaseVersion: live.Version,
+		Schedule:    scheduleDefinition(live, live.ID),
+		Note:        note,
+		CreatedBy:   author,
+	}
+	return v, s.insertDraft(ctx, v)
+}
+
+// PatchPriceSetDraft is CreatePriceSetDraft for a partial change: the patch
+// (API field names) is laid over the live definition.
+func (s *PricingService) PatchPriceSetDraft(ctx context.Context, id string, patch map[string]interface{}, note, author string) (*models.PricingVersion, error) {
+	live, err := s.GetPriceSet(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var def models.PriceSet
+	if err := mergePatch(live, patch, &def); err != nil {
+		return nil, err
+	}
+	return s.CreatePriceSetDraft(ctx, id, &def, note, author)
+}
+
+// PatchPriceScheduleDraft is CreatePriceScheduleDraft for a partial change.
+func (s *PricingService) PatchPriceScheduleDraft(ctx context.Context, id string, patch map[string]interface{}, note, author string) (*models.PricingVersion, error) {
+	live, err := s.GetPriceSchedule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var def models.PriceSchedule
+	if err := mergePatch(live, patch, &def); err != nil {
+		return nil, err
+	}
+	return s.CreatePriceScheduleDraft(ctx, id, &def, note, author)
+}
+
+// mergePatch overlays the top-level fields of patch on live, by their JSON
+// names, and decodes the result into out.
+func mergePatch(live interface{}, patch map[string]interface{}, out interface{}) error {
+	raw, err := json.Marshal(live)
+	if err != nil {
+		return err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+	for k, v := range patch {
+		doc[k] = v
+	}
+	if raw, err = json.Marshal(doc); err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, out)
+}
+
+func (s *PricingService) insertDraft(ctx context.Context, v *models.PricingVersion) error {
+	v.ID = primitive.NewObjectID()
+	v.Status = models.VersionDraft
+	v.CreatedAt = time.Now()
+	_, err := s.versions().InsertOne(ctx, v)
+	return err
+}
+
+// ListVersions returns versions newest first, filtered by kind, target and
+// status when given.
+func (s *PricingService) ListVersions(ctx context.Context, kind, targetID, status string) ([]models.PricingVersion, error) {
+	filter := bson.M{}
+	if kind != "" {
+		filter["kind"] = kind
+	}
+	if targetID != "" {
+		filter["targetId"] = targetID
+	}
+	if status != "" {
+		filter["status"] = status
+	}
+	cursor, err := s.versions().Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	out := []models.PricingVersion{}
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *PricingService) GetVersion(ctx context.Context, id string) (*models.PricingVersion, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var v models.PricingVersion
+	if err := s.versions().FindOne(ctx, bson.M{"_id": objID}).Decode(&v); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.New("version not found")
+		}
+		return nil, err
+	}
+	return &v, nil
+}
+
+// ApproveVersion publishes a draft. The approver must be a different admin
+// from its author; the route only lets admins approve.
+func (s *PricingService) ApproveVersion(ctx context.Context, id, approver string) (*models.PricingVersion, error) {
+	if approver == "" {
+		return nil, errors.New("approval needs an authenticated admin")
+	}
+	v, err := s.GetVersion(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if v.Status != models.VersionDraft {
+		return nil, fmt.Errorf("version is %s, only drafts can be approved", v.Status)
+	}
+	if v.CreatedBy == approver {
+		return nil, errors.New("a draft must be approved by a different admin than its author")
+	}
+	return v, s.publishVersion(ctx, v, approver)
+}
+
+// DiscardVersion drops a draft without publishing it.
+func (s *PricingService) DiscardVersion(ctx context.Context, id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.versions().UpdateOne(ctx,
+		bson.M{"_id": objID, "status": models.VersionDraft},
+		bson.M{"$set": bson.M{"status": models.VersionDiscarded}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errors.New("draft not found")
+	}
+	return nil
+}
+
+// RevertVersion files, as a draft, the definition of an earlier published
+// version. Like any draft it goes live once another admin approves it.
+func (s *PricingService) RevertVersion(ctx context.Context, kind, targetID string, version int, by string) (*models.PricingVersion, error) {
+	if by == "" {
+		return nil, errors.New("reverts need an authenticated admin")
+	}
+	var old models.PricingVersion
+	err := s.versions().FindOne(ctx, bson.M{
+		"kind": kind, "targetId": targetID, "version": version, "status": models.VersionPublished,
+	}).Decode(&old)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("version %d not found", version)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var v *models.PricingVersion
+	note := fmt.Sprintf("revert to version %d", version)
+	switch kind {
+	case models.VersionKindSet:
+		v, err = s.CreatePriceSetDraft(ctx, targetID, old.Set, note, by)
+	case models.VersionKindSchedule:
+		v, err = s.CreatePriceScheduleDraft(ctx, targetID, old.Schedule, note, by)
+	default:
+		return nil, errors.New("unknown version kind " + kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	v.RevertedFrom = version
+	if _, err := s.versions().UpdateOne(ctx, bson.M{"_id": v.ID}, bson.M{"$set": bson.M{"revertedFrom": version}}); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// publishVersion claims the draft, then applies it to the live document:
+// creates it, or updates or deletes it as long as it is still at the draft's
+// base version. A live document from before versioning (version 0) first
+// gets its current definition recorded, so it can be reverted to.
+func (s *PricingService) publishVersion(ctx context.Context, v *models.PricingVersion, approver string) error {
+	objID, err := primitive.ObjectIDFromHex(v.TargetID)
+	if err != nil {
+		return err
+	}
+	if v.BaseVersion == 0 && v.Action != models.VersionActionCreate {
+		if err := s.recordLegacyVersion(ctx, v.Kind, v.TargetID); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	next := v.BaseVersion + 1
+	res, err := s.versions().UpdateOne(ctx,
+		bson.M{"_id": v.ID, "status": models.VersionDraft},
+		bson.M{"$set": bson.M{"status": models.VersionPublished, "version": next, "approvedBy": approver, "publishedAt": now}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errors.New("draft is no longer pending")
+	}
+
+	switch v.Action {
+	case models.VersionActionCreate:
+		err = s.publishCreate(ctx, v, objID, now)
+	case models.VersionActionDelete:
+		err = s.publishDelete(ctx, v, objID)
+	default:
+		err = s.publishUpdate(ctx, v, objID, next, now)
+	}
+	if err != nil {
+		// Put the draft back so it can be discarded or retried.
+		s.versions().UpdateOne(ctx, bson.M{"_id": v.ID}, bson.M{
+			"$set":   bson.M{"status": models.VersionDraft},
+			"$unset": bson.M{"version": "", "approvedBy": "", "publishedAt": ""},
+		})
+		return err
+	}
+
+	v.Status = models.VersionPublished
+	v.Version = next
+	v.ApprovedBy = approver
+	v.PublishedAt = &now
+	s.invalidatePricing(ctx)
+	return nil
+}
+
+// publishCreate inserts the set or schedule of a creation draft as version 1.
+func (s *PricingService) publishCreate(ctx context.Context, v *models.PricingVersion, objID primitive.ObjectID, now time.Time) error {
+	var err error
+	switch v.Kind {
+	case models.VersionKindSet:
+		set := setDefinition(v.Set, objID)
+		set.Version, set.CreatedAt, set.UpdatedAt = 1, now, now
+		_, err = s.sets.InsertOne(ctx, set)
+	case models.VersionKindSchedule:
+		sch := scheduleDefinition(v.Schedule, objID)
+		sch.Version, sch.CreatedAt, sch.UpdatedAt = 1, now, now
+		_, err = s.schedules.InsertOne(ctx, sch)
+	default:
+		err = errors.New("unknown version kind " + v.Kind)
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrVersionConflict
+	}
+	return err
+}
+
+// publishUpdate writes the draft's definition to the live document.
+func (s *PricingService) publishUpdate(ctx context.Context, v *models.PricingVersion, objID primitive.ObjectID, next int, now time.Time) error {
+	coll, err := s.versionedCollection(v.Kind)
+	if err != nil {
+		return err
+	}
+	var update bson.M
+	if v.Kind == models.VersionKindSet {
+		update, err = setDefinitionUpdate(v.Set)
+	} else {
+		update, err = definitionUpdate(v.Schedule, nil, scheduleOptionalFields)
+	}
+	if err != nil {
+		return err
+	}
+	update["$set"].(bson.M)["version"] = next
+	update["$set"].(bson.M)["updatedAt"] = now
+	res, err := coll.UpdateOne(ctx, bson.M{"_id": objID, "version": versionFilter(v.BaseVersion)}, update)
+	if err == nil && res.MatchedCount == 0 {
+		err = ErrVersionConflict
+	}
+	return err
+}
+
+// publishDelete removes the live document. Its history stays, ending with
+// the deletion.
+func (s *PricingService) publishDelete(ctx context.Context, v *models.PricingVersion, objID primitive.ObjectID) error {
+	coll, err := s.versionedCollection(v.Kind)
+	if err != nil {
+		return err
+	}
+	res, err := coll.DeleteOne(ctx, bson.M{"_id": objID, "version": versionFilter(v.BaseVersion)})
+	if err == nil && res.DeletedCount == 0 {
+		err = ErrVersionConflict
+	}
+	return err
+}
+
+// versionedCollection is the collection of the live documents of a kind.
+func (s *PricingService) versionedCollection(kind string) (*mongo.Collection, error) {
+	switch kind {
+	case models.VersionKindSet:
+		return s.sets, nil
+	case models.VersionKindSchedule:
+		return s.schedules, nil
+	}
+	return nil, errors.New("unknown version kind " + kind)
+}
+
+// recordLegacyVersion stores the current definition of a document created
+// before versioning as its version 0, once.
+func (s *PricingService) recordLegacyVersion(ctx context.Context, kind, targetID string) error {
+	n, err := s.versions().CountDocuments(ctx, bson.M{"kind": kind, "targetId": targetID, "version": 0, "status": models.VersionPublished})
+	if err != nil || n > 0 {
+		return err
+	}
+	v := models.PricingVersion{Kind: kind, TargetID: targetID, Status: models.VersionPublished, Note: "before versioning"}
+	switch kind {
+	case models.VersionKindSet:
+		set, err := s.GetPriceSet(ctx, targetID)
+		if err != nil {
+			return err
+		}
+		v.Set = setDefinition(set, set.ID)
+		v.CreatedAt = set.UpdatedAt
+	case models.VersionKindSchedule:
+		sch, err := s.GetPriceSchedule(ctx, targetID)
+		if err != nil {
+			return err
+		}
+		v.Schedule = scheduleDefinition(sch, sch.ID)
+		v.CreatedAt = sch.UpdatedAt
+	}
+	v.ID = primitive.NewObjectID()
+	v.PublishedAt = &v.CreatedAt
+	_, err = s.versions().InsertOne(ctx, v)
+	return err
+}
+
+// recordCreatedVersion stores a newly created set or schedule as version 1.
+func (s *PricingService) recordCreatedVersion(ctx context.Context, v models.PricingVersion, createdAt time.Time) error {
+	v.ID = primitive.NewObjectID()
+	v.Action = models.VersionActionCreate
+	v.Version = 1
+	v.Status = models.VersionPublished
+	v.CreatedAt = createdAt
+	v.PublishedAt = &createdAt
+	_, err := s.versions().InsertOne(ctx, v)
+	return err
+}
+
+// setDefinition copies a set without its usage counters or bookkeeping.
+func setDefinition(set *models.PriceSet, id primitive.ObjectID) *models.PriceSet {
+	def := *set
+	def.ID = id
+	def.UsedCount, def.ReservedCount = 0, 0
+	def.CustomerUsage, def.CustomerReserved = nil, nil
+	def.Version = 0
+	def.CreatedAt, def.UpdatedAt = time.Time{}, time.Time{}
+	return &def
+}
+
+// scheduleDefinition copies a schedule without its bookkeeping.
+func scheduleDefinition(sch *models.PriceSchedule, id primitive.ObjectID) *models.PriceSchedule {
+	def := *sch
+	def.ID = id
+	def.Version = 0
+	def.CreatedAt, def.UpdatedAt = time.Time{}, time.Time{}
+	return &def
+}
+
+// definitionUpdate turns a definition into a $set of its fields, minus the
+// id, bookkeeping and usage fields, and an $unset of the optional fields it
+// leaves out.
+func definitionUpdate(def interface{}, usage, optional []string) (bson.M, error) {
+	raw, err := bson.Marshal(def)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	for _, k := range append([]string{"_id", "createdAt", "updatedAt", "version"}, usage...) {
+		delete(doc, k)
+	}
+	update := bson.M{"$set": doc}
+	unset := bson.M{}
+	for _, k := range optional {
+		if _, ok := doc[k]; !ok {
+			unset[k] = ""
+		}
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update, nil
+}
+
+// setDefinitionUpdate is definitionUpdate for a price set, with its
+// conditions written field by field and the managed ones left out.
+func setDefinitionUpdate(def *models.PriceSet) (bson.M, error) {
+	update, err := definitionUpdate(def, setUsageFields, setOptionalFields)
+	if err != nil {
+		return nil, err
+	}
+	set := update["$set"].(bson.M)
+	conds := bson.M{}
+	switch c := set["conditions"].(type) {
+	case bson.M:
+		conds = c
+	case primitive.D:
+		conds = c.Map()
+	}
+	delete(set, "conditions")
+	unset, _ := update["$unset"].(bson.M)
+	if unset == nil {
+		unset = bson.M{}
+	}
+	for _, k := range setConditionFields {
+		if slices.Contains(setManagedConditions, k) {
+			continue
+		}
+		if v, ok := conds[k]; ok {
+			set["conditions."+k] = v
+		} else {
+			unset["conditions."+k] = ""
+		}
+	}
+	update["$unset"] = unset
+	return update, nil
+}
+
+// versionFilter matches a live document at the given version; documents from
+// before versioning have no version field.
+func versionFilter(version int) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
+}
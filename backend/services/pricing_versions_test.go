@@ -0,0 +1,119 @@
+package services
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+)
+
+func TestDefinitionUpdateKeepsUsage(t *testing.T) {
+	set := &models.PriceSet{
+		ID:            primitive.NewObjectID(),
+		Name:          "buen fin",
+		Active:        true,
+		UsedCount:     40,
+		ReservedCount: 3,
+		CustomerUsage: map[string]int{"c1": 1},
+		Version:       4,
+		Rules:         []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 15}},
+	}
+	update, err := definitionUpdate(setDefinition(set, set.ID), setUsageFields, setOptionalFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := update["$set"].(bson.M)
+	for _, k := range []string{"_id", "usedCount", "reservedCount", "customerUsage", "version", "createdAt"} {
+		if _, ok := fields[k]; ok {
+			t.Errorf("%s must not be overwritten by a version", k)
+		}
+	}
+	if fields["name"] != "buen fin" || fields["active"] != true {
+		t.Errorf("definition fields missing from $set: %v", fields)
+	}
+	// The version has no window, so a window on the live set is cleared.
+	unset := update["$unset"].(bson.M)
+	if _, ok := unset["endsAt"]; !ok {
+		t.Errorf("expected endsAt cleared, got %v", unset)
+	}
+
+	ends := time.Now()
+	set.EndsAt = &ends
+	update, _ = definitionUpdate(setDefinition(set, set.ID), setUsageFields, setOptionalFields)
+	if _, ok := update["$unset"].(bson.M)["endsAt"]; ok {
+		t.Error("a window the version sets must not be cleared")
+	}
+}
+
+func TestSetDefinitionUpdateKeepsManagedConditions(t *testing.T) {
+	set := &models.PriceSet{
+		ID:         primitive.NewObjectID(),
+		Name:       "bienvenida",
+		Active:     true,
+		Conditions: models.PriceConditions{CouponCode: "HOLA", FirstOrder: true},
+		Rules:      []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 15}},
+	}
+	update, err := setDefinitionUpdate(setDefinition(set, set.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, unset := update["$set"].(bson.M), update["$unset"].(bson.M)
+	if _, ok := fields["conditions"]; ok {
+		t.Error("conditions must be written field by field")
+	}
+	if fields["conditions.couponCode"] != "HOLA" || fields["conditions.firstOrder"] != true {
+		t.Errorf("expected the version's conditions set, got %v", fields)
+	}
+	if _, ok := unset["conditions.minSubtotal"]; !ok {
+		t.Errorf("expected conditions the version leaves out cleared, got %v", unset)
+	}
+	// An older draft without unique codes must not switch them off.
+	if _, ok := fields["conditions.uniqueCodes"]; ok {
+		t.Error("uniqueCodes must not be written by a version")
+	}
+	if _, ok := unset["conditions.uniqueCodes"]; ok {
+		t.Error("uniqueCodes must not be cleared by a version")
+	}
+
+	// Every condition is listed, or a version would leave it stale.
+	conds := reflect.TypeOf(models.PriceConditions{})
+	for i := 0; i < conds.NumField(); i++ {
+		name, _, _ := strings.Cut(conds.Field(i).Tag.Get("bson"), ",")
+		if !slices.Contains(setConditionFields, name) {
+			t.Errorf("condition %s missing from setConditionFields", name)
+		}
+	}
+}
+
+func TestAppliedSetRecordsVersion(t *testing.T) {
+	set := models.PriceSet{ID: primitive.NewObjectID(), Name: "10 off", Active: true, Version: 3,
+		Rules: []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 10}}}
+	snap := compileSnapshot([]models.PriceSet{set}, nil, 0, time.Now())
+
+	p := &Product{ID: primitive.NewObjectID(), BasePrice: 100}
+	res, err := snap.resolve([]PriceInput{{Product: p, Quantity: 1}}, PricingContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.AppliedSets) != 1 || res.AppliedSets[0].SetVersion != 3 {
+		t.Errorf("expected the applied set to record version 3, got %+v", res.AppliedSets)
+	}
+}
+
+func TestMergePatchKeepsOmittedFields(t *testing.T) {
+	live := &models.PriceSet{ID: primitive.NewObjectID(), Name: "10 off", Active: true, Priority: 2,
+		Rules: []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 10}}}
+	var def models.PriceSet
+	if err := mergePatch(live, map[string]interface{}{"name": "12 off"}, &def); err != nil {
+		t.Fatal(err)
+	}
+	if def.Name != "12 off" || def.Priority != 2 || len(def.Rules) != 1 || def.Rules[0].Amount != 10 {
+		t.Errorf("expected only the name to change, got %+v", def)
+	}
+}
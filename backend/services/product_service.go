@@ -17,6 +17,7 @@ type productService struct {
 	db              *mongo.Database
 	collection      *mongo.Collection
 	categoryService CategoryService
+	eventBus        EventBus
 }
 
 func NewProductService(db *mongo.Database, categoryService CategoryService) ProductService {
@@ -27,6 +28,11 @@ func NewProductService(db *mongo.Database, categoryService CategoryService) Prod
 	}
 }
 
+// SetEventBus sets the event bus list price changes are published on
+func (s *productService) SetEventBus(eventBus EventBus) {
+	s.eventBus = eventBus
+}
+
 func (s *productService) GetProduct(ctx context.Context, id string) (*Product, error) {
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -44,16 +50,17 @@ func (s *productService) GetProductByID(ctx context.Context, id primitive.Object
 	return &product, nil
 }
 
+// GetVariant looks a variant up by its variantId within the product document.
 func (s *productService) GetVariant(ctx context.Context, productID string, variantID string) (*Variant, error) {
-	objID, err := primitive.ObjectIDFromHex(productID)
+	product, err := s.GetProduct(ctx, productID)
 	if err != nil {
 		return nil, err
 	}
-	vID, err := primitive.ObjectIDFromHex(variantID)
-	if err != nil {
-		return nil, err
+	variant := product.FindVariant(variantID)
+	if variant == nil {
+		return nil, errors.New("variant not found")
 	}
-	return s.GetVariantByID(ctx, objID, vID)
+	return variant, nil
 }
 
 func (s *productService) GetVariantByID(ctx context.Context, productID, variantID primitive.ObjectID) (*Variant, error) {
@@ -89,12 +96,63 @@ func (s *productService) UpdateProduct(ctx context.Context, id string, update ma
 	}
 
 	update["updatedAt"] = time.Now()
-	_, err = s.collection.UpdateOne(
-		ctx,
-		bson.M{"_id": objID},
-		bson.M{"$set": update},
-	)
-	return err
+	_, touchesPrice := update["basePrice"]
+	if _, ok := update["variants"]; ok {
+		touchesPrice = true
+	}
+	if !touchesPrice || s.eventBus == nil {
+		_, err = s.collection.UpdateOne(
+			ctx,
+			bson.M{"_id": objID},
+			bson.M{"$set": update},
+		)
+		return err
+	}
+
+	var before Product
+	err = s.collection.FindOneAndUpdate(ctx, bson.M{"_id": objID}, bson.M{"$set": update}).Decode(&before)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	after, err := s.GetProductByID(ctx, objID)
+	if err != nil {
+		return err
+	}
+	s.publishPriceChanges(ctx, &before, after)
+	return nil
+}
+
+// publishPriceChanges announces every list price an edit changed: the base
+// price of a product without variants, else each variant's price.
+func (s *productService) publishPriceChanges(ctx context.Context, before, after *Product) {
+	now := time.Now()
+	publish := func(variantID string, oldPrice, newPrice float64) {
+		if oldPrice == newPrice {
+			return
+		}
+		s.eventBus.Publish(ctx, ProductPriceChanged{
+			ProductID: after.ID.Hex(),
+			VariantID: variantID,
+			OldPrice:  oldPrice,
+			NewPrice:  newPrice,
+			Timestamp: now,
+		})
+	}
+	if len(after.Variants) == 0 {
+		publish("", before.PriceFor(nil), after.PriceFor(nil))
+		return
+	}
+	for i := range after.Variants {
+		v := &after.Variants[i]
+		old := before.FindVariant(v.VariantID)
+		if old == nil {
+			continue // new variant: no previous price
+		}
+		publish(v.VariantID, before.PriceFor(old), after.PriceFor(v))
+	}
 }
 
 func (s *productService) DeleteProduct(ctx context.Context, id string) error {
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// taxMigrationID marks, in the migrations collection, that the products from
+// before tax categories have been tagged.
+const taxMigrationID = "product_tax_categories"
+
+// MigrateProducts tags every product without a tax category with the default
+// category, and returns how many it tagged. It runs once per database: base
+// prices are net from then on, and products created later without a category
+// are taxed in the default one as they are.
+//
+// A catalog whose prices were entered with taxes included needs
+// pricesIncludeTax, which also takes the default category's taxes out of the
+// tagged products' base prices and variant price adjustments.
+func (s *TaxService) MigrateProducts(ctx context.Context, db *mongo.Database, pricesIncludeTax bool) (int64, error) {
+	migrations := db.Collection("migrations")
+	now := time.Now()
+	_, err := migrations.InsertOne(ctx, bson.M{"_id": taxMigrationID, "ranAt": now})
+	if mongo.IsDuplicateKeyError(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	set := bson.M{"taxCategory": s.defaultCategory, "updatedAt": now}
+	if pricesIncludeTax {
+		factor := grossFactor(s.Category(s.defaultCategory))
+		net := func(field string) bson.M {
+			return bson.M{"$round": bson.A{bson.M{"$divide": bson.A{bson.M{"$ifNull": bson.A{field, 0}}, factor}}, 2}}
+		}
+		set["basePrice"] = net("$basePrice")
+		set["variants"] = bson.M{"$map": bson.M{
+			"input": "$variants",
+			"as":    "v",
+			"in": bson.M{"$mergeObjects": bson.A{"$$v", bson.M{
+				"priceAdjustment": net("$$v.priceAdjustment"),
+			}}},
+		}}
+	}
+	res, err := db.Collection("products").UpdateMany(ctx,
+		bson.M{"taxCategory": bson.M{"$in": bson.A{nil, ""}}},
+		bson.A{bson.M{"$set": set}})
+	if err != nil {
+		// Left for the next start to try again.
+		migrations.DeleteOne(ctx, bson.M{"_id": taxMigrationID})
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
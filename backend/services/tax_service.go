@@ -0,0 +1,164 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"mercadomio-backend/models"
+)
+
+// TaxService computes sales taxes on net (tax-excluded) prices. Catalog base
+// prices, the pricing engine and order subtotals are all net; taxes are added
+// as the last step. Products without a tax category are taxed in the store's
+// default category.
+type TaxService struct {
+	categories         map[string]models.TaxCategory
+	defaultCategory    string
+	displayTaxIncluded bool
+}
+
+// NewTaxService creates a tax service with the Mexican tax categories.
+// CATALOG_PRICES_INCLUDE_TAX=true shows catalog prices with taxes included;
+// DEFAULT_TAX_CATEGORY is the category of products without one (general IVA
+// when unset).
+func NewTaxService() *TaxService {
+	s := &TaxService{
+		categories:      map[string]models.TaxCategory{},
+		defaultCategory: models.TaxCategoryGeneral,
+	}
+	s.displayTaxIncluded, _ = strconv.ParseBool(os.Getenv("CATALOG_PRICES_INCLUDE_TAX"))
+
+	iva := models.TaxRate{Tax: models.TaxIVA, Rate: 16, Compound: true}
+	for _, c := range []models.TaxCategory{
+		{Code: models.TaxCategoryGeneral, Name: "IVA 16%", Rates: []models.TaxRate{iva}},
+		{Code: models.TaxCategoryZeroRated, Name: "IVA 0%", Rates: []models.TaxRate{{Tax: models.TaxIVA, Rate: 0}}},
+		{Code: models.TaxCategoryExempt, Name: "Exento"},
+		{Code: models.TaxCategorySnacks, Name: "IEPS 8% + IVA 16%", Rates: []models.TaxRate{{Tax: models.TaxIEPS, Rate: 8}, iva}},
+		{Code: models.TaxCategoryBeer, Name: "IEPS 26.5% + IVA 16%", Rates: []models.TaxRate{{Tax: models.TaxIEPS, Rate: 26.5}, iva}},
+		{Code: models.TaxCategorySpirits, Name: "IEPS 53% + IVA 16%", Rates: []models.TaxRate{{Tax: models.TaxIEPS, Rate: 53}, iva}},
+	} {
+		s.SetCategory(c)
+	}
+
+	if code := os.Getenv("DEFAULT_TAX_CATEGORY"); code != "" {
+		if err := s.SetDefaultCategory(code); err != nil {
+			log.Printf("Warning: DEFAULT_TAX_CATEGORY: %v; untagged products are taxed as %s", err, s.defaultCategory)
+		}
+	}
+	return s
+}
+
+// SetCategory adds or replaces a tax category.
+func (s *TaxService) SetCategory(c models.TaxCategory) {
+	s.categories[c.Code] = c
+}
+
+// SetDefaultCategory sets the category products without one are taxed in.
+func (s *TaxService) SetDefaultCategory(code string) error {
+	if _, ok := s.categories[code]; !ok {
+		return errors.New("unknown tax category: " + code)
+	}
+	s.defaultCategory = code
+	return nil
+}
+
+// DefaultCategory returns the category products without one are taxed in.
+func (s *TaxService) DefaultCategory() string {
+	return s.defaultCategory
+}
+
+// SetDisplayTaxIncluded switches catalog prices between tax-included and
+// tax-excluded.
+func (s *TaxService) SetDisplayTaxIncluded(included bool) {
+	s.displayTaxIncluded = included
+}
+
+// DisplayTaxIncluded reports whether catalog prices are shown with taxes.
+func (s *TaxService) DisplayTaxIncluded() bool {
+	return s.displayTaxIncluded
+}
+
+// Categories returns the known tax categories, sorted by code.
+func (s *TaxService) Categories() []models.TaxCategory {
+	out := make([]models.TaxCategory, 0, len(s.categories))
+	for _, c := range s.categories {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Code < out[j].Code })
+	return out
+}
+
+// Category returns a tax category; unknown and empty codes fall back to the
+// default category.
+func (s *TaxService) Category(code string) models.TaxCategory {
+	if c, ok := s.categories[code]; ok {
+		return c
+	}
+	return s.categories[s.defaultCategory]
+}
+
+// Compute returns the taxes on a net amount and their sum. Each tax is rounded
+// half away from zero to the minor unit.
+func (s *TaxService) Compute(net models.Money, code string) (models.Money, []models.TaxLine) {
+	total := models.MoneyFromMinor(0)
+	var lines []models.TaxLine
+	for _, r := range s.Category(code).Rates {
+		base := net
+		if r.Compound {
+			base = net.Add(total)
+		}
+		amount := base.Percent(r.Rate)
+		total = total.Add(amount)
+		lines = append(lines, models.TaxLine{Tax: r.Tax, Rate: r.Rate, Base: base, Amount: amount})
+	}
+	return total, lines
+}
+
+// grossFactor is what a net price of 1 costs with the category's taxes.
+func grossFactor(c models.TaxCategory) float64 {
+	total := 0.0
+	for _, r := range c.Rates {
+		base := 1.0
+		if r.Compound {
+			base += total
+		}
+		total += base * r.Rate / 100
+	}
+	return 1 + total
+}
+
+// apply taxes every priced line on its line total (after discounts) and sums
+// the result per tax and rate.
+func (s *TaxService) apply(res *PriceResult) {
+	res.Tax = models.MoneyFromMinor(0)
+	res.Taxes = nil
+	for i := range res.Lines {
+		line := &res.Lines[i]
+		line.Tax, line.Taxes = s.Compute(line.LineTotal, line.TaxCategory)
+		res.Tax = res.Tax.Add(line.Tax)
+		res.Taxes = mergeTaxLines(res.Taxes, line.Taxes)
+	}
+	res.TotalWithTax = res.Total.Add(res.Tax)
+}
+
+// mergeTaxLines adds lines into a breakdown keyed by tax and rate.
+func mergeTaxLines(into, lines []models.TaxLine) []models.TaxLine {
+	for _, l := range lines {
+		merged := false
+		for i := range into {
+			if into[i].Tax == l.Tax && into[i].Rate == l.Rate {
+				into[i].Base = into[i].Base.Add(l.Base)
+				into[i].Amount = into[i].Amount.Add(l.Amount)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			into = append(into, l)
+		}
+	}
+	return into
+}
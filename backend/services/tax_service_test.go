@@ -0,0 +1,102 @@
+package services
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"mercadomio-backend/models"
+)
+
+func TestTaxCompute(t *testing.T) {
+	taxes := NewTaxService()
+	cases := []struct {
+		name     string
+		category string
+		net      models.Money
+		want     models.Money
+		lines    int
+	}{
+		{"general IVA", models.TaxCategoryGeneral, mxn(100), mxn(16), 1},
+		{"empty category is general", "", mxn(100), mxn(16), 1},
+		{"unknown category is general", "no-such", mxn(100), mxn(16), 1},
+		{"zero-rated food", models.TaxCategoryZeroRated, mxn(100), mxn(0), 1},
+		{"exempt", models.TaxCategoryExempt, mxn(100), mxn(0), 0},
+		// IEPS 8% = 8.00; IVA 16% on 108.00 = 17.28
+		{"IVA on top of IEPS", models.TaxCategorySnacks, mxn(100), mxn(25.28), 2},
+		// IVA rounds half away from zero: 16% of 0.03 = 0.0048 -> 0.00; of 0.04 = 0.0064 -> 0.01
+		{"rounding", models.TaxCategoryGeneral, mxn(0.04), mxn(0.01), 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, lines := taxes.Compute(tc.net, tc.category)
+			if got != tc.want || len(lines) != tc.lines {
+				t.Errorf("Compute(%s, %q) = %s in %d lines, want %s in %d", tc.net, tc.category, got, len(lines), tc.want, tc.lines)
+			}
+		})
+	}
+}
+
+func TestTaxApplyBreakdown(t *testing.T) {
+	res := &PriceResult{
+		Total: mxn(350),
+		Lines: []PricedLine{
+			{LineTotal: mxn(100)},
+			{LineTotal: mxn(200), TaxCategory: models.TaxCategoryZeroRated},
+			{LineTotal: mxn(50), TaxCategory: models.TaxCategorySnacks},
+		},
+	}
+	NewTaxService().apply(res)
+
+	// 16.00 + 0 + (4.00 IEPS + 8.64 IVA)
+	if res.Tax != mxn(28.64) || res.TotalWithTax != mxn(378.64) {
+		t.Fatalf("unexpected totals: tax %s, total %s", res.Tax, res.TotalWithTax)
+	}
+	if res.Lines[2].Tax != mxn(12.64) {
+		t.Errorf("expected 12.64 tax on the snack line, got %s", res.Lines[2].Tax)
+	}
+	want := map[string]models.TaxLine{
+		"IVA 16": {Base: mxn(154), Amount: mxn(24.64)},
+		"IVA 0":  {Base: mxn(200), Amount: mxn(0)},
+		"IEPS 8": {Base: mxn(50), Amount: mxn(4)},
+	}
+	if len(res.Taxes) != len(want) {
+		t.Fatalf("expected %d breakdown lines, got %+v", len(want), res.Taxes)
+	}
+	for _, l := range res.Taxes {
+		key := l.Tax + " " + strconv.FormatFloat(l.Rate, 'f', -1, 64)
+		if w, ok := want[key]; !ok || w.Base != l.Base || w.Amount != l.Amount {
+			t.Errorf("%s: got base %s amount %s", key, l.Base, l.Amount)
+		}
+	}
+}
+
+func TestTaxDefaultCategory(t *testing.T) {
+	taxes := NewTaxService()
+	if err := taxes.SetDefaultCategory("no-such"); err == nil {
+		t.Fatal("expected an unknown default category to be refused")
+	}
+	if err := taxes.SetDefaultCategory(models.TaxCategoryZeroRated); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := taxes.Compute(mxn(100), ""); got != mxn(0) {
+		t.Errorf("expected an untagged product taxed as zero-rated, got %s", got)
+	}
+	if got, _ := taxes.Compute(mxn(100), models.TaxCategoryGeneral); got != mxn(16) {
+		t.Errorf("expected a tagged product keeping its category, got %s", got)
+	}
+}
+
+func TestTaxGrossFactor(t *testing.T) {
+	taxes := NewTaxService()
+	cases := map[string]float64{
+		models.TaxCategoryGeneral: 1.16,
+		models.TaxCategoryExempt:  1,
+		models.TaxCategorySnacks:  1.2528, // IVA on top of IEPS
+	}
+	for code, want := range cases {
+		if got := grossFactor(taxes.Category(code)); math.Abs(got-want) > 1e-9 {
+			t.Errorf("grossFactor(%s) = %v, want %v", code, got, want)
+		}
+	}
+}
@@ -2,11 +2,16 @@ package tests
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"mercadomio-backend/handlers"
 	"mercadomio-backend/models"
 	"mercadomio-backend/services"
 )
@@ -185,3 +190,47 @@ func TestAuthServiceValidateToken(t *testing.T) {
 		t.Error("Expected error for invalid token")
 	}
 }
+
+// TestUpdateProfileIgnoresPricingFields sends a profile update that tries to
+// change the fields pricing and access depend on: only the name may change.
+func TestUpdateProfileIgnoresPricingFields(t *testing.T) {
+	db := connectTestDB(t)
+	authService := services.NewAuthService(db)
+	user, err := authService.Register(&models.UserRegisterRequest{
+		Email:    "profile@test.com",
+		Password: "password123",
+		Name:     "Profile User",
+		Type:     models.UserTypeIndividual,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Put("/profile", func(c *fiber.Ctx) error {
+		c.Locals("userID", user.ID.Hex())
+		return c.Next()
+	}, handlers.NewAuthHandlers(authService).UpdateProfile)
+
+	body := `{"name":"Renamed","type":"wholesale","tier":"gold","role":"admin"}`
+	req := httptest.NewRequest(http.MethodPut, "/profile", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	got, err := authService.GetUserByID(user.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Renamed" {
+		t.Errorf("expected the name updated, got %q", got.Name)
+	}
+	if got.Type != models.UserTypeIndividual || got.Tier != "" || got.Role != "" {
+		t.Errorf("expected type, tier and role ignored, got %q / %q / %q", got.Type, got.Tier, got.Role)
+	}
+}
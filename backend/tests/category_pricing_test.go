@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+	"mercadomio-backend/services"
+)
+
+// TestCategoryEditsReachPricing edits a category between resolves: its
+// parent, minimum margin and rounding must show up on the next price.
+func TestCategoryEditsReachPricing(t *testing.T) {
+	db := connectTestDB(t)
+	ctx := context.Background()
+
+	pricingService := services.NewPricingService(db, nil)
+	categoryService := services.NewCategoryService(db)
+	categoryService.SetPricingService(pricingService)
+
+	dairy, err := categoryService.CreateCategory(ctx, &services.Category{Name: "Lácteos"})
+	if err != nil {
+		t.Fatalf("failed to create category: %v", err)
+	}
+	cheese, err := categoryService.CreateCategory(ctx, &services.Category{Name: "Quesos"})
+	if err != nil {
+		t.Fatalf("failed to create category: %v", err)
+	}
+	if _, err := pricingService.CreatePriceSet(ctx, &models.PriceSet{
+		Name:     "dairy week",
+		Priority: 1,
+		Active:   true,
+		Rules: []models.PriceRule{
+			{Kind: models.RuleKindPercentage, Amount: 30, Scope: models.RuleScopeCategory, ScopeRefs: []string{dairy.ID.Hex()}},
+		},
+	}); err != nil {
+		t.Fatalf("failed to create price set: %v", err)
+	}
+
+	product := &services.Product{
+		ID:         primitive.NewObjectID(),
+		Name:       "Queso Oaxaca",
+		BasePrice:  100,
+		CostPrice:  80,
+		Categories: []primitive.ObjectID{cheese.ID},
+	}
+	price := func() models.Money {
+		t.Helper()
+		res, err := pricingService.ResolvePrices(ctx, []services.PriceInput{{Product: product, Quantity: 1}}, services.PricingContext{})
+		if err != nil {
+			t.Fatalf("ResolvePrices failed: %v", err)
+		}
+		return res.Lines[0].UnitPrice
+	}
+
+	if got := price(); got != models.NewMoney(100) {
+		t.Fatalf("expected no discount outside dairy, got %s", got)
+	}
+
+	steps := []struct {
+		name    string
+		updates bson.M
+		want    float64
+	}{
+		{"moved under dairy", bson.M{"parentId": dairy.ID}, 70},
+		{"10% minimum margin", bson.M{"minMarginPercent": 10.0}, 88.89},
+		{"up_90 rounding", bson.M{"rounding": string(models.RoundingUp90)}, 88.90},
+	}
+	for _, step := range steps {
+		if err := categoryService.UpdateCategory(ctx, cheese.ID, step.updates); err != nil {
+			t.Fatalf("%s: UpdateCategory failed: %v", step.name, err)
+		}
+		if got := price(); got != models.NewMoney(step.want) {
+			t.Errorf("%s: expected %.2f, got %s", step.name, step.want, got)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+	"mercadomio-backend/services"
+)
+
+func TestGeneratedCouponCodeRedemption(t *testing.T) {
+	db := connectTestDB(t)
+	ctx := context.Background()
+
+	productService := services.NewProductService(db, nil)
+	pricingService := services.NewPricingService(db, productService)
+	orderService := services.NewOrderService(db)
+	orderService.SetProductService(productService)
+	orderService.SetPricingService(pricingService)
+
+	product := &services.Product{Name: "Tamal oaxaqueño", BasePrice: 50}
+	if err := productService.CreateProduct(ctx, product); err != nil {
+		t.Fatalf("failed to create product: %v", err)
+	}
+	set, err := pricingService.CreatePriceSet(ctx, &models.PriceSet{
+		Name:   "influencer",
+		Active: true,
+		Rules:  []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 10}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create price set: %v", err)
+	}
+
+	codes, err := pricingService.GenerateCouponCodes(ctx, set.ID.Hex(), 50, "INF-", "XXXX-XXXX")
+	if err != nil {
+		t.Fatalf("GenerateCouponCodes failed: %v", err)
+	}
+	seen := map[string]bool{}
+	for _, cc := range codes {
+		if !strings.HasPrefix(cc.Code, "INF-") || seen[cc.Code] {
+			t.Fatalf("unexpected or duplicate code %q", cc.Code)
+		}
+		seen[cc.Code] = true
+	}
+	if len(seen) != 50 {
+		t.Fatalf("expected 50 codes, got %d", len(seen))
+	}
+
+	// Without a code the set no longer applies.
+	items := []services.CartItem{{ProductID: product.ID.Hex(), Quantity: 2}}
+	plain, err := orderService.CreateOrderFromCart(ctx, primitive.NewObjectID().Hex(), items, &services.PricingContext{})
+	if err != nil {
+		t.Fatalf("CreateOrderFromCart failed: %v", err)
+	}
+	if plain.Discount.Amount != 0 {
+		t.Errorf("expected no discount without a code, got %v", plain.Discount)
+	}
+
+	code := strings.ToLower(codes[0].Code)
+	order, err := orderService.CreateOrderFromCart(ctx, primitive.NewObjectID().Hex(), items, &services.PricingContext{CouponCode: code})
+	if err != nil {
+		t.Fatalf("CreateOrderFromCart failed: %v", err)
+	}
+	if order.Total != models.NewMoney(90) {
+		t.Errorf("expected the generated code to apply, total %v", order.Total)
+	}
+
+	// The code is single-use while the order holds it.
+	again, err := orderService.CreateOrderFromCart(ctx, primitive.NewObjectID().Hex(), items, &services.PricingContext{CouponCode: code})
+	if err == nil && again.Discount.Amount != 0 {
+		t.Errorf("a reserved code must not apply again, got %v", again.Discount)
+	}
+
+	if err := orderService.UpdateOrderStatus(ctx, order.ID.Hex(), models.OrderStatusPaid); err != nil {
+		t.Fatalf("pay failed: %v", err)
+	}
+	status, err := pricingService.GetCouponCodeStatus(ctx, code)
+	if err != nil {
+		t.Fatalf("GetCouponCodeStatus failed: %v", err)
+	}
+	if status.Status != models.CouponCodeRedeemed || status.OrderID != order.ID.Hex() || status.RedeemedAt == nil {
+		t.Errorf("expected the code redeemed by the order, got %+v", status.CouponCode)
+	}
+	if len(status.Redemptions) != 1 || status.Redemptions[0].OrderID != order.ID.Hex() || status.Redemptions[0].Status != models.ReservationCommitted {
+		t.Errorf("expected one committed redemption for the order, got %+v", status.Redemptions)
+	}
+}
@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+	"mercadomio-backend/services"
+)
+
+// newCouponFixture creates a product and a single-use coupon set and returns
+// an order service wired to price against them.
+func newCouponFixture(t *testing.T) (*services.OrderService, *services.PricingService, *models.PriceSet, []services.CartItem) {
+	t.Helper()
+	db := connectTestDB(t)
+	ctx := context.Background()
+
+	productService := services.NewProductService(db, nil)
+	pricingService := services.NewPricingService(db, productService)
+	orderService := services.NewOrderService(db)
+	orderService.SetProductService(productService)
+	orderService.SetPricingService(pricingService)
+
+	product := &services.Product{Name: "Mole poblano", BasePrice: 120}
+	if err := productService.CreateProduct(ctx, product); err != nil {
+		t.Fatalf("failed to create product: %v", err)
+	}
+	set, err := pricingService.CreatePriceSet(ctx, &models.PriceSet{
+		Name:       "single use",
+		Active:     true,
+		MaxUses:    1,
+		Conditions: models.PriceConditions{CouponCode: "ONCE"},
+		Rules:      []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 20}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create price set: %v", err)
+	}
+	return orderService, pricingService, set, []services.CartItem{{ProductID: product.ID.Hex(), Quantity: 1}}
+}
+
+func TestCouponReservationConcurrentOrders(t *testing.T) {
+	orderService, pricingService, set, items := newCouponFixture(t)
+	ctx := context.Background()
+
+	const workers = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var placed []*models.Order
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			order, err := orderService.CreateOrderFromCart(ctx, primitive.NewObjectID().Hex(), items, &services.PricingContext{CouponCode: "ONCE"})
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			placed = append(placed, order)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// Orders that lost the race either fail or are placed without the
+	// discount; only one may carry it.
+	discounted := 0
+	for _, o := range placed {
+		if o.Discount.Amount > 0 {
+			discounted++
+		}
+	}
+	if discounted != 1 {
+		t.Fatalf("expected exactly one discounted order, got %d", discounted)
+	}
+
+	got, err := pricingService.GetPriceSet(ctx, set.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ReservedCount != 1 || got.UsedCount != 0 {
+		t.Errorf("expected 1 reserved / 0 used before payment, got %d / %d", got.ReservedCount, got.UsedCount)
+	}
+}
+
+func TestCouponReservationCommitAndRelease(t *testing.T) {
+	orderService, pricingService, set, items := newCouponFixture(t)
+	ctx := context.Background()
+	pc := func() *services.PricingContext { return &services.PricingContext{CouponCode: "ONCE"} }
+
+	first, err := orderService.CreateOrderFromCart(ctx, primitive.NewObjectID().Hex(), items, pc())
+	if err != nil {
+		t.Fatalf("CreateOrderFromCart failed: %v", err)
+	}
+	if first.Discount.Amount == 0 {
+		t.Fatal("expected the coupon to apply to the first order")
+	}
+
+	// Cancelling gives the use back.
+	if err := orderService.UpdateOrderStatus(ctx, first.ID.Hex(), models.OrderStatusCancelled); err != nil {
+		t.Fatalf("cancel failed: %v", err)
+	}
+	got, _ := pricingService.GetPriceSet(ctx, set.ID.Hex())
+	if got.ReservedCount != 0 || got.UsedCount != 0 {
+		t.Errorf("expected the hold released on cancel, got %d reserved / %d used", got.ReservedCount, got.UsedCount)
+	}
+
+	// Paying commits it.
+	second, err := orderService.CreateOrderFromCart(ctx, primitive.NewObjectID().Hex(), items, pc())
+	if err != nil || second.Discount.Amount == 0 {
+		t.Fatalf("expected the released coupon to apply again, got %v / %v", err, second)
+	}
+	if err := orderService.UpdateOrderStatus(ctx, second.ID.Hex(), models.OrderStatusPaid); err != nil {
+		t.Fatalf("pay failed: %v", err)
+	}
+	got, _ = pricingService.GetPriceSet(ctx, set.ID.Hex())
+	if got.ReservedCount != 0 || got.UsedCount != 1 {
+		t.Errorf("expected the hold committed on payment, got %d reserved / %d used", got.ReservedCount, got.UsedCount)
+	}
+
+	// A later cancel does not give back a committed use.
+	if err := orderService.UpdateOrderStatus(ctx, second.ID.Hex(), models.OrderStatusCancelled); err != nil {
+		t.Fatalf("cancel failed: %v", err)
+	}
+	got, _ = pricingService.GetPriceSet(ctx, set.ID.Hex())
+	if got.UsedCount != 1 {
+		t.Errorf("committed use must stay counted, got %d used", got.UsedCount)
+	}
+}
+
+func TestCouponReservationExpiry(t *testing.T) {
+	orderService, pricingService, set, items := newCouponFixture(t)
+	ctx := context.Background()
+
+	order, err := orderService.CreateOrderFromCart(ctx, primitive.NewObjectID().Hex(), items, &services.PricingContext{CouponCode: "ONCE"})
+	if err != nil {
+		t.Fatalf("CreateOrderFromCart failed: %v", err)
+	}
+	if err := orderService.ExpireUsageHolds(ctx, time.Now().Add(services.UsageHoldTTL+time.Minute)); err != nil {
+		t.Fatalf("ExpireUsageHolds failed: %v", err)
+	}
+
+	expired, _ := orderService.GetOrderByID(ctx, order.ID.Hex())
+	if expired.Status != models.OrderStatusCancelled {
+		t.Errorf("expected the pending order cancelled, got %s", expired.Status)
+	}
+	got, _ := pricingService.GetPriceSet(ctx, set.ID.Hex())
+	if got.ReservedCount != 0 {
+		t.Errorf("expected the expired hold released, got %d reserved", got.ReservedCount)
+	}
+}
@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+	"mercadomio-backend/services"
+)
+
+func TestCustomerStatsProjection(t *testing.T) {
+	db := connectTestDB(t)
+	ctx := context.Background()
+
+	stats := services.NewCustomerStatsService(db, services.NewInMemoryEventBus())
+	customer := "customer-1"
+	first := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	second := time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC)
+
+	got, err := stats.GetStats(ctx, customer)
+	if err != nil || got.OrderCount != 0 {
+		t.Fatalf("new customer should have zero stats, got %+v (%v)", got, err)
+	}
+
+	if err := stats.RecordPaid(ctx, "order-2", customer, models.NewMoney(300), second); err != nil {
+		t.Fatalf("RecordPaid failed: %v", err)
+	}
+	if err := stats.RecordPaid(ctx, "order-1", customer, models.NewMoney(200), first); err != nil {
+		t.Fatalf("RecordPaid failed: %v", err)
+	}
+	// A redelivered event must not count twice.
+	if err := stats.RecordPaid(ctx, "order-1", customer, models.NewMoney(200), first); err != nil {
+		t.Fatalf("RecordPaid (duplicate) failed: %v", err)
+	}
+
+	got, err = stats.GetStats(ctx, customer)
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	if got.OrderCount != 2 || got.LifetimeSpend.Amount != models.NewMoney(500).Amount {
+		t.Errorf("expected 2 orders / 500, got %d / %s", got.OrderCount, got.LifetimeSpend)
+	}
+	if got.FirstOrderAt == nil || !got.FirstOrderAt.Equal(first) || got.LastOrderAt == nil || !got.LastOrderAt.Equal(second) {
+		t.Errorf("unexpected first/last order dates: %v / %v", got.FirstOrderAt, got.LastOrderAt)
+	}
+
+	if err := stats.RecordRefund(ctx, "order-2", customer, models.NewMoney(300)); err != nil {
+		t.Fatalf("RecordRefund failed: %v", err)
+	}
+	if err := stats.RecordRefund(ctx, "order-2", customer, models.NewMoney(300)); err != nil {
+		t.Fatalf("RecordRefund (duplicate) failed: %v", err)
+	}
+	got, _ = stats.GetStats(ctx, customer)
+	if got.OrderCount != 1 || got.LifetimeSpend.Amount != models.NewMoney(200).Amount {
+		t.Errorf("expected 1 order / 200 after refund, got %d / %s", got.OrderCount, got.LifetimeSpend)
+	}
+}
+
+func TestCustomerStatsRebuildAndPending(t *testing.T) {
+	db := connectTestDB(t)
+	ctx := context.Background()
+
+	stats := services.NewCustomerStatsService(db, services.NewInMemoryEventBus())
+	customer := primitive.NewObjectID()
+	placed := time.Date(2025, 4, 1, 9, 0, 0, 0, time.UTC)
+	orders := []interface{}{
+		models.Order{ID: primitive.NewObjectID(), UserID: customer, Total: models.NewMoney(150), Status: models.OrderStatusPaid, CreatedAt: placed, UpdatedAt: placed.Add(48 * time.Hour)},
+		models.Order{ID: primitive.NewObjectID(), UserID: customer, Total: models.NewMoney(90), Status: models.OrderStatusPending, CreatedAt: placed, UpdatedAt: placed},
+	}
+	if _, err := db.Collection("orders").InsertMany(ctx, orders); err != nil {
+		t.Fatalf("failed to insert orders: %v", err)
+	}
+
+	if err := stats.Rebuild(ctx); err != nil {
+		t.Fatalf("Rebuild failed: %v", err)
+	}
+	// The paid event, redelivered after the rebuild, is already counted.
+	paid := orders[0].(models.Order)
+	if err := stats.RecordPaid(ctx, paid.ID.Hex(), customer.Hex(), paid.Total, paid.CreatedAt); err != nil {
+		t.Fatalf("RecordPaid failed: %v", err)
+	}
+
+	got, err := stats.GetStats(ctx, customer.Hex())
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	if got.OrderCount != 1 || got.PendingOrders != 1 {
+		t.Errorf("expected 1 paid and 1 pending order, got %d / %d", got.OrderCount, got.PendingOrders)
+	}
+	if got.FirstOrderAt == nil || !got.FirstOrderAt.Equal(placed) {
+		t.Errorf("expected the first order dated when it was placed, got %v", got.FirstOrderAt)
+	}
+}
+
+func TestCustomerStatsRebuildRunsOnce(t *testing.T) {
+	db := connectTestDB(t)
+	ctx := context.Background()
+
+	customer := primitive.NewObjectID()
+	placed := time.Date(2025, 4, 1, 9, 0, 0, 0, time.UTC)
+	order := models.Order{ID: primitive.NewObjectID(), UserID: customer, Total: models.NewMoney(150), Status: models.OrderStatusPaid, CreatedAt: placed, UpdatedAt: placed}
+	if _, err := db.Collection("orders").InsertOne(ctx, order); err != nil {
+		t.Fatalf("failed to insert order: %v", err)
+	}
+
+	// Another replica already took the rebuild on.
+	if _, err := db.Collection("migrations").InsertOne(ctx, bson.M{"_id": "customer_stats_rebuild"}); err != nil {
+		t.Fatalf("failed to insert marker: %v", err)
+	}
+	stats := services.NewCustomerStatsService(db, services.NewInMemoryEventBus())
+	if err := stats.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	got, err := stats.GetStats(ctx, customer.Hex())
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	if got.OrderCount != 0 {
+		t.Errorf("expected the rebuild skipped, got %d orders counted", got.OrderCount)
+	}
+
+	if _, err := db.Collection("migrations").DeleteOne(ctx, bson.M{"_id": "customer_stats_rebuild"}); err != nil {
+		t.Fatalf("failed to remove marker: %v", err)
+	}
+	if err := services.NewCustomerStatsService(db, services.NewInMemoryEventBus()).Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	got, _ = stats.GetStats(ctx, customer.Hex())
+	if got.OrderCount != 1 {
+		t.Errorf("expected the rebuild to count the paid order, got %d", got.OrderCount)
+	}
+}
+
+func TestFirstOrderClaimedOnce(t *testing.T) {
+	db := connectTestDB(t)
+	ctx := context.Background()
+
+	pricingService := services.NewPricingService(db, nil)
+	set, err := pricingService.CreatePriceSet(ctx, &models.PriceSet{
+		Name:       "welcome",
+		Active:     true,
+		Conditions: models.PriceConditions{FirstOrder: true},
+		Rules:      []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 10}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create price set: %v", err)
+	}
+	customer := primitive.NewObjectID().Hex()
+	reserve := func(orderID string) error {
+		return pricingService.ReserveSetUsage(ctx, orderID, []string{set.ID.Hex()}, customer, time.Now().Add(time.Hour))
+	}
+
+	// Two orders placed at once: only one is the first.
+	if err := reserve("order-1"); err != nil {
+		t.Fatalf("ReserveSetUsage failed: %v", err)
+	}
+	if err := reserve("order-2"); !errors.Is(err, services.ErrSetUsageExhausted) {
+		t.Fatalf("expected a second first order refused, got %v", err)
+	}
+
+	// A cancelled first order frees the claim; a paid one spends it.
+	if err := pricingService.ReleaseSetUsage(ctx, "order-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reserve("order-2"); err != nil {
+		t.Fatalf("expected the claim free after a cancel, got %v", err)
+	}
+	if err := pricingService.CommitSetUsage(ctx, "order-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reserve("order-3"); !errors.Is(err, services.ErrSetUsageExhausted) {
+		t.Fatalf("expected the claim spent after payment, got %v", err)
+	}
+}
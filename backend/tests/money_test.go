@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"mercadomio-backend/models"
+)
+
+func TestMoneyPercentRounding(t *testing.T) {
+	cases := []struct {
+		amount int64
+		pct    float64
+		want   int64
+	}{
+		{1000, 10, 100},
+		{1005, 10, 101},   // 100.5 → half away from zero
+		{-1005, 10, -101}, // symmetric for negatives
+		{1999, 15, 300},   // 299.85
+		{1000, 33.3, 333}, // decimal percentage, not the nearest float
+		{1, 50, 1},
+	}
+	for _, tc := range cases {
+		if got := models.MoneyFromMinor(tc.amount).Percent(tc.pct); got.Amount != tc.want {
+			t.Errorf("%d × %v%%: expected %d, got %d", tc.amount, tc.pct, tc.want, got.Amount)
+		}
+	}
+}
+
+func TestMoneyAllocateSumsExactly(t *testing.T) {
+	shares := models.MoneyFromMinor(100).Allocate([]int64{1, 1, 1})
+	sum := int64(0)
+	for _, s := range shares {
+		sum += s.Amount
+	}
+	if sum != 100 || shares[0].Amount != 34 || shares[1].Amount != 33 {
+		t.Errorf("expected 34/33/33, got %+v", shares)
+	}
+
+	shares = models.MoneyFromMinor(-5100).Allocate([]int64{15000, 10000})
+	if shares[0].Amount != -3060 || shares[1].Amount != -2040 {
+		t.Errorf("expected -3060/-2040, got %+v", shares)
+	}
+}
+
+func TestMoneyJSONIsANumber(t *testing.T) {
+	out, err := json.Marshal(map[string]models.Money{"total": models.MoneyFromMinor(-1205)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"total":-12.05}` {
+		t.Errorf("unexpected JSON %s", out)
+	}
+
+	var in struct{ Price models.Money }
+	if err := json.Unmarshal([]byte(`{"Price": 19.99}`), &in); err != nil {
+		t.Fatal(err)
+	}
+	if in.Price != models.MoneyFromMinor(1999) {
+		t.Errorf("expected 19.99 MXN, got %v", in.Price)
+	}
+}
+
+func TestMoneyBSONBackwardCompatible(t *testing.T) {
+	// Orders stored before Money hold bare doubles in major units.
+	legacy, err := bson.Marshal(bson.M{"total": 59.98, "price": int32(10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Total models.Money `bson:"total"`
+		Price models.Money `bson:"price"`
+	}
+	if err := bson.Unmarshal(legacy, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Total != models.MoneyFromMinor(5998) || doc.Price != models.MoneyFromMinor(1000) {
+		t.Errorf("legacy decode: got total=%v price=%v", doc.Total, doc.Price)
+	}
+
+	// New documents round-trip through {amount, currency}.
+	current, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bson.Raw(current).Lookup("total", "amount").Int64(); got != 5998 {
+		t.Errorf("expected total.amount 5998, got %d", got)
+	}
+	var back struct {
+		Total models.Money `bson:"total"`
+	}
+	if err := bson.Unmarshal(current, &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.Total != doc.Total {
+		t.Errorf("round trip: expected %v, got %v", doc.Total, back.Total)
+	}
+}
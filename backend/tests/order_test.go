@@ -20,10 +20,10 @@ func TestOrderModelValidation(t *testing.T) {
 			{
 				ProductID: primitive.NewObjectID(),
 				Quantity:  2,
-				Price:     29.99,
+				Price:     models.NewMoney(29.99),
 			},
 		},
-		Total: 59.98,
+		Total: models.NewMoney(59.98),
 	}
 
 	err := order.Validate()
@@ -51,10 +51,10 @@ func TestOrderModelValidation(t *testing.T) {
 		{
 			ProductID: primitive.NewObjectID(),
 			Quantity:  1,
-			Price:     0,
+			Price:     models.NewMoney(0),
 		},
 	}
-	order.Total = 0
+	order.Total = models.Money{}
 	err = order.Validate()
 	if err == nil {
 		t.Errorf("Expected error for order with zero total")
@@ -67,21 +67,21 @@ func TestOrderModelCalculateTotal(t *testing.T) {
 			{
 				ProductID: primitive.NewObjectID(),
 				Quantity:  2,
-				Price:     10.00,
+				Price:     models.NewMoney(10.00),
 			},
 			{
 				ProductID: primitive.NewObjectID(),
 				Quantity:  1,
-				Price:     15.50,
+				Price:     models.NewMoney(15.50),
 			},
 		},
 	}
 
 	order.CalculateTotal()
 
-	expectedTotal := 35.50 // (2 * 10.00) + (1 * 15.50)
+	expectedTotal := models.NewMoney(35.50) // (2 * 10.00) + (1 * 15.50)
 	if order.Total != expectedTotal {
-		t.Errorf("Expected total %f, got %f", expectedTotal, order.Total)
+		t.Errorf("Expected total %v, got %v", expectedTotal, order.Total)
 	}
 }
 
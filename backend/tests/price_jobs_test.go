@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"mercadomio-backend/models"
+	"mercadomio-backend/services"
+)
+
+func TestPriceJobApplyAndRollback(t *testing.T) {
+	db := connectTestDB(t)
+	ctx := context.Background()
+	pricingService := services.NewPricingService(db, nil)
+
+	tagged := map[string]interface{}{"tags": []string{"abarrotes"}}
+	rice := services.Product{ID: primitive.NewObjectID(), Name: "Arroz", SKU: "ARROZ-1K", BasePrice: 30, CustomAttributes: tagged}
+	beans := services.Product{ID: primitive.NewObjectID(), Name: "Frijol", SKU: "FRIJOL-1K", BasePrice: 40, CustomAttributes: tagged}
+	soap := services.Product{ID: primitive.NewObjectID(), Name: "Jabón", SKU: "JABON", BasePrice: 20}
+	if _, err := db.Collection("products").InsertMany(ctx, []interface{}{rice, beans, soap}); err != nil {
+		t.Fatalf("failed to insert products: %v", err)
+	}
+
+	job, err := pricingService.PreviewPriceJob(ctx, &models.PriceJob{
+		Name:      "abarrotes +10%",
+		Operation: models.PriceJobPercentage,
+		Value:     10,
+		Selector:  models.PriceJobSelector{Tag: "abarrotes"},
+	}, "admin-a")
+	if err != nil {
+		t.Fatalf("PreviewPriceJob failed: %v", err)
+	}
+	if job.Status != models.PriceJobPreview || len(job.Changes) != 2 {
+		t.Fatalf("expected a preview of the two tagged products, got %+v", job)
+	}
+	if price := basePrice(t, db, rice.ID); price != 30 {
+		t.Fatalf("a preview must not write prices, rice is %v", price)
+	}
+
+	// Someone edits the beans after the preview.
+	if _, err := db.Collection("products").UpdateOne(ctx, bson.M{"_id": beans.ID}, bson.M{"$set": bson.M{"basePrice": 42}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pricingService.ApplyPriceJob(ctx, job.ID.Hex(), "admin-a"); err == nil {
+		t.Fatal("expected the job's author to be refused applying it")
+	}
+	job, err = pricingService.ApplyPriceJob(ctx, job.ID.Hex(), "admin-b")
+	if err != nil {
+		t.Fatalf("ApplyPriceJob failed: %v", err)
+	}
+	status := map[string]string{}
+	for _, c := range job.Changes {
+		status[c.ProductID] = c.Status
+	}
+	if job.Status != models.PriceJobApplied || status[rice.ID.Hex()] != models.PriceChangeApplied || status[beans.ID.Hex()] != models.PriceChangeConflict {
+		t.Fatalf("expected rice applied and beans in conflict, got %s %+v", job.Status, job.Changes)
+	}
+	if price := basePrice(t, db, rice.ID); price != 33 {
+		t.Errorf("expected rice at 33, got %v", price)
+	}
+	if price := basePrice(t, db, beans.ID); price != 42 {
+		t.Errorf("the conflicting edit must survive, beans are %v", price)
+	}
+	if job.AppliedBy != "admin-b" {
+		t.Errorf("expected the job applied by admin-b, got %q", job.AppliedBy)
+	}
+	if _, err := pricingService.ApplyPriceJob(ctx, job.ID.Hex(), "admin-b"); err == nil {
+		t.Error("expected a second apply to fail")
+	}
+
+	history, err := pricingService.ListPriceHistory(ctx, rice.ID.Hex(), 10)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("expected one history entry for rice, got %+v (%v)", history, err)
+	}
+	if h := history[0]; h.SourceType != models.PriceHistorySourceBulkJob || h.SourceRef != job.ID.Hex() || h.OldPrice != 30 || h.NewPrice != 33 {
+		t.Errorf("unexpected history entry %+v", h)
+	}
+
+	job, err = pricingService.RollbackPriceJob(ctx, job.ID.Hex(), "admin-a")
+	if err != nil {
+		t.Fatalf("RollbackPriceJob failed: %v", err)
+	}
+	if job.Status != models.PriceJobRolledBack || job.RolledBackAt == nil {
+		t.Errorf("expected a rolled back job, got %+v", job)
+	}
+	if price := basePrice(t, db, rice.ID); price != 30 {
+		t.Errorf("expected rice back at 30, got %v", price)
+	}
+	history, _ = pricingService.ListPriceHistory(ctx, rice.ID.Hex(), 10)
+	if len(history) != 2 || history[0].NewPrice != 30 || history[0].SourceRef != job.ID.Hex() {
+		t.Errorf("expected the rollback in the history, got %+v", history)
+	}
+}
+
+func basePrice(t *testing.T, db *mongo.Database, id primitive.ObjectID) float64 {
+	t.Helper()
+	var p services.Product
+	if err := db.Collection("products").FindOne(context.Background(), bson.M{"_id": id}).Decode(&p); err != nil {
+		t.Fatalf("failed to load product: %v", err)
+	}
+	return p.BasePrice
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+	"mercadomio-backend/services"
+)
+
+func TestWholesalePriceList(t *testing.T) {
+	db := connectTestDB(t)
+	ctx := context.Background()
+	pricingService := services.NewPricingService(db, nil)
+
+	rice := &services.Product{ID: primitive.NewObjectID(), Name: "Arroz", SKU: "ARROZ-1K", BasePrice: 30}
+	list, err := pricingService.CreatePriceList(ctx, &models.PriceList{
+		Name:      "mayoreo",
+		Active:    true,
+		UserTypes: []models.UserType{models.UserTypeWholesale},
+		Prices:    []models.PriceListEntry{{SKU: "ARROZ-1K", Price: 24}},
+	})
+	if err != nil {
+		t.Fatalf("CreatePriceList failed: %v", err)
+	}
+
+	price := func(userType models.UserType) models.Money {
+		t.Helper()
+		res, err := pricingService.ResolvePrices(ctx, []services.PriceInput{{Product: rice, Quantity: 1}},
+			services.PricingContext{CustomerType: userType})
+		if err != nil {
+			t.Fatalf("ResolvePrices failed: %v", err)
+		}
+		return res.Lines[0].UnitPrice
+	}
+	if got := price(models.UserTypeWholesale); got != models.NewMoney(24) {
+		t.Errorf("expected the wholesale price 24, got %s", got)
+	}
+	if got := price(models.UserTypeIndividual); got != models.NewMoney(30) {
+		t.Errorf("expected the base price 30 for individuals, got %s", got)
+	}
+
+	// Edits reach the next resolve.
+	list.Prices[0].Price = 25
+	if _, err := pricingService.UpdatePriceList(ctx, list.ID.Hex(), list); err != nil {
+		t.Fatalf("UpdatePriceList failed: %v", err)
+	}
+	if got := price(models.UserTypeWholesale); got != models.NewMoney(25) {
+		t.Errorf("expected the edited price 25, got %s", got)
+	}
+	if err := pricingService.DeletePriceList(ctx, list.ID.Hex()); err != nil {
+		t.Fatalf("DeletePriceList failed: %v", err)
+	}
+	if got := price(models.UserTypeWholesale); got != models.NewMoney(30) {
+		t.Errorf("expected the base price once the list is gone, got %s", got)
+	}
+}
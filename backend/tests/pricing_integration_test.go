@@ -2,9 +2,11 @@ package tests
 
 import (
 	"context"
+	"os"
 	"testing"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -13,21 +15,25 @@ import (
 	"mercadomio-backend/services"
 )
 
+// connectTestDB connects to MONGO_TEST_URI (default localhost) and returns
+// a database of its own for the test, dropped when the test ends.
 func connectTestDB(t *testing.T) *mongo.Database {
 	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		uri = "mongodb://localhost:27017"
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
 	if err != nil {
 		t.Fatalf("failed to connect to mongo: %v", err)
 	}
-	db := client.Database("mercadomio_test")
+	db := client.Database("mercadomio_test_" + primitive.NewObjectID().Hex())
 	t.Cleanup(func() {
 		dropCtx, dropCancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer dropCancel()
-		for _, name := range []string{"price_sets", "price_schedules", "price_history"} {
-			_ = db.Collection(name).Drop(dropCtx)
-		}
+		_ = db.Drop(dropCtx)
 		_ = client.Disconnect(dropCtx)
 	})
 	return db
@@ -99,16 +105,16 @@ func TestPricingResolvePricesIntegration(t *testing.T) {
 	// Schedule: base * 1.05. Subtotal BEFORE sets = 100*2 + 200 = 400.
 	// Product prices after schedule: 105 and 210.
 	// Set SAVE10: 10% off each line. Line1: 105 -> 94.5 unt*2 = 189. Line2: 210 -> 189.
-	expectedSubtotal := 400.0
-	expectedTotal := 189.0 + 189.0
-	if absDiffF(result.Subtotal, expectedSubtotal) > 1e-6 {
+	expectedSubtotal := models.NewMoney(400)
+	expectedTotal := models.NewMoney(189 + 189)
+	if result.Subtotal != expectedSubtotal {
 		t.Errorf("Subtotal = %v, want %v", result.Subtotal, expectedSubtotal)
 	}
-	if absDiffF(result.Total, expectedTotal) > 1e-6 {
+	if result.Total != expectedTotal {
 		t.Errorf("Total = %v, want %v", result.Total, expectedTotal)
 	}
-	if absDiffF(result.Discount, expectedSubtotal-expectedTotal) > 1e-6 {
-		t.Errorf("Discount = %v, want %v", result.Discount, expectedSubtotal-expectedTotal)
+	if result.Discount != expectedSubtotal.Sub(expectedTotal) {
+		t.Errorf("Discount = %v, want %v", result.Discount, expectedSubtotal.Sub(expectedTotal))
 	}
 	if len(result.AppliedSets) != 1 {
 		t.Errorf("AppliedSets = %d, want 1", len(result.AppliedSets))
@@ -132,11 +138,53 @@ func TestPricingNoCouponNoSets(t *testing.T) {
 	if err != nil {
 		t.Fatalf("ResolvePrices failed: %v", err)
 	}
-	if absDiffF(result.Subtotal, 100) > 1e-6 || absDiffF(result.Total, 100) > 1e-6 {
+	if result.Subtotal != models.NewMoney(100) || result.Total != models.NewMoney(100) {
 		t.Errorf("expected no-op resolve, got subtotal=%v total=%v", result.Subtotal, result.Total)
 	}
 }
 
+func TestPricingSnapshotInvalidatedByCRUD(t *testing.T) {
+	db := connectTestDB(t)
+	ctx := context.Background()
+	pricingService := services.NewPricingService(db, nil)
+
+	inputs := []services.PriceInput{
+		{Product: &services.Product{ID: primitive.NewObjectID(), BasePrice: 100}, Quantity: 1},
+	}
+	resolveTotal := func() models.Money {
+		t.Helper()
+		result, err := pricingService.ResolvePrices(ctx, inputs, services.PricingContext{})
+		if err != nil {
+			t.Fatalf("ResolvePrices failed: %v", err)
+		}
+		return result.Total
+	}
+
+	// Warm the snapshot before any set exists.
+	if got := resolveTotal(); got != models.NewMoney(100) {
+		t.Fatalf("expected 100 before any set, got %v", got)
+	}
+
+	set, err := pricingService.CreatePriceSet(ctx, &models.PriceSet{
+		Name:   "10% off",
+		Active: true,
+		Rules:  []models.PriceRule{{Kind: models.RuleKindPercentage, Amount: 10, Scope: models.RuleScopeAll}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create price set: %v", err)
+	}
+	if got := resolveTotal(); got != models.NewMoney(90) {
+		t.Errorf("expected new set to apply after create, got %v", got)
+	}
+
+	if err := pricingService.UpdatePriceSet(ctx, set.ID.Hex(), bson.M{"active": false}); err != nil {
+		t.Fatalf("failed to update price set: %v", err)
+	}
+	if got := resolveTotal(); got != models.NewMoney(100) {
+		t.Errorf("expected deactivated set to stop applying, got %v", got)
+	}
+}
+
 func pointerTime(t time.Time) *time.Time {
 	return &t
 }
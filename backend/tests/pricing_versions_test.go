@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"mercadomio-backend/models"
+	"mercadomio-backend/services"
+)
+
+func TestPriceSetDraftApprovalAndRevert(t *testing.T) {
+	db := connectTestDB(t)
+	ctx := context.Background()
+	pricingService := services.NewPricingService(db, nil)
+
+	set, err := pricingService.CreatePriceSet(ctx, &models.PriceSet{
+		Name:   "10 off",
+		Active: true,
+		Rules:  []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 10}},
+	})
+	if err != nil {
+		t.Fatalf("CreatePriceSet failed: %v", err)
+	}
+	if set.Version != 1 {
+		t.Fatalf("a new set should be version 1, got %d", set.Version)
+	}
+	// Usage recorded on the live set must survive publishing.
+	if _, err := db.Collection("price_sets").UpdateOne(ctx, bson.M{"_id": set.ID}, bson.M{"$set": bson.M{"usedCount": 7}}); err != nil {
+		t.Fatal(err)
+	}
+	// So must unique codes generated after the draft's definition was taken.
+	if _, err := pricingService.GenerateCouponCodes(ctx, set.ID.Hex(), 1, "V-", "XXXX"); err != nil {
+		t.Fatalf("GenerateCouponCodes failed: %v", err)
+	}
+
+	typo := *set
+	typo.Rules = []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 100}}
+	draft, err := pricingService.CreatePriceSetDraft(ctx, set.ID.Hex(), &typo, "oops", "alice")
+	if err != nil {
+		t.Fatalf("CreatePriceSetDraft failed: %v", err)
+	}
+	live, _ := pricingService.GetPriceSet(ctx, set.ID.Hex())
+	if live.Rules[0].Amount != 10 {
+		t.Fatalf("a draft must not change the live set, got %v", live.Rules[0].Amount)
+	}
+	if _, err := pricingService.ApproveVersion(ctx, draft.ID.Hex(), "alice"); err == nil {
+		t.Fatal("the author must not approve their own draft")
+	}
+	published, err := pricingService.ApproveVersion(ctx, draft.ID.Hex(), "bob")
+	if err != nil {
+		t.Fatalf("ApproveVersion failed: %v", err)
+	}
+	if published.Version != 2 || published.ApprovedBy != "bob" {
+		t.Errorf("expected version 2 approved by bob, got %+v", published)
+	}
+	live, _ = pricingService.GetPriceSet(ctx, set.ID.Hex())
+	if live.Version != 2 || live.Rules[0].Amount != 100 || live.UsedCount != 7 {
+		t.Errorf("expected version 2 live with usage kept, got v%d %v used %d", live.Version, live.Rules[0].Amount, live.UsedCount)
+	}
+	if !live.Conditions.UniqueCodes {
+		t.Error("publishing an older definition must keep unique codes on")
+	}
+
+	// A draft written against version 1 can no longer be published.
+	stale, err := pricingService.CreatePriceSetDraft(ctx, set.ID.Hex(), &typo, "", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Collection("pricing_versions").UpdateOne(ctx, bson.M{"_id": stale.ID}, bson.M{"$set": bson.M{"baseVersion": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pricingService.ApproveVersion(ctx, stale.ID.Hex(), "bob"); !errors.Is(err, services.ErrVersionConflict) {
+		t.Errorf("expected a version conflict, got %v", err)
+	}
+	if v, _ := pricingService.GetVersion(ctx, stale.ID.Hex()); v.Status != models.VersionDraft {
+		t.Errorf("a conflicting draft should stay a draft, got %s", v.Status)
+	}
+
+	// A revert is a draft like any other.
+	revert, err := pricingService.RevertVersion(ctx, models.VersionKindSet, set.ID.Hex(), 1, "carol")
+	if err != nil {
+		t.Fatalf("RevertVersion failed: %v", err)
+	}
+	if live, _ = pricingService.GetPriceSet(ctx, set.ID.Hex()); live.Version != 2 {
+		t.Fatalf("a revert must wait for approval, got live version %d", live.Version)
+	}
+	reverted, err := pricingService.ApproveVersion(ctx, revert.ID.Hex(), "bob")
+	if err != nil {
+		t.Fatalf("ApproveVersion failed: %v", err)
+	}
+	if reverted.Version != 3 || reverted.RevertedFrom != 1 {
+		t.Errorf("expected version 3 restoring 1, got %+v", reverted)
+	}
+	live, _ = pricingService.GetPriceSet(ctx, set.ID.Hex())
+	if live.Version != 3 || live.Rules[0].Amount != 10 || live.UsedCount != 7 {
+		t.Errorf("expected version 1's rules back as version 3, got v%d %v used %d", live.Version, live.Rules[0].Amount, live.UsedCount)
+	}
+
+	history, err := pricingService.ListVersions(ctx, models.VersionKindSet, set.ID.Hex(), models.VersionPublished)
+	if err != nil || len(history) != 3 {
+		t.Errorf("expected 3 published versions, got %d (%v)", len(history), err)
+	}
+}
+
+func TestPriceSetCreateAndDeleteNeedApproval(t *testing.T) {
+	db := connectTestDB(t)
+	ctx := context.Background()
+	pricingService := services.NewPricingService(db, nil)
+
+	draft, err := pricingService.CreateNewPriceSetDraft(ctx, &models.PriceSet{
+		Name:   "hot sale",
+		Active: true,
+		Rules:  []models.PriceRule{{Kind: models.RuleKindPercentage, Scope: models.RuleScopeAll, Amount: 20}},
+	}, "", "alice")
+	if err != nil {
+		t.Fatalf("CreateNewPriceSetDraft failed: %v", err)
+	}
+	if _, err := pricingService.GetPriceSet(ctx, draft.TargetID); err == nil {
+		t.Fatal("a new set must not exist before approval")
+	}
+	if _, err := pricingService.ApproveVersion(ctx, draft.ID.Hex(), "bob"); err != nil {
+		t.Fatalf("ApproveVersion failed: %v", err)
+	}
+	live, err := pricingService.GetPriceSet(ctx, draft.TargetID)
+	if err != nil || live.Version != 1 || live.Rules[0].Amount != 20 {
+		t.Fatalf("expected the set live as version 1, got %+v (%v)", live, err)
+	}
+
+	deletion, err := pricingService.DeletePriceSetDraft(ctx, draft.TargetID, "season over", "alice")
+	if err != nil {
+		t.Fatalf("DeletePriceSetDraft failed: %v", err)
+	}
+	if _, err := pricingService.GetPriceSet(ctx, draft.TargetID); err != nil {
+		t.Fatal("a set must stay live until its deletion is approved")
+	}
+	if _, err := pricingService.ApproveVersion(ctx, deletion.ID.Hex(), "bob"); err != nil {
+		t.Fatalf("ApproveVersion failed: %v", err)
+	}
+	if _, err := pricingService.GetPriceSet(ctx, draft.TargetID); err == nil {
+		t.Error("expected the set deleted once approved")
+	}
+	history, err := pricingService.ListVersions(ctx, models.VersionKindSet, draft.TargetID, models.VersionPublished)
+	if err != nil || len(history) != 2 || history[0].Action != models.VersionActionDelete {
+		t.Errorf("expected the creation and deletion in the history, got %+v (%v)", history, err)
+	}
+}
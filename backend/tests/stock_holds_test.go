@@ -0,0 +1,313 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+	"mercadomio-backend/services"
+)
+
+// newStockFixture creates a product with a variant of the given stock and
+// returns an order service that holds stock at checkout.
+func newStockFixture(t *testing.T, stock int) (*services.OrderService, services.ProductService, *services.InventoryService, *services.Product) {
+	t.Helper()
+	db := connectTestDB(t)
+	ctx := context.Background()
+
+	productService := services.NewProductService(db, nil)
+	inventory := services.NewInventoryService(db)
+	orderService := services.NewOrderService(db)
+	orderService.SetProductService(productService)
+	orderService.SetInventoryService(inventory)
+
+	product := &services.Product{
+		Name:      "Café de Chiapas",
+		BasePrice: 180,
+		Variants:  []services.Variant{{VariantID: "cafe-500g", SKU: "CAFE-500G", Stock: stock}},
+	}
+	if err := productService.CreateProduct(ctx, product); err != nil {
+		t.Fatalf("failed to create product: %v", err)
+	}
+	return orderService, productService, inventory, product
+}
+
+// available reads how many units of the product's variant are still for sale.
+func available(t *testing.T, productService services.ProductService, product *services.Product) (int, int) {
+	t.Helper()
+	got, err := productService.GetProduct(context.Background(), product.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := got.FindVariant("cafe-500g")
+	return got.Available(v), v.Stock
+}
+
+func TestStockHoldsLastUnit(t *testing.T) {
+	orderService, productService, _, product := newStockFixture(t, 1)
+	ctx := context.Background()
+	items := []services.CartItem{{ProductID: product.ID.Hex(), VariantID: "cafe-500g", Quantity: 1}}
+
+	const workers = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var placed []*models.Order
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			order, err := orderService.CreateOrderFromCart(ctx, primitive.NewObjectID().Hex(), items, nil)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			placed = append(placed, order)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(placed) != 1 {
+		t.Fatalf("expected exactly one order for the last unit, got %d", len(placed))
+	}
+	if avail, stock := available(t, productService, product); avail != 0 || stock != 1 {
+		t.Errorf("expected the unit held but still in stock, got %d available / %d stock", avail, stock)
+	}
+
+	// Paying converts the hold: stock drops and nothing stays held.
+	if err := orderService.UpdateOrderStatus(ctx, placed[0].ID.Hex(), models.OrderStatusPaid); err != nil {
+		t.Fatalf("pay failed: %v", err)
+	}
+	got, _ := productService.GetProduct(ctx, product.ID.Hex())
+	if v := got.FindVariant("cafe-500g"); v.Stock != 0 || got.ReservedStock["cafe-500g"] != 0 {
+		t.Errorf("expected stock 0 and no hold after payment, got %d stock / %d held", v.Stock, got.ReservedStock["cafe-500g"])
+	}
+}
+
+func TestStockHoldsReleaseAndExpiry(t *testing.T) {
+	orderService, productService, inventory, product := newStockFixture(t, 3)
+	ctx := context.Background()
+	items := []services.CartItem{{ProductID: product.ID.Hex(), VariantID: "cafe-500g", Quantity: 2}}
+
+	first, err := orderService.CreateOrderFromCart(ctx, primitive.NewObjectID().Hex(), items, nil)
+	if err != nil {
+		t.Fatalf("CreateOrderFromCart failed: %v", err)
+	}
+	if _, err := orderService.CreateOrderFromCart(ctx, primitive.NewObjectID().Hex(), items, nil); err == nil {
+		t.Fatal("expected a second order for 2 of the 1 unheld unit to be refused")
+	}
+
+	// Cancelling gives the units back.
+	if err := orderService.UpdateOrderStatus(ctx, first.ID.Hex(), models.OrderStatusCancelled); err != nil {
+		t.Fatalf("cancel failed: %v", err)
+	}
+	if avail, _ := available(t, productService, product); avail != 3 {
+		t.Errorf("expected all 3 units back after cancel, got %d", avail)
+	}
+
+	// A pending order whose hold expires is cancelled.
+	second, err := orderService.CreateOrderFromCart(ctx, primitive.NewObjectID().Hex(), items, nil)
+	if err != nil {
+		t.Fatalf("CreateOrderFromCart failed: %v", err)
+	}
+	if err := orderService.ExpireStockHolds(ctx, time.Now().Add(services.UsageHoldTTL+time.Minute)); err != nil {
+		t.Fatalf("ExpireStockHolds failed: %v", err)
+	}
+	expired, _ := orderService.GetOrderByID(ctx, second.ID.Hex())
+	if expired.Status != models.OrderStatusCancelled {
+		t.Errorf("expected the pending order cancelled, got %s", expired.Status)
+	}
+	if avail, _ := available(t, productService, product); avail != 3 {
+		t.Errorf("expected the expired hold released, got %d available", avail)
+	}
+
+	// Holding again for the same lines takes nothing more; fewer lines give
+	// the difference back.
+	lines := []services.StockLine{{ProductID: product.ID.Hex(), VariantID: "cafe-500g", Quantity: 2}}
+	for i := 0; i < 2; i++ {
+		if err := inventory.HoldStock(ctx, "cart:c1", lines, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("HoldStock failed: %v", err)
+		}
+	}
+	if avail, _ := available(t, productService, product); avail != 1 {
+		t.Errorf("expected a repeated hold to take 2 units once, got %d available", avail)
+	}
+	lines[0].Quantity = 1
+	if err := inventory.HoldStock(ctx, "cart:c1", lines, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("HoldStock failed: %v", err)
+	}
+	if avail, _ := available(t, productService, product); avail != 2 {
+		t.Errorf("expected a smaller hold to give 1 unit back, got %d available", avail)
+	}
+	lines[0].Quantity = 5
+	if err := inventory.HoldStock(ctx, "cart:c1", lines, time.Now().Add(time.Hour)); err != services.ErrInsufficientStock {
+		t.Fatalf("expected ErrInsufficientStock, got %v", err)
+	}
+
+	// Expired cart holds are simply released.
+	if err := orderService.ExpireStockHolds(ctx, time.Now().Add(2*time.Hour)); err != nil {
+		t.Fatalf("ExpireStockHolds failed: %v", err)
+	}
+	if avail, _ := available(t, productService, product); avail != 3 {
+		t.Errorf("expected the cart's hold released, got %d available", avail)
+	}
+}
+
+func TestCheckoutTakesOverCartHolds(t *testing.T) {
+	orderService, productService, inventory, product := newStockFixture(t, 1)
+	ctx := context.Background()
+	lines := []services.StockLine{{ProductID: product.ID.Hex(), VariantID: "cafe-500g", Quantity: 1}}
+	if err := inventory.HoldStock(ctx, "cart:c1", lines, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("HoldStock failed: %v", err)
+	}
+
+	// A checkout asking for more than the cart holds fails and leaves the
+	// cart's hold in place.
+	cart := &services.Cart{ID: "c1", Items: []services.CartItem{{ProductID: product.ID.Hex(), VariantID: "cafe-500g", Quantity: 2}}}
+	if _, err := orderService.CheckoutCart(ctx, primitive.NewObjectID().Hex(), cart, nil); err == nil {
+		t.Fatal("expected a checkout for 2 of 1 unit to be refused")
+	}
+	got, _ := productService.GetProduct(ctx, product.ID.Hex())
+	if got.ReservedStock["cafe-500g"] != 1 {
+		t.Fatalf("expected the cart to keep its unit after a failed checkout, got %d held", got.ReservedStock["cafe-500g"])
+	}
+
+	// The cart's last unit is its own to order; the order takes it over.
+	cart.Items[0].Quantity = 1
+	if _, err := orderService.CheckoutCart(ctx, primitive.NewObjectID().Hex(), cart, nil); err != nil {
+		t.Fatalf("CheckoutCart failed: %v", err)
+	}
+	got, _ = productService.GetProduct(ctx, product.ID.Hex())
+	if got.ReservedStock["cafe-500g"] != 1 {
+		t.Errorf("expected the unit held once, by the order, got %d held", got.ReservedStock["cafe-500g"])
+	}
+	// What is held is the order's: releasing the cart gives nothing back.
+	if err := inventory.ReleaseStockHolds(ctx, "cart:c1"); err != nil {
+		t.Fatal(err)
+	}
+	if avail, _ := available(t, productService, product); avail != 0 {
+		t.Errorf("expected the order's hold kept, got %d available", avail)
+	}
+}
+
+func TestCartHoldsFollowRacingWrites(t *testing.T) {
+	_, productService, inventory, product := newStockFixture(t, 100)
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+
+	config := services.NewCartConfig()
+	config.HoldStock = true
+	// Two tabs, through two connections.
+	var tabs []*services.CartServiceImpl
+	for i := 0; i < 2; i++ {
+		cs := services.NewCartService(redis.NewClient(&redis.Options{Addr: mr.Addr()}), productService, config, nil, services.NewInMemoryEventBus())
+		cs.SetInventoryService(inventory)
+		tabs = append(tabs, cs)
+	}
+
+	// Writes that give up (ErrCartBusy) must not leave their holds behind.
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			item := services.CartItem{ProductID: product.ID.Hex(), VariantID: "cafe-500g", Quantity: 1}
+			if _, err := tabs[i%2].AddToCart(ctx, "c1", item, services.AnyCartVersion); err != nil && !errors.Is(err, services.ErrCartBusy) {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	cart, err := tabs[0].GetCart(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := productService.GetProduct(ctx, product.ID.Hex())
+	if len(cart.Items) != 1 || got.ReservedStock["cafe-500g"] != cart.Items[0].Quantity {
+		t.Errorf("expected the holds to match the cart, got %d held for %+v", got.ReservedStock["cafe-500g"], cart.Items)
+	}
+}
+
+func TestOpenPaymentKeepsOrderHeld(t *testing.T) {
+	orderService, productService, _, product := newStockFixture(t, 1)
+	ctx := context.Background()
+	items := []services.CartItem{{ProductID: product.ID.Hex(), VariantID: "cafe-500g", Quantity: 1}}
+
+	order, err := orderService.CreateOrderFromCart(ctx, primitive.NewObjectID().Hex(), items, nil)
+	if err != nil {
+		t.Fatalf("CreateOrderFromCart failed: %v", err)
+	}
+	// A cash payment opened half way through the hold runs a full TTL.
+	deadline := time.Now().Add(services.UsageHoldTTL / 2).Add(services.UsageHoldTTL)
+	if err := orderService.KeepForPayment(ctx, order.ID.Hex(), deadline); err != nil {
+		t.Fatalf("KeepForPayment failed: %v", err)
+	}
+
+	expire := func(at time.Time) models.OrderStatus {
+		t.Helper()
+		if err := orderService.ExpireStockHolds(ctx, at); err != nil {
+			t.Fatalf("ExpireStockHolds failed: %v", err)
+		}
+		got, _ := orderService.GetOrderByID(ctx, order.ID.Hex())
+		return got.Status
+	}
+	if status := expire(time.Now().Add(services.UsageHoldTTL + time.Minute)); status != models.OrderStatusPending {
+		t.Fatalf("expected the order kept while its payment is open, got %s", status)
+	}
+	if avail, _ := available(t, productService, product); avail != 0 {
+		t.Errorf("expected the unit still held, got %d available", avail)
+	}
+	if status := expire(deadline.Add(services.PaymentGrace + time.Minute)); status != models.OrderStatusCancelled {
+		t.Fatalf("expected the order cancelled once its payment closed, got %s", status)
+	}
+	if err := orderService.KeepForPayment(ctx, order.ID.Hex(), time.Now().Add(time.Hour)); err == nil {
+		t.Error("expected no payment to be opened for a cancelled order")
+	}
+}
+
+func TestPaymentRacingExpiryAppliesOnce(t *testing.T) {
+	orderService, productService, _, product := newStockFixture(t, 5)
+	ctx := context.Background()
+	items := []services.CartItem{{ProductID: product.ID.Hex(), VariantID: "cafe-500g", Quantity: 2}}
+
+	order, err := orderService.CreateOrderFromCart(ctx, primitive.NewObjectID().Hex(), items, nil)
+	if err != nil {
+		t.Fatalf("CreateOrderFromCart failed: %v", err)
+	}
+
+	// The payment and the expiry's cancel land together; one must lose.
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, status := range []models.OrderStatus{models.OrderStatusPaid, models.OrderStatusCancelled} {
+		wg.Add(1)
+		go func(i int, status models.OrderStatus) {
+			defer wg.Done()
+			errs[i] = orderService.UpdateOrderStatus(ctx, order.ID.Hex(), status)
+		}(i, status)
+	}
+	wg.Wait()
+	if (errs[0] == nil) == (errs[1] == nil) {
+		t.Fatalf("expected exactly one update applied, got %v / %v", errs[0], errs[1])
+	}
+
+	got, _ := orderService.GetOrderByID(ctx, order.ID.Hex())
+	avail, stock := available(t, productService, product)
+	switch got.Status {
+	case models.OrderStatusPaid:
+		if stock != 3 || avail != 3 {
+			t.Errorf("expected 2 units sold once, got %d available / %d stock", avail, stock)
+		}
+	case models.OrderStatusCancelled:
+		if stock != 5 || avail != 5 {
+			t.Errorf("expected every unit back, got %d available / %d stock", avail, stock)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"mercadomio-backend/models"
+	"mercadomio-backend/services"
+)
+
+func TestTaxMigrationTagsUntaggedProducts(t *testing.T) {
+	db := connectTestDB(t)
+	ctx := context.Background()
+
+	productService := services.NewProductService(db, nil)
+	untagged := &services.Product{
+		Name:      "Tequila blanco",
+		BasePrice: 116,
+		Variants:  []services.Variant{{VariantID: "750ml", SKU: "TEQ-750", PriceAdjustment: 23.2}},
+	}
+	tagged := &services.Product{Name: "Tortillas", BasePrice: 25, TaxCategory: models.TaxCategoryZeroRated}
+	for _, p := range []*services.Product{untagged, tagged} {
+		if err := productService.CreateProduct(ctx, p); err != nil {
+			t.Fatalf("failed to create product: %v", err)
+		}
+	}
+
+	taxes := services.NewTaxService()
+	n, err := taxes.MigrateProducts(ctx, db, true)
+	if err != nil {
+		t.Fatalf("MigrateProducts failed: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 product tagged, got %d", n)
+	}
+	// Running again changes nothing, even for a product added untagged since.
+	if _, err := db.Collection("products").UpdateOne(ctx, bson.M{"_id": tagged.ID}, bson.M{"$unset": bson.M{"taxCategory": ""}}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := taxes.MigrateProducts(ctx, db, true); err != nil || n != 0 {
+		t.Errorf("expected a second run to do nothing, got %d (%v)", n, err)
+	}
+
+	got, err := productService.GetProduct(ctx, untagged.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TaxCategory != models.TaxCategoryGeneral || got.BasePrice != 100 || got.Variants[0].PriceAdjustment != 20 {
+		t.Errorf("expected general IVA taken out of the prices, got %s at %.2f (+%.2f)", got.TaxCategory, got.BasePrice, got.Variants[0].PriceAdjustment)
+	}
+	got, _ = productService.GetProduct(ctx, tagged.ID.Hex())
+	if got.BasePrice != 25 {
+		t.Errorf("expected the tagged product's price untouched, got %.2f", got.BasePrice)
+	}
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mercadomio-backend/models"
+	"mercadomio-backend/services"
+)
+
+// TestVariantPricingConsistency pins cart value, resolved price and order
+// total to the same number for a variant with a price adjustment.
+func TestVariantPricingConsistency(t *testing.T) {
+	db := connectTestDB(t)
+	ctx := context.Background()
+
+	productService := services.NewProductService(db, nil)
+	pricingService := services.NewPricingService(db, productService)
+	cartService := services.NewCartService(nil, productService, services.NewCartConfig(), db, nil)
+	orderService := services.NewOrderService(db)
+	orderService.SetProductService(productService)
+	orderService.SetPricingService(pricingService)
+
+	product := &services.Product{
+		Name:      "Café de olla",
+		BasePrice: 80,
+		Variants: []services.Variant{
+			{VariantID: "cafe-250g", SKU: "CAFE-250"},
+			{VariantID: "cafe-1kg", SKU: "CAFE-1000", PriceAdjustment: 170},
+		},
+	}
+	if err := productService.CreateProduct(ctx, product); err != nil {
+		t.Fatalf("failed to create product: %v", err)
+	}
+
+	items := []services.CartItem{
+		{ProductID: product.ID.Hex(), VariantID: "cafe-1kg", Quantity: 2},
+		{ProductID: product.ID.Hex(), VariantID: "cafe-250g", Quantity: 1},
+	}
+	const want = 2*250.0 + 80.0
+
+	cartValue := cartService.CartValue(ctx, &services.Cart{Items: items})
+	if absDiffF(cartValue, want) > 0.001 {
+		t.Errorf("cart value: expected %.2f, got %.2f", want, cartValue)
+	}
+
+	inputs := make([]services.PriceInput, 0, len(items))
+	for _, item := range items {
+		inputs = append(inputs, services.PriceInput{
+			Product:  product,
+			Variant:  product.FindVariant(item.VariantID),
+			Quantity: item.Quantity,
+		})
+	}
+	result, err := pricingService.ResolvePrices(ctx, inputs, services.PricingContext{})
+	if err != nil {
+		t.Fatalf("ResolvePrices failed: %v", err)
+	}
+	if result.Total != models.NewMoney(want) {
+		t.Errorf("resolved total: expected %.2f, got %v", want, result.Total)
+	}
+	if result.Lines[0].BasePrice != models.NewMoney(250) {
+		t.Errorf("1 kg line base: expected 250, got %v", result.Lines[0].BasePrice)
+	}
+
+	order, err := orderService.CreateOrderFromCart(ctx, primitive.NewObjectID().Hex(), items, &services.PricingContext{})
+	if err != nil {
+		t.Fatalf("CreateOrderFromCart failed: %v", err)
+	}
+	if order.Total != models.NewMoney(want) {
+		t.Errorf("order total: expected %.2f, got %v", want, order.Total)
+	}
+	if order.Items[0].Price != models.NewMoney(250) || order.Items[1].Price != models.NewMoney(80) {
+		t.Errorf("order item prices: expected 250 and 80, got %v and %v", order.Items[0].Price, order.Items[1].Price)
+	}
+}
+
+// TestVariantPricingDiscountedOrder checks that a percentage set applies to
+// the variant price, not the product base price.
+func TestVariantPricingDiscountedOrder(t *testing.T) {
+	db := connectTestDB(t)
+	ctx := context.Background()
+
+	productService := services.NewProductService(db, nil)
+	pricingService := services.NewPricingService(db, productService)
+	orderService := services.NewOrderService(db)
+	orderService.SetProductService(productService)
+	orderService.SetPricingService(pricingService)
+
+	product := &services.Product{
+		Name:      "Miel de abeja",
+		BasePrice: 100,
+		Variants:  []services.Variant{{VariantID: "miel-1l", SKU: "MIEL-1L", PriceAdjustment: 100}},
+	}
+	if err := productService.CreateProduct(ctx, product); err != nil {
+		t.Fatalf("failed to create product: %v", err)
+	}
+	if _, err := pricingService.CreatePriceSet(ctx, &models.PriceSet{
+		Name:   "10% off",
+		Active: true,
+		Rules:  []models.PriceRule{{Kind: models.RuleKindPercentage, Amount: 10, Scope: models.RuleScopeAll}},
+	}); err != nil {
+		t.Fatalf("failed to create price set: %v", err)
+	}
+
+	items := []services.CartItem{{ProductID: product.ID.Hex(), VariantID: "miel-1l", Quantity: 1}}
+	order, err := orderService.CreateOrderFromCart(ctx, primitive.NewObjectID().Hex(), items, &services.PricingContext{})
+	if err != nil {
+		t.Fatalf("CreateOrderFromCart failed: %v", err)
+	}
+	if order.Subtotal != models.NewMoney(200) || order.Discount != models.NewMoney(20) || order.Total != models.NewMoney(180) {
+		t.Errorf("expected 200 - 20 = 180, got %v - %v = %v", order.Subtotal, order.Discount, order.Total)
+	}
+}
+
+func TestVariantPricingUnknownVariant(t *testing.T) {
+	db := connectTestDB(t)
+	ctx := context.Background()
+
+	productService := services.NewProductService(db, nil)
+	orderService := services.NewOrderService(db)
+	orderService.SetProductService(productService)
+
+	product := &services.Product{Name: "Tortillas", BasePrice: 25}
+	if err := productService.CreateProduct(ctx, product); err != nil {
+		t.Fatalf("failed to create product: %v", err)
+	}
+
+	items := []services.CartItem{{ProductID: product.ID.Hex(), VariantID: "missing", Quantity: 1}}
+	if _, err := orderService.CreateOrderFromCart(ctx, primitive.NewObjectID().Hex(), items, nil); err == nil {
+		t.Error("expected an error for an unknown variant")
+	}
+}
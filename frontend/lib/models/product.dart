@@ -138,7 +138,8 @@ class Variant {
       variantId: json['variantId']?.toString() ?? '',
       name: json['name']?.toString() ?? '',
       price: (json['price'] as num?)?.toDouble() ?? 0.0,
-      stock: (json['stock'] as int?) ?? 0,
+      // Units still for sale (stock minus holds), when the catalog sends it.
+      stock: (json['available'] as int?) ?? (json['stock'] as int?) ?? 0,
       sku: json['sku']?.toString() ?? '',
       imageUrl: json['imageUrl']?.toString() ?? '',
       isAvailable: (json['isAvailable'] as bool?) ?? true,